@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/utils"
+)
+
+// RunNotificationDigests emails the notification digest every interval
+// until ctx is cancelled.
+func (h *Handlers) RunNotificationDigests(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.SendNotificationDigests(); err != nil {
+				log.Printf("Error sending notification digests: %v", err)
+			}
+		}
+	}
+}
+
+// SendNotificationDigests emails every user who chose to receive some
+// notifications by email a summary of those created since their last
+// digest. A failure for one user does not hold up the others.
+func (h *Handlers) SendNotificationDigests() error {
+	recipients, err := h.notifications.DigestRecipients()
+	if err != nil {
+		return err
+	}
+	for _, r := range recipients {
+		if err := h.sendNotificationDigest(r); err != nil {
+			log.Printf("Error sending notification digest to user %s: %v", r.UserID, err)
+		}
+	}
+	return nil
+}
+
+func (h *Handlers) sendNotificationDigest(r utils.DigestRecipient) error {
+	pending, err := h.notifications.PendingDigest(r.UserID)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	var body strings.Builder
+	body.WriteString("Here is what happened on the forum since your last digest:\n\n")
+	upTo := 0
+	for _, n := range pending {
+		link := h.siteURL("/", url.Values{"id": {strconv.Itoa(n.PostID)}})
+		fmt.Fprintf(&body, "- %s %s: %s\n", n.Actors(), n.Message(), link)
+		if n.ID > upTo {
+			upTo = n.ID
+		}
+	}
+	fmt.Fprintf(&body, "\nTo choose which notifications you receive by email, visit %s\n",
+		h.siteURL("/notifications/preferences", nil))
+
+	if err := h.mailer.Send(r.Email, "Your forum notifications", body.String()); err != nil {
+		return err
+	}
+	return h.notifications.MarkDigestSent(r.UserID, upTo)
+}
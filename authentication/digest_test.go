@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"forum/utils"
+)
+
+type sentMail struct{ to, subject, body string }
+
+type recordingMailer struct{ sent []sentMail }
+
+func (m *recordingMailer) Send(to, subject, body string) error {
+	m.sent = append(m.sent, sentMail{to, subject, body})
+	return nil
+}
+
+func TestSendNotificationDigests(t *testing.T) {
+	h, db := newTestHandlers(t)
+	m := &recordingMailer{}
+	h.mailer = m
+
+	userID := utils.GenerateId()
+	email := userID + "@example.com"
+	db.Exec("INSERT INTO users (id, username, email) VALUES (?, ?, ?)", userID, "digest_"+userID[:8], email)
+	if err := utils.SetNotificationPreference(db, userID, "like", utils.DeliveryEmail); err != nil {
+		t.Fatalf("SetNotificationPreference returned error: %v", err)
+	}
+	result, err := db.Exec("INSERT INTO posts (user_id, title, content) VALUES (?, 'Title', 'Content')", userID)
+	if err != nil {
+		t.Fatalf("Failed to insert post: %v", err)
+	}
+	postID, _ := result.LastInsertId()
+	for i := 0; i < 2; i++ {
+		actorID := utils.GenerateId()
+		db.Exec("INSERT INTO users (id, username, email) VALUES (?, ?, ?)", actorID, "actor_"+actorID[:8], actorID+"@example.com")
+		db.Exec("INSERT INTO reaction (user_id, post_id, like) VALUES (?, ?, 1)", actorID, postID)
+	}
+
+	if err := h.SendNotificationDigests(); err != nil {
+		t.Fatalf("SendNotificationDigests returned error: %v", err)
+	}
+	if len(m.sent) != 1 || m.sent[0].to != email {
+		t.Fatalf("sent %+v, want one digest to %s", m.sent, email)
+	}
+	if !strings.Contains(m.sent[0].body, "and 1 other liked your post") {
+		t.Errorf("digest does not group the likes:\n%s", m.sent[0].body)
+	}
+
+	// Nothing new, nothing sent
+	if err := h.SendNotificationDigests(); err != nil {
+		t.Fatalf("SendNotificationDigests returned error: %v", err)
+	}
+	if len(m.sent) != 1 {
+		t.Errorf("a second digest was sent without new notifications")
+	}
+}
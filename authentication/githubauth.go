@@ -1,55 +1,53 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
 	"fmt"
 	"forum/utils"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
-	"os"
+	"strconv"
+	"strings"
 )
 
-// Global variables for GitHub OAuth
-var (
+// githubProvider signs in with GitHub, which speaks OAuth 2 but not OIDC.
+type githubProvider struct {
 	clientID     string
 	clientSecret string
 	redirectURI  string
-)
-
-func init() {
-	// Load .env variables
-	loadEnv(".env")
-
-	// Retrieve environment variables
-	clientID = os.Getenv("GITHUB_CLIENT_ID")
-	clientSecret = os.Getenv("GITHUB_CLIENT_SECRET")
-	redirectURI = os.Getenv("GITHUB_REDIRECT_URI")
+}
 
-	if clientID == "" || clientSecret == "" || redirectURI == "" {
-		log.Fatal("Missing required environment variables")
-	}
+func (p *githubProvider) Info() utils.ProviderInfo {
+	return utils.ProviderInfo{Name: utils.ProviderGitHub, Label: "GitHub", Icon: "fab fa-github"}
 }
 
-// HandleGitHubLogin redirects user to GitHub login
-func HandleGitHubLogin(w http.ResponseWriter, r *http.Request) {
-	authURL := fmt.Sprintf(
-		"https://github.com/login/oauth/authorize?client_id=%s&redirect_uri=%s&scope=read:user",
-		clientID, redirectURI,
-	)
-	http.Redirect(w, r, authURL, http.StatusSeeOther)
+// AuthURL returns GitHub's authorisation page for one sign-in attempt.
+func (p *githubProvider) AuthURL(ctx context.Context, flow oauthFlow) (string, error) {
+	params := url.Values{}
+	params.Set("client_id", p.clientID)
+	params.Set("redirect_uri", p.redirectURI)
+	// user:email lets us see which addresses GitHub has verified
+	params.Set("scope", "read:user user:email")
+	params.Set("state", flow.State)
+	return "https://github.com/login/oauth/authorize?" + params.Encode(), nil
 }
 
-func getGithubAcccessToken(code string) (string, error) {
+func (p *githubProvider) getAccessToken(ctx context.Context, code string) (string, error) {
 	data := url.Values{}
-	data.Set("client_id", clientID)
-	data.Set("client_secret", clientSecret)
+	data.Set("client_id", p.clientID)
+	data.Set("client_secret", p.clientSecret)
 	data.Set("code", code)
-	data.Set("redirect_uri", redirectURI)
+	data.Set("redirect_uri", p.redirectURI)
 
-	resp, err := http.PostForm("https://github.com/login/oauth/access_token", data)
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://github.com/login/oauth/access_token", strings.NewReader(data.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -64,8 +62,8 @@ func getGithubAcccessToken(code string) (string, error) {
 	return values.Get("access_token"), nil
 }
 
-func getGithubUser(token string) (map[string]interface{}, error) {
-	req, err := http.NewRequest("GET", "http://api.github.com/user", nil)
+func getGithubUser(ctx context.Context, token string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/user", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -94,85 +92,91 @@ func getGithubUser(token string) (map[string]interface{}, error) {
 	return userData, nil
 }
 
-func HandleGitHubCallback(w http.ResponseWriter, r *http.Request) {
-	code := r.URL.Query().Get("code")
-	if code == "" {
-		http.Error(w, "Code not found", http.StatusBadRequest)
-		return
+// githubEmail is an address from GitHub's /user/emails.
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+func getGithubEmails(ctx context.Context, token string) ([]githubEmail, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.github.com/user/emails", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub returned %s", resp.Status)
+	}
+
+	var emails []githubEmail
+	if err := json.NewDecoder(resp.Body).Decode(&emails); err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+// pickGithubEmail returns the address to use and whether GitHub verified
+// it: the public address from the profile if it is verified, otherwise the
+// primary address if that is, otherwise the public one unverified.
+func pickGithubEmail(public string, emails []githubEmail) (string, bool) {
+	var primary string
+	for _, e := range emails {
+		if !e.Verified {
+			continue
+		}
+		if public != "" && strings.EqualFold(e.Email, public) {
+			return public, true
+		}
+		if e.Primary {
+			primary = e.Email
+		}
 	}
+	if primary != "" {
+		return primary, true
+	}
+	return public, false
+}
+
+// Exchange trades the callback's code for a token and fetches the user.
+func (p *githubProvider) Exchange(ctx context.Context, code string, flow oauthFlow) (oauthProfile, error) {
 	// exchange access token code
-	token, err := getGithubAcccessToken(code)
+	token, err := p.getAccessToken(ctx, code)
 	if err != nil {
-		http.Error(w, "Failed to get access tokken", http.StatusInternalServerError)
-		log.Println("Error getting token:", err)
-		return
+		return oauthProfile{}, fmt.Errorf("getting token: %v", err)
+	}
+	if token == "" {
+		return oauthProfile{}, fmt.Errorf("GitHub returned no access token")
 	}
 
 	//fetch the user data
-	userData, err := getGithubUser(token)
+	userData, err := getGithubUser(ctx, token)
 	if err != nil {
-		http.Error(w, "Failed to get user data", http.StatusInternalServerError)
-		log.Println("Error getting user data:", err)
-		return
+		return oauthProfile{}, fmt.Errorf("getting user data: %v", err)
 	}
 
-	// userJSON, err := json.MarshalIndent(userData, "", "  ")
-	// if err != nil {
-	// 	http.Error(w, "Failed to encode user data", http.StatusInternalServerError)
-	// 	log.Println("Error encoding JSON:", err)
-	// 	return
-	// }
-
-	// // Print JSON to console
-	// fmt.Println(string(userJSON))
-
 	//extract the info needed
-	username := userData["login"].(string)
-	email := userData["email"]
-	if email == nil {
-		email = ""
-	}
-	profile_pic := userData["avatar_url"].(string)
-	authoriser := "github"
-
-	var userID string
-	err = GlobalDB.QueryRow(`SELECT id FROM users WHERE username = ?`, username).Scan(&userID)
-	if err == sql.ErrNoRows {
-		userID = utils.GenerateId()
-
-		_, err = GlobalDB.Exec(`INSERT INTO users (id, username, email, authoriser, profile_pic) VALUES (?, ?, ?, ?, ?)`, userID, username, email, authoriser, profile_pic)
-		if err != nil {
-			http.Error(w, "Failed to save user", http.StatusInternalServerError)
-			log.Println("Database insert error:", err)
-			return
-		}
-	} else if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
-		log.Println("Database query error:", err)
-		return
+	profile := oauthProfile{Provider: utils.ProviderGitHub}
+	// JSON numbers decode as float64; GitHub IDs fit in one exactly
+	if id, ok := userData["id"].(float64); ok {
+		profile.ProviderUserID = strconv.FormatInt(int64(id), 10)
 	}
-
-	// Create session using UUID
-	sessionToken, err := utils.CreateSession(GlobalDB, userID)
+	profile.Username, _ = userData["login"].(string)
+	public, _ := userData["email"].(string)
+	profile.ProfilePic, _ = userData["avatar_url"].(string)
+	// The profile's public address is not necessarily verified
+	emails, err := getGithubEmails(ctx, token)
 	if err != nil {
-		http.Error(w, "Failed to create session", http.StatusInternalServerError)
-		log.Println("Session creation error:", err)
-		return
-	}
-
-	// Set session cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "session_token",
-		Value:    sessionToken,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   24 * 60 * 60,
-	})
-
-	log.Printf("User %s logged in with session %s", username, sessionToken)
-
-	// Redirect to homepage after login
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+		return oauthProfile{}, fmt.Errorf("getting email addresses: %v", err)
+	}
+	profile.Email, profile.EmailVerified = pickGithubEmail(public, emails)
+
+	return profile, nil
 }
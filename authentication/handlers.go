@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"forum/config"
+	"forum/repository"
+	"forum/utils"
+)
+
+// Handlers serves signing up and in, email verification, password resets
+// and signing in with other providers, and sends the notification digests.
+type Handlers struct {
+	users         repository.UserStore
+	sessions      repository.SessionStore
+	notifications repository.NotificationStore
+	tokens        repository.TokenStore
+	identities    repository.IdentityStore
+
+	mailer        utils.Mailer
+	providers     map[string]provider  // By name
+	providerInfos []utils.ProviderInfo // In the order the sign-in page lists them
+	baseURL       string               // Links in email and OAuth callbacks are built from it
+}
+
+// New returns the handlers for the forum cfg describes, with the sign-in
+// providers that have credentials in cfg enabled.
+func New(stores *repository.Stores, mailer utils.Mailer, cfg config.Config) (*Handlers, error) {
+	h := &Handlers{
+		users:         stores.Users,
+		sessions:      stores.Sessions,
+		notifications: stores.Notifications,
+		tokens:        stores.Tokens,
+		identities:    stores.Identities,
+		mailer:        mailer,
+		baseURL:       cfg.BaseURL,
+	}
+	var err error
+	h.providers, h.providerInfos, err = h.loadProviders(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Providers lists the enabled sign-in providers.
+func (h *Handlers) Providers() []utils.ProviderInfo {
+	return h.providerInfos
+}
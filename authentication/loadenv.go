@@ -1,46 +0,0 @@
-package handlers
-
-import (
-	"bufio"
-	"log"
-	"os"
-	"strings"
-)
-
-// Load environment variables from .env file
-func loadEnv(filename string) {
-	file, err := os.Open(filename)
-	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// Ignore empty lines and comments
-		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Split key and value
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-
-		// Remove surrounding quotes if present
-		value = strings.Trim(value, `"'`)
-
-		// Set environment variable
-		os.Setenv(key, value)
-	}
-
-	if err := scanner.Err(); err != nil {
-		log.Fatalf("Error reading .env file: %v", err)
-	}
-}
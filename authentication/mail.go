@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"forum/utils"
+)
+
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// siteURL returns an absolute link to path. Links in email are built from
+// the configured base URL rather than the request's Host header, which a
+// client controls.
+func (h *Handlers) siteURL(path string, query url.Values) string {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return h.baseURL + path
+}
+
+func (h *Handlers) sendVerificationEmail(userID, email string) error {
+	token, err := h.tokens.Issue(userID, utils.TokenVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	link := h.siteURL("/verify-email", url.Values{"token": {token}})
+	body := fmt.Sprintf(`Welcome to the forum!
+
+Please confirm your email address by opening this link:
+
+%s
+
+The link expires in 24 hours. If you did not sign up, you can ignore this email.
+`, link)
+	return h.mailer.Send(email, "Verify your email address", body)
+}
+
+func (h *Handlers) sendPasswordResetEmail(userID, email string) error {
+	token, err := h.tokens.Issue(userID, utils.TokenResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	link := h.siteURL("/reset-password", url.Values{"token": {token}})
+	body := fmt.Sprintf(`Someone asked to reset the password for your forum account.
+
+To choose a new password, open this link:
+
+%s
+
+The link expires in one hour and can only be used once. If you did not ask
+for a reset, you can ignore this email and your password will not change.
+`, link)
+	return h.mailer.Send(email, "Reset your password", body)
+}
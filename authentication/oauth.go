@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/authentication/oidc"
+	"forum/repository"
+	"forum/utils"
+)
+
+const (
+	oauthStateCookie = "oauth_state"
+	oauthStateTTL    = 10 * time.Minute
+
+	// What the user is doing when they are sent to a provider
+	oauthIntentLogin = "login"
+	oauthIntentLink  = "link"
+)
+
+var errOAuthState = errors.New("OAuth state is missing or does not match")
+
+// oauthProfile is what a provider tells us about the user who signed in.
+type oauthProfile struct {
+	Provider       string
+	ProviderUserID string // Stable ID at the provider, unlike login or email
+	Username       string
+	Email          string
+	EmailVerified  bool
+	ProfilePic     string
+}
+
+// verifiedEmail returns the user's email address if the provider has
+// verified it, and "" otherwise.
+func (p oauthProfile) verifiedEmail() string {
+	if !p.EmailVerified {
+		return ""
+	}
+	return p.Email
+}
+
+// oauthFlow is one sign-in attempt, remembered between sending the user to
+// a provider and the provider sending them back.
+type oauthFlow struct {
+	Provider string
+	Intent   string
+	State    string // Echoed back by the provider, ties the callback to this browser
+	Verifier string // PKCE code verifier
+	Nonce    string // Echoed in OIDC ID tokens, stops token replay
+}
+
+// startOAuth creates a sign-in attempt with fresh random values and
+// remembers it in a short-lived cookie.
+func startOAuth(w http.ResponseWriter, provider, intent string) oauthFlow {
+	flow := oauthFlow{
+		Provider: provider,
+		Intent:   intent,
+		State:    utils.GenerateSessionToken(),
+		Verifier: oidc.NewVerifier(),
+		Nonce:    oidc.NewNonce(),
+	}
+	// None of the values can contain ':', names are checked when loaded
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    strings.Join([]string{flow.Provider, flow.Intent, flow.State, flow.Verifier, flow.Nonce}, ":"),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oauthStateTTL.Seconds()),
+	})
+	return flow
+}
+
+// checkOAuthState verifies the state a provider sent back against the
+// cookie set by startOAuth and returns the attempt. The cookie is cleared
+// so a state can only be used once.
+func checkOAuthState(w http.ResponseWriter, r *http.Request, provider string) (oauthFlow, error) {
+	cookie, err := r.Cookie(oauthStateCookie)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		MaxAge:   -1,
+	})
+	if err != nil {
+		return oauthFlow{}, errOAuthState
+	}
+
+	parts := strings.Split(cookie.Value, ":")
+	if len(parts) != 5 || parts[0] != provider {
+		return oauthFlow{}, errOAuthState
+	}
+	flow := oauthFlow{Provider: parts[0], Intent: parts[1], State: parts[2], Verifier: parts[3], Nonce: parts[4]}
+
+	state := r.URL.Query().Get("state")
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(flow.State)) != 1 {
+		return oauthFlow{}, errOAuthState
+	}
+	return flow, nil
+}
+
+// LinkProvider starts linking a provider to the signed-in user.
+func (h *Handlers) LinkProvider(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+	if h.currentUserID(r) == "" {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+
+	name := r.FormValue("provider")
+	p, ok := h.providers[name]
+	if !ok {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), providerTimeout)
+	defer cancel()
+	redirectToProvider(ctx, w, r, p, startOAuth(w, name, oauthIntentLink))
+}
+
+// UnlinkProvider removes a linked provider from the signed-in user.
+func (h *Handlers) UnlinkProvider(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+	userID := h.currentUserID(r)
+	if userID == "" {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+
+	// Providers no longer configured can still be unlinked
+	provider := r.FormValue("provider")
+	if provider == "" {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	err := h.identities.Unlink(userID, provider)
+	switch err {
+	case nil:
+		http.Redirect(w, r, "/profile/"+userID, http.StatusSeeOther)
+	case utils.ErrLastLoginMethod:
+		utils.RenderErrorPage(w, http.StatusBadRequest, "This is the only way you can sign in. Set a password or link another account first.")
+	case utils.ErrIdentityNotFound:
+		utils.RenderErrorPage(w, http.StatusNotFound, "That account is not linked.")
+	default:
+		log.Printf("Error unlinking %s from user %s: %v", provider, userID, err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+	}
+}
+
+// completeOAuth finishes a provider callback, either linking the provider
+// account to the signed-in user or signing in as the user it belongs to.
+func (h *Handlers) completeOAuth(w http.ResponseWriter, r *http.Request, intent string, profile oauthProfile) {
+	if profile.ProviderUserID == "" {
+		utils.RenderErrorPage(w, http.StatusBadGateway, "The sign-in provider did not return an account ID.")
+		return
+	}
+
+	if intent == oauthIntentLink {
+		userID := h.currentUserID(r)
+		if userID == "" {
+			http.Redirect(w, r, "/signin", http.StatusSeeOther)
+			return
+		}
+
+		err := h.identities.Link(userID, profile.Provider, profile.ProviderUserID, profile.verifiedEmail())
+		switch err {
+		case nil:
+			http.Redirect(w, r, "/profile/"+userID, http.StatusSeeOther)
+		case utils.ErrIdentityTaken:
+			utils.RenderErrorPage(w, http.StatusConflict, "That account is already linked to another user.")
+		case utils.ErrProviderLinked:
+			utils.RenderErrorPage(w, http.StatusConflict, "You already have a different account from this provider linked. Unlink it first.")
+		default:
+			log.Printf("Error linking %s to user %s: %v", profile.Provider, userID, err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		}
+		return
+	}
+
+	userID, err := h.identities.Find(profile.Provider, profile.ProviderUserID)
+	if err == utils.ErrIdentityNotFound {
+		userID, err = h.claimLegacyAccount(profile)
+	}
+	if err == utils.ErrIdentityNotFound {
+		userID, err = h.createOAuthUser(profile)
+	}
+	if err == errEmailInUse {
+		utils.RenderErrorPage(w, http.StatusConflict, "An account with this email address already exists. Sign in with your password and link this account from your profile.")
+		return
+	} else if err != nil {
+		log.Printf("Error signing in with %s: %v", profile.Provider, err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	sessionToken, err := h.sessions.Create(userID, r.UserAgent(), utils.ClientIP(r))
+	if err != nil {
+		log.Println("Session creation error:", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_token",
+		Value:    sessionToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   24 * 60 * 60,
+	})
+
+	log.Printf("User %s logged in with %s", userID, profile.Provider)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// claimLegacyAccount links an account created by this provider before
+// identities were recorded. Only passwordless accounts the provider itself
+// created qualify, so a provider account cannot take over a local one. GitHub
+// accounts were matched by login, which a renamed or deleted account frees
+// for anyone to take, so the verified primary email must match too. Google
+// accounts were matched by verified email.
+func (h *Handlers) claimLegacyAccount(profile oauthProfile) (string, error) {
+	var username, email string
+	switch {
+	case profile.Provider == utils.ProviderGitHub && profile.Username != "" && profile.verifiedEmail() != "":
+		username, email = profile.Username, profile.Email
+	case profile.Provider == utils.ProviderGoogle && profile.Email != "" && profile.EmailVerified:
+		email = profile.Email
+	default:
+		return "", utils.ErrIdentityNotFound
+	}
+
+	userID, err := h.identities.Unclaimed(profile.Provider, username, email)
+	if err != nil {
+		return "", err
+	}
+
+	if err := h.identities.Link(userID, profile.Provider, profile.ProviderUserID, profile.verifiedEmail()); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+var errEmailInUse = errors.New("email address belongs to another account")
+
+// createOAuthUser creates a local user for a provider account and links
+// the two. A taken username gets a numeric suffix; a taken email address is
+// an error, since the owner should link the provider from their account.
+func (h *Handlers) createOAuthUser(profile oauthProfile) (string, error) {
+	// An address the provider has not verified could be anyone's, so it is
+	// neither stored nor allowed to keep its owner from signing up
+	email := profile.verifiedEmail()
+	if email != "" {
+		if _, err := h.users.GetByEmail(email); err == nil {
+			return "", errEmailInUse
+		} else if err != repository.ErrNotFound {
+			return "", err
+		}
+	}
+
+	username, err := h.availableUsername(profile.Username)
+	if err != nil {
+		return "", err
+	}
+
+	// When the provider has vouched for the address the user is not asked
+	// to verify it again
+	user := utils.User{
+		ID:            utils.GenerateId(),
+		UserName:      username,
+		Email:         email,
+		ProfilePic:    sql.NullString{String: profile.ProfilePic, Valid: true},
+		EmailVerified: email != "",
+	}
+	identity := utils.Identity{Provider: profile.Provider, ProviderUserID: profile.ProviderUserID, Email: email}
+	if err := h.identities.CreateUser(user, identity); err != nil {
+		return "", err
+	}
+	return user.ID, nil
+}
+
+// availableUsername returns name, or name with the first free numeric
+// suffix if someone already uses it.
+func (h *Handlers) availableUsername(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "user"
+	}
+
+	candidate := name
+	for i := 2; ; i++ {
+		_, err := h.users.GetByUsername(candidate)
+		if err == repository.ErrNotFound {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+		candidate = name + strconv.Itoa(i)
+	}
+}
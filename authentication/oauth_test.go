@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"forum/config"
+	"forum/repository"
+	"forum/utils"
+)
+
+func TestCheckOAuthState(t *testing.T) {
+	rr := httptest.NewRecorder()
+	state := startOAuth(rr, utils.ProviderGitHub, oauthIntentLink).State
+	cookie := rr.Result().Cookies()[0]
+
+	tests := []struct {
+		name     string
+		provider string
+		state    string
+		cookie   *http.Cookie
+		wantErr  bool
+	}{
+		{"Matching state", utils.ProviderGitHub, state, cookie, false},
+		{"No cookie", utils.ProviderGitHub, state, nil, true},
+		{"Wrong state", utils.ProviderGitHub, "OAUTH_STATE", cookie, true},
+		{"Other provider", utils.ProviderGoogle, state, cookie, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/auth/callback?state="+tt.state, nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			rr := httptest.NewRecorder()
+			flow, err := checkOAuthState(rr, req, tt.provider)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkOAuthState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (flow.Intent != oauthIntentLink || flow.Verifier == "" || flow.Nonce == "") {
+				t.Errorf("checkOAuthState() = %+v", flow)
+			}
+			// The state cookie is always cleared so it cannot be replayed
+			if c := rr.Result().Cookies(); len(c) != 1 || c[0].MaxAge >= 0 {
+				t.Errorf("state cookie was not cleared")
+			}
+		})
+	}
+}
+
+// newTestHandlers returns handlers using an empty database in a temporary
+// directory, and the database.
+func newTestHandlers(t *testing.T) (*Handlers, *sql.DB) {
+	t.Helper()
+	db, err := utils.InitialiseDB(config.Database{Path: filepath.Join(t.TempDir(), "forum.db"), MigrateOnStart: true})
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	h, err := New(repository.NewSQLite(db), &utils.LogMailer{}, config.Default())
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+	return h, db
+}
+
+func TestCompleteOAuth_MatchesByProviderID(t *testing.T) {
+	h, db := newTestHandlers(t)
+
+	// A local account whose username a GitHub user could pick
+	localID := utils.GenerateId()
+	name := "local_" + localID[:8]
+	_, err := db.Exec("INSERT INTO users (id, username, email, password) VALUES (?, ?, ?, 'hash')",
+		localID, name, localID+"@example.com")
+	if err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	signIn := func(profile oauthProfile) string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.completeOAuth(rr, httptest.NewRequest("GET", "/auth/github/callback", nil), oauthIntentLogin, profile)
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("sign-in: got status %d, want %d", rr.Code, http.StatusSeeOther)
+		}
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == "session_token" {
+				userID, err := utils.ValidateSession(db, c.Value)
+				if err != nil {
+					t.Fatalf("invalid session: %v", err)
+				}
+				return userID
+			}
+		}
+		t.Fatal("no session cookie was set")
+		return ""
+	}
+
+	githubID := utils.GenerateId()
+	first := signIn(oauthProfile{Provider: utils.ProviderGitHub, ProviderUserID: githubID, Username: name})
+	if first == localID {
+		t.Fatal("GitHub sign-in took over the local account with the same username")
+	}
+	var username string
+	db.QueryRow("SELECT username FROM users WHERE id = ?", first).Scan(&username)
+	if username == name {
+		t.Errorf("new user got the taken username %q", username)
+	}
+
+	// Renaming on GitHub keeps the same local user
+	if again := signIn(oauthProfile{Provider: utils.ProviderGitHub, ProviderUserID: githubID, Username: "renamed"}); again != first {
+		t.Errorf("second sign-in got user %s, want %s", again, first)
+	}
+
+	// Linking the same GitHub account to the local user is refused
+	if err := utils.LinkIdentity(db, localID, utils.ProviderGitHub, githubID, ""); err != utils.ErrIdentityTaken {
+		t.Errorf("LinkIdentity() error = %v, want ErrIdentityTaken", err)
+	}
+	// The only way the new user can sign in cannot be unlinked
+	if err := utils.UnlinkIdentity(db, first, utils.ProviderGitHub); err != utils.ErrLastLoginMethod {
+		t.Errorf("UnlinkIdentity() error = %v, want ErrLastLoginMethod", err)
+	}
+
+	// Users with a password can link and unlink freely
+	otherID := utils.GenerateId()
+	if err := utils.LinkIdentity(db, localID, utils.ProviderGitHub, otherID, ""); err != nil {
+		t.Fatalf("LinkIdentity() error = %v", err)
+	}
+	if got := signIn(oauthProfile{Provider: utils.ProviderGitHub, ProviderUserID: otherID}); got != localID {
+		t.Errorf("linked sign-in got user %s, want %s", got, localID)
+	}
+	if err := utils.UnlinkIdentity(db, localID, utils.ProviderGitHub); err != nil {
+		t.Errorf("UnlinkIdentity() error = %v", err)
+	}
+}
+
+// fakeProvider signs everyone in as the same account.
+type fakeProvider struct {
+	subject string
+}
+
+func (p *fakeProvider) Info() utils.ProviderInfo {
+	return utils.ProviderInfo{Name: "fake", Label: "Fake"}
+}
+
+func (p *fakeProvider) AuthURL(ctx context.Context, flow oauthFlow) (string, error) {
+	return "https://idp.example.com/authorize?state=" + flow.State, nil
+}
+
+func (p *fakeProvider) Exchange(ctx context.Context, code string, flow oauthFlow) (oauthProfile, error) {
+	return oauthProfile{Provider: "fake", ProviderUserID: p.subject, Username: "fake user"}, nil
+}
+
+func TestHandlers_ProviderAuth(t *testing.T) {
+	h, _ := newTestHandlers(t)
+	h.providers = map[string]provider{"fake": &fakeProvider{subject: utils.GenerateId()}}
+
+	rr := httptest.NewRecorder()
+	h.ProviderAuth(rr, httptest.NewRequest("GET", "/auth/fake", nil))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("start: got status %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	stateCookie := rr.Result().Cookies()[0]
+	location := rr.Header().Get("Location")
+	state := location[len("https://idp.example.com/authorize?state="):]
+
+	// A callback from another browser has no state cookie
+	rr = httptest.NewRecorder()
+	h.ProviderAuth(rr, httptest.NewRequest("GET", "/auth/fake/callback?code=c&state="+state, nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("callback without cookie: got status %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	req := httptest.NewRequest("GET", "/auth/fake/callback?code=c&state="+state, nil)
+	req.AddCookie(stateCookie)
+	rr = httptest.NewRecorder()
+	h.ProviderAuth(rr, req)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/" {
+		t.Fatalf("callback: got %d to %q, want redirect to /", rr.Code, rr.Header().Get("Location"))
+	}
+
+	rr = httptest.NewRecorder()
+	h.ProviderAuth(rr, httptest.NewRequest("GET", "/auth/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown provider: got status %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestCompleteOAuth_UnverifiedEmail(t *testing.T) {
+	h, db := newTestHandlers(t)
+
+	signIn := func(profile oauthProfile) string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.completeOAuth(rr, httptest.NewRequest("GET", "/auth/github/callback", nil), oauthIntentLogin, profile)
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("sign-in: got status %d, want %d", rr.Code, http.StatusSeeOther)
+		}
+		var userID string
+		db.QueryRow("SELECT user_id FROM user_identities WHERE provider = ? AND provider_user_id = ?",
+			profile.Provider, profile.ProviderUserID).Scan(&userID)
+		return userID
+	}
+
+	// Someone claiming a victim's address the provider never verified
+	attacker := signIn(oauthProfile{Provider: utils.ProviderGitHub, ProviderUserID: "1", Username: "mallory", Email: "victim@example.com"})
+	var email, identityEmail sql.NullString
+	var verifiedAt sql.NullTime
+	db.QueryRow("SELECT email, email_verified_at FROM users WHERE id = ?", attacker).Scan(&email, &verifiedAt)
+	db.QueryRow("SELECT email FROM user_identities WHERE user_id = ?", attacker).Scan(&identityEmail)
+	if email.Valid || identityEmail.Valid {
+		t.Errorf("unverified address was stored: user %v, identity %v", email, identityEmail)
+	}
+	if verifiedAt.Valid {
+		t.Errorf("user with an unverified address was marked verified")
+	}
+
+	// The address is still free for its owner
+	owner := signIn(oauthProfile{Provider: utils.ProviderGoogle, ProviderUserID: "2", Username: "victim", Email: "victim@example.com", EmailVerified: true})
+	db.QueryRow("SELECT email, email_verified_at FROM users WHERE id = ?", owner).Scan(&email, &verifiedAt)
+	if email.String != "victim@example.com" || !verifiedAt.Valid {
+		t.Errorf("verified sign-up got email %v, verified %v", email, verifiedAt.Valid)
+	}
+}
+
+func TestCompleteOAuth_LegacyGitHubAccount(t *testing.T) {
+	h, db := newTestHandlers(t)
+
+	// Created by GitHub sign-in before identities were recorded
+	legacyID := utils.GenerateId()
+	_, err := db.Exec("INSERT INTO users (id, username, email, authoriser) VALUES (?, 'octocat', 'octocat@example.com', ?)",
+		legacyID, utils.ProviderGitHub)
+	if err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	signIn := func(profile oauthProfile) string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.completeOAuth(rr, httptest.NewRequest("GET", "/auth/github/callback", nil), oauthIntentLogin, profile)
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("sign-in: got status %d, want %d", rr.Code, http.StatusSeeOther)
+		}
+		var userID string
+		db.QueryRow("SELECT user_id FROM user_identities WHERE provider = ? AND provider_user_id = ?",
+			profile.Provider, profile.ProviderUserID).Scan(&userID)
+		return userID
+	}
+
+	// Whoever holds the login now does not get the account without its
+	// address
+	for _, profile := range []oauthProfile{
+		{Provider: utils.ProviderGitHub, ProviderUserID: "1", Username: "octocat"},
+		{Provider: utils.ProviderGitHub, ProviderUserID: "2", Username: "octocat", Email: "octocat@example.com"},
+		{Provider: utils.ProviderGitHub, ProviderUserID: "3", Username: "octocat", Email: "other@example.com", EmailVerified: true},
+	} {
+		if got := signIn(profile); got == legacyID {
+			t.Errorf("sign-in as %+v claimed the legacy account", profile)
+		}
+	}
+
+	profile := oauthProfile{Provider: utils.ProviderGitHub, ProviderUserID: "4", Username: "octocat", Email: "octocat@example.com", EmailVerified: true}
+	if got := signIn(profile); got != legacyID {
+		t.Errorf("sign-in with the verified address got user %s, want %s", got, legacyID)
+	}
+}
+
+func TestPickGithubEmail(t *testing.T) {
+	emails := []githubEmail{
+		{Email: "old@example.com", Verified: false},
+		{Email: "work@example.com", Verified: true},
+		{Email: "home@example.com", Primary: true, Verified: true},
+	}
+	tests := []struct {
+		name         string
+		public       string
+		emails       []githubEmail
+		want         string
+		wantVerified bool
+	}{
+		{"Verified public address", "work@example.com", emails, "work@example.com", true},
+		{"Unverified public address", "old@example.com", emails, "home@example.com", true},
+		{"No public address", "", emails, "home@example.com", true},
+		{"Nothing verified", "old@example.com", emails[:1], "old@example.com", false},
+		{"Unverified primary", "", []githubEmail{{Email: "new@example.com", Primary: true}}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, verified := pickGithubEmail(tt.public, tt.emails)
+			if got != tt.want || verified != tt.wantVerified {
+				t.Errorf("pickGithubEmail() = %q, %v; want %q, %v", got, verified, tt.want, tt.wantVerified)
+			}
+		})
+	}
+}
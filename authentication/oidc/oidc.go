@@ -0,0 +1,303 @@
+// Package oidc signs users in with any OpenID Connect issuer. It finds the
+// issuer's endpoints through discovery, protects the authorization code with
+// PKCE and verifies the ID token's signature and claims before trusting it.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes one issuer and this forum's client registration with it.
+type Config struct {
+	Issuer       string // e.g. https://accounts.google.com, compared exactly
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // Defaults to openid, profile and email
+	Claims       ClaimMap
+	HTTPClient   *http.Client // Defaults to a client with a 10 second timeout
+}
+
+// ClaimMap names the claims that hold each user detail, since issuers do not
+// agree on them. Empty fields use the standard OIDC claim names.
+type ClaimMap struct {
+	Username      string // Default preferred_username, falling back to name
+	Email         string // Default email
+	EmailVerified string // Default email_verified
+	Picture       string // Default picture
+}
+
+// Identity is the verified user an issuer signed in.
+type Identity struct {
+	Subject       string // The issuer's stable ID for the user
+	Username      string
+	Email         string
+	EmailVerified bool
+	Picture       string
+	Claims        map[string]interface{}
+}
+
+// metadata is the part of the discovery document the flow needs.
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Provider runs the authorization code flow against one issuer. Discovery
+// and signing keys are fetched on first use and cached.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu          sync.Mutex
+	meta        *metadata
+	keys        map[string]interface{} // Public keys by key ID
+	keysFetched time.Time
+}
+
+var (
+	ErrDiscovery    = errors.New("oidc: discovery failed")
+	ErrInvalidToken = errors.New("oidc: invalid ID token")
+)
+
+// timeNow is replaced in tests.
+var timeNow = time.Now
+
+// clockSkew is how far the issuer's clock may be from ours.
+const clockSkew = time.Minute
+
+// New returns a provider for cfg. Nothing is fetched until the first sign-in.
+func New(cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client ID and redirect URL are required")
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{cfg: cfg, client: client}, nil
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() string {
+	return randomString()
+}
+
+// NewNonce returns a random value that ties an ID token to one sign-in.
+func NewNonce() string {
+	return randomString()
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// challenge derives the S256 PKCE code challenge from a verifier.
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// discover fetches and caches the issuer's discovery document.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	var meta metadata
+	if err := p.getJSON(ctx, wellKnown, "", &meta); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	// An issuer may only speak for itself
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrDiscovery, meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: endpoints are missing", ErrDiscovery)
+	}
+
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// AuthCodeURL returns the issuer's sign-in page for one attempt. The state,
+// nonce and verifier must be kept until the callback.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.cfg.ClientID)
+	params.Set("redirect_uri", p.cfg.RedirectURL)
+	params.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", challenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// tokenResponse is the token endpoint's reply, successful or not.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange trades an authorization code for tokens and returns the user the
+// verified ID token describes.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("oidc: token response: %v", err)
+	}
+	if tokens.Error != "" {
+		return nil, fmt.Errorf("oidc: token endpoint: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("oidc: token endpoint returned %d without an ID token", resp.StatusCode)
+	}
+
+	claims, err := p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	// Some issuers keep profile details out of the ID token
+	if meta.UserinfoEndpoint != "" && tokens.AccessToken != "" && p.missingProfileClaims(claims) {
+		var info map[string]interface{}
+		if err := p.getJSON(ctx, meta.UserinfoEndpoint, tokens.AccessToken, &info); err == nil && info["sub"] == claims["sub"] {
+			for k, v := range info {
+				if _, ok := claims[k]; !ok {
+					claims[k] = v
+				}
+			}
+		}
+	}
+
+	return p.identity(claims), nil
+}
+
+func (p *Provider) missingProfileClaims(claims map[string]interface{}) bool {
+	_, hasEmail := claims[orDefault(p.cfg.Claims.Email, "email")]
+	_, hasName := claims[orDefault(p.cfg.Claims.Username, "preferred_username")]
+	return !hasEmail || !hasName
+}
+
+// identity maps claims to an Identity using the configured claim names.
+func (p *Provider) identity(claims map[string]interface{}) *Identity {
+	str := func(name string) string {
+		s, _ := claims[name].(string)
+		return s
+	}
+
+	id := &Identity{
+		Subject:  str("sub"),
+		Username: str(orDefault(p.cfg.Claims.Username, "preferred_username")),
+		Email:    str(orDefault(p.cfg.Claims.Email, "email")),
+		Picture:  str(orDefault(p.cfg.Claims.Picture, "picture")),
+		Claims:   claims,
+	}
+	if id.Username == "" && p.cfg.Claims.Username == "" {
+		id.Username = str("name")
+	}
+
+	// Some issuers send booleans as strings
+	switch v := claims[orDefault(p.cfg.Claims.EmailVerified, "email_verified")].(type) {
+	case bool:
+		id.EmailVerified = v
+	case string:
+		id.EmailVerified = v == "true"
+	}
+	return id
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// getJSON fetches url and decodes its JSON body, with a bearer token if
+// one is given.
+func (p *Provider) getJSON(ctx context.Context, url, bearer string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,293 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockIssuer is a minimal OIDC issuer. Tests stand in for the browser by
+// calling authorize with the URL the provider built.
+type mockIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mu       sync.Mutex
+	codes    map[string]authRequest
+	userinfo map[string]interface{}
+}
+
+type authRequest struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{t: t, key: key, ecKey: ecKey, codes: make(map[string]authRequest)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                           m.server.URL,
+			"authorization_endpoint":           m.server.URL + "/authorize",
+			"token_endpoint":                   m.server.URL + "/token",
+			"userinfo_endpoint":                m.server.URL + "/userinfo",
+			"jwks_uri":                         m.server.URL + "/jwks",
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		}})
+	})
+	mux.HandleFunc("/token", m.handleToken)
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(m.userinfo)
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	req, ok := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	m.mu.Unlock()
+
+	id, secret, _ := r.BasicAuth()
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	switch {
+	case id != "forum" || secret != "secret":
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+	case !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != req.challenge:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+	default:
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access-token",
+			"id_token":     m.sign("RS256", "rsa", req.claims),
+		})
+	}
+}
+
+// authorize plays the user approving the sign-in and returns the code the
+// issuer would send back. Extra claims override the defaults.
+func (m *mockIssuer) authorize(authURL string, extra map[string]interface{}) string {
+	m.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		m.t.Fatalf("authorization request without PKCE: %s", authURL)
+	}
+
+	claims := m.claims(q.Get("nonce"))
+	for k, v := range extra {
+		claims[k] = v
+	}
+	code := NewNonce()
+	m.mu.Lock()
+	m.codes[code] = authRequest{challenge: q.Get("code_challenge"), claims: claims}
+	m.mu.Unlock()
+	return code
+}
+
+func (m *mockIssuer) claims(nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                m.server.URL,
+		"sub":                "user-123",
+		"aud":                "forum",
+		"exp":                now.Add(time.Hour).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+	}
+}
+
+func (m *mockIssuer) sign(alg, kid string, claims map[string]interface{}) string {
+	if alg == "ES256" {
+		return signJWT(alg, kid, m.ecKey, claims)
+	}
+	return signJWT(alg, kid, m.key, claims)
+}
+
+func signJWT(alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, hash[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (m *mockIssuer) provider(t *testing.T, claims ClaimMap) *Provider {
+	t.Helper()
+	p, err := New(Config{
+		Issuer:       m.server.URL,
+		ClientID:     "forum",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8000/auth/mock/callback",
+		Claims:       claims,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestProvider_AuthorizationCodeFlow(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p := issuer.provider(t, ClaimMap{})
+	ctx := context.Background()
+
+	verifier, nonce := NewVerifier(), NewNonce()
+	authURL, err := p.AuthCodeURL(ctx, "state-1", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	if !strings.HasPrefix(authURL, issuer.server.URL+"/authorize?") {
+		t.Errorf("AuthCodeURL() = %s, want the discovered endpoint", authURL)
+	}
+	if u, _ := url.Parse(authURL); u.Query().Get("state") != "state-1" {
+		t.Errorf("state was not passed to the issuer")
+	}
+
+	code := issuer.authorize(authURL, nil)
+	id, err := p.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if id.Subject != "user-123" || id.Username != "alice" || id.Email != "alice@example.com" || !id.EmailVerified {
+		t.Errorf("Exchange() = %+v", id)
+	}
+
+	// A code is bound to the verifier of the request that obtained it
+	code = issuer.authorize(authURL, nil)
+	if _, err := p.Exchange(ctx, code, NewVerifier(), nonce); err == nil {
+		t.Error("Exchange() accepted a code with the wrong PKCE verifier")
+	}
+}
+
+func TestProvider_ClaimMappingAndUserinfo(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.userinfo = map[string]interface{}{"sub": "user-123", "avatar": "https://example.com/a.png", "login": "from-userinfo"}
+	p := issuer.provider(t, ClaimMap{Username: "login", Picture: "avatar", EmailVerified: "verified"})
+	ctx := context.Background()
+
+	verifier, nonce := NewVerifier(), NewNonce()
+	authURL, _ := p.AuthCodeURL(ctx, "state", nonce, verifier)
+	code := issuer.authorize(authURL, map[string]interface{}{"verified": "true"})
+
+	id, err := p.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if id.Username != "from-userinfo" || id.Picture != "https://example.com/a.png" || !id.EmailVerified {
+		t.Errorf("Exchange() = %+v, want claims mapped from userinfo", id)
+	}
+}
+
+func TestProvider_VerifyIDToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p := issuer.provider(t, ClaimMap{})
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	with := func(k string, v interface{}) map[string]interface{} {
+		c := issuer.claims("nonce")
+		c[k] = v
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"Valid RS256", issuer.sign("RS256", "rsa", issuer.claims("nonce")), false},
+		{"Valid ES256", issuer.sign("ES256", "ec", issuer.claims("nonce")), false},
+		{"Audience list", issuer.sign("RS256", "rsa", with("aud", []string{"other", "forum"})), false},
+		{"Wrong nonce", issuer.sign("RS256", "rsa", issuer.claims("replayed")), true},
+		{"Wrong audience", issuer.sign("RS256", "rsa", with("aud", "someone-else")), true},
+		{"Wrong issuer", issuer.sign("RS256", "rsa", with("iss", "https://evil.example.com")), true},
+		{"Expired", issuer.sign("RS256", "rsa", with("exp", time.Now().Add(-time.Hour).Unix())), true},
+		{"Other authorized party", issuer.sign("RS256", "rsa", with("azp", "other")), true},
+		{"No subject", issuer.sign("RS256", "rsa", with("sub", "")), true},
+		{"Unknown key", issuer.sign("RS256", "missing", issuer.claims("nonce")), true},
+		{"Malformed", "not-a-jwt", true},
+		// Signed with someone else's key under the issuer's key ID
+		{"Forged signature", signJWT("RS256", "rsa", other, issuer.claims("nonce")), true},
+		{"Unsigned", signJWT("none", "rsa", nil, issuer.claims("nonce")), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := p.VerifyIDToken(context.Background(), tt.token, "nonce")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyIDToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProvider_DiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	p, _ := New(Config{
+		Issuer:      issuer.server.URL + "/other",
+		ClientID:    "forum",
+		RedirectURL: "http://localhost:8000/auth/mock/callback",
+	})
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", NewVerifier()); err == nil {
+		t.Error("AuthCodeURL() trusted a discovery document for another issuer")
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"forum/utils"
+)
+
+type ForgotPasswordData struct {
+	Email        string
+	Notice       string
+	GeneralError string
+}
+
+type ResetPasswordData struct {
+	Token         string
+	PasswordError string
+	GeneralError  string
+}
+
+// ForgotPassword emails a reset link. The response is the same
+// whether or not the address belongs to an account, so the form cannot be
+// used to find out who is registered.
+func (h *Handlers) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := utils.ParseTemplate(r, "templates/forgot_password.html")
+	if err != nil {
+		log.Printf("Error loading template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		tmpl.Execute(w, ForgotPasswordData{})
+	case http.MethodPost:
+		email := strings.TrimSpace(r.FormValue("email"))
+		if !utils.ValidateEmail(email) {
+			tmpl.Execute(w, ForgotPasswordData{Email: email, GeneralError: "Please enter a valid email address"})
+			return
+		}
+
+		// Accounts created through GitHub or Google have no password to reset
+		user, err := h.users.GetByEmail(email)
+		if err == nil && user.Password != "" {
+			if err := h.sendPasswordResetEmail(user.ID, email); err != nil {
+				log.Printf("Error sending password reset email: %v", err)
+			}
+		}
+
+		tmpl.Execute(w, ForgotPasswordData{
+			Notice: "If an account uses that address, we've sent it a link to reset the password.",
+		})
+	default:
+		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+	}
+}
+
+// ResetPassword sets a new password using a token from a reset email.
+// Changing the password signs the user out everywhere.
+func (h *Handlers) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := utils.ParseTemplate(r, "templates/reset_password.html")
+	if err != nil {
+		log.Printf("Error loading template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
+		return
+	}
+
+	invalidLink := ResetPasswordData{GeneralError: "This reset link is invalid or has expired."}
+
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("token")
+		if _, err := h.tokens.Check(token, utils.TokenResetPassword); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			tmpl.Execute(w, invalidLink)
+			return
+		}
+		tmpl.Execute(w, ResetPasswordData{Token: token})
+	case http.MethodPost:
+		token := r.FormValue("token")
+		password := r.FormValue("password")
+		data := ResetPasswordData{Token: token}
+
+		if !utils.ValidatePassword(password) {
+			data.PasswordError = "Password must be at least 8 characters, comprising of capital and small letters, numbers, and special characters"
+		} else if password != r.FormValue("confirm-password") {
+			data.PasswordError = "Passwords do not match"
+		}
+		if data.PasswordError != "" {
+			tmpl.Execute(w, data)
+			return
+		}
+
+		userID, err := h.tokens.Consume(token, utils.TokenResetPassword)
+		if err == utils.ErrInvalidToken {
+			w.WriteHeader(http.StatusBadRequest)
+			tmpl.Execute(w, invalidLink)
+			return
+		} else if err != nil {
+			log.Printf("Error checking reset token: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+
+		hashedPassword, err := utils.HashPassword(password)
+		if err != nil {
+			log.Printf("Error hashing password: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+
+		// The reset link proves the user controls the address
+		if err := h.users.SetPassword(userID, hashedPassword); err != nil {
+			log.Printf("Error updating password: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+		if err := h.users.MarkEmailVerified(userID); err != nil {
+			log.Printf("Error marking email verified after password reset: %v", err)
+		}
+		if err := h.sessions.DeleteAll(userID); err != nil {
+			log.Printf("Error signing out sessions after password reset: %v", err)
+		}
+
+		http.Redirect(w, r, "/signin?notice=reset", http.StatusSeeOther)
+	default:
+		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+	}
+}
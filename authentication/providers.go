@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/authentication/oidc"
+	"forum/config"
+	"forum/utils"
+)
+
+// provider is a way to sign in with an account elsewhere. GitHub speaks plain
+// OAuth 2; every other provider is an OpenID Connect issuer.
+type provider interface {
+	Info() utils.ProviderInfo
+	// AuthURL returns the provider's sign-in page for one attempt
+	AuthURL(ctx context.Context, flow oauthFlow) (string, error)
+	// Exchange turns the code from the callback into the user's profile
+	Exchange(ctx context.Context, code string, flow oauthFlow) (oauthProfile, error)
+}
+
+// providerTimeout bounds each round of requests to a provider.
+const providerTimeout = 15 * time.Second
+
+// loadProviders enables GitHub and Google when they have a client ID, and
+// every other OpenID Connect issuer in auth.OIDC. Their callbacks are on
+// h.baseURL.
+func (h *Handlers) loadProviders(auth config.Auth) (map[string]provider, []utils.ProviderInfo, error) {
+	loaded := map[string]provider{}
+	var infos []utils.ProviderInfo
+	add := func(p provider) {
+		loaded[p.Info().Name] = p
+		infos = append(infos, p.Info())
+	}
+
+	if gh := auth.GitHub; gh.ClientID != "" {
+		add(&githubProvider{
+			clientID:     gh.ClientID,
+			clientSecret: gh.ClientSecret,
+			redirectURI:  or(gh.RedirectURL, h.siteURL("/auth/github/callback", nil)),
+		})
+	}
+
+	if g := auth.Google; g.ClientID != "" {
+		p, err := newOIDCProvider(utils.ProviderInfo{Name: utils.ProviderGoogle, Label: "Google", Icon: "fab fa-google"}, oidc.Config{
+			Issuer:       "https://accounts.google.com",
+			ClientID:     g.ClientID,
+			ClientSecret: g.ClientSecret,
+			RedirectURL:  or(g.RedirectURL, h.siteURL("/auth/google/callback", nil)),
+			// Google has no preferred_username, so the display name is used
+			Claims: oidc.ClaimMap{Username: "name"},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("google: %v", err)
+		}
+		add(p)
+	}
+
+	for _, c := range auth.OIDC {
+		cfg := oidc.Config{
+			Issuer:       c.Issuer,
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			RedirectURL:  or(c.RedirectURL, h.siteURL("/auth/"+c.Name+"/callback", nil)),
+			Scopes:       c.Scopes,
+			Claims: oidc.ClaimMap{
+				Username:      c.ClaimUsername,
+				Email:         c.ClaimEmail,
+				EmailVerified: c.ClaimEmailVerified,
+				Picture:       c.ClaimPicture,
+			},
+		}
+		info := utils.ProviderInfo{Name: c.Name, Label: or(c.Label, c.Name), Icon: or(c.Icon, "fas fa-key")}
+
+		p, err := newOIDCProvider(info, cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", c.Name, err)
+		}
+		add(p)
+	}
+
+	for _, info := range infos {
+		log.Printf("Sign-in provider enabled: %s", info.Label)
+	}
+	return loaded, infos, nil
+}
+
+// or returns value, or fallback when value is empty.
+func or(value, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+// ProviderAuth serves /auth/{provider}, which starts signing in, and
+// /auth/{provider}/callback, where the provider sends the user back.
+func (h *Handlers) ProviderAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/auth/"), "/")
+	p, ok := h.providers[name]
+	if !ok || (rest != "" && rest != "callback") {
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPageNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), providerTimeout)
+	defer cancel()
+
+	if rest == "" {
+		flow := startOAuth(w, name, oauthIntentLogin)
+		redirectToProvider(ctx, w, r, p, flow)
+		return
+	}
+
+	flow, err := checkOAuthState(w, r, name)
+	if err != nil {
+		log.Printf("%s OAuth state mismatch. Possible CSRF attack.", name)
+		utils.RenderErrorPage(w, http.StatusBadRequest, "This sign-in attempt has expired. Please try again.")
+		return
+	}
+	if e := r.URL.Query().Get("error"); e != "" {
+		log.Printf("%s sign-in failed: %s %s", name, e, r.URL.Query().Get("error_description"))
+		utils.RenderErrorPage(w, http.StatusBadRequest, "Sign-in was cancelled or refused by "+p.Info().Label+".")
+		return
+	}
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		utils.RenderErrorPage(w, http.StatusBadRequest, "Authorization code not found")
+		return
+	}
+
+	profile, err := p.Exchange(ctx, code, flow)
+	if err != nil {
+		log.Printf("Error completing %s sign-in: %v", name, err)
+		utils.RenderErrorPage(w, http.StatusBadGateway, "Could not sign in with "+p.Info().Label+". Please try again.")
+		return
+	}
+	h.completeOAuth(w, r, flow.Intent, profile)
+}
+
+func redirectToProvider(ctx context.Context, w http.ResponseWriter, r *http.Request, p provider, flow oauthFlow) {
+	authURL, err := p.AuthURL(ctx, flow)
+	if err != nil {
+		log.Printf("Error starting %s sign-in: %v", flow.Provider, err)
+		utils.RenderErrorPage(w, http.StatusBadGateway, p.Info().Label+" sign-in is unavailable right now. Please try again later.")
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// oidcProvider adapts an OpenID Connect issuer to the provider interface.
+type oidcProvider struct {
+	info   utils.ProviderInfo
+	issuer *oidc.Provider
+}
+
+func newOIDCProvider(info utils.ProviderInfo, cfg oidc.Config) (*oidcProvider, error) {
+	issuer, err := oidc.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &oidcProvider{info: info, issuer: issuer}, nil
+}
+
+func (p *oidcProvider) Info() utils.ProviderInfo {
+	return p.info
+}
+
+func (p *oidcProvider) AuthURL(ctx context.Context, flow oauthFlow) (string, error) {
+	return p.issuer.AuthCodeURL(ctx, flow.State, flow.Nonce, flow.Verifier)
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code string, flow oauthFlow) (oauthProfile, error) {
+	id, err := p.issuer.Exchange(ctx, code, flow.Verifier, flow.Nonce)
+	if err != nil {
+		return oauthProfile{}, err
+	}
+	return oauthProfile{
+		Provider:       p.info.Name,
+		ProviderUserID: id.Subject,
+		Username:       id.Username,
+		Email:          id.Email,
+		EmailVerified:  id.EmailVerified,
+		ProfilePic:     id.Picture,
+	}, nil
+}
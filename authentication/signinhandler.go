@@ -1,11 +1,11 @@
 package handlers
 
 import (
-	"database/sql"
-	"html/template"
 	"log"
 	"net/http"
+	"time"
 
+	"forum/repository"
 	"forum/utils"
 )
 
@@ -14,16 +14,26 @@ type SignInData struct {
 	UsernameError string
 	PasswordError string
 	GeneralError  string
+	Notice        string
+	Providers     []utils.ProviderInfo
 }
 
-func SignInHandler(w http.ResponseWriter, r *http.Request) {
+// signInNotices are the messages other pages can show on the sign-in page
+// by redirecting with ?notice=<key>.
+var signInNotices = map[string]string{
+	"signup":   "Account created. Check your email for a link to verify your address.",
+	"verified": "Your email address is verified. You can sign in now.",
+	"reset":    "Your password has been changed. Sign in with your new password.",
+}
+
+func (h *Handlers) SignIn(w http.ResponseWriter, r *http.Request) {
 	_, err := r.Cookie("session_token")
 	if err == nil {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	tmpl, err := template.ParseFiles("templates/signin.html")
+	tmpl, err := utils.ParseTemplate(r, "templates/signin.html")
 	if err != nil {
 		utils.RenderErrorPage(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 		log.Printf("Error loading template: %v", err)
@@ -31,12 +41,12 @@ func SignInHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == "GET" {
-		tmpl.Execute(w, nil)
+		tmpl.Execute(w, SignInData{Notice: signInNotices[r.URL.Query().Get("notice")], Providers: h.providerInfos})
 		return
 	}
 
 	if r.Method == "POST" {
-		data := SignInData{}
+		data := SignInData{Providers: h.providerInfos}
 
 		username := r.FormValue("username")
 		password := r.FormValue("password")
@@ -55,23 +65,12 @@ func SignInHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		var user utils.User
-		err := GlobalDB.QueryRow(`
-			SELECT id, password
-			FROM users
-			WHERE username = ?
-		`, username).Scan(&user.ID, &user.Password)
+		user, err := h.users.GetByUsername(username)
 		if err != nil {
-			data := struct {
-				GeneralError string
-				Username     string
-			}{
-				GeneralError: "Invalid username or password",
-				Username:     username,
-			}
-			tmpl, _ := template.ParseFiles("templates/signin.html")
+			data.GeneralError = "Invalid username or password"
+			data.Username = username
 			tmpl.Execute(w, data)
-			if err != sql.ErrNoRows {
+			if err != repository.ErrNotFound {
 				log.Printf("Error querying database: %v", err)
 			}
 			return
@@ -84,7 +83,21 @@ func SignInHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		sessionToken, err := utils.CreateSession(GlobalDB, user.ID)
+		if user.Banned {
+			data.GeneralError = "This account has been banned"
+			data.Username = username
+			tmpl.Execute(w, data)
+			return
+		}
+
+		if user.SuspendedUntil.After(time.Now()) {
+			data.GeneralError = "This account is suspended until " + user.SuspendedUntil.Local().Format("Jan 2, 2006 15:04")
+			data.Username = username
+			tmpl.Execute(w, data)
+			return
+		}
+
+		sessionToken, err := h.sessions.Create(user.ID, r.UserAgent(), utils.ClientIP(r))
 		if err != nil {
 			utils.RenderErrorPage(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
 			return
@@ -94,14 +107,12 @@ func SignInHandler(w http.ResponseWriter, r *http.Request) {
 			Name:     "session_token",
 			Value:    sessionToken,
 			Path:     "/",
-			HttpOnly: false,
+			HttpOnly: true,
 			Secure:   false,
 			SameSite: http.SameSiteLaxMode,
 			MaxAge:   24 * 60 * 60,
 		})
 
-		log.Printf("Set session cookie: %s", sessionToken)
-
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
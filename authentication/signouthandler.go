@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"database/sql"
 	"log"
 	"net/http"
 	"time"
@@ -9,30 +8,37 @@ import (
 	"forum/utils"
 )
 
-func SignOutHandler(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_token")
-		if err != nil {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
-			return
-		}
-
-		_, err = db.Exec("DELETE FROM sessions WHERE id = ?", cookie.Value)
-		if err != nil {
-			log.Printf("Error deleting session from database: %v", err)
-		}
-
-		http.SetCookie(w, &http.Cookie{
-			Name:    "session_token",
-			Value:   "",
-			Expires: time.Now().Add(-1 * time.Hour),
-		})
-
-		if err != nil {
-			utils.RenderErrorPage(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
-			return
-		}
+func (h *Handlers) SignOut(w http.ResponseWriter, r *http.Request) {
+	// Signing out changes state, so it is a POST checked by CSRFProtect
+	if r.Method != http.MethodPost {
+		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
 
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// A session that is no longer valid has nothing left to delete
+	if userID, validErr := h.sessions.Validate(cookie.Value); validErr == nil {
+		err = h.sessions.Delete(userID, cookie.Value)
+	}
+	if err != nil {
+		log.Printf("Error deleting session from database: %v", err)
 	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:    "session_token",
+		Value:   "",
+		Expires: time.Now().Add(-1 * time.Hour),
+	})
+
+	if err != nil {
+		utils.RenderErrorPage(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"database/sql"
 	"html/template"
 	"log"
 	"net/http"
@@ -25,13 +24,7 @@ type SignUpData struct {
 	UserName string
 }
 
-var GlobalDB *sql.DB
-
-func InitDB(database *sql.DB) {
-	GlobalDB = database
-}
-
-func SignUpHandler(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) SignUp(w http.ResponseWriter, r *http.Request) {
 	//Prevent logged-in users from signing up again
 	_, err := r.Cookie("session_token")
 	if err == nil { 
@@ -40,7 +33,7 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == "GET" {
-		tmpl, err := template.ParseFiles("templates/signup.html")
+		tmpl, err := utils.ParseTemplate(r, "templates/signup.html")
 		if err != nil {
 			utils.RenderErrorPage(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 			log.Printf("Error loading template: %v", err)
@@ -84,7 +77,7 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 
 		if hasError {
 			data.Errors = errors
-			tmpl := template.Must(template.ParseFiles("templates/signup.html"))
+			tmpl := template.Must(utils.ParseTemplate(r, "templates/signup.html"))
 			tmpl.Execute(w, data)
 			return
 		}
@@ -93,24 +86,28 @@ func SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			errors.GeneralError = "Internal Server Error"
 			data.Errors = errors
-			tmpl := template.Must(template.ParseFiles("templates/signup.html"))
+			tmpl := template.Must(utils.ParseTemplate(r, "templates/signup.html"))
 			tmpl.Execute(w, data)
 			return
 		}
 
 		id := utils.GenerateId()
 
-		_, err = GlobalDB.Exec(`
-            INSERT INTO users (id, email, username, password)
-            VALUES (?, ?, ?, ?)
-        `, id, data.Email, data.UserName, hashedPassword)
+		err = h.users.Create(utils.User{ID: id, Email: data.Email, UserName: data.UserName, Password: hashedPassword})
 		if err != nil {
 			errors.GeneralError = "Username or email already exists"
 			data.Errors = errors
-			tmpl := template.Must(template.ParseFiles("templates/signup.html"))
+			tmpl := template.Must(utils.ParseTemplate(r, "templates/signup.html"))
 			tmpl.Execute(w, data)
 			return
 		}
+
+		// The account exists either way, the user can ask for a new link
+		if err := h.sendVerificationEmail(id, data.Email); err != nil {
+			log.Printf("Error sending verification email: %v", err)
+		}
+		http.Redirect(w, r, "/signin?notice=signup", http.StatusSeeOther)
+		return
 	}
 
 	http.Redirect(w, r, "/signin", http.StatusSeeOther)
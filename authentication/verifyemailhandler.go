@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"forum/utils"
+)
+
+type VerifyEmailData struct {
+	Email        string
+	Sent         bool
+	GeneralError string
+}
+
+// currentUserID returns the signed-in user, or an empty string.
+func (h *Handlers) currentUserID(r *http.Request) string {
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return ""
+	}
+	userID, err := h.sessions.Validate(cookie.Value)
+	if err != nil {
+		return ""
+	}
+	return userID
+}
+
+// VerifyEmail confirms an email address when given a token. Without
+// one it shows signed-in users how to verify their address.
+func (h *Handlers) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	if token := r.URL.Query().Get("token"); token != "" {
+		userID, err := h.tokens.Consume(token, utils.TokenVerifyEmail)
+		if err == utils.ErrInvalidToken {
+			utils.RenderErrorPage(w, http.StatusBadRequest, "This verification link is invalid or has expired.")
+			return
+		} else if err != nil {
+			log.Printf("Error checking verification token: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+
+		if err := h.users.MarkEmailVerified(userID); err != nil {
+			log.Printf("Error marking email verified: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+		http.Redirect(w, r, "/signin?notice=verified", http.StatusSeeOther)
+		return
+	}
+
+	userID := h.currentUserID(r)
+	if userID == "" {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+
+	user, err := h.users.Get(userID)
+	if err != nil {
+		log.Printf("Error fetching user: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+	if user.EmailVerified {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	data := VerifyEmailData{Email: user.Email, Sent: r.URL.Query().Get("sent") != ""}
+
+	tmpl, err := utils.ParseTemplate(r, "templates/verify_email.html")
+	if err != nil {
+		log.Printf("Error loading template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
+		return
+	}
+	tmpl.Execute(w, data)
+}
+
+// ResendVerification sends a fresh verification link to the signed-in
+// user. Earlier links stop working.
+func (h *Handlers) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+
+	userID := h.currentUserID(r)
+	if userID == "" {
+		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		return
+	}
+
+	user, err := h.users.Get(userID)
+	if err != nil {
+		log.Printf("Error fetching user: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+	if user.EmailVerified {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if user.Email == "" {
+		// The provider did not vouch for one, see createOAuthUser
+		http.Redirect(w, r, "/verify-email", http.StatusSeeOther)
+		return
+	}
+
+	if err := h.sendVerificationEmail(userID, user.Email); err != nil {
+		log.Printf("Error sending verification email: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, "We couldn't send the email. Please try again later.")
+		return
+	}
+	http.Redirect(w, r, "/verify-email?sent=1", http.StatusSeeOther)
+}
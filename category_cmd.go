@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"forum/repository"
+)
+
+const categoryUsage = `Usage: go run . category <command>
+  list
+  add <name>
+  rename <name> <new name>
+  merge <name> <into>              file its posts under <into> and delete it`
+
+// categoryCommand manages the categories posts are filed under.
+func categoryCommand(stores *repository.Stores, args []string, in io.Reader, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(categoryUsage)
+	}
+	command, args := args[0], args[1:]
+
+	switch {
+	case command == "list" && len(args) == 0:
+		categories, err := stores.Categories.List()
+		if err != nil {
+			return err
+		}
+		for _, c := range categories {
+			fmt.Fprintln(out, c.Name)
+		}
+		return nil
+	case command == "add" && len(args) == 1:
+		name := strings.TrimSpace(args[0])
+		if name == "" {
+			return errors.New("the category name is empty")
+		}
+		if err := stores.Categories.Create(name); err != nil {
+			return fmt.Errorf("adding %s: %v", name, err)
+		}
+		fmt.Fprintf(out, "Added %s\n", name)
+		return nil
+	case command == "rename" && len(args) == 2:
+		id, err := categoryID(stores, args[0])
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSpace(args[1])
+		if name == "" {
+			return errors.New("the new name is empty")
+		}
+		if err := stores.Categories.Rename(id, name); err != nil {
+			return fmt.Errorf("renaming %s: %v", args[0], err)
+		}
+		fmt.Fprintf(out, "Renamed %s to %s\n", args[0], name)
+		return nil
+	case command == "merge" && len(args) == 2:
+		fromID, err := categoryID(stores, args[0])
+		if err != nil {
+			return err
+		}
+		intoID, err := categoryID(stores, args[1])
+		if err != nil {
+			return err
+		}
+		if err := stores.Categories.Merge(fromID, intoID); err != nil {
+			return fmt.Errorf("merging %s into %s: %v", args[0], args[1], err)
+		}
+		fmt.Fprintf(out, "Merged %s into %s\n", args[0], args[1])
+		return nil
+	}
+	return errors.New(categoryUsage)
+}
+
+func categoryID(stores *repository.Stores, name string) (int, error) {
+	id, err := stores.Categories.IDByName(name)
+	if err == repository.ErrNotFound {
+		return 0, fmt.Errorf("no category named %q", name)
+	}
+	return id, err
+}
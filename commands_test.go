@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"forum/repository"
+	"forum/repository/memory"
+	"forum/utils"
+)
+
+// runCommand runs an administration command with the given standard input
+// and returns what it printed.
+func runCommand(t *testing.T, run func(*repository.Stores, []string, io.Reader, io.Writer) error, stores *repository.Stores, input string, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := run(stores, args, strings.NewReader(input), &out)
+	return out.String(), err
+}
+
+func TestUserCommand(t *testing.T) {
+	stores := memory.New()
+
+	out, err := runCommand(t, userCommand, stores, "", "create", "-role", "moderator", "alice", "alice@example.com")
+	if err != nil {
+		t.Fatalf("user create returned error: %v", err)
+	}
+	if !strings.Contains(out, "Password: ") {
+		t.Errorf("user create did not print the generated password:\n%s", out)
+	}
+	alice, err := stores.Users.GetByUsername("alice")
+	if err != nil || alice.Role != utils.RoleModerator || !alice.EmailVerified {
+		t.Fatalf("created user = %+v, %v; want a verified moderator", alice, err)
+	}
+
+	if _, err := runCommand(t, userCommand, stores, "weak\n", "reset-password", "-password-stdin", "alice"); err == nil {
+		t.Errorf("reset-password accepted a weak password")
+	}
+	stores.Sessions.Create(alice.ID, "laptop", "127.0.0.1")
+	if _, err := runCommand(t, userCommand, stores, "N3w-Passw0rd\n", "reset-password", "-password-stdin", "alice"); err != nil {
+		t.Fatalf("reset-password returned error: %v", err)
+	}
+	alice, _ = stores.Users.GetByUsername("alice")
+	if !utils.CheckPasswordsHash("N3w-Passw0rd", alice.Password) {
+		t.Errorf("reset-password did not set the password read from input")
+	}
+	if sessions, _ := stores.Sessions.List(alice.ID); len(sessions) != 0 {
+		t.Errorf("reset-password left %d sessions", len(sessions))
+	}
+
+	if _, err := runCommand(t, userCommand, stores, "", "promote", "alice"); err != nil {
+		t.Fatalf("promote returned error: %v", err)
+	}
+	if role, _ := stores.Users.Role(alice.ID); role != utils.RoleAdmin {
+		t.Errorf("role after promote = %s, want admin", role)
+	}
+	if _, err := runCommand(t, userCommand, stores, "", "promote", "alice", "emperor"); err == nil {
+		t.Errorf("promote accepted an unknown role")
+	}
+	if _, err := runCommand(t, userCommand, stores, "", "ban", "alice"); err != nil {
+		t.Fatalf("ban returned error: %v", err)
+	}
+	if user, _ := stores.Users.Get(alice.ID); !user.Banned {
+		t.Errorf("user is not banned after ban")
+	}
+
+	for _, args := range [][]string{
+		{"ban", "nobody"},
+		{"create", "alice", "other@example.com"},
+		{"create", "bob", "not an address"},
+		{"delete", "alice"},
+		{},
+	} {
+		if _, err := runCommand(t, userCommand, stores, "", args...); err == nil {
+			t.Errorf("user %q did not fail", args)
+		}
+	}
+}
+
+func TestCategoryCommand(t *testing.T) {
+	stores := memory.New()
+	for _, args := range [][]string{
+		{"add", "Recipes"},
+		{"add", "Cooking"},
+		{"rename", "Cooking", "Food"},
+		{"merge", "Recipes", "Food"},
+	} {
+		if _, err := runCommand(t, categoryCommand, stores, "", args...); err != nil {
+			t.Fatalf("category %q returned error: %v", args, err)
+		}
+	}
+	out, err := runCommand(t, categoryCommand, stores, "", "list")
+	if err != nil || out != "Food\n" {
+		t.Errorf("category list = %q, %v; want only Food", out, err)
+	}
+	if _, err := runCommand(t, categoryCommand, stores, "", "merge", "Missing", "Food"); err == nil {
+		t.Errorf("merge of a missing category did not fail")
+	}
+}
+
+func TestSeedCommand(t *testing.T) {
+	stores := memory.New()
+	if _, err := runCommand(t, categoryCommand, stores, "", "add", "General"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runCommand(t, seedCommand, stores, "", "-users", "3", "-posts", "4")
+	if err != nil {
+		t.Fatalf("seed returned error: %v", err)
+	}
+	if !strings.Contains(out, "Created 3 demo users, 4 posts") {
+		t.Errorf("seed printed %q", out)
+	}
+	users, _ := stores.Users.List()
+	if len(users) != 3 {
+		t.Errorf("seed created %d users, want 3", len(users))
+	}
+
+	// A second run reuses the demo users
+	if out, err := runCommand(t, seedCommand, stores, "", "-users", "3", "-posts", "1"); err != nil || !strings.Contains(out, "Created 0 demo users") {
+		t.Errorf("second seed = %q, %v; want the users reused", out, err)
+	}
+}
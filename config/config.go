@@ -0,0 +1,237 @@
+// Package config loads the forum's settings. Every setting has an
+// environment variable and can also be given in a config file of KEY=VALUE
+// lines using the same names; the most common ones also have a flag. Flags
+// win over the environment, which wins over the file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is every setting of the forum.
+type Config struct {
+	Addr    string // Address the server listens on, e.g. ":8000"
+	BaseURL string // Public URL of the forum, used for links in email and OAuth callbacks
+
+	Server        Server
+	Log           Log
+	Database      Database
+	Uploads       Uploads
+	Mail          Mail
+	Auth          Auth
+	Notifications Notifications
+
+	MaxCommentDepth int // Levels of replies nested before replies go flat
+}
+
+// Server limits how long requests may take.
+type Server struct {
+	ReadTimeout  time.Duration // Reading a whole request, including an upload
+	WriteTimeout time.Duration // Writing a response; notification streams are exempt
+	IdleTimeout  time.Duration // Keeping an idle keep-alive connection open
+	// ShutdownTimeout is how long requests in flight and background jobs
+	// get to finish once the server is asked to stop.
+	ShutdownTimeout time.Duration
+}
+
+// Log configures the log, which goes to standard error.
+type Log struct {
+	Format string // "text" or "json"
+	Level  string // "debug", "info", "warn" or "error"
+}
+
+// Database is where the forum keeps its data.
+type Database struct {
+	Path string
+	// MigrateOnStart applies pending migrations when the server starts.
+	// Otherwise the server refuses to start until they have been applied.
+	MigrateOnStart bool
+	// CounterCheckInterval is how often the like, dislike and comment
+	// counts stored on posts and comments are checked and repaired.
+	CounterCheckInterval time.Duration
+}
+
+// Uploads is where uploaded images are kept and how unused ones are
+// cleaned up.
+type Uploads struct {
+	Backend string // "local" or "s3"
+	Dir     string // Directory of the local backend
+	S3      S3
+
+	CleanupInterval time.Duration
+	CleanupGrace    time.Duration // Unreferenced uploads younger than this are kept
+	CleanupDryRun   bool
+}
+
+// S3 configures the S3 upload backend.
+type S3 struct {
+	Endpoint  string // Host and optional port, e.g. s3.amazonaws.com or localhost:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	Proxy     bool          // Serve files through the forum instead of signed URLs
+	URLExpiry time.Duration // Lifetime of signed URLs
+}
+
+// Mail configures outgoing email. Without an SMTP host, messages are
+// written to LogFile, or to the log when that is empty. The log redacts
+// the tokens in links, so LogFile is set by default.
+type Mail struct {
+	From         string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	LogFile      string
+}
+
+// Auth configures accounts and signing in.
+type Auth struct {
+	RequireEmailVerification bool
+	AdminUsernames           []string // Promoted to admin on start
+
+	GitHub Provider // Enabled when it has a client ID
+	Google Provider // Enabled when it has a client ID
+	OIDC   []Provider
+}
+
+// Provider is an OAuth or OpenID Connect sign-in provider. Fields left
+// empty take the provider's defaults.
+type Provider struct {
+	Name         string
+	Label        string
+	Icon         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	ClaimUsername      string
+	ClaimEmail         string
+	ClaimEmailVerified string
+	ClaimPicture       string
+}
+
+// Notifications configures notification email.
+type Notifications struct {
+	DigestInterval time.Duration
+}
+
+// Default returns the settings used when nothing is configured.
+func Default() Config {
+	return Config{
+		Addr:    ":8000",
+		BaseURL: "http://localhost:8000",
+		Server: Server{
+			ReadTimeout:     2 * time.Minute,
+			WriteTimeout:    2 * time.Minute,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Log: Log{
+			Format: "text",
+			Level:  "info",
+		},
+		Database: Database{
+			Path:                 "forum.db",
+			MigrateOnStart:       true,
+			CounterCheckInterval: 24 * time.Hour,
+		},
+		Uploads: Uploads{
+			Backend:         "local",
+			Dir:             "static/uploads",
+			S3:              S3{UseSSL: true, URLExpiry: time.Hour},
+			CleanupInterval: 6 * time.Hour,
+			CleanupGrace:    24 * time.Hour,
+		},
+		Mail: Mail{
+			From:     "forum@localhost",
+			SMTPPort: "587",
+			LogFile:  "mail.log",
+		},
+		Notifications: Notifications{
+			DigestInterval: 24 * time.Hour,
+		},
+		MaxCommentDepth: 5,
+	}
+}
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Validate reports every problem with the settings at once.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if _, port, err := net.SplitHostPort(c.Addr); err != nil || port == "" {
+		errs = append(errs, fmt.Errorf("ADDR %q is not a host and port, e.g. :8000", c.Addr))
+	}
+	if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("BASE_URL %q is not an http or https URL", c.BaseURL))
+	}
+	check(c.Server.ReadTimeout > 0, "READ_TIMEOUT must be positive")
+	check(c.Server.WriteTimeout > 0, "WRITE_TIMEOUT must be positive")
+	check(c.Server.IdleTimeout > 0, "IDLE_TIMEOUT must be positive")
+	check(c.Server.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "LOG_FORMAT %q is not text or json", c.Log.Format)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "LOG_LEVEL %q is not debug, info, warn or error", c.Log.Level)
+	check(c.Database.Path != "", "DB_PATH is empty")
+	check(c.Database.CounterCheckInterval > 0, "COUNTER_CHECK_INTERVAL must be positive")
+	check(c.MaxCommentDepth > 0, "MAX_COMMENT_DEPTH must be at least 1")
+	check(c.Notifications.DigestInterval > 0, "NOTIFICATION_DIGEST_INTERVAL must be positive")
+
+	u := c.Uploads
+	switch u.Backend {
+	case "local":
+		check(u.Dir != "", "UPLOAD_DIR is empty")
+	case "s3":
+		check(u.S3.Endpoint != "" && u.S3.Bucket != "", "the s3 upload backend needs S3_ENDPOINT and S3_BUCKET")
+		check(u.S3.URLExpiry > 0, "S3_URL_EXPIRY must be positive")
+	default:
+		errs = append(errs, fmt.Errorf("UPLOAD_BACKEND %q is not local or s3", u.Backend))
+	}
+	check(u.CleanupInterval > 0, "UPLOAD_CLEANUP_INTERVAL must be positive")
+	check(u.CleanupGrace >= 0, "UPLOAD_CLEANUP_GRACE must not be negative")
+
+	if c.Mail.SMTPHost != "" {
+		port, err := strconv.Atoi(c.Mail.SMTPPort)
+		check(err == nil && port > 0 && port < 65536, "SMTP_PORT %q is not a port number", c.Mail.SMTPPort)
+	}
+
+	seen := map[string]bool{"github": c.Auth.GitHub.ClientID != "", "google": c.Auth.Google.ClientID != ""}
+	for _, p := range c.Auth.OIDC {
+		prefix := oidcPrefix(p.Name)
+		switch {
+		case !providerNamePattern.MatchString(p.Name) || p.Name == "link" || p.Name == "unlink":
+			errs = append(errs, fmt.Errorf("invalid provider name %q in OIDC_PROVIDERS", p.Name))
+		case seen[p.Name]:
+			errs = append(errs, fmt.Errorf("provider %q is configured twice", p.Name))
+		case p.Issuer == "" || p.ClientID == "":
+			errs = append(errs, fmt.Errorf("provider %q needs %sISSUER and %sCLIENT_ID", p.Name, prefix, prefix))
+		}
+		seen[p.Name] = true
+	}
+
+	return errors.Join(errs...)
+}
+
+// oidcPrefix is the start of the names of an OIDC provider's settings.
+func oidcPrefix(name string) string {
+	return "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forum.env")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, `
+# Settings for tests
+ADDR=:9000
+DB_PATH="file.db"
+MAX_COMMENT_DEPTH=3
+ADMIN_USERNAMES=alice, bob
+`)
+	t.Setenv("DB_PATH", "env.db")
+	t.Setenv("NOTIFICATION_DIGEST_INTERVAL", "1h")
+
+	cfg, rest, err := Load([]string{"-config", path, "-addr", ":7000", "migrate", "up"})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.Addr != ":7000" {
+		t.Errorf("Addr = %q, want the flag's :7000", cfg.Addr)
+	}
+	if cfg.Database.Path != "env.db" {
+		t.Errorf("Database.Path = %q, want the environment's env.db", cfg.Database.Path)
+	}
+	if cfg.MaxCommentDepth != 3 {
+		t.Errorf("MaxCommentDepth = %d, want the file's 3", cfg.MaxCommentDepth)
+	}
+	if cfg.Notifications.DigestInterval != time.Hour {
+		t.Errorf("DigestInterval = %v, want 1h", cfg.Notifications.DigestInterval)
+	}
+	if len(cfg.Auth.AdminUsernames) != 2 || cfg.Auth.AdminUsernames[1] != "bob" {
+		t.Errorf("AdminUsernames = %q, want alice and bob", cfg.Auth.AdminUsernames)
+	}
+	if cfg.Uploads.Dir != Default().Uploads.Dir || !cfg.Database.MigrateOnStart {
+		t.Errorf("unset settings did not keep their defaults: %+v", cfg)
+	}
+	if len(rest) != 2 || rest[0] != "migrate" {
+		t.Errorf("remaining arguments = %q, want migrate up", rest)
+	}
+}
+
+func TestLoad_ConfigFile(t *testing.T) {
+	// Without -config a missing .env is fine, the working directory of the
+	// tests has none
+	if _, _, err := Load(nil); err != nil {
+		t.Errorf("Load without a config file returned error: %v", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.env")
+	if _, _, err := Load([]string{"-config", missing}); err == nil {
+		t.Errorf("Load accepted a missing -config file")
+	}
+	t.Setenv("FORUM_CONFIG", missing)
+	if _, _, err := Load(nil); err == nil {
+		t.Errorf("Load accepted a missing FORUM_CONFIG file")
+	}
+
+	bad := writeFile(t, "ADDR=:8000\nnot a setting\n")
+	if _, _, err := Load([]string{"-config", bad}); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("Load of a malformed file returned %v, want the line number", err)
+	}
+}
+
+func TestLoad_ReportsEveryProblem(t *testing.T) {
+	t.Setenv("ADDR", "8000")
+	t.Setenv("BASE_URL", "forum.example.com")
+	t.Setenv("MAX_COMMENT_DEPTH", "deep")
+	t.Setenv("UPLOAD_BACKEND", "ftp")
+	t.Setenv("REQUIRE_EMAIL_VERIFICATION", "maybe")
+	t.Setenv("UPLOAD_CLEANUP_GRACE", "a day")
+	t.Setenv("SHUTDOWN_TIMEOUT", "soon")
+	t.Setenv("OIDC_PROVIDERS", "keycloak,Bad_Name")
+
+	_, _, err := Load(nil)
+	if err == nil {
+		t.Fatal("Load accepted invalid settings")
+	}
+	for _, want := range []string{
+		"ADDR", "BASE_URL", "MAX_COMMENT_DEPTH", "UPLOAD_BACKEND", "REQUIRE_EMAIL_VERIFICATION",
+		"UPLOAD_CLEANUP_GRACE", "SHUTDOWN_TIMEOUT", "OIDC_KEYCLOAK_ISSUER", `"bad_name"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		valid  bool
+	}{
+		{"defaults", func(c *Config) {}, true},
+		{"s3 without a bucket", func(c *Config) { c.Uploads.Backend = "s3"; c.Uploads.S3.Endpoint = "localhost:9000" }, false},
+		{"s3", func(c *Config) {
+			c.Uploads.Backend = "s3"
+			c.Uploads.S3.Endpoint, c.Uploads.S3.Bucket = "localhost:9000", "forum"
+		}, true},
+		{"bad SMTP port", func(c *Config) { c.Mail.SMTPHost, c.Mail.SMTPPort = "smtp.example.com", "smtp" }, false},
+		{"empty database path", func(c *Config) { c.Database.Path = "" }, false},
+		{"no write timeout", func(c *Config) { c.Server.WriteTimeout = 0 }, false},
+		{"no counter check interval", func(c *Config) { c.Database.CounterCheckInterval = 0 }, false},
+		{"json logs at debug level", func(c *Config) { c.Log.Format, c.Log.Level = "json", "DEBUG" }, true},
+		{"unknown log format", func(c *Config) { c.Log.Format = "xml" }, false},
+		{"unknown log level", func(c *Config) { c.Log.Level = "loud" }, false},
+		{"negative shutdown timeout", func(c *Config) { c.Server.ShutdownTimeout = -time.Second }, false},
+		{"OIDC provider named like a route", func(c *Config) {
+			c.Auth.OIDC = []Provider{{Name: "link", Issuer: "https://id.example.com", ClientID: "forum"}}
+		}, false},
+		{"OIDC provider named like GitHub", func(c *Config) {
+			c.Auth.GitHub.ClientID = "forum"
+			c.Auth.OIDC = []Provider{{Name: "github", Issuer: "https://id.example.com", ClientID: "forum"}}
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.change(&cfg)
+			if err := cfg.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flagSettings are the settings that can also be given as flags.
+var flagSettings = []struct{ name, key, usage string }{
+	{"addr", "ADDR", "address to listen on (default :8000)"},
+	{"base-url", "BASE_URL", "public URL of the forum (default http://localhost:8000)"},
+	{"db", "DB_PATH", "path of the SQLite database (default forum.db)"},
+	{"upload-dir", "UPLOAD_DIR", "directory of the local upload backend (default static/uploads)"},
+}
+
+// Load reads the settings from the flags at the start of args, the
+// environment and the config file, and validates them. The file is the one
+// named by -config or FORUM_CONFIG, which must exist, or else .env if there
+// is one. The arguments after the flags are returned with the settings.
+func Load(args []string) (Config, []string, error) {
+	flags := flag.NewFlagSet("forum", flag.ContinueOnError)
+	configFile := flags.String("config", "", "file of KEY=VALUE settings (default .env if it exists)")
+	for _, s := range flagSettings {
+		flags.String(s.name, "", s.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	src := source{flags: map[string]string{}}
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range flagSettings {
+			if f.Name == s.name {
+				src.flags[s.key] = f.Value.String()
+			}
+		}
+	})
+
+	path, required := *configFile, true
+	if path == "" {
+		path = os.Getenv("FORUM_CONFIG")
+	}
+	if path == "" {
+		path, required = ".env", false
+	}
+	file, err := readFile(path)
+	if err != nil && (required || !errors.Is(err, fs.ErrNotExist)) {
+		return Config{}, nil, fmt.Errorf("reading config file: %v", err)
+	}
+	src.file = file
+
+	cfg := src.config()
+	if err := errors.Join(errors.Join(src.errs...), cfg.Validate()); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
+
+// readFile reads a file of KEY=VALUE lines. Blank lines and lines starting
+// with # are skipped, and quotes around values are removed.
+func readFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("%s:%d: want KEY=VALUE", path, n)
+		}
+		values[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// source looks settings up in the flags, the environment and the config
+// file, in that order, and collects the values it cannot parse.
+type source struct {
+	flags map[string]string
+	file  map[string]string
+	errs  []error
+}
+
+func (s *source) lookup(key string) (string, bool) {
+	if v, ok := s.flags[key]; ok {
+		return v, true
+	}
+	if v, ok := os.LookupEnv(key); ok {
+		return v, true
+	}
+	v, ok := s.file[key]
+	return v, ok
+}
+
+func (s *source) string(key, fallback string) string {
+	if v, ok := s.lookup(key); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+func (s *source) bool(key string, fallback bool) bool {
+	v := s.string(key, "")
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		s.errs = append(s.errs, fmt.Errorf("%s %q is not true or false", key, v))
+		return fallback
+	}
+	return b
+}
+
+func (s *source) int(key string, fallback int) int {
+	v := s.string(key, "")
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		s.errs = append(s.errs, fmt.Errorf("%s %q is not a whole number", key, v))
+		return fallback
+	}
+	return n
+}
+
+func (s *source) duration(key string, fallback time.Duration) time.Duration {
+	v := s.string(key, "")
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		s.errs = append(s.errs, fmt.Errorf("%s %q is not a duration such as 90s, 30m or 24h", key, v))
+		return fallback
+	}
+	return d
+}
+
+// list splits a comma-separated setting, dropping empty entries.
+func (s *source) list(key string) []string {
+	var items []string
+	for _, item := range strings.Split(s.string(key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (s *source) config() Config {
+	d := Default()
+	return Config{
+		Addr:    s.string("ADDR", d.Addr),
+		BaseURL: strings.TrimRight(s.string("BASE_URL", d.BaseURL), "/"),
+		Server: Server{
+			ReadTimeout:     s.duration("READ_TIMEOUT", d.Server.ReadTimeout),
+			WriteTimeout:    s.duration("WRITE_TIMEOUT", d.Server.WriteTimeout),
+			IdleTimeout:     s.duration("IDLE_TIMEOUT", d.Server.IdleTimeout),
+			ShutdownTimeout: s.duration("SHUTDOWN_TIMEOUT", d.Server.ShutdownTimeout),
+		},
+		Log: Log{
+			Format: s.string("LOG_FORMAT", d.Log.Format),
+			Level:  s.string("LOG_LEVEL", d.Log.Level),
+		},
+		Database: Database{
+			Path:                 s.string("DB_PATH", d.Database.Path),
+			MigrateOnStart:       s.bool("MIGRATE_ON_START", d.Database.MigrateOnStart),
+			CounterCheckInterval: s.duration("COUNTER_CHECK_INTERVAL", d.Database.CounterCheckInterval),
+		},
+		Uploads: Uploads{
+			Backend: s.string("UPLOAD_BACKEND", d.Uploads.Backend),
+			Dir:     s.string("UPLOAD_DIR", d.Uploads.Dir),
+			S3: S3{
+				Endpoint:  s.string("S3_ENDPOINT", ""),
+				Region:    s.string("S3_REGION", ""),
+				Bucket:    s.string("S3_BUCKET", ""),
+				AccessKey: s.string("S3_ACCESS_KEY", ""),
+				SecretKey: s.string("S3_SECRET_KEY", ""),
+				UseSSL:    s.bool("S3_USE_SSL", d.Uploads.S3.UseSSL),
+				Proxy:     s.bool("S3_PROXY", d.Uploads.S3.Proxy),
+				URLExpiry: s.duration("S3_URL_EXPIRY", d.Uploads.S3.URLExpiry),
+			},
+			CleanupInterval: s.duration("UPLOAD_CLEANUP_INTERVAL", d.Uploads.CleanupInterval),
+			CleanupGrace:    s.duration("UPLOAD_CLEANUP_GRACE", d.Uploads.CleanupGrace),
+			CleanupDryRun:   s.bool("UPLOAD_CLEANUP_DRY_RUN", d.Uploads.CleanupDryRun),
+		},
+		Mail: Mail{
+			From:         s.string("MAIL_FROM", d.Mail.From),
+			SMTPHost:     s.string("SMTP_HOST", ""),
+			SMTPPort:     s.string("SMTP_PORT", d.Mail.SMTPPort),
+			SMTPUsername: s.string("SMTP_USERNAME", ""),
+			SMTPPassword: s.string("SMTP_PASSWORD", ""),
+			LogFile:      s.string("MAIL_LOG_FILE", d.Mail.LogFile),
+		},
+		Auth: Auth{
+			RequireEmailVerification: s.bool("REQUIRE_EMAIL_VERIFICATION", d.Auth.RequireEmailVerification),
+			AdminUsernames:           s.list("ADMIN_USERNAMES"),
+			GitHub:                   s.provider("github", "GITHUB_"),
+			Google:                   s.provider("google", "GOOGLE_"),
+			OIDC:                     s.oidcProviders(),
+		},
+		Notifications: Notifications{
+			DigestInterval: s.duration("NOTIFICATION_DIGEST_INTERVAL", d.Notifications.DigestInterval),
+		},
+		MaxCommentDepth: s.int("MAX_COMMENT_DEPTH", d.MaxCommentDepth),
+	}
+}
+
+// provider reads the settings of a sign-in provider, which start with prefix.
+func (s *source) provider(name, prefix string) Provider {
+	return Provider{
+		Name:               name,
+		Label:              s.string(prefix+"LABEL", ""),
+		Icon:               s.string(prefix+"ICON", ""),
+		Issuer:             s.string(prefix+"ISSUER", ""),
+		ClientID:           s.string(prefix+"CLIENT_ID", ""),
+		ClientSecret:       s.string(prefix+"CLIENT_SECRET", ""),
+		RedirectURL:        s.string(prefix+"REDIRECT_URI", ""),
+		Scopes:             strings.Fields(s.string(prefix+"SCOPES", "")),
+		ClaimUsername:      s.string(prefix+"CLAIM_USERNAME", ""),
+		ClaimEmail:         s.string(prefix+"CLAIM_EMAIL", ""),
+		ClaimEmailVerified: s.string(prefix+"CLAIM_EMAIL_VERIFIED", ""),
+		ClaimPicture:       s.string(prefix+"CLAIM_PICTURE", ""),
+	}
+}
+
+// oidcProviders reads the OpenID Connect issuers named in OIDC_PROVIDERS,
+// e.g. "keycloak,gitlab", each configured by OIDC_KEYCLOAK_ISSUER,
+// OIDC_KEYCLOAK_CLIENT_ID and so on.
+func (s *source) oidcProviders() []Provider {
+	var list []Provider
+	for _, name := range s.list("OIDC_PROVIDERS") {
+		name = strings.ToLower(name)
+		list = append(list, s.provider(name, oidcPrefix(name)))
+	}
+	return list
+}
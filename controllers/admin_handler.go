@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+// AdminHandler serves the staff panel for managing roles, bans and categories.
+type AdminHandler struct {
+	authenticator
+	stores *repository.Stores
+}
+
+func NewAdminHandler(stores *repository.Stores) *AdminHandler {
+	return &AdminHandler{authenticator: newAuthenticator(stores), stores: stores}
+}
+
+type adminPageData struct {
+	Users         []utils.User
+	Categories    []utils.Category
+	Roles         []string
+	CurrentUserID string
+	CurrentRole   string
+	IsLoggedIn    bool
+	CanManage     bool // Role may change roles
+	CanCategorise bool // Role may manage categories
+}
+
+func (ah *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/admin":
+		if r.Method == http.MethodGet {
+			ah.requirePermission(utils.PermBanUsers, ah.handleAdminPage).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/admin/role":
+		if r.Method == http.MethodPost {
+			ah.requirePermission(utils.PermManageRoles, ah.handleSetRole).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/admin/ban", "/admin/unban":
+		if r.Method == http.MethodPost {
+			ah.requirePermission(utils.PermBanUsers, ah.handleBan).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	default:
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPageNotFound)
+	}
+}
+
+func (ah *AdminHandler) handleAdminPage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	role := r.Context().Value("userRole").(string)
+
+	users, err := ah.stores.Users.List()
+	if err != nil {
+		log.Printf("Error fetching users: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	categories, err := ah.stores.Categories.List()
+	if err != nil {
+		log.Printf("Error fetching categories: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrCategoryLoad)
+		return
+	}
+
+	data := adminPageData{
+		Users:         users,
+		Categories:    categories,
+		Roles:         []string{utils.RoleMember, utils.RoleModerator, utils.RoleAdmin},
+		CurrentUserID: userID,
+		CurrentRole:   role,
+		IsLoggedIn:    true,
+		CanManage:     utils.HasPermission(role, utils.PermManageRoles),
+		CanCategorise: utils.HasPermission(role, utils.PermManageCategories),
+	}
+
+	tmpl, err := utils.ParseTemplate(r, "templates/admin.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
+		return
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
+	}
+}
+
+// handleSetRole promotes or demotes a user. Admins cannot change their own
+// role, so the forum is never left without an admin by accident.
+func (ah *AdminHandler) handleSetRole(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	if err := r.ParseForm(); err != nil {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	targetID := r.FormValue("user_id")
+	role := r.FormValue("role")
+	if targetID == "" || !utils.ValidRole(role) {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+	if targetID == userID {
+		utils.RenderErrorPage(w, http.StatusForbidden, "You cannot change your own role.")
+		return
+	}
+
+	err := ah.stores.Users.SetRole(targetID, role)
+	if err == repository.ErrNotFound {
+		utils.RenderErrorPage(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		log.Printf("Error setting role of user %s: %v", targetID, err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	entry := utils.ModerationEntry{ModeratorID: userID, Action: utils.ModSetRole, TargetUserID: targetID, Note: role}
+	if err := ah.stores.Moderation.Log(entry); err != nil {
+		log.Printf("Error writing moderation log: %v", err)
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleBan bans or unbans a user. Staff can only ban users with a lower
+// role than their own.
+func (ah *AdminHandler) handleBan(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	role := r.Context().Value("userRole").(string)
+
+	if err := r.ParseForm(); err != nil {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	targetID := r.FormValue("user_id")
+	if targetID == "" {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+	if targetID == userID {
+		utils.RenderErrorPage(w, http.StatusForbidden, "You cannot ban yourself.")
+		return
+	}
+
+	target, err := ah.stores.Users.Get(targetID)
+	if err == repository.ErrNotFound {
+		utils.RenderErrorPage(w, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		log.Printf("Error fetching role of user %s: %v", targetID, err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+	if !utils.Outranks(role, target.Role) {
+		utils.RenderErrorPage(w, http.StatusForbidden, utils.ErrForbidden)
+		return
+	}
+
+	action := utils.ModBan
+	if r.URL.Path == "/admin/unban" {
+		action = utils.ModUnban
+		err = ah.stores.Users.Unban(targetID)
+	} else {
+		err = ah.stores.Users.Ban(targetID)
+	}
+	if err != nil {
+		log.Printf("Error updating ban of user %s: %v", targetID, err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	entry := utils.ModerationEntry{ModeratorID: userID, Action: action, TargetUserID: targetID}
+	if err := ah.stores.Moderation.Log(entry); err != nil {
+		log.Printf("Error writing moderation log: %v", err)
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"forum/config"
+	"forum/repository"
+	"forum/storage"
+	"forum/utils"
+)
+
+// openTestDB opens an empty database in a temporary directory.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := utils.InitialiseDB(config.Database{Path: filepath.Join(t.TempDir(), "forum.db"), MigrateOnStart: true})
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// seedUser creates a user with the given role and a session, returning the
+// user's ID and session token.
+func seedUser(t *testing.T, db *sql.DB, role string) (string, string) {
+	t.Helper()
+
+	userID := utils.GenerateId()
+	_, err := db.Exec("INSERT INTO users (id, username, email, role) VALUES (?, ?, ?, ?)",
+		userID, role+"_"+userID[:8], userID+"@example.com", role)
+	if err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	token, err := utils.CreateSession(db, userID, "test-agent", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	return userID, token
+}
+
+func postForm(handler http.Handler, path, token string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCategoryManagementRequiresPermission(t *testing.T) {
+	db := openTestDB(t)
+
+	_, memberToken := seedUser(t, db, utils.RoleMember)
+	_, adminToken := seedUser(t, db, utils.RoleAdmin)
+	name := "Category " + utils.GenerateId()[:8]
+	defer db.Exec("DELETE FROM categories WHERE name = ?", name)
+
+	ch := NewCategoryHandler(repository.NewSQLite(db))
+	form := url.Values{"name": {name}}
+
+	if rr := postForm(ch, "/categories", "", form); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/signin" {
+		t.Errorf("anonymous create: got %d to %q, want redirect to /signin", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := postForm(ch, "/categories", memberToken, form); rr.Code != http.StatusForbidden {
+		t.Errorf("member create: got %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := postForm(ch, "/categories", adminToken, form); rr.Code != http.StatusSeeOther {
+		t.Errorf("admin create: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM categories WHERE name = ?)", name).Scan(&exists)
+	if !exists {
+		t.Errorf("admin was not able to create category %q", name)
+	}
+}
+
+func TestAdminHandler_BanAndRoles(t *testing.T) {
+	db := openTestDB(t)
+
+	memberID, memberToken := seedUser(t, db, utils.RoleMember)
+	moderatorID, moderatorToken := seedUser(t, db, utils.RoleModerator)
+	adminID, adminToken := seedUser(t, db, utils.RoleAdmin)
+
+	ah := NewAdminHandler(repository.NewSQLite(db))
+
+	// Moderators can ban members but not promote them or ban admins
+	if rr := postForm(ah, "/admin/role", moderatorToken, url.Values{"user_id": {memberID}, "role": {"admin"}}); rr.Code != http.StatusForbidden {
+		t.Errorf("moderator promote: got %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := postForm(ah, "/admin/ban", moderatorToken, url.Values{"user_id": {adminID}}); rr.Code != http.StatusForbidden {
+		t.Errorf("moderator ban admin: got %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := postForm(ah, "/admin/ban", moderatorToken, url.Values{"user_id": {memberID}}); rr.Code != http.StatusSeeOther {
+		t.Errorf("moderator ban member: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if _, err := utils.ValidateSession(db, memberToken); err == nil {
+		t.Errorf("banned member still has a valid session")
+	}
+
+	if rr := postForm(ah, "/admin/unban", adminToken, url.Values{"user_id": {memberID}}); rr.Code != http.StatusSeeOther {
+		t.Errorf("admin unban: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if rr := postForm(ah, "/admin/role", adminToken, url.Values{"user_id": {moderatorID}, "role": {"member"}}); rr.Code != http.StatusSeeOther {
+		t.Errorf("admin demote: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if rr := postForm(ah, "/admin/role", adminToken, url.Values{"user_id": {adminID}, "role": {"member"}}); rr.Code != http.StatusForbidden {
+		t.Errorf("admin self-demote: got %d, want %d", rr.Code, http.StatusForbidden)
+	}
+
+	if role, _ := utils.GetUserRole(db, moderatorID); role != utils.RoleMember {
+		t.Errorf("demoted moderator has role %q, want member", role)
+	}
+	var banned bool
+	db.QueryRow("SELECT banned_at IS NOT NULL FROM users WHERE id = ?", memberID).Scan(&banned)
+	if banned {
+		t.Errorf("member is still banned after unban")
+	}
+}
+
+func TestModeratorCanDeleteAnyPost(t *testing.T) {
+	db := openTestDB(t)
+
+	_, postID := seedPost(t, db)
+	defer repository.NewSQLite(db).Posts.Delete(postID)
+	moderatorID, _ := seedUser(t, db, utils.RoleModerator)
+
+	form := url.Values{"post_id": {strconv.Itoa(postID)}}
+	req := httptest.NewRequest("POST", "/deletepost", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithValue(req.Context(), "userID", moderatorID))
+
+	rr := httptest.NewRecorder()
+	NewPostHandler(repository.NewSQLite(db), storage.NewLocalStore(t.TempDir()), config.Default()).handleDeletePost(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("moderator delete: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", postID).Scan(&exists)
+	if exists {
+		t.Errorf("post %d still exists after moderator deleted it", postID)
+	}
+}
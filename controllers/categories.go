@@ -1,18 +1,23 @@
 package controllers
 
 import (
-	"html/template"
 	"log"
 	"net/http"
-	"time"
+	"net/url"
+	"strconv"
+	"strings"
 
+	"forum/repository"
 	"forum/utils"
 )
 
-type CategoryHandler struct{}
+type CategoryHandler struct {
+	authenticator
+	stores *repository.Stores
+}
 
-func NewCategoryHandler() *CategoryHandler {
-	return &CategoryHandler{}
+func NewCategoryHandler(stores *repository.Stores) *CategoryHandler {
+	return &CategoryHandler{authenticator: newAuthenticator(stores), stores: stores}
 }
 
 func (ch *CategoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -21,7 +26,19 @@ func (ch *CategoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodGet {
 			ch.handleGetCategories(w, r)
 		} else if r.Method == http.MethodPost {
-			ch.handleCreateCategory(w, r)
+			ch.requirePermission(utils.PermManageCategories, ch.handleCreateCategory).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/categories/rename":
+		if r.Method == http.MethodPost {
+			ch.requirePermission(utils.PermManageCategories, ch.handleRenameCategory).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/categories/delete":
+		if r.Method == http.MethodPost {
+			ch.requirePermission(utils.PermManageCategories, ch.handleDeleteCategory).ServeHTTP(w, r)
 		} else {
 			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
 		}
@@ -33,18 +50,15 @@ func (ch *CategoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			var exists bool
-			err := utils.GlobalDB.QueryRow("SELECT EXISTS(SELECT 1 FROM categories WHERE name = ?)",
-				categoryName).Scan(&exists)
-			if err != nil {
+			_, err := ch.stores.Categories.IDByName(categoryName)
+			if err == repository.ErrNotFound {
+				utils.RenderErrorPage(w, http.StatusNotFound, "Category not found")
+				return
+			} else if err != nil {
 				log.Printf("Error checking category existence: %v", err)
 				utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
 				return
 			}
-			if !exists {
-				utils.RenderErrorPage(w, http.StatusNotFound, "Category not found")
-				return
-			}
 
 			ch.handleGetPostsByCategoryName(w, r, categoryName)
 		} else {
@@ -55,47 +69,15 @@ func (ch *CategoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (ch *CategoryHandler) checkAuthStatus(r *http.Request) bool {
-	cookie, err := r.Cookie("session_token")
-	if err != nil {
-		return false
-	}
-	_, err = utils.ValidateSession(utils.GlobalDB, cookie.Value)
-	return err == nil
-}
-
-func (ch *CategoryHandler) getAllUsers() ([]utils.User, error) {
-	rows, err := utils.GlobalDB.Query(`
-		SELECT id, username, profile_pic 
-		FROM users
-		ORDER BY username
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []utils.User
-	for rows.Next() {
-		var user utils.User
-		err := rows.Scan(&user.ID, &user.UserName, &user.ProfilePic)
-		if err != nil {
-			return nil, err
-		}
-		users = append(users, user)
-	}
-	return users, nil
-}
-
-func (ch *CategoryHandler) handleGetCategories(w http.ResponseWriter, _ *http.Request) {
-	categories, err := ch.getAllCategories()
+func (ch *CategoryHandler) handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := ch.stores.Categories.List()
 	if err != nil {
 		log.Printf("Error fetching categories: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
 		return
 	}
 
-	tmpl, err := template.ParseFiles("templates/category_posts.html")
+	tmpl, err := utils.ParseTemplate(r, "templates/category_posts.html")
 	if err != nil {
 		log.Printf("Error parsing categories template: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
@@ -121,61 +103,100 @@ func (ch *CategoryHandler) handleCreateCategory(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	stmt, err := utils.GlobalDB.Prepare("INSERT INTO categories (name) VALUES (?)")
-	if err != nil {
-		log.Printf("Error preparing statement: %v", err)
+	if err := ch.stores.Categories.Create(name); err != nil {
+		log.Printf("Error creating category: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
 		return
 	}
-	defer stmt.Close()
 
-	_, err = stmt.Exec(name)
+	http.Redirect(w, r, "/categories", http.StatusSeeOther)
+}
+
+func (ch *CategoryHandler) handleRenameCategory(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	name := strings.TrimSpace(r.FormValue("name"))
+	if err != nil || name == "" {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	err = ch.stores.Categories.Rename(id, name)
+	if err == repository.ErrNotFound {
+		utils.RenderErrorPage(w, http.StatusNotFound, "Category not found")
+		return
+	} else if err != nil {
+		log.Printf("Error renaming category %d: %v", id, err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// handleDeleteCategory removes a category. Its posts are kept and only lose
+// the category.
+func (ch *CategoryHandler) handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
 	if err != nil {
-		log.Printf("Error executing insert: %v", err)
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	if err := ch.stores.Categories.Delete(id); err != nil {
+		log.Printf("Error deleting category %d: %v", id, err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
 		return
 	}
 
-	http.Redirect(w, r, "/categories", http.StatusSeeOther)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
 func (ch *CategoryHandler) handleGetPostsByCategoryName(w http.ResponseWriter, r *http.Request, categoryName string) {
-	posts, err := ch.getPostsByCategoryName(categoryName)
-	if err != nil {
+	opts := parseFeedOptions(r)
+	posts, next, err := fetchFeed(ch.stores.Posts, repository.FeedFilter{CategoryName: categoryName}, opts)
+	if err == errInvalidCursor {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	} else if err != nil {
 		log.Printf("Error fetching posts for category %s: %v", categoryName, err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
 		return
 	}
 
-	users, err := ch.getAllUsers()
+	users, err := ch.stores.Users.List()
 	if err != nil {
 		log.Printf("Error fetching users: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
 		return
 	}
 
-	isLoggedIn := ch.checkAuthStatus(r)
-	var currentUserID string
-
-	if cookie, err := r.Cookie("session_token"); err == nil {
-		if userID, err := utils.ValidateSession(utils.GlobalDB, cookie.Value); err == nil {
-			currentUserID = userID
-		}
-	}
+	currentUserID := ch.currentUser(r)
 
 	data := struct {
 		IsLoggedIn    bool
 		Posts         []utils.Post
 		Users         []utils.User
 		CurrentUserID string
+		Feed          utils.FeedNav
 	}{
-		IsLoggedIn:    isLoggedIn,
+		IsLoggedIn:    currentUserID != "",
 		Posts:         posts,
 		Users:         users,
 		CurrentUserID: currentUserID,
+		Feed:          newFeedNav("/category", url.Values{"name": {categoryName}}, opts, next),
 	}
 
-	tmpl, err := template.ParseFiles("templates/category_posts.html")
+	tmpl, err := utils.ParseTemplate(r, "templates/category_posts.html")
 	if err != nil {
 		log.Printf("Error parsing category posts template: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
@@ -187,60 +208,3 @@ func (ch *CategoryHandler) handleGetPostsByCategoryName(w http.ResponseWriter, r
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
 	}
 }
-
-func (ch *CategoryHandler) getPostsByCategoryName(categoryName string) ([]utils.Post, error) {
-	rows, err := utils.GlobalDB.Query(`
-        SELECT p.id, p.title, p.content, p.imagepath, p.post_at, u.username, u.profile_pic,
-               (SELECT COUNT(*) FROM reaction WHERE post_id = p.id AND like = 1) AS Likes,
-               (SELECT COUNT(*) FROM reaction WHERE post_id = p.id AND like = 0) AS Dislikes,
-               (SELECT COUNT(*) FROM comments WHERE post_id = p.id) AS Comments
-        FROM posts p
-        JOIN post_categories pc ON p.id = pc.post_id
-        JOIN users u ON p.user_id = u.id
-        JOIN categories c ON pc.category_id = c.id
-        WHERE c.name = ?
-    `, categoryName)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	postMap := make(map[int]utils.Post)
-	for rows.Next() {
-		var post utils.Post
-		var postTime time.Time
-		if err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.ImagePath, &postTime, &post.Username, &post.ProfilePic, &post.Likes, &post.Dislikes, &post.Comments); err != nil {
-			log.Printf("Error scanning post: %v", err)
-			continue
-		}
-		post.PostTime = FormatTimeAgo(postTime.Local())
-		postMap[post.ID] = post
-	}
-
-	var posts []utils.Post
-	for _, post := range postMap {
-		posts = append(posts, post)
-	}
-
-	return posts, rows.Err()
-}
-
-func (ch *CategoryHandler) getAllCategories() ([]utils.Category, error) {
-	rows, err := utils.GlobalDB.Query("SELECT id, name FROM categories")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var categories []utils.Category
-	for rows.Next() {
-		var category utils.Category
-		if err := rows.Scan(&category.ID, &category.Name); err != nil {
-			log.Printf("Error scanning category: %v", err)
-			continue
-		}
-		categories = append(categories, category)
-	}
-
-	return categories, rows.Err()
-}
@@ -1,63 +1,20 @@
 package controllers
 
 import (
-	"database/sql"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
 	"testing"
 
+	"forum/repository/memory"
 	"forum/utils"
 )
 
-// setupTestDB initializes an in-memory SQLite database for testing.
-func setupTestDB(t *testing.T) *sql.DB {
-	testDB, err := sql.Open("sqlite3", ":memory:")
-	if err != nil {
-		t.Fatalf("Failed to open test db: %v", err)
-	}
-
-	_, err = testDB.Exec(`
-		CREATE TABLE categories (
-			id INTEGER PRIMARY KEY,
-			name TEXT NOT NULL
-		);
-		CREATE TABLE posts (
-			id INTEGER PRIMARY KEY,
-			title TEXT,
-			content TEXT,
-			imagepath TEXT,
-			post_at DATETIME,
-			user_id INTEGER
-		);
-		CREATE TABLE post_categories (
-			post_id INTEGER,
-			category_id INTEGER
-		);
-		CREATE TABLE users (
-			id INTEGER PRIMARY KEY,
-			username TEXT,
-			profile_pic TEXT
-		);
-		CREATE TABLE reaction (
-			post_id INTEGER,
-			like INTEGER
-		);
-	`)
-	if err != nil {
-		t.Fatalf("Failed to create test tables: %v", err)
-	}
-
-	return testDB
-}
-
 func TestCategoryHandler_handleCreateCategory(t *testing.T) {
-	testDB := setupTestDB(t)
-	defer testDB.Close()
-
-	utils.GlobalDB = testDB
-
-	ch := NewCategoryHandler()
+	stores := memory.New()
+	ch := NewCategoryHandler(stores)
 
 	form := strings.NewReader("name=Programming")
 	req, err := http.NewRequest("POST", "/categories", form)
@@ -73,26 +30,38 @@ func TestCategoryHandler_handleCreateCategory(t *testing.T) {
 	if status := rr.Code; status != http.StatusSeeOther {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusSeeOther)
 	}
+	if _, err := stores.Categories.IDByName("Programming"); err != nil {
+		t.Errorf("category was not created: %v", err)
+	}
 }
-func TestCategoryHandler_getAllCategories(t *testing.T) {
-	testDB := setupTestDB(t)
-	defer testDB.Close()
 
-	utils.GlobalDB = testDB
+func TestCategoryHandler_RenameAndDelete(t *testing.T) {
+	stores := memory.New()
+	stores.Users.Create(utils.User{ID: "admin", UserName: "admin", Role: utils.RoleAdmin})
+	token, _ := stores.Sessions.Create("admin", "test-agent", "127.0.0.1")
+	stores.Categories.Create("Programing")
+	id, _ := stores.Categories.IDByName("Programing")
 
-	ch := NewCategoryHandler()
+	ch := NewCategoryHandler(stores)
 
-	_, err := testDB.Exec("INSERT INTO categories (id, name) VALUES (1, 'Programming')")
-	if err != nil {
-		t.Fatalf("Failed to insert test data: %v", err)
+	rr := postForm(ch, "/categories/rename", token, url.Values{"id": {strconv.Itoa(id)}, "name": {"Programming"}})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("rename: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if got, err := stores.Categories.IDByName("Programming"); err != nil || got != id {
+		t.Errorf("renamed category: got ID %d, %v, want %d", got, err, id)
 	}
 
-	categories, err := ch.getAllCategories()
-	if err != nil {
-		t.Fatalf("getAllCategories returned error: %v", err)
+	rr = postForm(ch, "/categories/rename", token, url.Values{"id": {strconv.Itoa(id + 100)}, "name": {"Other"}})
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("rename missing category: got %d, want %d", rr.Code, http.StatusNotFound)
 	}
 
-	if len(categories) != 1 {
-		t.Errorf("getAllCategories returned %v categories, want 1", len(categories))
+	rr = postForm(ch, "/categories/delete", token, url.Values{"id": {strconv.Itoa(id)}})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("delete: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if categories, _ := stores.Categories.List(); len(categories) != 0 {
+		t.Errorf("categories after delete = %v, want none", categories)
 	}
 }
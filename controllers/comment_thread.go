@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"sort"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+// defaultMaxCommentDepth is how many levels of replies are nested before
+// further replies are attached to the deepest level instead.
+const defaultMaxCommentDepth = 5
+
+// commentNode is a comment with its replies, ready for recursive rendering.
+type commentNode struct {
+	utils.Comment
+	Depth         int
+	Replies       []*commentNode
+	CanReply      bool   // False at the maximum depth
+	CurrentUserID string // Viewer, so nested templates can show owner actions
+	CanModerate   bool   // Viewer may delete anyone's comments
+}
+
+func (ph *PostHandler) commentDepthLimit() int {
+	if ph.maxCommentDepth <= 0 {
+		return defaultMaxCommentDepth
+	}
+	return ph.maxCommentDepth
+}
+
+// buildCommentTree nests a flat list of comments under their parents. Top-level
+// comments are newest first and replies oldest first, so conversations read
+// top to bottom. Comments whose parent no longer exists are shown at the top level.
+func buildCommentTree(comments []utils.Comment, currentUserID string, canModerate bool, maxDepth int) []*commentNode {
+	nodes := make(map[int]*commentNode, len(comments))
+	for _, c := range comments {
+		nodes[c.ID] = &commentNode{Comment: c, CurrentUserID: currentUserID, CanModerate: canModerate}
+	}
+
+	var roots []*commentNode
+	for _, c := range comments {
+		node := nodes[c.ID]
+		if parent, ok := nodes[c.ParentID]; ok && c.ParentID != c.ID {
+			parent.Replies = append(parent.Replies, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	sort.SliceStable(roots, func(i, j int) bool {
+		return roots[i].CommentTime.After(roots[j].CommentTime)
+	})
+
+	var walk func(nodes []*commentNode, depth int)
+	walk = func(nodes []*commentNode, depth int) {
+		for _, node := range nodes {
+			node.Depth = depth
+			node.CanReply = depth < maxDepth-1
+			sort.SliceStable(node.Replies, func(i, j int) bool {
+				return node.Replies[i].CommentTime.Before(node.Replies[j].CommentTime)
+			})
+			walk(node.Replies, depth+1)
+		}
+	}
+	walk(roots, 0)
+
+	return roots
+}
+
+// replyParent resolves the parent a new reply should be stored under. Replies
+// to a comment at the maximum depth become siblings of that comment, so
+// threads never nest deeper than maxDepth.
+func replyParent(comments repository.CommentStore, postID, parentID, maxDepth int) (int, error) {
+	parent, err := comments.Get(parentID)
+	if err != nil {
+		return 0, err
+	}
+	if parent.PostID != postID {
+		return 0, repository.ErrNotFound
+	}
+
+	depth, err := comments.Depth(parentID, maxDepth)
+	if err != nil {
+		return 0, err
+	}
+
+	if depth+1 >= maxDepth {
+		// Zero makes the reply a top-level comment
+		return parent.ParentID, nil
+	}
+	return parentID, nil
+}
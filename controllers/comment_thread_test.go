@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+func TestBuildCommentTree(t *testing.T) {
+	now := time.Now()
+	comments := []utils.Comment{
+		{ID: 1, CommentTime: now.Add(-3 * time.Hour)},
+		{ID: 2, ParentID: 1, CommentTime: now.Add(-1 * time.Hour)},
+		{ID: 3, ParentID: 1, CommentTime: now.Add(-2 * time.Hour)},
+		{ID: 4, ParentID: 3, CommentTime: now},
+		{ID: 5, CommentTime: now.Add(-30 * time.Minute)},
+		{ID: 6, ParentID: 99, CommentTime: now.Add(-4 * time.Hour)}, // Parent was deleted
+	}
+
+	roots := buildCommentTree(comments, "viewer", false, 3)
+
+	if len(roots) != 3 || roots[0].ID != 5 || roots[1].ID != 1 || roots[2].ID != 6 {
+		t.Fatalf("top-level order = %v, want newest first [5 1 6]", nodeIDs(roots))
+	}
+
+	replies := roots[1].Replies
+	if len(replies) != 2 || replies[0].ID != 3 || replies[1].ID != 2 {
+		t.Fatalf("replies order = %v, want oldest first [3 2]", nodeIDs(replies))
+	}
+
+	deepest := replies[0].Replies[0]
+	if deepest.ID != 4 || deepest.Depth != 2 {
+		t.Errorf("deepest reply = %d at depth %d, want 4 at depth 2", deepest.ID, deepest.Depth)
+	}
+	if deepest.CanReply {
+		t.Errorf("comment at the maximum depth should not accept replies")
+	}
+	if !replies[0].CanReply || deepest.CurrentUserID != "viewer" {
+		t.Errorf("nested comment = %+v, want reply allowed and viewer set", replies[0])
+	}
+}
+
+func nodeIDs(nodes []*commentNode) []int {
+	var ids []int
+	for _, n := range nodes {
+		ids = append(ids, n.ID)
+	}
+	return ids
+}
+
+func TestReplyParentAndNotification(t *testing.T) {
+	db := openTestDB(t)
+
+	ownerID, postID := seedPost(t, db)
+	defer repository.NewSQLite(db).Posts.Delete(postID)
+
+	replierID := utils.GenerateId()
+	if _, err := db.Exec("INSERT INTO users (id, username, email) VALUES (?, ?, ?)", replierID, "replier_"+replierID[:8], replierID+"@example.com"); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	commenterID := utils.GenerateId()
+	if _, err := db.Exec("INSERT INTO users (id, username, email) VALUES (?, ?, ?)", commenterID, "commenter_"+commenterID[:8], commenterID+"@example.com"); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	insert := func(userID string, parentID int) int {
+		var parent interface{}
+		if parentID != 0 {
+			parent = parentID
+		}
+		result, err := db.Exec("INSERT INTO comments (post_id, user_id, content, parent_id) VALUES (?, ?, 'text', ?)", postID, userID, parent)
+		if err != nil {
+			t.Fatalf("Failed to insert comment: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		return int(id)
+	}
+
+	top := insert(commenterID, 0)
+	child := insert(replierID, top)
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = ? AND type = 'reply' AND post_id = ?", commenterID, postID).Scan(&count)
+	if count != 1 {
+		t.Errorf("parent author got %d reply notifications, want 1", count)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = ? AND type = 'comment' AND post_id = ?", ownerID, postID).Scan(&count)
+	if count != 2 {
+		t.Errorf("post author got %d comment notifications, want 2", count)
+	}
+
+	// With a maximum depth of 2, replies to the child become its siblings
+	if got, err := replyParent(repository.NewSQLite(db).Comments, postID, top, 2); err != nil || got != top {
+		t.Errorf("replyParent(repository.NewSQLite(db).Comments, top) = %d, %v; want %d", got, err, top)
+	}
+	if got, err := replyParent(repository.NewSQLite(db).Comments, postID, child, 2); err != nil || got != top {
+		t.Errorf("replyParent(repository.NewSQLite(db).Comments, child) = %d, %v; want %d", got, err, top)
+	}
+	if _, err := replyParent(repository.NewSQLite(db).Comments, postID+1, top, 2); err == nil {
+		t.Errorf("replyParent accepted a parent from another post")
+	}
+
+	db.Exec("DELETE FROM notifications WHERE post_id = ?", postID)
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	db := openTestDB(t)
+
+	_, session := seedUser(t, db, utils.RoleMember)
+	token, err := utils.SessionCSRFToken(db, session)
+	if err != nil || token == "" {
+		t.Fatalf("SessionCSRFToken = %q, %v", token, err)
+	}
+	_, otherSession := seedUser(t, db, utils.RoleMember)
+	otherToken, _ := utils.SessionCSRFToken(db, otherSession)
+
+	handler := CSRFProtect(repository.NewSQLite(db).Sessions, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		session string
+		form    url.Values
+		header  string
+		want    int
+	}{
+		{"GET needs no token", "GET", session, nil, "", http.StatusOK},
+		{"Anonymous POST", "POST", "", url.Values{"content": {"hi"}}, "", http.StatusOK},
+		{"Missing token", "POST", session, url.Values{"content": {"hi"}}, "", http.StatusForbidden},
+		{"Wrong token", "POST", session, url.Values{utils.CSRFField: {"guess"}}, "", http.StatusForbidden},
+		{"Another session's token", "POST", session, url.Values{utils.CSRFField: {otherToken}}, "", http.StatusForbidden},
+		{"Form token", "POST", session, url.Values{utils.CSRFField: {token}}, "", http.StatusOK},
+		{"Header token", "POST", session, nil, token, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/comment", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.session != "" {
+				req.AddCookie(&http.Cookie{Name: "session_token", Value: tt.session})
+			}
+			if tt.header != "" {
+				req.Header.Set(utils.CSRFHeader, tt.header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("got status %d, want %d", rr.Code, tt.want)
+			}
+		})
+	}
+
+	// multipartPost submits the token with a file of size bytes
+	multipartPost := func(size int) int {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField(utils.CSRFField, token)
+		part, _ := form.CreateFormFile("image", "photo.png")
+		part.Write(make([]byte, size))
+		form.Close()
+
+		req := httptest.NewRequest("POST", "/post", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if got := multipartPost(1 << 10); got != http.StatusOK {
+		t.Errorf("multipart form with token: got status %d, want %d", got, http.StatusOK)
+	}
+	if got := multipartPost(maxRequestSize); got != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized upload: got status %d, want %d", got, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestSessionCSRFToken_BackfillsOldSessions(t *testing.T) {
+	db := openTestDB(t)
+
+	_, session := seedUser(t, db, utils.RoleMember)
+	db.Exec("UPDATE sessions SET csrf_token = NULL WHERE id = ?", session)
+
+	first, err := utils.SessionCSRFToken(db, session)
+	if err != nil || first == "" {
+		t.Fatalf("SessionCSRFToken = %q, %v", first, err)
+	}
+	if second, _ := utils.SessionCSRFToken(db, session); second != first {
+		t.Errorf("token changed between calls: %q then %q", first, second)
+	}
+	if _, err := utils.SessionCSRFToken(db, "no-such-session"); err != utils.ErrNoSession {
+		t.Errorf("unknown session: got %v, want ErrNoSession", err)
+	}
+}
@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+const feedPageSize = 20
+
+// maxFeedShown caps the posts a cursor can list as shown, which keeps
+// cursor URLs short and the query within SQLite's limit on parameters. The
+// "top" and "hot" feeds stop offering a next page once it is reached.
+const maxFeedShown = 10 * feedPageSize
+
+var errInvalidCursor = errors.New("invalid feed cursor")
+
+// Lengths of the time windows the "top" sort can be restricted to. A zero
+// duration means all time.
+var topWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+// feedOptions are the sort and paging parameters taken from the query string.
+type feedOptions struct {
+	Sort   string // "new", "top" or "hot"
+	Window string // time window for "top"
+	Cursor string
+}
+
+// feedCursor marks where the next page starts. Posts made after AsOf, the
+// time the first page was loaded, are left out and "hot" ages and the "top"
+// window are measured from it. The "new" feed continues after the post with
+// ID. Votes move posts up and down the "top" and "hot" feeds between pages,
+// so those instead continue with the best of the posts not Shown yet.
+type feedCursor struct {
+	AsOf  int64   `json:"t"`
+	Score float64 `json:"s,omitempty"`
+	ID    int     `json:"i,omitempty"`
+	Shown []int   `json:"x,omitempty"`
+}
+
+func parseFeedOptions(r *http.Request) feedOptions {
+	query := r.URL.Query()
+	opts := feedOptions{
+		Sort:   query.Get("sort"),
+		Window: query.Get("t"),
+		Cursor: query.Get("cursor"),
+	}
+	if opts.Sort != "top" && opts.Sort != "hot" {
+		opts.Sort = "new"
+	}
+	if opts.Sort == "top" {
+		if _, ok := topWindows[opts.Window]; !ok {
+			opts.Window = "week"
+		}
+	} else {
+		opts.Window = ""
+	}
+	return opts
+}
+
+func encodeFeedCursor(c feedCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeFeedCursor(s string) (feedCursor, error) {
+	var c feedCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// fetchFeed returns one page of posts matching the filter in the requested
+// order, and the cursor of the next page, if there is one.
+func fetchFeed(store repository.PostStore, filter repository.FeedFilter, opts feedOptions) ([]utils.Post, string, error) {
+	cursor := feedCursor{AsOf: time.Now().UnixMilli()}
+	if opts.Cursor != "" {
+		var err error
+		cursor, err = decodeFeedCursor(opts.Cursor)
+		if err != nil || len(cursor.Shown) > maxFeedShown {
+			return nil, "", errInvalidCursor
+		}
+	}
+
+	query := repository.FeedQuery{
+		Filter: filter,
+		Sort:   opts.Sort,
+		AsOf:   time.UnixMilli(cursor.AsOf),
+		Limit:  feedPageSize + 1,
+	}
+	if window := topWindows[opts.Window]; opts.Sort == "top" && window > 0 {
+		query.Since = query.AsOf.Add(-window)
+	}
+	if opts.Sort != "new" {
+		query.Exclude = cursor.Shown
+	} else if opts.Cursor != "" {
+		query.After = &repository.FeedPosition{Score: cursor.Score, ID: cursor.ID}
+	}
+
+	scored, err := store.Feed(query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The extra post only tells us whether there is another page
+	var nextCursor string
+	if len(scored) > feedPageSize {
+		scored = scored[:feedPageSize]
+		next := feedCursor{AsOf: cursor.AsOf}
+		if opts.Sort != "new" {
+			next.Shown = cursor.Shown
+			for _, p := range scored {
+				next.Shown = append(next.Shown, p.ID)
+			}
+		} else {
+			last := scored[feedPageSize-1]
+			next.Score, next.ID = last.Score, last.ID
+		}
+		if len(next.Shown) <= maxFeedShown {
+			nextCursor = encodeFeedCursor(next)
+		}
+	}
+
+	posts := make([]utils.Post, len(scored))
+	for i, p := range scored {
+		posts[i] = p.Post
+		posts[i].PostTime = FormatTimeAgo(p.PostedAt.Local())
+	}
+	return posts, nextCursor, nil
+}
+
+// newFeedNav builds the sort links and next-page link for a feed served at
+// path. Fixed query parameters such as the category name are kept on every link.
+func newFeedNav(path string, fixed url.Values, opts feedOptions, nextCursor string) utils.FeedNav {
+	link := func(sort, window, cursor string) string {
+		q := url.Values{}
+		for k, v := range fixed {
+			q[k] = v
+		}
+		q.Set("sort", sort)
+		if window != "" {
+			q.Set("t", window)
+		}
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		return path + "?" + q.Encode()
+	}
+
+	nav := utils.FeedNav{
+		Sort:    opts.Sort,
+		Window:  opts.Window,
+		NewURL:  link("new", "", ""),
+		HotURL:  link("hot", "", ""),
+		TopURLs: make(map[string]string),
+	}
+	for window := range topWindows {
+		nav.TopURLs[window] = link("top", window, "")
+	}
+	if nextCursor != "" {
+		nav.NextURL = link(opts.Sort, opts.Window, nextCursor)
+	}
+	return nav
+}
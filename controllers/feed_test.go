@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"forum/repository"
+	"forum/repository/memory"
+	"forum/utils"
+)
+
+func TestParseFeedOptions(t *testing.T) {
+	tests := []struct {
+		url        string
+		wantSort   string
+		wantWindow string
+	}{
+		{"/", "new", ""},
+		{"/?sort=hot", "hot", ""},
+		{"/?sort=top", "top", "week"},
+		{"/?sort=top&t=year", "top", "year"},
+		{"/?sort=top&t=decade", "top", "week"},
+		{"/?sort=new&t=year", "new", ""},
+		{"/?sort=random", "new", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			opts := parseFeedOptions(httptest.NewRequest("GET", tt.url, nil))
+			if opts.Sort != tt.wantSort || opts.Window != tt.wantWindow {
+				t.Errorf("parseFeedOptions(%s) = %s/%s, want %s/%s", tt.url, opts.Sort, opts.Window, tt.wantSort, tt.wantWindow)
+			}
+		})
+	}
+}
+
+func TestFetchFeedPagination(t *testing.T) {
+	db := openTestDB(t)
+
+	authorID := utils.GenerateId()
+	_, err := db.Exec("INSERT INTO users (id, username, email) VALUES (?, ?, ?)", authorID, "feed_"+authorID[:8], authorID+"@example.com")
+	if err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	// Posts spread over the last few days, with scores that tie in places
+	const total = feedPageSize*2 + 5
+	var postIDs []int
+	for i := 0; i < total; i++ {
+		postedAt := time.Now().Add(-time.Duration(i) * 3 * time.Hour)
+		result, err := db.Exec(`
+            INSERT INTO posts (user_id, title, content, post_at, likes, dislikes)
+            VALUES (?, 'Title', 'Content', ?, ?, ?)
+        `, authorID, postedAt, i%7, i%3)
+		if err != nil {
+			t.Fatalf("Failed to insert post: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		postIDs = append(postIDs, int(id))
+	}
+	// vote shifts a post's score and restores it when the subtest ends, so
+	// each sort starts from the same scores
+	vote := func(t *testing.T, column string, id int) {
+		t.Helper()
+		if _, err := db.Exec("UPDATE posts SET "+column+" = "+column+" + 100 WHERE id = ?", id); err != nil {
+			t.Fatalf("Failed to vote on post %d: %v", id, err)
+		}
+		t.Cleanup(func() {
+			if _, err := db.Exec("UPDATE posts SET "+column+" = "+column+" - 100 WHERE id = ?", id); err != nil {
+				t.Errorf("Failed to restore votes on post %d: %v", id, err)
+			}
+		})
+	}
+
+	for _, sort := range []string{"new", "top", "hot"} {
+		t.Run(sort, func(t *testing.T) {
+			opts := feedOptions{Sort: sort, Window: "all"}
+			seen := make(map[int]bool)
+			pages := 0
+			for {
+				posts, next, err := fetchFeed(repository.NewSQLite(db).Posts, repository.FeedFilter{AuthorID: authorID}, opts)
+				if err != nil {
+					t.Fatalf("fetchFeed returned error: %v", err)
+				}
+				pages++
+				for _, post := range posts {
+					if seen[post.ID] {
+						t.Errorf("post %d returned on more than one page", post.ID)
+					}
+					seen[post.ID] = true
+				}
+				if next == "" {
+					break
+				}
+				// Votes between pages sink a post already shown and raise
+				// one not shown yet
+				if pages == 1 {
+					vote(t, "dislikes", posts[0].ID)
+					for _, id := range postIDs {
+						if !seen[id] {
+							vote(t, "likes", id)
+							break
+						}
+					}
+				}
+				opts.Cursor = next
+			}
+
+			if len(seen) != total {
+				t.Errorf("paged through %d posts, want %d", len(seen), total)
+			}
+			if pages != 3 {
+				t.Errorf("got %d pages, want 3", pages)
+			}
+		})
+	}
+
+	posts, _, err := fetchFeed(repository.NewSQLite(db).Posts, repository.FeedFilter{AuthorID: authorID}, feedOptions{Sort: "top", Window: "day"})
+	if err != nil {
+		t.Fatalf("fetchFeed returned error: %v", err)
+	}
+	for i := 1; i < len(posts); i++ {
+		if posts[i-1].Likes-posts[i-1].Dislikes < posts[i].Likes-posts[i].Dislikes {
+			t.Errorf("top feed not sorted by score at position %d", i)
+		}
+	}
+	// 3-hourly posts: only those from the last 24 hours fall in the window
+	if len(posts) != 8 && len(posts) != 9 {
+		t.Errorf("top of the day returned %d posts, want 8 or 9", len(posts))
+	}
+
+	if _, _, err := fetchFeed(repository.NewSQLite(db).Posts, repository.FeedFilter{}, feedOptions{Sort: "new", Cursor: "not-a-cursor"}); err != errInvalidCursor {
+		t.Errorf("invalid cursor returned %v, want errInvalidCursor", err)
+	}
+}
+
+func TestFetchFeedShownLimit(t *testing.T) {
+	stores := memory.New()
+	if err := stores.Users.Create(utils.User{ID: "author", UserName: "author"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	postedAt := time.Now().Add(-time.Hour)
+	for i := 0; i < maxFeedShown+2*feedPageSize; i++ {
+		post := utils.Post{UserID: "author", Title: "Title", Content: "Content", PostedAt: postedAt}
+		if _, err := stores.Posts.Create(post, nil); err != nil {
+			t.Fatalf("Failed to create post: %v", err)
+		}
+	}
+
+	opts := feedOptions{Sort: "hot"}
+	shown := 0
+	for {
+		posts, next, err := fetchFeed(stores.Posts, repository.FeedFilter{}, opts)
+		if err != nil {
+			t.Fatalf("fetchFeed returned error: %v", err)
+		}
+		shown += len(posts)
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+	}
+	if want := maxFeedShown + feedPageSize; shown != want {
+		t.Errorf("hot feed showed %d posts, want it to stop at %d", shown, want)
+	}
+
+	crafted := feedCursor{AsOf: time.Now().UnixMilli(), Shown: make([]int, maxFeedShown+1)}
+	_, _, err := fetchFeed(stores.Posts, repository.FeedFilter{}, feedOptions{Sort: "hot", Cursor: encodeFeedCursor(crafted)})
+	if err != errInvalidCursor {
+		t.Errorf("cursor over the limit returned %v, want errInvalidCursor", err)
+	}
+}
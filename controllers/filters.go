@@ -1,222 +1,95 @@
 package controllers
 
 import (
-	"database/sql"
-	"html/template"
 	"log"
 	"net/http"
-	"time"
 
+	"forum/repository"
 	"forum/utils"
 )
 
-func CreatedPosts(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+// FilterHandler serves the signed-in user's own posts at /created and the
+// posts they reacted to at /liked.
+type FilterHandler struct {
+	authenticator
+	stores *repository.Stores
+}
 
-	// Check session
-	userID, err := validateUserSession(w, r)
-	if err != nil {
-		return
+func NewFilterHandler(stores *repository.Stores) *FilterHandler {
+	return &FilterHandler{authenticator: newAuthenticator(stores), stores: stores}
+}
+
+func (fh *FilterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/created":
+		fh.requireAuth(fh.CreatedPosts).ServeHTTP(w, r)
+	case "/liked":
+		fh.requireAuth(fh.LikedPosts).ServeHTTP(w, r)
+	default:
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPageNotFound)
 	}
+}
+
+func (fh *FilterHandler) CreatedPosts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	userID := r.Context().Value("userID").(string)
 
 	// Fetch posts
-	posts, err := fetchUserPostsForPosts(userID)
-	if err != nil {
+	opts := parseFeedOptions(r)
+	posts, next, err := fetchFeed(fh.stores.Posts, repository.FeedFilter{AuthorID: userID}, opts)
+	if err == errInvalidCursor {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	} else if err != nil {
 		log.Printf("Error fetching posts: %v", err)
 		http.Error(w, "Error fetching posts", http.StatusInternalServerError)
 		return
 	}
 
 	// Fetch all users
-	users, err := getAllUsers()
+	users, err := fh.stores.Users.List()
 	if err != nil {
 		log.Printf("Error fetching users: %v", err)
 	}
 
-	if err := renderCreatedTemplateForPosts(w, posts, users, userID); err != nil {
+	feed := newFeedNav("/created", nil, opts, next)
+	if err := renderCreatedTemplateForPosts(w, r, posts, users, userID, feed); err != nil {
 		log.Printf("Error rendering template: %v", err)
 		return
 	}
 }
 
-func LikedPosts(w http.ResponseWriter, r *http.Request) {
+func (fh *FilterHandler) LikedPosts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-
-	// Check session
-	userID, err := validateUserSession(w, r)
-	if err != nil {
-		return
-	}
+	userID := r.Context().Value("userID").(string)
 
 	// Fetch posts
-	posts, err := fetchUserPostsForLikes(userID)
-	if err != nil {
+	opts := parseFeedOptions(r)
+	posts, next, err := fetchFeed(fh.stores.Posts, repository.FeedFilter{ReactedBy: userID}, opts)
+	if err == errInvalidCursor {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	} else if err != nil {
 		log.Printf("Error fetching posts: %v", err)
 		http.Error(w, "Error fetching posts", http.StatusInternalServerError)
 		return
 	}
 
 	// Fetch all users
-	users, err := getAllUsers()
+	users, err := fh.stores.Users.List()
 	if err != nil {
 		log.Printf("Error fetching users: %v", err)
 	}
 
-	if err := renderCreatedTemplateForLikes(w, posts, users, userID); err != nil {
+	feed := newFeedNav("/liked", nil, opts, next)
+	if err := renderCreatedTemplateForLikes(w, r, posts, users, userID, feed); err != nil {
 		log.Printf("Error rendering template: %v", err)
 		return
 	}
 }
 
-// getAllUsers fetches all users from the database
-func getAllUsers() ([]utils.User, error) {
-	rows, err := utils.GlobalDB.Query(`
-		SELECT id, username, profile_pic 
-		FROM users
-		ORDER BY username
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []utils.User
-	for rows.Next() {
-		var user utils.User
-		err := rows.Scan(&user.ID, &user.UserName, &user.ProfilePic)
-		if err != nil {
-			return nil, err
-		}
-		users = append(users, user)
-	}
-	return users, nil
-}
-
-func validateUserSession(w http.ResponseWriter, r *http.Request) (string, error) {
-	cookie, err := r.Cookie("session_token")
-	if err != nil {
-		http.Redirect(w, r, "/signin", http.StatusSeeOther)
-		return "", err
-	}
-
-	userID, err := utils.ValidateSession(utils.GlobalDB, cookie.Value)
-	if err != nil {
-		http.Redirect(w, r, "/signin", http.StatusSeeOther)
-		return "", err
-	}
-
-	return userID, nil
-}
-
-func fetchUserPostsForPosts(userID string) ([]utils.Post, error) {
-	rows, err := utils.GlobalDB.Query(`
-        SELECT p.id, p.user_id, p.title, p.content, p.imagepath, p.post_at, p.likes, p.dislikes, p.comments,
-               u.username, u.profile_pic, c.id AS category_id, c.name AS category_name
-        FROM posts p
-        JOIN users u ON p.user_id = u.id
-        LEFT JOIN post_categories pc ON p.id = pc.post_id
-        LEFT JOIN categories c ON pc.category_id = c.id
-        WHERE p.user_id = ?
-        ORDER BY p.post_at DESC
-    `, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	postMap := make(map[int]utils.Post)
-	var postTime time.Time
-	for rows.Next() {
-		var post utils.Post
-		var categoryID sql.NullInt64
-		var categoryName sql.NullString
-		err := rows.Scan(
-			&post.ID,
-			&post.UserID,
-			&post.Title,
-			&post.Content,
-			&post.ImagePath,
-			&postTime,
-			&post.Likes,
-			&post.Dislikes,
-			&post.Comments,
-			&post.Username,
-			&post.ProfilePic,
-			&categoryID,
-			&categoryName,
-		)
-		if err != nil {
-			return nil, err
-		}
-		post.PostTime = FormatTimeAgo(postTime.Local())
-
-		postMap[post.ID] = post
-	}
-
-	var posts []utils.Post
-	for _, post := range postMap {
-		posts = append(posts, post)
-	}
-
-	return posts, nil
-}
-
-func fetchUserPostsForLikes(userID string) ([]utils.Post, error) {
-	rows, err := utils.GlobalDB.Query(`
-        SELECT p.id, p.user_id, p.title, p.content, p.imagepath, p.post_at, p.likes, p.dislikes, p.comments,
-               u.username, u.profile_pic, c.id AS category_id, c.name AS category_name
-        FROM posts p
-        JOIN users u ON p.user_id = u.id
-        LEFT JOIN post_categories pc ON p.id = pc.post_id
-        LEFT JOIN categories c ON pc.category_id = c.id
-        JOIN reaction r ON p.id = r.post_id
-        WHERE r.user_id = ? AND r.like = 1 OR r.like = 0
-        ORDER BY p.post_at DESC
-    `, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	postMap := make(map[int]utils.Post)
-	var postTime time.Time
-	for rows.Next() {
-		var post utils.Post
-		var categoryID sql.NullInt64
-		var categoryName sql.NullString
-		err := rows.Scan(
-			&post.ID,
-			&post.UserID,
-			&post.Title,
-			&post.Content,
-			&post.ImagePath,
-			&postTime,
-			&post.Likes,
-			&post.Dislikes,
-			&post.Comments,
-			&post.Username,
-			&post.ProfilePic,
-			&categoryID,
-			&categoryName,
-		)
-		if err != nil {
-			return nil, err
-		}
-		post.PostTime = FormatTimeAgo(postTime.Local())
-
-		postMap[post.ID] = post
-	}
-
-	var posts []utils.Post
-	for _, post := range postMap {
-		posts = append(posts, post)
-	}
-
-	return posts, nil
-}
-
-func renderCreatedTemplateForPosts(w http.ResponseWriter, posts []utils.Post, users []utils.User, userID string) error {
-	tmpl, err := template.ParseFiles("templates/created.html")
+func renderCreatedTemplateForPosts(w http.ResponseWriter, r *http.Request, posts []utils.Post, users []utils.User, userID string, feed utils.FeedNav) error {
+	tmpl, err := utils.ParseTemplate(r, "templates/created.html")
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		return err
@@ -226,17 +99,19 @@ func renderCreatedTemplateForPosts(w http.ResponseWriter, posts []utils.Post, us
 		Posts  []utils.Post
 		Users  []utils.User
 		UserID string
+		Feed   utils.FeedNav
 	}{
 		Posts:  posts,
 		Users:  users,
 		UserID: userID,
+		Feed:   feed,
 	}
 
 	return tmpl.Execute(w, data)
 }
 
-func renderCreatedTemplateForLikes(w http.ResponseWriter, posts []utils.Post, users []utils.User, userID string) error {
-	tmpl, err := template.ParseFiles("templates/liked.html")
+func renderCreatedTemplateForLikes(w http.ResponseWriter, r *http.Request, posts []utils.Post, users []utils.User, userID string, feed utils.FeedNav) error {
+	tmpl, err := utils.ParseTemplate(r, "templates/liked.html")
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		return err
@@ -246,10 +121,12 @@ func renderCreatedTemplateForLikes(w http.ResponseWriter, posts []utils.Post, us
 		Posts  []utils.Post
 		Users  []utils.User
 		UserID string
+		Feed   utils.FeedNav
 	}{
 		Posts:  posts,
 		Users:  users,
 		UserID: userID,
+		Feed:   feed,
 	}
 
 	return tmpl.Execute(w, data)
@@ -1,63 +1,80 @@
 package controllers
 
 import (
-	"crypto/md5"
-	"fmt"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
 	"log"
 	"mime/multipart"
-	"os"
-	"path/filepath"
-	"time"
 
+	"forum/storage"
 	"forum/utils"
 )
 
-const (
-	maxUploadSize = 20 << 20 // 20MB
-	uploadDir     = "static/uploads"
-)
+const maxUploadSize = 20 << 20 // 20MB
 
 // ImageHandler handles image upload and processing
 type ImageHandler struct {
-	uploadPath string
+	store storage.BlobStore
 }
 
-func NewImageHandler() *ImageHandler {
-	// Create upload directory if it doesn't exist
-	if err := os.MkdirAll(uploadDir, 0o755); err != nil {
-		log.Printf("Error creating upload directory: %v", err)
-	}
-	return &ImageHandler{uploadPath: uploadDir}
+// NewImageHandler returns an ImageHandler that stores images in store.
+func NewImageHandler(store storage.BlobStore) *ImageHandler {
+	return &ImageHandler{store: store}
 }
 
-// ProcessImage handles the image upload process
+// ProcessImage checks that an upload is an image, strips its metadata and
+// stores it along with a thumbnail and a medium size. Keys are the hash of
+// the upload, so the same image is only stored once. It returns the URL path
+// of the original size.
+//
+// An image that is already stored is written again anyway: that makes it
+// recent, so the upload cleanup cannot delete it as unreferenced before the
+// post or profile using it is saved.
 func (ih *ImageHandler) ProcessImage(file multipart.File, header *multipart.FileHeader) (string, error) {
-	if err := utils.ValidateImage(file, header); err != nil {
+	contentType, err := utils.ValidateImage(file, header)
+	if err != nil {
 		return "", err
 	}
-	// Generate unique filename
-	ext := filepath.Ext(header.Filename)
-	newFileName := fmt.Sprintf("%x%s", md5.Sum([]byte(time.Now().String())), ext)
-
-	// Create uploads directory if it doesn't exist
-	uploadsDir := "static/uploads"
-	if err := os.MkdirAll(uploadsDir, 0o755); err != nil {
+	data, err := io.ReadAll(io.LimitReader(file, utils.MaxFileSize+1))
+	if err != nil {
 		return "", err
 	}
+	if len(data) > utils.MaxFileSize {
+		return "", utils.ErrImageFileTooLarge
+	}
 
-	// Save file
-	filePath := filepath.Join(uploadsDir, newFileName)
-	dst, err := os.Create(filePath)
+	ctx := context.Background()
+	sum := sha256.Sum256(data)
+	key := hex.EncodeToString(sum[:]) + utils.ValidImageTypes[contentType]
+	imagePath := storage.URLPrefix + key
+
+	original, sizes, err := utils.EncodeImage(data, contentType)
 	if err != nil {
 		return "", err
 	}
-	defer dst.Close()
-
-	if _, err = io.Copy(dst, file); err != nil {
-		os.Remove(filePath)
+	for size, encoded := range sizes {
+		sizeKey := storage.KeyFromURL(utils.ImageVariant(imagePath, size))
+		if err := ih.store.Put(ctx, sizeKey, encoded, storage.ContentType(sizeKey)); err != nil {
+			return "", err
+		}
+	}
+	if err := ih.store.Put(ctx, key, original, contentType); err != nil {
 		return "", err
 	}
 
-	return "/static/uploads/" + newFileName, nil
+	return imagePath, nil
+}
+
+// uploadErrorMessage returns the message to show for an error from
+// ProcessImage.
+func uploadErrorMessage(err error) string {
+	if errors.Is(err, utils.ErrInvalidImage) || errors.Is(err, utils.ErrImageFileTooLarge) ||
+		errors.Is(err, utils.ErrImageTooLarge) {
+		return err.Error()
+	}
+	log.Printf("Error processing image: %v", err)
+	return utils.ErrFileUpload
 }
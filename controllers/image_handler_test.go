@@ -0,0 +1,185 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/storage"
+	"forum/utils"
+)
+
+// uploadRequestFile returns data as the "image" field of a multipart form.
+func uploadRequestFile(t *testing.T, data []byte, filename string) (multipart.File, *multipart.FileHeader) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("image", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/create", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if err := req.ParseMultipartForm(maxUploadSize); err != nil {
+		t.Fatal(err)
+	}
+	file, header, err := req.FormFile("image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file, header
+}
+
+func TestProcessImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 600, 300))); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	ih := &ImageHandler{store: storage.NewLocalStore(dir)}
+
+	first, err := ih.ProcessImage(uploadRequestFile(t, buf.Bytes(), "a.png"))
+	if err != nil {
+		t.Fatalf("ProcessImage returned error: %v", err)
+	}
+	second, err := ih.ProcessImage(uploadRequestFile(t, buf.Bytes(), "b.jpg"))
+	if err != nil {
+		t.Fatalf("ProcessImage returned error: %v", err)
+	}
+	if first != second {
+		t.Errorf("the same image was stored as %q and %q", first, second)
+	}
+	if !strings.HasPrefix(first, storage.URLPrefix) || filepath.Ext(first) != ".png" {
+		t.Errorf("stored as %q, want the extension of the detected type", first)
+	}
+
+	for _, p := range []string{first, utils.ImageVariant(first, utils.ImageThumb), utils.ImageVariant(first, utils.ImageMedium)} {
+		if _, err := os.Stat(filepath.Join(dir, storage.KeyFromURL(p))); err != nil {
+			t.Errorf("%s was not stored: %v", p, err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("upload directory has %d files, want 3", len(entries))
+	}
+
+	if _, err := ih.ProcessImage(uploadRequestFile(t, []byte("GIF89a but not really"), "c.gif")); err == nil {
+		t.Errorf("ProcessImage accepted a broken image")
+	}
+}
+
+func TestCleanUpUploads(t *testing.T) {
+	db := openTestDB(t)
+
+	dir := t.TempDir()
+	store := storage.NewLocalStore(dir)
+	ih := &ImageHandler{store: store}
+	upload := func(shade uint8) string {
+		t.Helper()
+		img := image.NewNRGBA(image.Rect(0, 0, 600, 300))
+		img.Set(0, 0, color.NRGBA{shade, 0, 0, 255})
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		imagePath, err := ih.ProcessImage(uploadRequestFile(t, buf.Bytes(), "a.png"))
+		if err != nil {
+			t.Fatalf("ProcessImage returned error: %v", err)
+		}
+		return imagePath
+	}
+	countFiles := func() int {
+		entries, _ := os.ReadDir(dir)
+		return len(entries)
+	}
+	age := func() {
+		old := time.Now().Add(-48 * time.Hour)
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			os.Chtimes(filepath.Join(dir, entry.Name()), old, old)
+		}
+	}
+
+	userID, _ := seedUser(t, db, utils.RoleMember)
+	avatar, postImage, revisionImage, orphan := upload(1), upload(2), upload(3), upload(4)
+	if _, err := db.Exec("UPDATE users SET profile_pic = ? WHERE id = ?", avatar, userID); err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.Exec("INSERT INTO posts (user_id, title, content, imagepath, post_at) VALUES (?, 'GC', 'GC', ?, ?)",
+		userID, postImage, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	postID, _ := result.LastInsertId()
+	if _, err := db.Exec(`INSERT INTO post_revisions (post_id, editor_id, title, content, imagepath, categories, revised_at)
+		VALUES (?, ?, 'GC', 'GC', ?, '', ?)`, postID, userID, revisionImage, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// An upload from before images were stored by hash
+	if err := store.Put(context.Background(), "1700000000_legacy.png", []byte("png"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+	if got := countFiles(); got != 13 {
+		t.Fatalf("upload directory has %d files, want 13", got)
+	}
+
+	ctx := context.Background()
+	res, err := utils.CleanUpUploads(ctx, db, store, 24*time.Hour, false)
+	if err != nil {
+		t.Fatalf("CleanUpUploads returned error: %v", err)
+	}
+	if res.Referenced != 9 || res.Recent != 4 || res.Deleted != 0 || countFiles() != 13 {
+		t.Errorf("recent uploads: %v, %d files left", res, countFiles())
+	}
+
+	age()
+	res, err = utils.CleanUpUploads(ctx, db, store, 24*time.Hour, true)
+	if err != nil {
+		t.Fatalf("CleanUpUploads returned error: %v", err)
+	}
+	if res.Deleted != 4 || res.Bytes == 0 || countFiles() != 13 {
+		t.Errorf("dry run: %v, %d files left", res, countFiles())
+	}
+
+	res, err = utils.CleanUpUploads(ctx, db, store, 24*time.Hour, false)
+	if err != nil {
+		t.Fatalf("CleanUpUploads returned error: %v", err)
+	}
+	if res.Deleted != 4 || countFiles() != 9 {
+		t.Errorf("cleanup: %v, %d files left", res, countFiles())
+	}
+	for _, p := range []string{orphan, utils.ImageVariant(orphan, utils.ImageThumb), "/uploads/1700000000_legacy.png"} {
+		if exists, _ := store.Exists(ctx, storage.KeyFromURL(p)); exists {
+			t.Errorf("unreferenced %s was kept", p)
+		}
+	}
+
+	// A replaced profile picture is collected once it is old enough, but
+	// uploading it again makes it recent
+	if _, err := db.Exec("UPDATE users SET profile_pic = ? WHERE id = ?", postImage, userID); err != nil {
+		t.Fatal(err)
+	}
+	if again := upload(1); again != avatar {
+		t.Fatalf("the same image was stored as %q and %q", avatar, again)
+	}
+	if res, _ := utils.CleanUpUploads(ctx, db, store, 24*time.Hour, false); res.Deleted != 0 || res.Recent != 3 {
+		t.Errorf("re-uploaded image: %v", res)
+	}
+	age()
+	if res, _ := utils.CleanUpUploads(ctx, db, store, 24*time.Hour, false); res.Deleted != 3 || countFiles() != 6 {
+		t.Errorf("replaced profile picture: %v, %d files left", res, countFiles())
+	}
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"forum/logging"
+	"forum/repository"
+	"forum/utils"
+)
+
+// Middleware wraps a handler with behaviour shared by every request.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in middleware. The first runs first, around all the others.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// CSRF is CSRFProtect as Middleware.
+func CSRF(sessions repository.SessionStore) Middleware {
+	return func(next http.Handler) http.Handler {
+		return CSRFProtect(sessions, next)
+	}
+}
+
+// requestIDHeaders are the headers a request ID is taken from when a proxy
+// in front of the forum has already assigned one, so its logs and ours
+// agree.
+var requestIDHeaders = []string{"X-Request-ID", "Fly-Request-Id"}
+
+// RequestLog returns middleware that gives every request an ID, sent back
+// in the X-Request-ID header, and logs the request once it is done with
+// its status, duration and signed-in user.
+func RequestLog(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			req := &logging.Request{ID: requestID(r)}
+			w.Header().Set("X-Request-ID", req.ID)
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(logging.WithRequest(r.Context(), req)))
+
+			level := slog.LevelInfo
+			if rec.Status() >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(r.Context(), level, "request",
+				slog.String("id", req.ID),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.Status()),
+				slog.Int64("bytes", rec.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("user_id", req.UserID),
+			)
+		})
+	}
+}
+
+func requestID(r *http.Request) string {
+	for _, header := range requestIDHeaders {
+		if id := r.Header.Get(header); logging.ValidRequestID(id) {
+			return id
+		}
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RecoverPanics returns middleware that logs a handler's panic with its
+// stack and shows the error page instead of dropping the connection.
+func RecoverPanics(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec, ok := w.(*responseRecorder)
+			if !ok {
+				rec = &responseRecorder{ResponseWriter: w}
+			}
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				if err == http.ErrAbortHandler {
+					// Meant to abort the response, see http.Handler
+					panic(err)
+				}
+				var id string
+				if req := logging.FromContext(r.Context()); req != nil {
+					id = req.ID
+				}
+				logger.Error("panic serving request",
+					"id", id,
+					"method", r.Method,
+					"path", r.URL.Path,
+					"error", fmt.Sprint(err),
+					"stack", string(debug.Stack()),
+				)
+				if !rec.wroteHeader {
+					utils.RenderErrorPage(rec, http.StatusInternalServerError, utils.ErrInternalServer)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// responseRecorder notes the status and size of a response. It unwraps to
+// the ResponseWriter it wraps, so http.ResponseController still reaches
+// the connection, and it flushes for server-sent events.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Status returns the status sent, 200 if the handler wrote nothing.
+func (rec *responseRecorder) Status() int {
+	if !rec.wroteHeader {
+		return http.StatusOK
+	}
+	return rec.status
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"forum/repository/memory"
+	"forum/utils"
+)
+
+// logRecords decodes the JSON records written to buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var records []map[string]interface{}
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var record map[string]interface{}
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("log output is not JSON: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRequestLog(t *testing.T) {
+	stores := memory.New()
+	userID := utils.GenerateId()
+	stores.Users.Create(utils.User{ID: userID, UserName: "alice"})
+	session, _ := stores.Sessions.Create(userID, "test", "127.0.0.1")
+	auth := newAuthenticator(stores)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth.currentUser(r)
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}), RequestLog(logger))
+
+	req := httptest.NewRequest(http.MethodGet, "/tea?token=secret", nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: session})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	id := rr.Header().Get("X-Request-ID")
+	if id == "" {
+		t.Fatal("response has no X-Request-ID")
+	}
+	records := logRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("logged %d records, want 1", len(records))
+	}
+	want := map[string]interface{}{
+		"msg": "request", "id": id, "method": "GET", "path": "/tea",
+		"status": float64(http.StatusTeapot), "bytes": float64(15), "user_id": userID,
+	}
+	for key, value := range want {
+		if records[0][key] != value {
+			t.Errorf("record %s = %v, want %v", key, records[0][key], value)
+		}
+	}
+	if _, ok := records[0]["duration"]; !ok {
+		t.Errorf("record has no duration: %v", records[0])
+	}
+
+	// An ID from the proxy is kept, one that could mangle the logs is not
+	for header, keep := range map[string]bool{"abc-123": true, "bad id\n": false} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Fly-Request-Id", header)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if got := rr.Header().Get("X-Request-ID"); (got == header) != keep {
+			t.Errorf("request ID for header %q = %q", header, got)
+		}
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}), RequestLog(logger), RecoverPanics(logger))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rr.Code)
+	}
+
+	records := logRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("logged %d records, want the panic and the request", len(records))
+	}
+	if records[0]["error"] != "boom" || records[0]["stack"] == "" || records[0]["id"] != rr.Header().Get("X-Request-ID") {
+		t.Errorf("panic record = %v", records[0])
+	}
+	if records[1]["status"] != float64(http.StatusInternalServerError) || records[1]["level"] != "ERROR" {
+		t.Errorf("request record = %v, want an error with status 500", records[1])
+	}
+}
+
+func TestResponseRecorder_Flushes(t *testing.T) {
+	rr := httptest.NewRecorder()
+	var w http.ResponseWriter = &responseRecorder{ResponseWriter: rr}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		t.Fatal("responseRecorder is not an http.Flusher")
+	}
+	w.Write([]byte("data: 1\n\n"))
+	flusher.Flush()
+	if !rr.Flushed {
+		t.Errorf("Flush did not reach the underlying writer")
+	}
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"forum/logging"
+	"forum/repository"
+	"forum/utils"
+)
+
+// authenticator checks sessions and roles for the handlers.
+type authenticator struct {
+	sessions repository.SessionStore
+	users    repository.UserStore
+	// requireVerification keeps users with unverified email addresses from
+	// posting, see requireVerified
+	requireVerification bool
+}
+
+func newAuthenticator(stores *repository.Stores) authenticator {
+	return authenticator{sessions: stores.Sessions, users: stores.Users}
+}
+
+// currentUser returns the ID of the signed-in user, or "" for visitors.
+func (a authenticator) currentUser(r *http.Request) string {
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return ""
+	}
+	userID, err := a.sessions.Validate(cookie.Value)
+	if err != nil {
+		return ""
+	}
+	logging.SetUser(r.Context(), userID)
+	return userID
+}
+
+// requireAuth redirects visitors without a valid session to the sign-in page
+// and stores the user's ID in the request context.
+func (a authenticator) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := a.currentUser(r)
+		if userID == "" {
+			http.Redirect(w, r, "/signin", http.StatusSeeOther)
+			return
+		}
+
+		// Store userID in request context
+		ctx := context.WithValue(r.Context(), "userID", userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// requirePermission extends requireAuth by also rejecting users whose role
+// does not grant perm. The user's role is stored in the context as "userRole".
+func (a authenticator) requirePermission(perm utils.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return a.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value("userID").(string)
+		role, err := a.users.Role(userID)
+		if err != nil {
+			log.Printf("Error fetching role for user %s: %v", userID, err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+
+		if !utils.HasPermission(role, perm) {
+			utils.RenderErrorPage(w, http.StatusForbidden, utils.ErrForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "userRole", role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// userCan reports whether a user's role grants perm. Lookup errors deny.
+func (a authenticator) userCan(userID string, perm utils.Permission) bool {
+	if userID == "" {
+		return false
+	}
+	role, err := a.users.Role(userID)
+	if err != nil {
+		log.Printf("Error fetching role for user %s: %v", userID, err)
+		return false
+	}
+	return utils.HasPermission(role, perm)
+}
+
+// requireVerified extends requireAuth by sending users who have not verified
+// their email address to /verify-email. It only applies when
+// a.requireVerification is set.
+func (a authenticator) requireVerified(next http.HandlerFunc) http.HandlerFunc {
+	return a.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !a.requireVerification {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		userID := r.Context().Value("userID").(string)
+		verified, err := a.users.EmailVerified(userID)
+		if err != nil {
+			log.Printf("Error checking email verification for user %s: %v", userID, err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+		if !verified {
+			http.Redirect(w, r, "/verify-email", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maxRequestSize caps the bodies CSRFProtect reads: one image upload and
+// the fields that come with it.
+const maxRequestSize = maxUploadSize + 1<<20
+
+// CSRFProtect rejects state-changing requests from signed-in users unless
+// they carry the session's CSRF token, either in the csrf_token form field
+// or, for fetch calls, in the X-CSRF-Token header. Requests without a valid
+// session pass through, since handlers that need one turn them away anyway.
+func CSRFProtect(sessions repository.SessionStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie("session_token")
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, err := sessions.Validate(cookie.Value); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		expected, err := sessions.CSRFToken(cookie.Value)
+		if err != nil {
+			log.Printf("Error fetching CSRF token: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+
+		submitted := r.Header.Get(utils.CSRFHeader)
+		if submitted == "" {
+			// The form is parsed here, before any handler could limit it.
+			// Handlers parsing it again get what was read here.
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+			err := r.ParseMultipartForm(maxUploadSize)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				utils.RenderErrorPage(w, http.StatusRequestEntityTooLarge, utils.ErrFileTooLarge)
+				return
+			}
+			submitted = r.PostFormValue(utils.CSRFField)
+		}
+		if !utils.ValidCSRFToken(expected, submitted) {
+			log.Printf("Rejected %s %s: CSRF token mismatch", r.Method, r.URL.Path)
+			utils.RenderErrorPage(w, http.StatusForbidden, utils.ErrCSRF)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PageSessions returns middleware that lets page templates show the CSRF
+// token and unread notification count of the session making a request.
+func PageSessions(sessions repository.SessionStore, notifications repository.NotificationStore) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := pageSession{sessions: sessions, notifications: notifications}
+			if cookie, err := r.Cookie("session_token"); err == nil {
+				session.token = cookie.Value
+			}
+			next.ServeHTTP(w, r.WithContext(utils.WithPageSession(r.Context(), session)))
+		})
+	}
+}
+
+// pageSession looks up what a page shows only when its template asks.
+type pageSession struct {
+	sessions      repository.SessionStore
+	notifications repository.NotificationStore
+	token         string
+}
+
+func (s pageSession) CSRFToken() string {
+	if s.token == "" {
+		return ""
+	}
+	token, err := s.sessions.CSRFToken(s.token)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+func (s pageSession) UnreadNotifications() int {
+	if s.token == "" {
+		return 0
+	}
+	userID, err := s.sessions.Validate(s.token)
+	if err != nil {
+		return 0
+	}
+	count, err := s.notifications.CountUnread(userID)
+	if err != nil {
+		return 0
+	}
+	return count
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"forum/repository/memory"
+	"forum/utils"
+)
+
+func TestAuthenticator_currentUser(t *testing.T) {
+	stores := memory.New()
+	auth := newAuthenticator(stores)
+
+	userID := "test_user_123"
+	sessionToken, err := stores.Sessions.Create(userID, "test-agent", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed to create test session: %v", err)
+	}
+
+	testCases := []struct {
+		name   string
+		cookie *http.Cookie
+		want   string
+	}{
+		{"Valid Session", &http.Cookie{Name: "session_token", Value: sessionToken}, userID},
+		{"No Session Cookie", nil, ""},
+		{"Invalid Session Token", &http.Cookie{Name: "session_token", Value: "invalid_token"}, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			if tc.cookie != nil {
+				req.AddCookie(tc.cookie)
+			}
+			if got := auth.currentUser(req); got != tc.want {
+				t.Errorf("currentUser() = %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_requirePermission(t *testing.T) {
+	stores := memory.New()
+	auth := newAuthenticator(stores)
+	stores.Users.Create(utils.User{ID: "member", UserName: "member"})
+	stores.Users.Create(utils.User{ID: "admin", UserName: "admin", Role: utils.RoleAdmin})
+	memberToken, _ := stores.Sessions.Create("member", "test-agent", "127.0.0.1")
+	adminToken, _ := stores.Sessions.Create("admin", "test-agent", "127.0.0.1")
+
+	var gotRole string
+	handler := auth.requirePermission(utils.PermManageCategories, func(w http.ResponseWriter, r *http.Request) {
+		gotRole = r.Context().Value("userRole").(string)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	get := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get(""); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/signin" {
+		t.Errorf("visitor: got %d to %q, want redirect to /signin", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := get(memberToken); rr.Code != http.StatusForbidden {
+		t.Errorf("member: got %d, want %d", rr.Code, http.StatusForbidden)
+	}
+	if rr := get(adminToken); rr.Code != http.StatusOK || gotRole != utils.RoleAdmin {
+		t.Errorf("admin: got %d with role %q, want %d with admin", rr.Code, gotRole, http.StatusOK)
+	}
+
+	// Banning ends the session, so the next request is treated as a visitor
+	stores.Users.Ban("admin")
+	if rr := get(adminToken); rr.Code != http.StatusSeeOther {
+		t.Errorf("banned admin: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+func TestPageSessions(t *testing.T) {
+	stores := memory.New()
+	stores.Users.Create(utils.User{ID: "member", UserName: "member"})
+	token, _ := stores.Sessions.Create("member", "test-agent", "127.0.0.1")
+	csrfToken, _ := stores.Sessions.CSRFToken(token)
+
+	page := filepath.Join(t.TempDir(), "page.html")
+	os.WriteFile(page, []byte(`{{csrfToken}}|{{unreadNotifications}}`), 0o644)
+	handler := PageSessions(stores.Sessions, stores.Notifications)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tmpl, err := utils.ParseTemplate(r, page)
+		if err != nil {
+			t.Fatalf("ParseTemplate returned error: %v", err)
+		}
+		tmpl.Execute(w, nil)
+	}))
+
+	render := func(token string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return strings.TrimSpace(rr.Body.String())
+	}
+
+	if got, want := render(token), csrfToken+"|0"; got != want {
+		t.Errorf("signed in: got %q, want %q", got, want)
+	}
+	if got := render(""); got != "|0" {
+		t.Errorf("visitor: got %q, want %q", got, "|0")
+	}
+	if got := render("invalid_token"); got != "|0" {
+		t.Errorf("invalid session: got %q, want %q", got, "|0")
+	}
+}
@@ -0,0 +1,383 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+const (
+	maxReportDetails  = 500
+	maxQueueSize      = 100
+	maxLogEntries     = 50
+	maxSuspensionDays = 365
+)
+
+var (
+	errInvalidReport      = errors.New("invalid report reason or details")
+	errReportTarget       = errors.New("reported content does not exist")
+	errReportOwnContent   = errors.New("cannot report your own content")
+	errCannotModerateUser = errors.New("cannot act on a user with an equal or higher role")
+	errUnknownAction      = errors.New("unknown moderation action")
+)
+
+// ModerationHandler accepts reports from users and serves the moderation
+// queue where staff resolve them.
+type ModerationHandler struct {
+	authenticator
+	stores *repository.Stores
+}
+
+func NewModerationHandler(stores *repository.Stores) *ModerationHandler {
+	return &ModerationHandler{authenticator: newAuthenticator(stores), stores: stores}
+}
+
+type queuedReport struct {
+	ID           int
+	PostID       int
+	CommentID    int // 0 when the post itself is reported
+	Reason       string
+	Details      string
+	ReporterName string
+	AuthorName   string // Empty when the content has been deleted
+	Excerpt      string
+	Hidden       bool
+	OpenReports  int // Open reports on the same content
+	CreatedAt    string
+}
+
+type moderationLogEntry struct {
+	Action        string
+	ModeratorName string
+	TargetName    string
+	PostID        int
+	CommentID     int
+	ReportID      int
+	Note          string
+	CreatedAt     string
+}
+
+func (mh *ModerationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/report":
+		if r.Method == http.MethodPost {
+			mh.requireAuth(mh.handleReport).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/moderation":
+		if r.Method == http.MethodGet {
+			mh.requirePermission(utils.PermReviewReports, mh.handleQueue).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/moderation/resolve":
+		if r.Method == http.MethodPost {
+			mh.requirePermission(utils.PermReviewReports, mh.handleResolve).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	default:
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPageNotFound)
+	}
+}
+
+// canViewHidden reports whether a viewer may see content hidden by a
+// moderator: its author and staff can, everyone else cannot.
+func (a authenticator) canViewHidden(authorID, viewerID string) bool {
+	if viewerID == "" {
+		return false
+	}
+	return authorID == viewerID || a.userCan(viewerID, utils.PermReviewReports)
+}
+
+func (mh *ModerationHandler) handleReport(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	if err := r.ParseForm(); err != nil {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	postID, _ := strconv.Atoi(r.FormValue("post_id"))
+	commentID, _ := strconv.Atoi(r.FormValue("comment_id"))
+	if postID <= 0 && commentID <= 0 {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	postID, err := mh.createReport(userID, postID, commentID, r.FormValue("reason"), r.FormValue("details"))
+	switch err {
+	case nil:
+	case errReportTarget:
+		utils.RenderErrorPage(w, http.StatusNotFound, "The reported content could not be found.")
+		return
+	case errReportOwnContent:
+		utils.RenderErrorPage(w, http.StatusBadRequest, "You cannot report your own content.")
+		return
+	case errInvalidReport:
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	default:
+		log.Printf("Error creating report: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/?id=%d&reported=1", postID), http.StatusSeeOther)
+}
+
+// createReport records a report on a post, or on a comment when commentID is
+// set, and returns the ID of the post involved. Reporting the same content
+// twice while the first report is open does nothing.
+func (mh *ModerationHandler) createReport(reporterID string, postID, commentID int, reason, details string) (int, error) {
+	details = strings.TrimSpace(details)
+	if !utils.ValidReportReason(reason) || len([]rune(details)) > maxReportDetails {
+		return 0, errInvalidReport
+	}
+
+	authorID, postID, err := mh.contentAuthor(postID, commentID)
+	if err == repository.ErrNotFound {
+		return 0, errReportTarget
+	} else if err != nil {
+		return 0, err
+	}
+	if authorID == reporterID {
+		return 0, errReportOwnContent
+	}
+
+	err = mh.stores.Moderation.CreateReport(repository.Report{
+		ReporterID: reporterID,
+		PostID:     postID,
+		CommentID:  commentID,
+		Reason:     reason,
+		Details:    details,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return postID, nil
+}
+
+// contentAuthor returns the author of a post, or of a comment when commentID
+// is set, and the ID of the post involved.
+func (mh *ModerationHandler) contentAuthor(postID, commentID int) (string, int, error) {
+	if commentID > 0 {
+		comment, err := mh.stores.Comments.Get(commentID)
+		return comment.UserID, comment.PostID, err
+	}
+	post, err := mh.stores.Posts.Get(postID)
+	if err != nil {
+		return "", 0, err
+	}
+	return post.UserID, postID, nil
+}
+
+func (mh *ModerationHandler) handleQueue(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	reports, err := mh.getOpenReports()
+	if err != nil {
+		log.Printf("Error fetching reports: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	entries, err := mh.getModerationLog(maxLogEntries)
+	if err != nil {
+		log.Printf("Error fetching moderation log: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	data := struct {
+		Reports       []queuedReport
+		Log           []moderationLogEntry
+		CurrentUserID string
+		IsLoggedIn    bool
+	}{
+		Reports:       reports,
+		Log:           entries,
+		CurrentUserID: userID,
+		IsLoggedIn:    true,
+	}
+
+	tmpl, err := utils.ParseTemplate(r, "templates/moderation.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
+		return
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
+	}
+}
+
+// getOpenReports returns open reports oldest first, so the queue is worked
+// through in the order reports came in.
+func (mh *ModerationHandler) getOpenReports() ([]queuedReport, error) {
+	open, err := mh.stores.Moderation.OpenReports(maxQueueSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []queuedReport
+	for _, r := range open {
+		rep := queuedReport{
+			ID:           r.ID,
+			PostID:       r.PostID,
+			CommentID:    r.CommentID,
+			Reason:       r.Reason,
+			Details:      r.Details,
+			ReporterName: r.ReporterName,
+			AuthorName:   r.AuthorName,
+			Excerpt:      r.Excerpt,
+			Hidden:       r.Hidden,
+			OpenReports:  r.OpenReports,
+			CreatedAt:    FormatTimeAgo(r.CreatedAt),
+		}
+		if excerpt := []rune(rep.Excerpt); len(excerpt) > 200 {
+			rep.Excerpt = string(excerpt[:200]) + "…"
+		}
+		reports = append(reports, rep)
+	}
+	return reports, nil
+}
+
+func (mh *ModerationHandler) getModerationLog(limit int) ([]moderationLogEntry, error) {
+	logged, err := mh.stores.Moderation.RecentLog(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []moderationLogEntry
+	for _, e := range logged {
+		entries = append(entries, moderationLogEntry{
+			Action:        e.Action,
+			ModeratorName: e.ModeratorName,
+			TargetName:    e.TargetName,
+			PostID:        e.PostID,
+			CommentID:     e.CommentID,
+			ReportID:      e.ReportID,
+			Note:          e.Note,
+			CreatedAt:     e.CreatedAt.Local().Format("Jan 2, 2006 15:04"),
+		})
+	}
+	return entries, nil
+}
+
+func (mh *ModerationHandler) handleResolve(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	role := r.Context().Value("userRole").(string)
+
+	if err := r.ParseForm(); err != nil {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	reportID, err := strconv.Atoi(r.FormValue("report_id"))
+	if err != nil {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+	days := 0
+	if r.FormValue("action") == utils.ModSuspend {
+		days, err = strconv.Atoi(r.FormValue("days"))
+		if err != nil || days < 1 || days > maxSuspensionDays {
+			utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+			return
+		}
+	}
+
+	err = mh.resolveReport(userID, role, reportID, r.FormValue("action"), strings.TrimSpace(r.FormValue("note")), days)
+	switch err {
+	case nil:
+	case repository.ErrNotFound:
+		utils.RenderErrorPage(w, http.StatusNotFound, "Report not found")
+		return
+	case repository.ErrReportClosed:
+		utils.RenderErrorPage(w, http.StatusConflict, "This report has already been resolved.")
+		return
+	case errReportTarget:
+		utils.RenderErrorPage(w, http.StatusConflict, "The reported content no longer exists. Dismiss the report instead.")
+		return
+	case errCannotModerateUser:
+		utils.RenderErrorPage(w, http.StatusForbidden, utils.ErrForbidden)
+		return
+	case errUnknownAction:
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	default:
+		log.Printf("Error resolving report %d: %v", reportID, err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
+}
+
+// resolveReport applies a moderator's decision on a report and records it in
+// the moderation log. Any action other than dismissing closes every open
+// report on the same content, and needs a role above the content's author.
+func (mh *ModerationHandler) resolveReport(moderatorID, moderatorRole string, reportID int, action, note string, suspendDays int) error {
+	report, err := mh.stores.Moderation.GetReport(reportID)
+	if err != nil {
+		return err
+	}
+	if report.Status != "open" {
+		return repository.ErrReportClosed
+	}
+
+	authorID, _, err := mh.contentAuthor(report.PostID, report.CommentID)
+	if err != nil && err != repository.ErrNotFound {
+		return err
+	}
+	entry := utils.ModerationEntry{
+		ModeratorID:  moderatorID,
+		Action:       action,
+		ReportID:     reportID,
+		TargetUserID: authorID,
+		PostID:       report.PostID,
+		CommentID:    report.CommentID,
+		Note:         note,
+	}
+
+	var suspendUntil time.Time
+	switch action {
+	case utils.ModDismiss:
+		return mh.stores.Moderation.Resolve(entry, suspendUntil)
+	case utils.ModDelete, utils.ModHide, utils.ModWarn:
+	case utils.ModSuspend:
+		suspendUntil = time.Now().Add(time.Duration(suspendDays) * 24 * time.Hour)
+		entry.Note = strings.TrimSpace(fmt.Sprintf("%d days. %s", suspendDays, note))
+	default:
+		return errUnknownAction
+	}
+
+	if authorID == "" {
+		return errReportTarget
+	}
+	authorRole, err := mh.stores.Users.Role(authorID)
+	if err != nil {
+		return err
+	}
+	if authorID == moderatorID || !utils.Outranks(moderatorRole, authorRole) {
+		return errCannotModerateUser
+	}
+
+	err = mh.stores.Moderation.Resolve(entry, suspendUntil)
+	if err == repository.ErrNotFound {
+		// The content or its author went away since it was looked up
+		return errReportTarget
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"testing"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+func TestCreateReport(t *testing.T) {
+	db := openTestDB(t)
+
+	stores := repository.NewSQLite(db)
+	mh := NewModerationHandler(stores)
+	ownerID, postID := seedPost(t, db)
+	defer stores.Posts.Delete(postID)
+	reporterID, _ := seedUser(t, db, utils.RoleMember)
+
+	var commentID int
+	db.QueryRow("SELECT id FROM comments WHERE post_id = ?", postID).Scan(&commentID)
+
+	if _, err := mh.createReport(ownerID, postID, 0, "spam", ""); err != errReportOwnContent {
+		t.Errorf("reporting own post returned %v, want errReportOwnContent", err)
+	}
+	if _, err := mh.createReport(reporterID, postID, 0, "boring", ""); err != errInvalidReport {
+		t.Errorf("unknown reason returned %v, want errInvalidReport", err)
+	}
+	if _, err := mh.createReport(reporterID, postID+1000000, 0, "spam", ""); err != errReportTarget {
+		t.Errorf("missing post returned %v, want errReportTarget", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := mh.createReport(reporterID, postID, 0, "spam", "buy now"); err != nil {
+			t.Fatalf("createReport returned error: %v", err)
+		}
+	}
+	got, err := mh.createReport(reporterID, 0, commentID, "harassment", "")
+	if err != nil || got != postID {
+		t.Fatalf("comment report = %d, %v; want post %d", got, err, postID)
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM reports WHERE reporter_id = ? AND comment_id IS NULL", reporterID).Scan(&count)
+	if count != 1 {
+		t.Errorf("duplicate post reports stored %d rows, want 1", count)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM reports WHERE reporter_id = ? AND comment_id = ? AND post_id = ?", reporterID, commentID, postID).Scan(&count)
+	if count != 1 {
+		t.Errorf("comment report stored %d rows, want 1", count)
+	}
+}
+
+func TestResolveReport(t *testing.T) {
+	db := openTestDB(t)
+
+	stores := repository.NewSQLite(db)
+	mh := NewModerationHandler(stores)
+	ownerID, postID := seedPost(t, db)
+	defer stores.Posts.Delete(postID)
+	firstID, _ := seedUser(t, db, utils.RoleMember)
+	secondID, _ := seedUser(t, db, utils.RoleMember)
+	moderatorID, _ := seedUser(t, db, utils.RoleModerator)
+
+	mh.createReport(firstID, postID, 0, "spam", "")
+	mh.createReport(secondID, postID, 0, "off-topic", "")
+	var firstReport, secondReport int
+	db.QueryRow("SELECT id FROM reports WHERE reporter_id = ?", firstID).Scan(&firstReport)
+	db.QueryRow("SELECT id FROM reports WHERE reporter_id = ?", secondID).Scan(&secondReport)
+
+	if err := mh.resolveReport(moderatorID, utils.RoleModerator, firstReport, "shout", "", 0); err != errUnknownAction {
+		t.Errorf("unknown action returned %v, want errUnknownAction", err)
+	}
+	if err := mh.resolveReport(moderatorID, utils.RoleModerator, firstReport, utils.ModHide, "spam link", 0); err != nil {
+		t.Fatalf("hide returned error: %v", err)
+	}
+
+	var hidden bool
+	db.QueryRow("SELECT hidden_at IS NOT NULL FROM posts WHERE id = ?", postID).Scan(&hidden)
+	if !hidden {
+		t.Errorf("post was not hidden")
+	}
+	var status string
+	db.QueryRow("SELECT status FROM reports WHERE id = ?", secondReport).Scan(&status)
+	if status != "actioned" {
+		t.Errorf("other report on the same post has status %q, want actioned", status)
+	}
+	if err := mh.resolveReport(moderatorID, utils.RoleModerator, secondReport, utils.ModDismiss, "", 0); err != repository.ErrReportClosed {
+		t.Errorf("resolving a closed report returned %v, want ErrReportClosed", err)
+	}
+
+	posts, _, err := fetchFeed(stores.Posts, repository.FeedFilter{AuthorID: ownerID}, feedOptions{Sort: "new"})
+	if err != nil {
+		t.Fatalf("fetchFeed returned error: %v", err)
+	}
+	if len(posts) != 0 {
+		t.Errorf("hidden post is still in the feed")
+	}
+
+	// Acting on content needs a higher role than its author
+	db.Exec("UPDATE users SET role = ? WHERE id = ?", utils.RoleModerator, ownerID)
+	mh.createReport(firstID, postID, 0, "spam", "again")
+	var thirdReport int
+	db.QueryRow("SELECT id FROM reports WHERE reporter_id = ? AND status = 'open'", firstID).Scan(&thirdReport)
+	for _, action := range []string{utils.ModDelete, utils.ModHide, utils.ModWarn, utils.ModSuspend} {
+		if err := mh.resolveReport(moderatorID, utils.RoleModerator, thirdReport, action, "", 3); err != errCannotModerateUser {
+			t.Errorf("%s against a moderator returned %v, want errCannotModerateUser", action, err)
+		}
+	}
+	db.QueryRow("SELECT status FROM reports WHERE id = ?", thirdReport).Scan(&status)
+	if status != "open" {
+		t.Errorf("refused actions left the report %s", status)
+	}
+	if err := mh.resolveReport(moderatorID, utils.RoleAdmin, thirdReport, utils.ModSuspend, "", 3); err != nil {
+		t.Fatalf("suspend returned error: %v", err)
+	}
+	var suspended bool
+	db.QueryRow("SELECT julianday(suspended_until) > julianday('now') FROM users WHERE id = ?", ownerID).Scan(&suspended)
+	if !suspended {
+		t.Errorf("author was not suspended")
+	}
+
+	var entries int
+	db.QueryRow("SELECT COUNT(*) FROM moderation_log WHERE moderator_id = ?", moderatorID).Scan(&entries)
+	if entries != 2 {
+		t.Errorf("moderation log has %d entries, want 2", entries)
+	}
+	if _, err := db.Exec("UPDATE moderation_log SET note = 'changed' WHERE moderator_id = ?", moderatorID); err == nil {
+		t.Errorf("moderation log entry was updated")
+	}
+	if _, err := db.Exec("DELETE FROM moderation_log WHERE moderator_id = ?", moderatorID); err == nil {
+		t.Errorf("moderation log entry was deleted")
+	}
+}
@@ -1,60 +1,175 @@
 package controllers
 
 import (
-	"html/template"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"forum/repository"
 	"forum/utils"
 )
 
-type NotificationHandler struct{}
+type NotificationHandler struct {
+	authenticator
+	notifications repository.NotificationStore
+}
 
-func NewNotificationHandler() *NotificationHandler {
-	return &NotificationHandler{}
+func NewNotificationHandler(stores *repository.Stores) *NotificationHandler {
+	return &NotificationHandler{authenticator: newAuthenticator(stores), notifications: stores.Notifications}
 }
 
 func (nh *NotificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
-		return
+	switch r.URL.Path {
+	case "/notifications":
+		if r.Method == http.MethodGet {
+			nh.requireAuth(nh.handleGetNotifications).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/notifications/read":
+		if r.Method == http.MethodPost {
+			nh.requireAuth(nh.handleMarkRead).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/notifications/read-all":
+		if r.Method == http.MethodPost {
+			nh.requireAuth(nh.handleMarkAllRead).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/notifications/stream":
+		if r.Method == http.MethodGet {
+			nh.requireAuth(nh.handleStream).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/notifications/preferences":
+		switch r.Method {
+		case http.MethodGet:
+			nh.requireAuth(nh.handleGetPreferences).ServeHTTP(w, r)
+		case http.MethodPost:
+			nh.requireAuth(nh.handleSavePreferences).ServeHTTP(w, r)
+		default:
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	default:
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPageNotFound)
 	}
-	nh.handleGetNotifications(w, r)
 }
 
 func (nh *NotificationHandler) handleGetNotifications(w http.ResponseWriter, r *http.Request) {
-	// Get current user ID from session
-	cookie, err := r.Cookie("session_token")
+	userID := r.Context().Value("userID").(string)
+
+	// Fetch notifications for the user
+	notifications, err := nh.notifications.List(userID)
 	if err != nil {
-		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		log.Printf("Error fetching notifications: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
 		return
 	}
+	for i := range notifications {
+		notifications[i].CreatedAtFormatted = FormatTimeAgo(notifications[i].CreatedAt)
+	}
 
-	userID, err := utils.ValidateSession(utils.GlobalDB, cookie.Value)
+	data := struct {
+		Notifications []utils.Notification
+		IsLoggedIn    bool
+		CurrentUserID string
+	}{
+		Notifications: notifications,
+		IsLoggedIn:    true,
+		CurrentUserID: userID,
+	}
+
+	tmpl, err := utils.ParseTemplate(r, "templates/notifications.html")
 	if err != nil {
-		http.Redirect(w, r, "/signin", http.StatusSeeOther)
+		log.Printf("Error parsing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
 		return
 	}
 
-	// Fetch notifications for the user
-	notifications, err := nh.getUserNotifications(userID)
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
+	}
+}
+
+func (nh *NotificationHandler) handleMarkRead(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	notificationID, err := strconv.Atoi(r.FormValue("id"))
 	if err != nil {
-		log.Printf("Error fetching notifications: %v", err)
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	found, err := nh.notifications.MarkRead(userID, notificationID)
+	if err != nil {
+		log.Printf("Error marking notification %d read: %v", notificationID, err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
 		return
 	}
+	if !found {
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+func (nh *NotificationHandler) handleMarkAllRead(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	if err := nh.notifications.MarkAllRead(userID); err != nil {
+		log.Printf("Error marking notifications read: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusSeeOther)
+}
+
+// preferenceRow is one notification type on the preferences page.
+type preferenceRow struct {
+	Type     string
+	Label    string
+	Delivery string
+}
+
+func (nh *NotificationHandler) handleGetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	prefs, err := nh.notifications.Preferences(userID)
+	if err != nil {
+		log.Printf("Error fetching notification preferences: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	var rows []preferenceRow
+	for _, t := range utils.NotificationTypes {
+		rows = append(rows, preferenceRow{Type: t.Type, Label: t.Label, Delivery: prefs[t.Type]})
+	}
 
 	data := struct {
-		Notifications []utils.Notification
+		Preferences   []preferenceRow
+		Saved         bool
 		IsLoggedIn    bool
 		CurrentUserID string
 	}{
-		Notifications: notifications,
+		Preferences:   rows,
+		Saved:         r.URL.Query().Get("saved") == "1",
 		IsLoggedIn:    true,
 		CurrentUserID: userID,
 	}
 
-	tmpl, err := template.ParseFiles("templates/notifications.html")
+	tmpl, err := utils.ParseTemplate(r, "templates/notification_preferences.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
@@ -67,29 +182,158 @@ func (nh *NotificationHandler) handleGetNotifications(w http.ResponseWriter, r *
 	}
 }
 
-func (nh *NotificationHandler) getUserNotifications(userID string) ([]utils.Notification, error) {
-	rows, err := utils.GlobalDB.Query(`
-        SELECT n.id, n.type, n.created_at, n.post_id, u.username, u.profile_pic
-        FROM notifications n
-        JOIN users u ON n.actor_id = u.id
-        WHERE n.user_id = ?
-        ORDER BY n.created_at DESC
-    `, userID)
+func (nh *NotificationHandler) handleSavePreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	for _, t := range utils.NotificationTypes {
+		delivery := r.FormValue(t.Type)
+		if delivery == "" {
+			continue
+		}
+		err := nh.notifications.SetPreference(userID, t.Type, delivery)
+		if err == utils.ErrInvalidDelivery {
+			utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+			return
+		} else if err != nil {
+			log.Printf("Error saving notification preference: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/notifications/preferences?saved=1", http.StatusSeeOther)
+}
+
+// streamHeartbeat keeps idle streams from being closed by proxies.
+const streamHeartbeat = 25 * time.Second
+
+// streamMessage is the data of a "notification" event. HTML is the entry as
+// rendered on the notifications page, so the page can insert it as is in
+// place of the entry with the same Group.
+type streamMessage struct {
+	ID     int    `json:"id"`
+	Group  string `json:"group"`
+	Unread int    `json:"unread"`
+	HTML   string `json:"html"`
+}
+
+// handleStream pushes the user's new notifications and unread count as
+// server-sent events until the client disconnects or the server shuts down.
+// A "notification" event carries a new notification and an "unread" event
+// only the new count.
+func (nh *NotificationHandler) handleStream(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	tmpl, err := utils.ParseTemplate(r, "templates/notifications.html")
 	if err != nil {
-		return nil, err
+		log.Printf("Error parsing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
+		return
+	}
+
+	// Subscribe before looking up missed notifications so none fall between
+	events, unsubscribe := utils.Notifications.Subscribe(userID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	// The server's read and write timeouts are for ordinary requests; a
+	// stream stays open. Not every ResponseWriter supports deadlines, e.g.
+	// in tests, and then there are none to clear.
+	rc := http.NewResponseController(w)
+	for _, clear := range []func(time.Time) error{rc.SetReadDeadline, rc.SetWriteDeadline} {
+		if err := clear(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Printf("Error clearing deadlines of notification stream: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
 	}
-	defer rows.Close()
+	w.WriteHeader(http.StatusOK)
 
-	var notifications []utils.Notification
-	for rows.Next() {
-		var n utils.Notification
-		err := rows.Scan(&n.ID, &n.Type, &n.CreatedAt, &n.PostID, &n.ActorName, &n.ActorProfilePic)
+	lastSent := 0
+	// send pushes the entry of notification id, or only the unread count if
+	// id is 0
+	send := func(id int) bool {
+		if id != 0 && id <= lastSent {
+			return true
+		}
+		unread, err := nh.notifications.CountUnread(userID)
 		if err != nil {
-			log.Printf("Error scanning notification: %v", err)
-			continue
+			log.Printf("Error counting unread notifications: %v", err)
+			return false
+		}
+
+		var group utils.Notification
+		if id != 0 {
+			group, err = nh.notifications.Group(userID, id)
+			if err == repository.ErrNotFound {
+				// Retracted before it could be sent
+				id = 0
+			} else if err != nil {
+				log.Printf("Error fetching notification %d: %v", id, err)
+				return false
+			}
+		}
+
+		if id == 0 {
+			fmt.Fprintf(w, "event: unread\ndata: %d\n\n", unread)
+		} else {
+			group.CreatedAtFormatted = FormatTimeAgo(group.CreatedAt)
+			var item bytes.Buffer
+			if err := tmpl.ExecuteTemplate(&item, "notification-item", group); err != nil {
+				log.Printf("Error rendering notification: %v", err)
+				return false
+			}
+			data, _ := json.Marshal(streamMessage{ID: id, Group: group.GroupKey(), Unread: unread, HTML: item.String()})
+			fmt.Fprintf(w, "id: %d\nevent: notification\ndata: %s\n\n", id, data)
+			lastSent = id
+		}
+		flusher.Flush()
+		return true
+	}
+
+	// Browsers reconnect with the ID of the last event they received
+	if lastID, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		missed, err := nh.notifications.Since(userID, lastID)
+		if err != nil {
+			log.Printf("Error fetching missed notifications: %v", err)
+			return
+		}
+		for _, id := range missed {
+			if !send(id) {
+				return
+			}
+		}
+	}
+	if !send(0) {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				// The server is shutting down
+				return
+			}
+			if !send(ev.NotificationID) {
+				return
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
 		}
-		n.CreatedAtFormatted = FormatTimeAgo(n.CreatedAt)
-		notifications = append(notifications, n)
 	}
-	return notifications, nil
 }
@@ -0,0 +1,428 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/repository"
+	"forum/storage"
+	"forum/utils"
+)
+
+// seedNotification has actorID like a new post of userID and returns the
+// notification the trigger creates for it.
+func seedNotification(t *testing.T, db *sql.DB, userID, actorID string) int {
+	t.Helper()
+
+	result, err := db.Exec("INSERT INTO posts (user_id, title, content) VALUES (?, 'Title', 'Content')", userID)
+	if err != nil {
+		t.Fatalf("Failed to insert post: %v", err)
+	}
+	postID, _ := result.LastInsertId()
+	if _, err := db.Exec("INSERT INTO reaction (user_id, post_id, like) VALUES (?, ?, 1)", actorID, postID); err != nil {
+		t.Fatalf("Failed to insert reaction: %v", err)
+	}
+
+	var id int
+	err = db.QueryRow("SELECT id FROM notifications WHERE user_id = ? AND post_id = ?", userID, postID).Scan(&id)
+	if err != nil {
+		t.Fatalf("Trigger did not create a notification: %v", err)
+	}
+	return id
+}
+
+func TestNotificationReadActions(t *testing.T) {
+	db := openTestDB(t)
+
+	userID, token := seedUser(t, db, utils.RoleMember)
+	actorID, actorToken := seedUser(t, db, utils.RoleMember)
+	first := seedNotification(t, db, userID, actorID)
+	seedNotification(t, db, userID, actorID)
+	seedNotification(t, db, userID, actorID)
+
+	unread := func() int {
+		count, err := utils.CountUnreadNotifications(db, userID)
+		if err != nil {
+			t.Fatalf("CountUnreadNotifications returned error: %v", err)
+		}
+		return count
+	}
+	if got := unread(); got != 3 {
+		t.Fatalf("unread = %d, want 3", got)
+	}
+
+	nh := NewNotificationHandler(repository.NewSQLite(db))
+	form := url.Values{"id": {strconv.Itoa(first)}}
+
+	// Someone else's notification cannot be marked read
+	if rr := postForm(nh, "/notifications/read", actorToken, form); rr.Code != http.StatusNotFound {
+		t.Errorf("marking another user's notification: got %d, want %d", rr.Code, http.StatusNotFound)
+	}
+
+	if rr := postForm(nh, "/notifications/read", token, form); rr.Code != http.StatusSeeOther {
+		t.Fatalf("mark read: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if got := unread(); got != 2 {
+		t.Errorf("after marking one read unread = %d, want 2", got)
+	}
+
+	if rr := postForm(nh, "/notifications/read-all", token, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("mark all read: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if got := unread(); got != 0 {
+		t.Errorf("after marking all read unread = %d, want 0", got)
+	}
+
+	if rr := postForm(nh, "/notifications/read-all", "", nil); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/signin" {
+		t.Errorf("anonymous mark all read: got %d to %q, want redirect to /signin", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestNotificationGrouping(t *testing.T) {
+	db := openTestDB(t)
+
+	userID, token := seedUser(t, db, utils.RoleMember)
+	result, err := db.Exec("INSERT INTO posts (user_id, title, content) VALUES (?, 'Title', 'Content')", userID)
+	if err != nil {
+		t.Fatalf("Failed to insert post: %v", err)
+	}
+	postID, _ := result.LastInsertId()
+
+	var last string
+	for i := 0; i < 3; i++ {
+		actorID, _ := seedUser(t, db, utils.RoleMember)
+		if _, err := db.Exec("INSERT INTO reaction (user_id, post_id, like) VALUES (?, ?, 1)", actorID, postID); err != nil {
+			t.Fatalf("Failed to insert reaction: %v", err)
+		}
+		last = actorID
+	}
+	var lastName string
+	db.QueryRow("SELECT username FROM users WHERE id = ?", last).Scan(&lastName)
+
+	notifications, err := utils.ListNotifications(db, userID)
+	if err != nil {
+		t.Fatalf("ListNotifications returned error: %v", err)
+	}
+	if len(notifications) != 1 {
+		t.Fatalf("got %d entries for three likes, want 1", len(notifications))
+	}
+	if want := lastName + " and 2 others"; notifications[0].Actors() != want {
+		t.Errorf("Actors() = %q, want %q", notifications[0].Actors(), want)
+	}
+	if count, _ := utils.CountUnreadNotifications(db, userID); count != 1 {
+		t.Errorf("unread = %d, want 1 for one grouped entry", count)
+	}
+
+	// Marking the entry read marks every like in it
+	form := url.Values{"id": {strconv.Itoa(notifications[0].ID)}}
+	if rr := postForm(NewNotificationHandler(repository.NewSQLite(db)), "/notifications/read", token, form); rr.Code != http.StatusSeeOther {
+		t.Fatalf("mark read: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	var unreadRows int
+	db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read_at IS NULL", userID).Scan(&unreadRows)
+	if unreadRows != 0 {
+		t.Errorf("%d grouped notifications still unread", unreadRows)
+	}
+}
+
+func TestRetractedReactionUpdatesNotification(t *testing.T) {
+	db := openTestDB(t)
+
+	userID, _ := seedUser(t, db, utils.RoleMember)
+	actorID, _ := seedUser(t, db, utils.RoleMember)
+	id := seedNotification(t, db, userID, actorID)
+	var postID int
+	db.QueryRow("SELECT post_id FROM notifications WHERE id = ?", id).Scan(&postID)
+
+	types := func() []string {
+		rows, err := db.Query("SELECT type FROM notifications WHERE user_id = ? AND post_id = ?", userID, postID)
+		if err != nil {
+			t.Fatalf("Failed to query notifications: %v", err)
+		}
+		defer rows.Close()
+		var types []string
+		for rows.Next() {
+			var notificationType string
+			rows.Scan(&notificationType)
+			types = append(types, notificationType)
+		}
+		return types
+	}
+
+	// Switching to a dislike replaces the like
+	if _, err := db.Exec("UPDATE reaction SET like = 0 WHERE user_id = ? AND post_id = ?", actorID, postID); err != nil {
+		t.Fatalf("Failed to update reaction: %v", err)
+	}
+	if got := types(); len(got) != 1 || got[0] != "dislike" {
+		t.Errorf("after switching reaction got notifications %v, want [dislike]", got)
+	}
+
+	if _, err := db.Exec("DELETE FROM reaction WHERE user_id = ? AND post_id = ?", actorID, postID); err != nil {
+		t.Fatalf("Failed to delete reaction: %v", err)
+	}
+	if got := types(); len(got) != 0 {
+		t.Errorf("after retracting reaction got notifications %v, want none", got)
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	db := openTestDB(t)
+
+	userID, token := seedUser(t, db, utils.RoleMember)
+	actorID, _ := seedUser(t, db, utils.RoleMember)
+	nh := NewNotificationHandler(repository.NewSQLite(db))
+
+	if rr := postForm(nh, "/notifications/preferences", token, url.Values{"like": {"sometimes"}}); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid delivery: got %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+
+	form := url.Values{"dislike": {utils.DeliveryOff}, "comment": {utils.DeliveryEmail}}
+	if rr := postForm(nh, "/notifications/preferences", token, form); rr.Code != http.StatusSeeOther {
+		t.Fatalf("save preferences: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	prefs, err := utils.GetNotificationPreferences(db, userID)
+	if err != nil {
+		t.Fatalf("GetNotificationPreferences returned error: %v", err)
+	}
+	if prefs["like"] != utils.DeliveryInApp || prefs["dislike"] != utils.DeliveryOff || prefs["comment"] != utils.DeliveryEmail {
+		t.Errorf("preferences = %v", prefs)
+	}
+
+	result, err := db.Exec("INSERT INTO posts (user_id, title, content) VALUES (?, 'Title', 'Content')", userID)
+	if err != nil {
+		t.Fatalf("Failed to insert post: %v", err)
+	}
+	postID, _ := result.LastInsertId()
+	db.Exec("INSERT INTO reaction (user_id, post_id, like) VALUES (?, ?, 0)", actorID, postID)
+	db.Exec("INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, 'hi')", postID, actorID)
+
+	var dislikes int
+	db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = ? AND type = 'dislike'", userID).Scan(&dislikes)
+	if dislikes != 0 {
+		t.Errorf("dislike notifications are off but %d were created", dislikes)
+	}
+
+	// Emailed notifications wait for the digest instead of showing in-app
+	if notifications, _ := utils.ListNotifications(db, userID); len(notifications) != 0 {
+		t.Errorf("got %d in-app notifications, want none", len(notifications))
+	}
+	pending, err := utils.PendingDigest(db, userID)
+	if err != nil {
+		t.Fatalf("PendingDigest returned error: %v", err)
+	}
+	if len(pending) != 1 || pending[0].Type != "comment" {
+		t.Fatalf("pending digest = %+v, want the comment", pending)
+	}
+	if err := utils.MarkDigestSent(db, userID, pending[0].ID); err != nil {
+		t.Fatalf("MarkDigestSent returned error: %v", err)
+	}
+	if pending, _ := utils.PendingDigest(db, userID); len(pending) != 0 {
+		t.Errorf("%d notifications still pending after the digest was sent", len(pending))
+	}
+}
+
+// chdirWithTemplates moves the test into a temporary directory linked to the
+// repository's templates, so handlers can render pages and the database the
+// test creates is thrown away.
+func chdirWithTemplates(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(filepath.Join(wd, "..", "templates"), filepath.Join(dir, "templates")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestNotificationStream(t *testing.T) {
+	chdirWithTemplates(t)
+	db := openTestDB(t)
+
+	// Without polling, only the update hook on the trigger's insert can wake
+	// the broker
+	utils.Notifications.PollInterval = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go utils.Notifications.Run(ctx, db)
+
+	userID, token := seedUser(t, db, utils.RoleMember)
+	actorID, _ := seedUser(t, db, utils.RoleMember)
+	missed := seedNotification(t, db, userID, actorID)
+
+	server := httptest.NewUnstartedServer(NewNotificationHandler(repository.NewSQLite(db)))
+	// Timeouts far shorter than the stream stays open, as a real server has
+	server.Config.ReadTimeout = 200 * time.Millisecond
+	server.Config.WriteTimeout = 200 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/notifications/stream", nil)
+	req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+	// Resuming from just before the first notification replays it
+	req.Header.Set("Last-Event-ID", strconv.Itoa(missed-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	// nextEvent returns the name and data of the next event on the stream
+	nextEvent := func() (string, string) {
+		var name, data string
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatal("stream closed")
+				}
+				switch {
+				case strings.HasPrefix(line, "event: "):
+					name = strings.TrimPrefix(line, "event: ")
+				case strings.HasPrefix(line, "data: "):
+					data = strings.TrimPrefix(line, "data: ")
+				case line == "" && name != "":
+					return name, data
+				}
+			case <-timeout:
+				t.Fatal("timed out waiting for an event")
+			}
+		}
+	}
+
+	if name, data := nextEvent(); name != "notification" || !strings.Contains(data, `"id":`+strconv.Itoa(missed)) {
+		t.Fatalf("first event = %s %s, want the missed notification", name, data)
+	}
+	if name, data := nextEvent(); name != "unread" || data != "1" {
+		t.Fatalf("second event = %s %s, want unread 1", name, data)
+	}
+
+	// The stream outlives the server's timeouts
+	time.Sleep(500 * time.Millisecond)
+	created := seedNotification(t, db, userID, actorID)
+	name, data := nextEvent()
+	if name != "notification" || !strings.Contains(data, `"id":`+strconv.Itoa(created)) || !strings.Contains(data, `"unread":2`) {
+		t.Fatalf("got event %s %s, want notification %d with unread 2", name, data, created)
+	}
+
+	if err := utils.MarkAllNotificationsRead(db, userID); err != nil {
+		t.Fatal(err)
+	}
+	if name, data := nextEvent(); name != "unread" || data != "0" {
+		t.Fatalf("after marking all read got %s %s, want unread 0", name, data)
+	}
+}
+
+func TestMentionNotifications(t *testing.T) {
+	db := openTestDB(t)
+
+	authorID, authorToken := seedUser(t, db, utils.RoleMember)
+	readerID, _ := seedUser(t, db, utils.RoleMember)
+	blockerID, _ := seedUser(t, db, utils.RoleMember)
+	quietID, _ := seedUser(t, db, utils.RoleMember)
+	usernames := make(map[string]string)
+	for _, id := range []string{authorID, readerID, blockerID, quietID} {
+		var name string
+		db.QueryRow("SELECT username FROM users WHERE id = ?", id).Scan(&name)
+		usernames[id] = name
+	}
+
+	if err := utils.BlockUser(db, blockerID, authorID); err != nil {
+		t.Fatalf("BlockUser returned error: %v", err)
+	}
+	if err := utils.SetNotificationPreference(db, quietID, "mention", utils.DeliveryOff); err != nil {
+		t.Fatalf("SetNotificationPreference returned error: %v", err)
+	}
+
+	result, err := db.Exec("INSERT INTO posts (user_id, title, content) VALUES (?, 'Title', 'Content')", authorID)
+	if err != nil {
+		t.Fatalf("Failed to insert post: %v", err)
+	}
+	id, _ := result.LastInsertId()
+	postID := int(id)
+	defer repository.NewSQLite(db).Posts.Delete(postID)
+
+	mentions := func(userID string) int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = ? AND post_id = ? AND type = 'mention'", userID, postID).Scan(&n)
+		return n
+	}
+
+	text := "@" + usernames[readerID] + " @" + usernames[blockerID] + " @" + usernames[quietID] + " @" + usernames[authorID] + " @nobody"
+	if err := utils.RecordMentions(db, authorID, postID, 0, text); err != nil {
+		t.Fatalf("RecordMentions returned error: %v", err)
+	}
+	if got := mentions(readerID); got != 1 {
+		t.Errorf("mentioned user got %d notifications, want 1", got)
+	}
+	if got := mentions(blockerID); got != 0 {
+		t.Errorf("user blocking the author got %d notifications, want 0", got)
+	}
+	if got := mentions(quietID); got != 0 {
+		t.Errorf("user with mentions off got %d notifications, want 0", got)
+	}
+	if got := mentions(authorID); got != 0 {
+		t.Errorf("author got %d notifications for mentioning themselves, want 0", got)
+	}
+
+	// Editing the text does not notify the same user again
+	if err := utils.RecordMentions(db, authorID, postID, 0, text+" edited"); err != nil {
+		t.Fatalf("RecordMentions returned error: %v", err)
+	}
+	if got := mentions(readerID); got != 1 {
+		t.Errorf("after edit mentioned user has %d notifications, want 1", got)
+	}
+
+	html := string(utils.RenderMarkdown(repository.NewSQLite(db).Renders, "<b>@"+usernames[readerID]+"</b> @nobody"))
+	link := `<a href="/profile/` + readerID + `" class="mention">@` + usernames[readerID] + `</a>`
+	if !strings.Contains(html, link) || strings.Contains(html, "<b>") || !strings.Contains(html, "@nobody") {
+		t.Errorf("RenderMarkdown = %q", html)
+	}
+
+	// Unblocking through the profile page lets mentions through again
+	ph := NewProfileHandler(repository.NewSQLite(db), storage.NewLocalStore(t.TempDir()), nil)
+	if rr := postForm(ph, "/profile/"+authorID+"/block", authorToken, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("blocking yourself: got %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+	if rr := postForm(ph, "/profile/"+readerID+"/block", authorToken, nil); rr.Code != http.StatusSeeOther {
+		t.Errorf("block: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if blocked, _ := utils.IsBlocked(db, authorID, readerID); !blocked {
+		t.Errorf("block route did not block the user")
+	}
+	if rr := postForm(ph, "/profile/"+readerID+"/unblock", authorToken, nil); rr.Code != http.StatusSeeOther {
+		t.Errorf("unblock: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	if blocked, _ := utils.IsBlocked(db, authorID, readerID); blocked {
+		t.Errorf("unblock route did not unblock the user")
+	}
+}
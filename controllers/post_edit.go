@@ -0,0 +1,385 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+type editPostData struct {
+	ErrorMessage  string
+	PostID        int
+	Title         string
+	Content       string
+	ImagePath     string
+	Categories    []utils.Category
+	SelectedCats  map[string]bool
+	IsLoggedIn    bool
+	CurrentUserID string
+}
+
+// postVersion is one entry of a post's edit history as shown on the
+// revisions page, together with the changes it made to the version before it.
+type postVersion struct {
+	Number      int
+	Title       string
+	Content     string
+	ImagePath   string
+	Categories  string
+	EditorName  string
+	EditedAt    string
+	IsCurrent   bool
+	TitleDiff   []utils.DiffLine
+	ContentDiff []utils.DiffLine
+}
+
+func (ph *PostHandler) displayEditForm(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || postID <= 0 {
+		utils.RenderErrorPage(w, http.StatusNotFound, "Invalid post ID")
+		return
+	}
+
+	post, err := ph.stores.Posts.Get(postID)
+	if err == repository.ErrNotFound {
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPostNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error fetching post for edit: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	if post.UserID != userID {
+		utils.RenderErrorPage(w, http.StatusForbidden, utils.ErrForbidden)
+		return
+	}
+
+	data := editPostData{
+		PostID:        postID,
+		Title:         post.Title,
+		Content:       post.Content,
+		ImagePath:     post.ImagePath,
+		IsLoggedIn:    true,
+		CurrentUserID: userID,
+	}
+	data.Categories, err = ph.stores.Categories.List()
+	if err != nil {
+		log.Printf("Error getting categories: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrCategoryLoad)
+		return
+	}
+
+	selected, err := ph.stores.Posts.Categories(postID)
+	if err != nil {
+		log.Printf("Error getting post categories: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrCategoryLoad)
+		return
+	}
+	data.SelectedCats = make(map[string]bool)
+	for _, name := range selected {
+		data.SelectedCats[name] = true
+	}
+
+	ph.renderEditForm(w, r, data)
+}
+
+func (ph *PostHandler) renderEditForm(w http.ResponseWriter, r *http.Request, data editPostData) {
+	tmpl, err := utils.ParseTemplate(r, "templates/editpost.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
+		return
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+func (ph *PostHandler) handleEditPost(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	// Ensure user owns the post
+	old, err := ph.stores.Posts.Get(postID)
+	if err == repository.ErrNotFound {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error checking post ownership: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if old.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	oldCats, err := ph.stores.Posts.Categories(postID)
+	if err != nil {
+		log.Printf("Error getting post categories: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	data := editPostData{
+		PostID:        postID,
+		Title:         strings.TrimSpace(r.FormValue("title")),
+		Content:       strings.TrimSpace(r.FormValue("content")),
+		ImagePath:     old.ImagePath,
+		SelectedCats:  make(map[string]bool),
+		IsLoggedIn:    true,
+		CurrentUserID: userID,
+	}
+	for _, name := range r.Form["categories[]"] {
+		data.SelectedCats[name] = true
+	}
+	data.Categories, err = ph.stores.Categories.List()
+	if err != nil {
+		log.Printf("Error getting categories: %v", err)
+	}
+
+	if data.Title == "" || data.Content == "" || len(data.SelectedCats) == 0 {
+		data.ErrorMessage = "Title, content, and at least one category are required"
+		ph.renderEditForm(w, r, data)
+		return
+	}
+
+	// A new upload replaces the image, otherwise it can be removed explicitly
+	imagePath := old.ImagePath
+	file, header, err := r.FormFile("image")
+	if err == nil {
+		defer file.Close()
+
+		imagePath, err = ph.imageHandler.ProcessImage(file, header)
+		if err != nil {
+			data.ErrorMessage = uploadErrorMessage(err)
+			ph.renderEditForm(w, r, data)
+			return
+		}
+	} else if r.FormValue("remove_image") == "on" {
+		imagePath = ""
+	}
+
+	newCats := make([]string, 0, len(data.SelectedCats))
+	for name := range data.SelectedCats {
+		newCats = append(newCats, name)
+	}
+	sort.Strings(newCats)
+
+	if data.Title == old.Title && data.Content == old.Content && imagePath == old.ImagePath &&
+		strings.Join(newCats, ", ") == strings.Join(oldCats, ", ") {
+		http.Redirect(w, r, fmt.Sprintf("/?id=%d", postID), http.StatusSeeOther)
+		return
+	}
+
+	err = ph.stores.Posts.Update(repository.PostEdit{
+		PostID:     postID,
+		EditorID:   userID,
+		Title:      data.Title,
+		Content:    data.Content,
+		ImagePath:  imagePath,
+		Categories: newCats,
+	})
+	if err != nil {
+		log.Printf("Error updating post: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := ph.stores.Notifications.RecordMentions(userID, postID, 0, data.Content); err != nil {
+		log.Printf("Error recording mentions in post %d: %v", postID, err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/?id=%d", postID), http.StatusSeeOther)
+}
+
+func (ph *PostHandler) handleDeletePost(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	// Ensure user owns the post
+	post, err := ph.stores.Posts.Get(postID)
+	if err == repository.ErrNotFound {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error checking post ownership: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if post.UserID != userID && !ph.userCan(userID, utils.PermDeleteAnyContent) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := ph.stores.Posts.Delete(postID); err != nil {
+		log.Printf("Error deleting post: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (ph *PostHandler) handlePostRevisions(w http.ResponseWriter, r *http.Request) {
+	postID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || postID <= 0 {
+		utils.RenderErrorPage(w, http.StatusNotFound, "Invalid post ID")
+		return
+	}
+
+	post, _, err := ph.getPostByID(postID)
+	if err != nil {
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPostNotFound)
+		return
+	}
+
+	currentUserID := ph.currentUser(r)
+	if post.Hidden && !ph.canViewHidden(post.UserID, currentUserID) {
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPostNotFound)
+		return
+	}
+
+	versions, err := getPostVersions(ph.stores.Posts, post)
+	if err != nil {
+		log.Printf("Error fetching post revisions: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	data := struct {
+		Post          *utils.Post
+		Versions      []postVersion
+		CurrentUserID string
+		IsLoggedIn    bool
+	}{
+		Post:          post,
+		Versions:      versions,
+		CurrentUserID: currentUserID,
+		IsLoggedIn:    currentUserID != "",
+	}
+
+	tmpl, err := utils.ParseTemplate(r, "templates/post_revisions.html")
+	if err != nil {
+		log.Printf("Template parsing error: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
+		return
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+// getPostVersions returns every version of a post, newest first. Each stored
+// revision is the state of the post before an edit, so the edit time and
+// editor recorded on a revision belong to the version that replaced it.
+func getPostVersions(store repository.PostStore, post *utils.Post) ([]postVersion, error) {
+	revisions, err := store.Revisions(post.ID)
+	if err != nil {
+		return nil, err
+	}
+	currentCats, err := store.Categories(post.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]postVersion, 0, len(revisions)+1)
+	for i, rev := range revisions {
+		v := postVersion{
+			Number:     i + 1,
+			Title:      rev.Title,
+			Content:    rev.Content,
+			ImagePath:  rev.ImagePath,
+			Categories: rev.Categories,
+			EditorName: post.Username,
+			EditedAt:   post.PostTime,
+		}
+		if i > 0 {
+			v.EditorName = revisions[i-1].EditorName
+			v.EditedAt = FormatTimeAgo(revisions[i-1].RevisedAt.Local())
+		}
+		versions = append(versions, v)
+	}
+
+	current := postVersion{
+		Number:     len(revisions) + 1,
+		Title:      post.Title,
+		Content:    post.Content,
+		ImagePath:  post.ImagePath,
+		Categories: strings.Join(currentCats, ", "),
+		EditorName: post.Username,
+		EditedAt:   post.PostTime,
+		IsCurrent:  true,
+	}
+	if n := len(revisions); n > 0 {
+		current.EditorName = revisions[n-1].EditorName
+		current.EditedAt = post.EditedTime
+	}
+	versions = append(versions, current)
+
+	for i := 1; i < len(versions); i++ {
+		versions[i].TitleDiff = utils.DiffLines(versions[i-1].Title, versions[i].Title)
+		versions[i].ContentDiff = utils.DiffLines(versions[i-1].Content, versions[i].Content)
+	}
+
+	// Newest first
+	for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+		versions[i], versions[j] = versions[j], versions[i]
+	}
+
+	return versions, nil
+}
+
+// handlePreview renders the Markdown in the content field the way the post
+// page will show it, for the preview on the create and edit forms.
+func (ph *PostHandler) handlePreview(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := r.ParseForm(); err != nil {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(utils.RenderMarkdown(ph.stores.Renders, r.FormValue("content"))))
+}
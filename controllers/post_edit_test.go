@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"forum/config"
+	"forum/repository"
+	"forum/storage"
+	"forum/utils"
+)
+
+// seedPost creates an owner, a post with one comment and one reaction, and
+// returns the owner's ID and the post ID.
+func seedPost(t *testing.T, db *sql.DB) (string, int) {
+	t.Helper()
+
+	ownerID := utils.GenerateId()
+	_, err := db.Exec("INSERT INTO users (id, username, email) VALUES (?, ?, ?)",
+		ownerID, "owner_"+ownerID[:8], ownerID+"@example.com")
+	if err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	result, err := db.Exec("INSERT INTO posts (user_id, title, content) VALUES (?, 'Title', 'Content')", ownerID)
+	if err != nil {
+		t.Fatalf("Failed to insert post: %v", err)
+	}
+	postID, _ := result.LastInsertId()
+
+	if _, err := db.Exec("INSERT INTO comments (post_id, user_id, content) VALUES (?, ?, 'hi')", postID, ownerID); err != nil {
+		t.Fatalf("Failed to insert comment: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO reaction (user_id, post_id, like) VALUES (?, ?, 1)", ownerID, postID); err != nil {
+		t.Fatalf("Failed to insert reaction: %v", err)
+	}
+
+	return ownerID, int(postID)
+}
+
+func TestPostHandler_handleDeletePost(t *testing.T) {
+	db := openTestDB(t)
+
+	ownerID, postID := seedPost(t, db)
+	ph := NewPostHandler(repository.NewSQLite(db), storage.NewLocalStore(t.TempDir()), config.Default())
+
+	newRequest := func(userID string) *http.Request {
+		form := url.Values{"post_id": {strconv.Itoa(postID)}}
+		req := httptest.NewRequest("POST", "/deletepost", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req.WithContext(context.WithValue(req.Context(), "userID", userID))
+	}
+
+	rr := httptest.NewRecorder()
+	ph.handleDeletePost(rr, newRequest("someone-else"))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("non-owner delete returned %v, want %v", rr.Code, http.StatusForbidden)
+	}
+
+	rr = httptest.NewRecorder()
+	ph.handleDeletePost(rr, newRequest(ownerID))
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("owner delete returned %v, want %v", rr.Code, http.StatusSeeOther)
+	}
+
+	for _, table := range []string{"comments", "reaction", "post_categories"} {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE post_id = ?", postID).Scan(&count); err != nil {
+			t.Fatalf("Failed to count %s: %v", table, err)
+		}
+		if count != 0 {
+			t.Errorf("%s still has %d rows for deleted post", table, count)
+		}
+	}
+
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", postID).Scan(&exists)
+	if exists {
+		t.Errorf("post %d still exists after delete", postID)
+	}
+}
+
+func TestGetPostVersions(t *testing.T) {
+	db := openTestDB(t)
+
+	ownerID, postID := seedPost(t, db)
+	defer repository.NewSQLite(db).Posts.Delete(postID)
+
+	_, err := db.Exec(`
+        INSERT INTO post_revisions (post_id, editor_id, title, content)
+        VALUES (?, ?, 'Old title', 'Old content')
+    `, postID, ownerID)
+	if err != nil {
+		t.Fatalf("Failed to insert revision: %v", err)
+	}
+
+	post := &utils.Post{ID: postID, Title: "Title", Content: "Content", Username: "owner"}
+	versions, err := getPostVersions(repository.NewSQLite(db).Posts, post)
+	if err != nil {
+		t.Fatalf("getPostVersions returned error: %v", err)
+	}
+
+	if len(versions) != 2 {
+		t.Fatalf("getPostVersions returned %d versions, want 2", len(versions))
+	}
+	if !versions[0].IsCurrent || versions[0].Number != 2 {
+		t.Errorf("first version = %+v, want current version 2", versions[0])
+	}
+	if versions[1].Title != "Old title" || versions[1].TitleDiff != nil {
+		t.Errorf("original version = %+v, want old title without diff", versions[1])
+	}
+	want := []utils.DiffLine{{Kind: "removed", Text: "Old content"}, {Kind: "added", Text: "Content"}}
+	if len(versions[0].ContentDiff) != len(want) || versions[0].ContentDiff[0] != want[0] || versions[0].ContentDiff[1] != want[1] {
+		t.Errorf("content diff = %v, want %v", versions[0].ContentDiff, want)
+	}
+}
+
+func TestPreviewAndRenderedContent(t *testing.T) {
+	db := openTestDB(t)
+
+	_, token := seedUser(t, db, utils.RoleMember)
+	stores := repository.NewSQLite(db)
+	ph := NewPostHandler(stores, storage.NewLocalStore(t.TempDir()), config.Default())
+
+	rr := postForm(ph, "/preview", token, url.Values{"content": {"**hi** <script>x</script>"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("preview returned %d, want %d", rr.Code, http.StatusOK)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "<strong>hi</strong>") || strings.Contains(body, "<script") {
+		t.Errorf("preview = %q", body)
+	}
+	if rr := postForm(ph, "/preview", "", url.Values{"content": {"hi"}}); rr.Code == http.StatusOK {
+		t.Errorf("preview without a session returned %d", rr.Code)
+	}
+
+	_, postID := seedPost(t, db)
+	html := utils.RenderContent(stores.Renders, utils.ContentPost, postID, "*first*")
+	if !strings.Contains(string(html), "<em>first</em>") {
+		t.Errorf("RenderContent = %q", html)
+	}
+
+	// Cached HTML is served until the text changes
+	db.Exec("UPDATE rendered_content SET html = 'cached' WHERE kind = 'post' AND content_id = ?", postID)
+	if html := utils.RenderContent(stores.Renders, utils.ContentPost, postID, "*first*"); html != "cached" {
+		t.Errorf("unchanged text rendered again: %q", html)
+	}
+	if html := utils.RenderContent(stores.Renders, utils.ContentPost, postID, "*second*"); !strings.Contains(string(html), "<em>second</em>") {
+		t.Errorf("edited text served stale HTML: %q", html)
+	}
+
+	if err := stores.Posts.Delete(postID); err != nil {
+		t.Fatalf("deletePost returned error: %v", err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM rendered_content WHERE kind = 'post' AND content_id = ?", postID).Scan(&count)
+	if count != 0 {
+		t.Errorf("rendered HTML of a deleted post was kept")
+	}
+}
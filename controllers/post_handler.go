@@ -1,49 +1,41 @@
 package controllers
 
 import (
-	"context"
-	"database/sql"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"forum/config"
+	"forum/repository"
+	"forum/storage"
 	"forum/utils"
 )
 
 type PostHandler struct {
-	imageHandler *ImageHandler
+	authenticator
+	stores          *repository.Stores
+	imageHandler    *ImageHandler
+	maxCommentDepth int
 }
 
-func NewPostHandler() *PostHandler {
+func NewPostHandler(stores *repository.Stores, uploads storage.BlobStore, cfg config.Config) *PostHandler {
+	auth := newAuthenticator(stores)
+	auth.requireVerification = cfg.Auth.RequireEmailVerification
 	return &PostHandler{
-		imageHandler: NewImageHandler(),
+		authenticator:   auth,
+		stores:          stores,
+		imageHandler:    NewImageHandler(uploads),
+		maxCommentDepth: cfg.MaxCommentDepth,
 	}
 }
 
 // Update handler signatures to match http.HandlerFunc
 func (ph *PostHandler) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_token")
-		if err != nil {
-			http.Redirect(w, r, "/signin", http.StatusSeeOther)
-			return
-		}
-
-		userID, err := utils.ValidateSession(utils.GlobalDB, cookie.Value)
-		if err != nil {
-			http.Redirect(w, r, "/signin", http.StatusSeeOther)
-			return
-		}
-
-		// Store userID in request context
-		ctx := context.WithValue(r.Context(), "userID", userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	}
+	return ph.requireAuth(next)
 }
 
 func (ph *PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -51,9 +43,9 @@ func (ph *PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "/create":
 		switch r.Method {
 		case http.MethodGet:
-			ph.authMiddleware(ph.displayCreateForm).ServeHTTP(w, r)
+			ph.requireVerified(ph.displayCreateForm).ServeHTTP(w, r)
 		case http.MethodPost:
-			ph.authMiddleware(ph.handleCreatePost).ServeHTTP(w, r)
+			ph.requireVerified(ph.handleCreatePost).ServeHTTP(w, r)
 		default:
 			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
 		}
@@ -82,8 +74,7 @@ func (ph *PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 
 				// Check if post exists
-				var exists bool
-				if err := utils.GlobalDB.QueryRow("SELECT EXISTS(SELECT 1 FROM posts WHERE id = ?)", postID).Scan(&exists); err != nil || !exists {
+				if _, err := ph.stores.Posts.Get(postID); err != nil {
 					utils.RenderErrorPage(w, http.StatusNotFound, "Post not found")
 					return
 				}
@@ -95,7 +86,7 @@ func (ph *PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	case "/comment":
 		if r.Method == http.MethodPost {
-			ph.authMiddleware(ph.handleComment).ServeHTTP(w, r)
+			ph.requireVerified(ph.handleComment).ServeHTTP(w, r)
 		} else {
 			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
 		}
@@ -112,6 +103,37 @@ func (ph *PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
 		}
 
+	case "/editpost":
+		switch r.Method {
+		case http.MethodGet:
+			ph.authMiddleware(ph.displayEditForm).ServeHTTP(w, r)
+		case http.MethodPost:
+			ph.authMiddleware(ph.handleEditPost).ServeHTTP(w, r)
+		default:
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+
+	case "/deletepost":
+		if r.Method == http.MethodPost {
+			ph.authMiddleware(ph.handleDeletePost).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+
+	case "/postrevisions":
+		if r.Method == http.MethodGet {
+			ph.handlePostRevisions(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+
+	case "/preview":
+		if r.Method == http.MethodPost {
+			ph.authMiddleware(ph.handlePreview).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+
 	case "/deletecomment":
 		if r.Method == http.MethodPost {
 			ph.authMiddleware(ph.handleDeleteComment).ServeHTTP(w, r)
@@ -135,7 +157,7 @@ type createPostData struct {
 }
 
 func (ph *PostHandler) displayCreateForm(w http.ResponseWriter, r *http.Request) {
-	categories, err := ph.getAllCategories()
+	categories, err := ph.stores.Categories.List()
 	if err != nil {
 		log.Printf("Error getting categories: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
@@ -149,7 +171,7 @@ func (ph *PostHandler) displayCreateForm(w http.ResponseWriter, r *http.Request)
 		CurrentUserID: userID,
 	}
 
-	tmpl, err := template.ParseFiles("templates/createpost.html")
+	tmpl, err := utils.ParseTemplate(r, "templates/createpost.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
@@ -161,52 +183,33 @@ func (ph *PostHandler) displayCreateForm(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (ph *PostHandler) getAllCategories() ([]utils.Category, error) {
-	rows, err := utils.GlobalDB.Query("SELECT id, name FROM categories")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var categories []utils.Category
-	for rows.Next() {
-		var category utils.Category
-		if err := rows.Scan(&category.ID, &category.Name); err != nil {
-			log.Printf("Error scanning category: %v", err)
-			continue
-		}
-		categories = append(categories, category)
-	}
-
-	return categories, rows.Err()
-}
-
 func (ph *PostHandler) handleGetPosts(w http.ResponseWriter, r *http.Request) {
-	posts, err := ph.getAllPosts()
-	if err != nil {
+	opts := parseFeedOptions(r)
+	posts, next, err := fetchFeed(ph.stores.Posts, repository.FeedFilter{}, opts)
+	if err == errInvalidCursor {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	} else if err != nil {
 		log.Printf("Error fetching posts: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
 		return
 	}
 
-	users, err := ph.getAllUsers() // Add this function
+	users, err := ph.stores.Users.List()
 	if err != nil {
 		log.Printf("Error fetching users: %v", err)
 	}
 
+	userID := ph.currentUser(r)
 	pageData := utils.PageData{
-		IsLoggedIn: ph.checkAuthStatus(r),
-		Posts:      posts,
-		Users: users,
-	}
-
-	if cookie, err := r.Cookie("session_token"); err == nil {
-		if userID, err := utils.ValidateSession(utils.GlobalDB, cookie.Value); err == nil {
-			pageData.CurrentUserID = userID
-		}
+		IsLoggedIn:    userID != "",
+		CurrentUserID: userID,
+		Posts:         posts,
+		Users:         users,
+		Feed:          newFeedNav("/", nil, opts, next),
 	}
 
-	tmpl, err := template.ParseFiles("templates/index.html")
+	tmpl, err := utils.ParseTemplate(r, "templates/index.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
@@ -218,100 +221,10 @@ func (ph *PostHandler) handleGetPosts(w http.ResponseWriter, r *http.Request) {
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
 	}
 }
-func (ph *PostHandler) getAllUsers() ([]utils.User, error) {
-	rows, err := utils.GlobalDB.Query(`
-        SELECT id, username, profile_pic 
-        FROM users 
-        ORDER BY username ASC
-    `)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []utils.User
-	for rows.Next() {
-		var user utils.User
-		err := rows.Scan(&user.ID, &user.UserName, &user.ProfilePic)
-		if err != nil {
-			return nil, err
-		}
-		users = append(users, user)
-	}
-	return users, nil
-}
-func (ph *PostHandler) getAllPosts() ([]utils.Post, error) {
-	rows, err := utils.GlobalDB.Query(`
-        SELECT p.id, p.user_id, p.title, p.content, p.imagepath, 
-               p.post_at, p.likes, p.dislikes, p.comments,
-               u.username, u.profile_pic, c.id AS category_id, c.name AS category_name
-        FROM posts p
-        JOIN users u ON p.user_id = u.id
-        LEFT JOIN post_categories pc ON p.id = pc.post_id
-        LEFT JOIN categories c ON pc.category_id = c.id
-        ORDER BY p.post_at DESC
-    `)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	postMap := make(map[int]utils.Post)
-	for rows.Next() {
-		var post utils.Post
-		var postTime time.Time
-
-		var categoryID sql.NullInt64
-		var categoryName sql.NullString
-		if err := rows.Scan(
-			&post.ID,
-			&post.UserID,
-			&post.Title,
-			&post.Content,
-			&post.ImagePath,
-			&postTime,
-			&post.Likes,
-			&post.Dislikes,
-			&post.Comments,
-			&post.Username,
-			&post.ProfilePic,
-			&categoryID,
-			&categoryName,
-		); err != nil {
-			log.Printf("Error scanning post: %v", err)
-			continue
-		}
-
-		if categoryID.Valid {
-			categoryIDInt := int(categoryID.Int64)
-			post.CategoryID = &categoryIDInt
-		} else {
-			post.CategoryID = nil
-		}
-
-		if categoryName.Valid {
-			post.CategoryName = &categoryName.String
-		} else {
-			post.CategoryName = nil
-		}
-
-		post.PostTime = FormatTimeAgo(postTime)
-
-		postMap[post.ID] = post
-	}
-
-	var posts []utils.Post
-	for _, post := range postMap {
-		posts = append(posts, post)
-	}
-
-	return posts, rows.Err()
-}
-
 func (ph *PostHandler) handleCreatePost(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value("userID").(string)
 
-	tmpl, err := template.ParseFiles("templates/createpost.html")
+	tmpl, err := utils.ParseTemplate(r, "templates/createpost.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
@@ -354,56 +267,28 @@ func (ph *PostHandler) handleCreatePost(w http.ResponseWriter, r *http.Request)
 
 		imagePath, err = ph.imageHandler.ProcessImage(file, header)
 		if err != nil {
-			data.ErrorMessage = "Error processing image: " + err.Error()
+			data.ErrorMessage = uploadErrorMessage(err)
 			tmpl.Execute(w, data)
 			return
 		}
 	}
 
-	currentTime := time.Now()
-	stmt, err := utils.GlobalDB.Prepare(`
-        INSERT INTO posts (user_id, title, content, imagepath, post_at)
-        VALUES (?, ?, ?, ?, ?)
-    `)
-	if err != nil {
-		data.ErrorMessage = "Error creating post"
-		tmpl.Execute(w, data)
-		return
-	}
-	defer stmt.Close()
-
-	result, err := stmt.Exec(userID, data.Title, data.Content, imagePath, currentTime)
+	post := utils.Post{UserID: userID, Title: data.Title, Content: data.Content, ImagePath: imagePath}
+	postID, err := ph.stores.Posts.Create(post, data.SelectedCats)
 	if err != nil {
+		log.Printf("Error saving post: %v", err)
 		data.ErrorMessage = "Error saving post"
 		tmpl.Execute(w, data)
 		return
 	}
 
-	postID, _ := result.LastInsertId()
-
-	for _, categoryName := range data.SelectedCats {
-		categoryID, err := getCategoryIDByName(categoryName)
-		if err != nil {
-			continue
-		}
-		utils.GlobalDB.Exec(`
-            INSERT INTO post_categories (post_id, category_id) 
-            VALUES (?, ?)
-        `, postID, categoryID)
+	if err := ph.stores.Notifications.RecordMentions(userID, postID, 0, data.Content); err != nil {
+		log.Printf("Error recording mentions in post %d: %v", postID, err)
 	}
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-func getCategoryIDByName(name string) (int, error) {
-	var id int
-	err := utils.GlobalDB.QueryRow("SELECT id FROM categories WHERE name = ?", name).Scan(&id)
-	if err != nil {
-		return 0, err
-	}
-	return id, nil
-}
-
 func FormatTimeAgo(t time.Time) string {
 	now := time.Now()
 	diff := now.Sub(t)
@@ -450,7 +335,7 @@ func (ph *PostHandler) handleSinglePost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	postID, err := strconv.ParseInt(postIDStr, 10, 64)
+	postID, err := strconv.Atoi(postIDStr)
 	if err != nil {
 		log.Printf("Invalid post ID: %v", err)
 		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrPostNotFound)
@@ -470,7 +355,7 @@ func (ph *PostHandler) handleSinglePost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	tmpl, err := template.ParseFiles("templates/post.html")
+	tmpl, err := utils.ParseTemplate(r, "templates/post.html")
 	if err != nil {
 		log.Printf("Template parsing error: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
@@ -479,96 +364,60 @@ func (ph *PostHandler) handleSinglePost(w http.ResponseWriter, r *http.Request)
 
 	data := struct {
 		Post          *utils.Post
-		Comments      []utils.Comment
+		Comments      []*commentNode
+		CommentCount  int
 		CurrentUserID string
 		IsLoggedIn    bool
+		CanModerate   bool
+		Reported      bool
 	}{
-		Post:       post,
-		Comments:   comments,
-		IsLoggedIn: ph.checkAuthStatus(r),
+		Post:         post,
+		CommentCount: len(comments),
+		Reported:     r.URL.Query().Get("reported") != "",
 	}
-	if cookie, err := r.Cookie("session_token"); err == nil {
-		if userID, err := utils.ValidateSession(utils.GlobalDB, cookie.Value); err == nil {
-			data.CurrentUserID = userID
+	data.CurrentUserID = ph.currentUser(r)
+	data.IsLoggedIn = data.CurrentUserID != ""
+
+	if post.Hidden && !ph.canViewHidden(post.UserID, data.CurrentUserID) {
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPostNotFound)
+		return
+	}
+	post.ContentHTML = utils.RenderContent(ph.stores.Renders, utils.ContentPost, post.ID, post.Content)
+	for i := range comments {
+		if comments[i].Hidden && !ph.canViewHidden(comments[i].UserID, data.CurrentUserID) {
+			comments[i].Content = ""
+			continue
 		}
+		comments[i].ContentHTML = utils.RenderContent(ph.stores.Renders, utils.ContentComment, comments[i].ID, comments[i].Content)
 	}
 
+	data.CanModerate = ph.userCan(data.CurrentUserID, utils.PermDeleteAnyContent)
+	data.Comments = buildCommentTree(comments, data.CurrentUserID, data.CanModerate, ph.commentDepthLimit())
+
 	if err := tmpl.Execute(w, data); err != nil {
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
 	}
 }
 
-// Add this helper method to fetch a single post
-func (ph *PostHandler) getPostByID(id int64) (*utils.Post, []utils.Comment, error) {
-	row := utils.GlobalDB.QueryRow(`
-        SELECT p.id, p.user_id, p.title, p.content, p.imagepath, 
-               p.post_at, p.likes, p.dislikes, p.comments,
-               u.username, u.profile_pic
-        FROM posts p
-        JOIN users u ON p.user_id = u.id
-        WHERE p.id = ?
-    `, id)
-
-	var post utils.Post
-	var postTime time.Time
-
-	err := row.Scan(
-		&post.ID,
-		&post.UserID,
-		&post.Title,
-		&post.Content,
-		&post.ImagePath,
-		&postTime,
-		&post.Likes,
-		&post.Dislikes,
-		&post.Comments,
-		&post.Username,
-		&post.ProfilePic,
-	)
-
-	if err == sql.ErrNoRows {
-		return nil, nil, err
-	}
+// getPostByID fetches a post with its comments, newest first.
+func (ph *PostHandler) getPostByID(id int) (*utils.Post, []utils.Comment, error) {
+	post, err := ph.stores.Posts.Get(id)
 	if err != nil {
 		return nil, nil, err
 	}
+	post.PostTime = FormatTimeAgo(post.PostedAt.Local())
+	if !post.UpdatedAt.IsZero() {
+		post.EditedTime = FormatTimeAgo(post.UpdatedAt.Local())
+	}
 
-	post.PostTime = FormatTimeAgo(postTime.Local())
-	rows, err := utils.GlobalDB.Query(`
-	  SELECT c.id, c.user_id, c.content, c.comment_at, u.username, u.profile_pic, 
-	         (SELECT COUNT(*) FROM comment_reaction WHERE comment_id = c.id AND is_like = 1) as likes,
-	         (SELECT COUNT(*) FROM comment_reaction WHERE comment_id = c.id AND is_like = 0) as dislikes
-	  FROM comments c
-	  JOIN users u ON c.user_id = u.id
-	  WHERE c.post_id = ?
-	  ORDER BY c.comment_at DESC`, id)
+	comments, err := ph.stores.Comments.ListByPost(id)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer rows.Close()
-
-	var comments []utils.Comment
-	for rows.Next() {
-		var c utils.Comment
-		var t time.Time
-		err := rows.Scan(&c.ID, &c.UserID, &c.Content, &t, &c.Username, &c.ProfilePic, &c.Likes, &c.Dislikes)
-		if err != nil {
-			continue
-		}
-		c.CommentTime = t.Local()
-		comments = append(comments, c)
+	for i := range comments {
+		comments[i].CommentTime = comments[i].CommentTime.Local()
 	}
-
-	return &post, comments, nil
-}
-
-func (ph *PostHandler) checkAuthStatus(r *http.Request) bool {
-	cookie, err := r.Cookie("session_token")
-	if err != nil {
-		return false
-	}
-	_, err = utils.ValidateSession(utils.GlobalDB, cookie.Value)
-	return err == nil
+	return post, comments, nil
 }
 
 func (ph *PostHandler) handleReactions(w http.ResponseWriter, r *http.Request) {
@@ -598,51 +447,9 @@ func (ph *PostHandler) handleReactions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the user already has a reaction
-	var existingLike int
-	err := utils.GlobalDB.QueryRow("SELECT like FROM reaction WHERE user_id = ? AND post_id = ?", userID, req.PostID).Scan(&existingLike)
-	if err != nil && err != sql.ErrNoRows {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-		return
-	}
-
-	if err == sql.ErrNoRows {
-		// Insert new reaction
-		_, err = utils.GlobalDB.Exec("INSERT INTO reaction (user_id, post_id, like) VALUES (?, ?, ?)", userID, req.PostID, req.Like)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-			return
-		}
-	} else {
-		if existingLike == req.Like {
-			// User is unliking or undisliking
-			_, err = utils.GlobalDB.Exec("DELETE FROM reaction WHERE user_id = ? AND post_id = ?", userID, req.PostID)
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-				return
-			}
-		} else {
-			// Update existing reaction
-			_, err = utils.GlobalDB.Exec("UPDATE reaction SET like = ? WHERE user_id = ? AND post_id = ?", req.Like, userID, req.PostID)
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-				return
-			}
-		}
-	}
-
-	// Fetch updated like and dislike counts
-	var likes, dislikes int
-	err = utils.GlobalDB.QueryRow("SELECT likes, dislikes FROM posts WHERE id = ?", req.PostID).Scan(&likes, &dislikes)
+	likes, dislikes, err := ph.stores.Posts.React(userID, req.PostID, req.Like)
 	if err != nil {
+		log.Printf("Error reacting to post %d: %v", req.PostID, err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
@@ -681,22 +488,39 @@ func (ph *PostHandler) handleComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = utils.GlobalDB.Exec(`
-        INSERT INTO comments (post_id, user_id, content) 
-        VALUES (?, ?, ?)`,
-		postID, userID, content,
-	)
+	// Replies carry the ID of the comment they answer
+	var parentID int
+	if parentStr := r.FormValue("parent_id"); parentStr != "" {
+		requested, err := strconv.Atoi(parentStr)
+		if err != nil {
+			http.Error(w, "Invalid parent comment ID", http.StatusBadRequest)
+			return
+		}
+		parentID, err = replyParent(ph.stores.Comments, postID, requested, ph.commentDepthLimit())
+		if err == repository.ErrNotFound {
+			http.Error(w, "Parent comment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Error resolving parent comment: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	commentID, err := ph.stores.Comments.Create(utils.Comment{
+		PostID:   postID,
+		ParentID: parentID,
+		UserID:   userID,
+		Content:  content,
+	})
 	if err != nil {
 		log.Printf("Error creating comment: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	_, err = utils.GlobalDB.Exec(`
-        UPDATE posts SET comments = comments + 1 
-        WHERE id = ?`, postID)
-	if err != nil {
-		log.Printf("Error updating comment count: %v", err)
+	if err := ph.stores.Notifications.RecordMentions(userID, postID, commentID, content); err != nil {
+		log.Printf("Error recording mentions in comment %d: %v", commentID, err)
 	}
 
 	http.Redirect(w, r, fmt.Sprintf("/?id=%d", postID), http.StatusSeeOther)
@@ -730,54 +554,12 @@ func (ph *PostHandler) handleCommentReactions(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Check if the user already reacted to this comment by querying comment_reaction
-	var existingIsLike int
-	err := utils.GlobalDB.QueryRow("SELECT is_like FROM comment_reaction WHERE user_id = ? AND comment_id = ?", userID, req.CommentID).Scan(&existingIsLike)
-	if err != nil && err != sql.ErrNoRows {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database error (select)"})
-		return
-	}
-
-	if err == sql.ErrNoRows {
-		// No reaction exists—insert a new reaction
-		_, err = utils.GlobalDB.Exec("INSERT INTO comment_reaction (user_id, comment_id, is_like) VALUES (?, ?, ?)", userID, req.CommentID, req.Like)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{"error": "Database error (insert)"})
-			return
-		}
-	} else {
-		if existingIsLike == req.Like {
-			// Same reaction exists; remove it
-			_, err = utils.GlobalDB.Exec("DELETE FROM comment_reaction WHERE user_id = ? AND comment_id = ?", userID, req.CommentID)
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Database error (delete)"})
-				return
-			}
-		} else {
-			// Reaction exists but is different; update it
-			_, err = utils.GlobalDB.Exec("UPDATE comment_reaction SET is_like = ? WHERE user_id = ? AND comment_id = ?", req.Like, userID, req.CommentID)
-			if err != nil {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				json.NewEncoder(w).Encode(map[string]string{"error": "Database error (update)"})
-				return
-			}
-		}
-	}
-
-	// Get updated likes and dislikes counts
-	var likes, dislikes int
-	err = utils.GlobalDB.QueryRow("SELECT likes, dislikes FROM comments WHERE id = ?", req.CommentID).Scan(&likes, &dislikes)
+	likes, dislikes, err := ph.stores.Comments.React(userID, req.CommentID, req.Like)
 	if err != nil {
+		log.Printf("Error reacting to comment %d: %v", req.CommentID, err)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database error (get counts)"})
+		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
 		return
 	}
 
@@ -815,9 +597,8 @@ func (ph *PostHandler) handleEditComment(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Ensure user owns the comment
-	var ownerID string
-	err = utils.GlobalDB.QueryRow("SELECT user_id FROM comments WHERE id = ?", commentID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
+	comment, err := ph.stores.Comments.Get(commentID)
+	if err == repository.ErrNotFound {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	} else if err != nil {
@@ -826,24 +607,19 @@ func (ph *PostHandler) handleEditComment(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if ownerID != userID {
+	if comment.UserID != userID {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
-	// Update the comment
-	result, err := utils.GlobalDB.Exec("UPDATE comments SET content = ? WHERE id = ?", newContent, commentID)
-	if err != nil {
+	if err := ph.stores.Comments.Update(commentID, newContent); err != nil {
 		log.Printf("Error updating comment: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("Error getting rows affected: %v", err)
-	} else if rowsAffected == 0 {
-		log.Printf("No rows were updated for comment ID: %d", commentID)
+	if err := ph.stores.Notifications.RecordMentions(userID, comment.PostID, commentID, newContent); err != nil {
+		log.Printf("Error recording mentions in comment %d: %v", commentID, err)
 	}
 
 	http.Redirect(w, r, r.Header.Get("Referer"), http.StatusSeeOther)
@@ -867,19 +643,9 @@ func (ph *PostHandler) handleDeleteComment(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Get the post ID before deleting the comment (for updating comment count)
-	var postID int
-	err = utils.GlobalDB.QueryRow("SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&postID)
-	if err != nil && err != sql.ErrNoRows {
-		log.Printf("Error getting post ID: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-
 	// Ensure user owns the comment
-	var ownerID string
-	err = utils.GlobalDB.QueryRow("SELECT user_id FROM comments WHERE id = ?", commentID).Scan(&ownerID)
-	if err == sql.ErrNoRows {
+	comment, err := ph.stores.Comments.Get(commentID)
+	if err == repository.ErrNotFound {
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	} else if err != nil {
@@ -888,33 +654,16 @@ func (ph *PostHandler) handleDeleteComment(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if ownerID != userID {
+	if comment.UserID != userID && !ph.userCan(userID, utils.PermDeleteAnyContent) {
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
-	// Delete the comment
-	_, err = utils.GlobalDB.Exec("DELETE FROM comments WHERE id = ?", commentID)
-	if err != nil {
+	if err := ph.stores.Comments.Delete(commentID); err != nil {
 		log.Printf("Error deleting comment: %v", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Update the post's comment count
-	if postID > 0 {
-		_, err = utils.GlobalDB.Exec(`
-			UPDATE posts 
-			SET comments = (
-				SELECT COUNT(*) 
-				FROM comments 
-				WHERE post_id = ?
-			) 
-			WHERE id = ?`, postID, postID)
-		if err != nil {
-			log.Printf("Error updating post comment count: %v", err)
-		}
-	}
-
 	http.Redirect(w, r, r.Header.Get("Referer"), http.StatusSeeOther)
 }
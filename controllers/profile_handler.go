@@ -2,18 +2,20 @@ package controllers
 
 import (
 	"database/sql"
-	"html/template"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 
+	"forum/repository"
+	"forum/storage"
 	"forum/utils"
 )
 
 type ProfileHandler struct {
+	authenticator
+	users        repository.UserStore
 	imageHandler *ImageHandler
+	providers    []utils.ProviderInfo // Sign-in providers users can link
 }
 
 type ProfileData struct {
@@ -24,11 +26,28 @@ type ProfileData struct {
 	IsOwnProfile bool
 	UserID       string
 	ErrorMessage string
+	Role         string
+	IsStaff      bool // Viewer may open the admin panel
+	Providers    []linkedProvider
+	IsBlocked    bool // Viewer blocks this user
 }
 
-func NewProfileHandler() *ProfileHandler {
+// linkedProvider is a sign-in provider as shown on the user's own profile.
+type linkedProvider struct {
+	Provider string
+	Label    string
+	Icon     string
+	Linked   bool
+	CanLink  bool // Provider is still configured
+	Email    string
+}
+
+func NewProfileHandler(stores *repository.Stores, uploads storage.BlobStore, providers []utils.ProviderInfo) *ProfileHandler {
 	return &ProfileHandler{
-		imageHandler: NewImageHandler(),
+		authenticator: newAuthenticator(stores),
+		users:         stores.Users,
+		imageHandler:  NewImageHandler(uploads),
+		providers:     providers,
 	}
 }
 
@@ -38,15 +57,19 @@ func (ph *ProfileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	targetUserID := strings.TrimPrefix(urlPath, "/profile/")
 
 	// Check if viewing own profile
-	var currentUserID string
-	isLoggedIn := false
-
-	// Check if user is logged in
-	if cookie, err := r.Cookie("session_token"); err == nil {
-		if userID, err := utils.ValidateSession(utils.GlobalDB, cookie.Value); err == nil {
-			currentUserID = userID
-			isLoggedIn = true
+	currentUserID := ph.currentUser(r)
+	isLoggedIn := currentUserID != ""
+
+	// POST /profile/{id}/block and /profile/{id}/unblock
+	if target, action, ok := strings.Cut(targetUserID, "/"); ok {
+		if r.Method != http.MethodPost {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+			return
 		}
+		ph.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+			ph.handleBlock(w, r, target, action)
+		}).ServeHTTP(w, r)
+		return
 	}
 
 	// Handle profile updates only for own profile
@@ -55,30 +78,68 @@ func (ph *ProfileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ph.displayUserProfile(w, targetUserID, currentUserID, isLoggedIn)
+	ph.displayUserProfile(w, r, targetUserID, currentUserID, isLoggedIn)
 }
 
-func (ph *ProfileHandler) displayUserProfile(w http.ResponseWriter, targetUserID string, currentUserID string, isLoggedIn bool) {
-	var profile ProfileData
-	err := utils.GlobalDB.QueryRow(`
-        SELECT id, username, email, COALESCE(profile_pic, '') as profile_pic 
-        FROM users 
-        WHERE id = ?
-    `, targetUserID).Scan(&profile.UserID, &profile.Username, &profile.Email, &profile.ProfilePic)
+func (ph *ProfileHandler) displayUserProfile(w http.ResponseWriter, r *http.Request, targetUserID string, currentUserID string, isLoggedIn bool) {
+	user, err := ph.users.Get(targetUserID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrNotFound)
-			return
+		if err != repository.ErrNotFound {
+			log.Printf("Error fetching profile: %v", err)
 		}
-		log.Printf("Error fetching profile: %v", err)
 		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrNotFound)
 		return
 	}
+	profile := ProfileData{
+		UserID:     user.ID,
+		Username:   user.UserName,
+		Email:      user.Email,
+		ProfilePic: user.ProfilePic,
+		Role:       user.Role,
+	}
 
 	profile.IsLoggedIn = isLoggedIn
 	profile.IsOwnProfile = targetUserID == currentUserID
+	profile.IsStaff = ph.userCan(currentUserID, utils.PermBanUsers)
+
+	if isLoggedIn && !profile.IsOwnProfile {
+		profile.IsBlocked, err = ph.users.IsBlocked(currentUserID, targetUserID)
+		if err != nil {
+			log.Printf("Error checking block: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+	}
 
-	tmpl, err := template.ParseFiles("templates/profile.html")
+	if profile.IsOwnProfile {
+		identities, err := ph.users.Identities(currentUserID)
+		if err != nil {
+			log.Printf("Error fetching linked accounts: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+		linked := make(map[string]utils.Identity)
+		for _, id := range identities {
+			linked[id.Provider] = id
+		}
+		for _, info := range ph.providers {
+			id, ok := linked[info.Name]
+			delete(linked, info.Name)
+			profile.Providers = append(profile.Providers, linkedProvider{
+				Provider: info.Name, Label: info.Label, Icon: info.Icon, Linked: ok, CanLink: true, Email: id.Email,
+			})
+		}
+		// Providers removed from the configuration can still be unlinked
+		for _, id := range identities {
+			if _, ok := linked[id.Provider]; ok {
+				profile.Providers = append(profile.Providers, linkedProvider{
+					Provider: id.Provider, Label: id.Provider, Icon: "fas fa-key", Linked: true, Email: id.Email,
+				})
+			}
+		}
+	}
+
+	tmpl, err := utils.ParseTemplate(r, "templates/profile.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
 		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
@@ -90,22 +151,23 @@ func (ph *ProfileHandler) displayUserProfile(w http.ResponseWriter, targetUserID
 
 func (ph *ProfileHandler) handleProfileUpdate(w http.ResponseWriter, r *http.Request, userID string) {
     // Get current user profile data
-    var profile ProfileData
-    err := utils.GlobalDB.QueryRow(`
-        SELECT id, username, email, COALESCE(profile_pic, '') as profile_pic 
-        FROM users 
-        WHERE id = ?
-    `, userID).Scan(&profile.UserID, &profile.Username, &profile.Email, &profile.ProfilePic)
+    user, err := ph.users.Get(userID)
     if err != nil {
         log.Printf("Error fetching profile: %v", err)
         utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
         return
     }
 
-    profile.IsLoggedIn = true
-    profile.IsOwnProfile = true
+    profile := ProfileData{
+        UserID:       user.ID,
+        Username:     user.UserName,
+        Email:        user.Email,
+        ProfilePic:   user.ProfilePic,
+        IsLoggedIn:   true,
+        IsOwnProfile: true,
+    }
 
-    tmpl, err := template.ParseFiles("templates/profile.html")
+    tmpl, err := utils.ParseTemplate(r, "templates/profile.html")
     if err != nil {
         log.Printf("Error parsing template: %v", err)
         utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
@@ -134,60 +196,58 @@ func (ph *ProfileHandler) handleProfileUpdate(w http.ResponseWriter, r *http.Req
         return
     }
 
-    // Validate file type
-    if !isValidImageType(header.Header.Get("Content-Type")) {
-        profile.ErrorMessage = "Invalid file type. Please upload an image (JPEG, PNG, GIF)"
-        tmpl.Execute(w, profile)
-        return
-    }
-
-    // Get old profile pic path
-    var oldImagePath sql.NullString
-    err = utils.GlobalDB.QueryRow("SELECT profile_pic FROM users WHERE id = ?", userID).Scan(&oldImagePath)
-    if err != nil {
-        profile.ErrorMessage = "Error retrieving old profile picture"
-        tmpl.Execute(w, profile)
-        return
-    }
-
     // Process new image
     imagePath, err := ph.imageHandler.ProcessImage(file, header)
     if err != nil {
-        profile.ErrorMessage = "Error processing image: " + err.Error()
+        profile.ErrorMessage = uploadErrorMessage(err)
         tmpl.Execute(w, profile)
         return
     }
 
-    // Update database with new image path
-    _, err = utils.GlobalDB.Exec(`
-        UPDATE users 
-        SET profile_pic = ? 
-        WHERE id = ?
-    `, imagePath, userID)
-    if err != nil {
-        // Clean up new image if database update fails
-        os.Remove(imagePath)
+    // Update database with new image path. The old picture may be shared
+    // with other profiles or posts, since uploads are stored by content hash,
+    // so it is left to the upload cleanup, which deletes it once unused.
+    if err := ph.users.SetProfilePic(userID, imagePath); err != nil {
         profile.ErrorMessage = "Error updating profile picture in database"
         tmpl.Execute(w, profile)
         return
     }
 
-    // Delete old profile pic if it exists
-    if oldImagePath.Valid && oldImagePath.String != "" {
-        oldPath := filepath.Join("uploads", filepath.Base(oldImagePath.String))
-        if err := os.Remove(oldPath); err != nil {
-            log.Printf("Error removing old profile picture: %v", err)
-        }
-    }
-
     // Redirect back to profile page
     http.Redirect(w, r, "/profile/"+userID, http.StatusSeeOther)
 }
-func isValidImageType(contentType string) bool {
-	validTypes := map[string]bool{
-		"image/jpeg": true,
-		"image/png":  true,
-		"image/gif":  true,
+// handleBlock blocks or unblocks targetUserID for the signed-in user.
+func (ph *ProfileHandler) handleBlock(w http.ResponseWriter, r *http.Request, targetUserID, action string) {
+	userID := r.Context().Value("userID").(string)
+	if targetUserID == userID {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
 	}
-	return validTypes[contentType]
+
+	_, err := ph.users.Get(targetUserID)
+	if err == repository.ErrNotFound {
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error looking up user: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	switch action {
+	case "block":
+		err = ph.users.Block(userID, targetUserID)
+	case "unblock":
+		err = ph.users.Unblock(userID, targetUserID)
+	default:
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPageNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating block: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	http.Redirect(w, r, "/profile/"+targetUserID, http.StatusSeeOther)
 }
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"errors"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+const (
+	maxSearchResults = 50
+
+	// Markers wrapped around matched terms in snippets, swapped for <mark>
+	highlightStart = repository.MatchStart
+	highlightEnd   = repository.MatchEnd
+)
+
+type SearchHandler struct {
+	authenticator
+	categories repository.CategoryStore
+	search     repository.SearchStore
+}
+
+func NewSearchHandler(stores *repository.Stores) *SearchHandler {
+	return &SearchHandler{authenticator: newAuthenticator(stores), categories: stores.Categories, search: stores.Search}
+}
+
+// searchFilters narrows a search down by category, author and date range.
+type searchFilters struct {
+	Query    string
+	Category string
+	Author   string
+	From     string // YYYY-MM-DD, inclusive
+	To       string // YYYY-MM-DD, inclusive
+}
+
+type searchResult struct {
+	Kind      string // "post" or "comment"
+	PostID    int
+	CommentID int
+	PostTitle string
+	Author    string
+	Snippet   template.HTML
+	CreatedAt string
+}
+
+func (sh *SearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+	sh.handleSearch(w, r)
+}
+
+func (sh *SearchHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filters := searchFilters{
+		Query:    strings.TrimSpace(query.Get("q")),
+		Category: query.Get("category"),
+		Author:   strings.TrimSpace(query.Get("author")),
+		From:     query.Get("from"),
+		To:       query.Get("to"),
+	}
+
+	data := struct {
+		Filters       searchFilters
+		Results       []searchResult
+		Categories    []utils.Category
+		Searched      bool
+		ErrorMessage  string
+		IsLoggedIn    bool
+		CurrentUserID string
+	}{
+		Filters: filters,
+	}
+
+	data.CurrentUserID = sh.currentUser(r)
+	data.IsLoggedIn = data.CurrentUserID != ""
+
+	categories, err := sh.categories.List()
+	if err != nil {
+		log.Printf("Error fetching categories: %v", err)
+	}
+	data.Categories = categories
+
+	if filters.Query != "" {
+		data.Searched = true
+		results, err := sh.searchContent(filters)
+		if err == errInvalidDate {
+			data.ErrorMessage = "Dates must be in the format YYYY-MM-DD"
+		} else if err != nil {
+			log.Printf("Error searching: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+		data.Results = results
+	}
+
+	tmpl, err := utils.ParseTemplate(r, "templates/search.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
+		return
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
+	}
+}
+
+var errInvalidDate = errors.New("invalid date filter")
+
+// searchContent runs a search over posts and comments and prepares the
+// results for display.
+func (sh *SearchHandler) searchContent(filters searchFilters) ([]searchResult, error) {
+	q := repository.SearchQuery{
+		Text:     filters.Query,
+		Category: filters.Category,
+		Author:   filters.Author,
+		Limit:    maxSearchResults,
+	}
+	if filters.From != "" {
+		from, err := time.Parse("2006-01-02", filters.From)
+		if err != nil {
+			return nil, errInvalidDate
+		}
+		q.From = from
+	}
+	if filters.To != "" {
+		to, err := time.Parse("2006-01-02", filters.To)
+		if err != nil {
+			return nil, errInvalidDate
+		}
+		q.Before = to.AddDate(0, 0, 1)
+	}
+
+	found, err := sh.search.Search(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []searchResult
+	for _, res := range found {
+		results = append(results, searchResult{
+			Kind:      res.Kind,
+			PostID:    res.PostID,
+			CommentID: res.CommentID,
+			PostTitle: res.PostTitle,
+			Author:    res.Author,
+			Snippet:   highlightSnippet(res.Snippet),
+			CreatedAt: FormatTimeAgo(res.CreatedAt.Local()),
+		})
+	}
+	return results, nil
+}
+
+// highlightSnippet escapes a snippet and turns the match markers into <mark> tags.
+func highlightSnippet(snippet string) template.HTML {
+	escaped := html.EscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, highlightEnd, "</mark>")
+	return template.HTML(escaped)
+}
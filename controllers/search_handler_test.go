@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"forum/repository/memory"
+	"forum/utils"
+)
+
+func TestHighlightSnippet(t *testing.T) {
+	got := highlightSnippet("use <b>" + highlightStart + "goroutines" + highlightEnd + "</b>")
+	want := "use &lt;b&gt;<mark>goroutines</mark>&lt;/b&gt;"
+	if string(got) != want {
+		t.Errorf("highlightSnippet() = %s, want %s", got, want)
+	}
+}
+
+func TestSearchContent(t *testing.T) {
+	stores := memory.New()
+	sh := NewSearchHandler(stores)
+	ownerID := utils.GenerateId()
+	if err := stores.Users.Create(utils.User{ID: ownerID, UserName: "owner", Email: "owner@example.com"}); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	marker := "zyxwordforsearch"
+	postID, err := stores.Posts.Create(utils.Post{UserID: ownerID, Title: "About " + marker, Content: "Content"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create post: %v", err)
+	}
+	if _, err := stores.Comments.Create(utils.Comment{PostID: postID, UserID: ownerID, Content: "I agree on " + marker}); err != nil {
+		t.Fatalf("Failed to create comment: %v", err)
+	}
+
+	results, err := sh.searchContent(searchFilters{Query: marker})
+	if err != nil {
+		t.Fatalf("searchContent returned error: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("searchContent returned %d results, want 2", len(results))
+	}
+	for _, res := range results {
+		if res.PostID != postID {
+			t.Errorf("result %+v does not belong to post %d", res, postID)
+		}
+		if !strings.Contains(string(res.Snippet), "<mark>") {
+			t.Errorf("result snippet %q has no highlight", res.Snippet)
+		}
+	}
+
+	results, err = sh.searchContent(searchFilters{Query: marker, Author: "nobody-with-this-name"})
+	if err != nil {
+		t.Fatalf("searchContent returned error: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("author filter returned %d results, want 0", len(results))
+	}
+
+	today := time.Now().Format("2006-01-02")
+	if results, _ := sh.searchContent(searchFilters{Query: marker, From: today, To: today}); len(results) != 2 {
+		t.Errorf("searching today returned %d results, want 2", len(results))
+	}
+	if _, err := sh.searchContent(searchFilters{Query: marker, From: "yesterday"}); err != errInvalidDate {
+		t.Errorf("invalid date returned %v, want errInvalidDate", err)
+	}
+}
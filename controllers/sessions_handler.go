@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+// SessionsHandler lets users see the devices they are signed in on and sign
+// any of them out.
+type SessionsHandler struct {
+	authenticator
+}
+
+func NewSessionsHandler(stores *repository.Stores) *SessionsHandler {
+	return &SessionsHandler{authenticator: newAuthenticator(stores)}
+}
+
+type sessionView struct {
+	PublicID  string
+	Device    string
+	IP        string
+	CreatedAt string
+	LastSeen  string
+	Current   bool
+}
+
+func (sh *SessionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/sessions":
+		if r.Method == http.MethodGet {
+			sh.requireAuth(sh.handleListSessions).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/sessions/revoke":
+		if r.Method == http.MethodPost {
+			sh.requireAuth(sh.handleRevokeSession).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	case "/sessions/revoke-others":
+		if r.Method == http.MethodPost {
+			sh.requireAuth(sh.handleRevokeOtherSessions).ServeHTTP(w, r)
+		} else {
+			utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		}
+	default:
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrPageNotFound)
+	}
+}
+
+// currentSessionToken returns the token of the session making the request.
+// Handlers behind requireAuth always have one.
+func currentSessionToken(r *http.Request) string {
+	cookie, err := r.Cookie("session_token")
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+func (sh *SessionsHandler) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+	current := currentSessionToken(r)
+
+	sessions, err := sh.sessions.List(userID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	var views []sessionView
+	for _, s := range sessions {
+		views = append(views, sessionView{
+			PublicID:  utils.SessionPublicID(s.ID),
+			Device:    describeUserAgent(s.UserAgent),
+			IP:        s.IP,
+			CreatedAt: s.CreatedAt.Local().Format("Jan 2, 2006 15:04"),
+			LastSeen:  FormatTimeAgo(s.LastSeen.Local()),
+			Current:   s.ID == current,
+		})
+	}
+
+	data := struct {
+		Sessions      []sessionView
+		CurrentUserID string
+		IsLoggedIn    bool
+	}{
+		Sessions:      views,
+		CurrentUserID: userID,
+		IsLoggedIn:    true,
+	}
+
+	tmpl, err := utils.ParseTemplate(r, "templates/sessions.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateLoad)
+		return
+	}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrTemplateExec)
+	}
+}
+
+// handleRevokeSession signs out one session, identified by its public ID.
+// Revoking the current session works like signing out.
+func (sh *SessionsHandler) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	if err := r.ParseForm(); err != nil {
+		utils.RenderErrorPage(w, http.StatusBadRequest, utils.ErrInvalidForm)
+		return
+	}
+	publicID := r.FormValue("session")
+
+	sessions, err := sh.sessions.List(userID)
+	if err != nil {
+		log.Printf("Error listing sessions: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	for _, s := range sessions {
+		if utils.SessionPublicID(s.ID) != publicID {
+			continue
+		}
+		if err := sh.sessions.Delete(userID, s.ID); err != nil {
+			log.Printf("Error revoking session: %v", err)
+			utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+			return
+		}
+		if s.ID == currentSessionToken(r) {
+			http.SetCookie(w, &http.Cookie{
+				Name:    "session_token",
+				Value:   "",
+				Path:    "/",
+				Expires: time.Now().Add(-1 * time.Hour),
+			})
+			http.Redirect(w, r, "/signin", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+		return
+	}
+
+	utils.RenderErrorPage(w, http.StatusNotFound, "Session not found")
+}
+
+func (sh *SessionsHandler) handleRevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value("userID").(string)
+
+	n, err := sh.sessions.DeleteOthers(userID, currentSessionToken(r))
+	if err != nil {
+		log.Printf("Error revoking sessions: %v", err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+
+	log.Printf("User %s signed out %d other sessions", userID, n)
+	http.Redirect(w, r, "/sessions", http.StatusSeeOther)
+}
+
+// describeUserAgent turns a User-Agent header into a short label such as
+// "Firefox on Windows". It only recognises common browsers and systems.
+func describeUserAgent(ua string) string {
+	if ua == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		// Order matters: Edge and Opera also claim to be Chrome, and Chrome
+		// claims to be Safari
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	system := ""
+	for _, s := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
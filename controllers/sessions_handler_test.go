@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+func TestDescribeUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15", "Safari on macOS"},
+		{"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36 Edg/120.0", "Edge on Windows"},
+		{"curl/8.4.0", "curl"},
+		{"", "Unknown device"},
+	}
+
+	for _, tt := range tests {
+		if got := describeUserAgent(tt.ua); got != tt.want {
+			t.Errorf("describeUserAgent(%q) = %q, want %q", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestSessionsHandler_Revoke(t *testing.T) {
+	db := openTestDB(t)
+
+	userID, laptop := seedUser(t, db, utils.RoleMember)
+	phone, err := utils.CreateSession(db, userID, "phone", "10.0.0.2")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	tablet, err := utils.CreateSession(db, userID, "tablet", "10.0.0.3")
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// Signing in on another device keeps the earlier sessions
+	for _, token := range []string{laptop, phone, tablet} {
+		if _, err := utils.ValidateSession(db, token); err != nil {
+			t.Fatalf("session was invalidated by a later sign-in: %v", err)
+		}
+	}
+
+	sh := NewSessionsHandler(repository.NewSQLite(db))
+	rr := postForm(sh, "/sessions/revoke", laptop, url.Values{"session": {utils.SessionPublicID(phone)}})
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/sessions" {
+		t.Fatalf("revoke: got %d to %q, want redirect to /sessions", rr.Code, rr.Header().Get("Location"))
+	}
+	if _, err := utils.ValidateSession(db, phone); err == nil {
+		t.Errorf("revoked session is still valid")
+	}
+
+	// Another user's session cannot be revoked
+	_, otherToken := seedUser(t, db, utils.RoleMember)
+	if rr := postForm(sh, "/sessions/revoke", laptop, url.Values{"session": {utils.SessionPublicID(otherToken)}}); rr.Code != http.StatusNotFound {
+		t.Errorf("revoking another user's session: got %d, want %d", rr.Code, http.StatusNotFound)
+	}
+
+	if rr := postForm(sh, "/sessions/revoke-others", laptop, nil); rr.Code != http.StatusSeeOther {
+		t.Fatalf("revoke others: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+	sessions, err := utils.ListSessions(db, userID)
+	if err != nil {
+		t.Fatalf("ListSessions returned error: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != laptop {
+		t.Errorf("after revoking others %d sessions remain, want only the current one", len(sessions))
+	}
+	if sessions[0].UserAgent != "test-agent" || sessions[0].IP != "127.0.0.1" {
+		t.Errorf("session device = %q from %q, want test-agent from 127.0.0.1", sessions[0].UserAgent, sessions[0].IP)
+	}
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"forum/storage"
+	"forum/utils"
+)
+
+// UploadsHandler serves uploaded files from /uploads/{key}, redirecting to a
+// signed URL when the backend has one and streaming the file otherwise.
+type UploadsHandler struct {
+	store storage.BlobStore
+}
+
+// NewUploadsHandler returns an UploadsHandler serving the files in store.
+func NewUploadsHandler(store storage.BlobStore) *UploadsHandler {
+	return &UploadsHandler{store: store}
+}
+
+func (uh *UploadsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		utils.RenderErrorPage(w, http.StatusMethodNotAllowed, utils.ErrMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, storage.URLPrefix)
+	if !storage.ValidKey(key) {
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrNotFound)
+		return
+	}
+
+	signedURL, err := uh.store.SignedURL(r.Context(), key)
+	if err != nil {
+		log.Printf("Error signing upload URL for %s: %v", key, err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+	if signedURL != "" {
+		// Signed URLs expire, so browsers may only keep the redirect briefly
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		http.Redirect(w, r, signedURL, http.StatusFound)
+		return
+	}
+
+	blob, err := uh.store.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		utils.RenderErrorPage(w, http.StatusNotFound, utils.ErrNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening upload %s: %v", key, err)
+		utils.RenderErrorPage(w, http.StatusInternalServerError, utils.ErrInternalServer)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", storage.ContentType(key))
+	w.Header().Set("Cache-Control", storage.CacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if rs, ok := blob.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, key, time.Time{}, rs)
+		return
+	}
+	io.Copy(w, blob)
+}
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"forum/storage"
+)
+
+// signingStore is a local store that hands out signed URLs like S3 does.
+type signingStore struct {
+	*storage.LocalStore
+}
+
+func (signingStore) SignedURL(ctx context.Context, key string) (string, error) {
+	return "https://bucket.example.com/" + key + "?signature=x", nil
+}
+
+func TestUploadsHandler(t *testing.T) {
+	local := storage.NewLocalStore(t.TempDir())
+	if err := local.Put(context.Background(), "abc.png", []byte("\x89PNG data"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(handler http.Handler, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		return rr
+	}
+
+	uh := &UploadsHandler{store: local}
+	rr := get(uh, "/uploads/abc.png")
+	if rr.Code != http.StatusOK || rr.Body.String() != "\x89PNG data" {
+		t.Errorf("proxied upload returned %d %q", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+	for _, path := range []string{"/uploads/missing.png", "/uploads/../forum.db", "/uploads/"} {
+		if rr := get(uh, path); rr.Code != http.StatusNotFound {
+			t.Errorf("GET %s returned %d, want %d", path, rr.Code, http.StatusNotFound)
+		}
+	}
+
+	rr = get(&UploadsHandler{store: signingStore{local}}, "/uploads/abc.png")
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "https://bucket.example.com/abc.png?signature=x" {
+		t.Errorf("signed upload returned %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+func TestUserTokens_SingleUseAndExpiry(t *testing.T) {
+	db := openTestDB(t)
+
+	userID, _ := seedUser(t, db, utils.RoleMember)
+
+	token, err := utils.IssueToken(db, userID, utils.TokenResetPassword, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+	if _, err := utils.CheckToken(db, token, utils.TokenVerifyEmail); err != utils.ErrInvalidToken {
+		t.Errorf("token was accepted for another purpose: %v", err)
+	}
+	if got, err := utils.ConsumeToken(db, token, utils.TokenResetPassword); err != nil || got != userID {
+		t.Fatalf("ConsumeToken = %q, %v; want %q", got, err, userID)
+	}
+	if _, err := utils.ConsumeToken(db, token, utils.TokenResetPassword); err != utils.ErrInvalidToken {
+		t.Errorf("token was used twice: %v", err)
+	}
+
+	expired, err := utils.IssueToken(db, userID, utils.TokenResetPassword, -time.Minute)
+	if err != nil {
+		t.Fatalf("IssueToken failed: %v", err)
+	}
+	if _, err := utils.CheckToken(db, expired, utils.TokenResetPassword); err != utils.ErrInvalidToken {
+		t.Errorf("expired token was accepted: %v", err)
+	}
+
+	// Asking for a new link makes the previous one useless
+	first, _ := utils.IssueToken(db, userID, utils.TokenVerifyEmail, time.Hour)
+	second, _ := utils.IssueToken(db, userID, utils.TokenVerifyEmail, time.Hour)
+	if _, err := utils.CheckToken(db, first, utils.TokenVerifyEmail); err != utils.ErrInvalidToken {
+		t.Errorf("superseded token was accepted: %v", err)
+	}
+	if _, err := utils.CheckToken(db, second, utils.TokenVerifyEmail); err != nil {
+		t.Errorf("latest token was rejected: %v", err)
+	}
+}
+
+func TestRequireVerified(t *testing.T) {
+	db := openTestDB(t)
+
+	userID, token := seedUser(t, db, utils.RoleMember)
+	auth := newAuthenticator(repository.NewSQLite(db))
+	auth.requireVerification = true
+	handler := auth.requireVerified(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/create", nil)
+		req.AddCookie(&http.Cookie{Name: "session_token", Value: token})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := get(); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/verify-email" {
+		t.Errorf("unverified user: got %d to %q, want redirect to /verify-email", rr.Code, rr.Header().Get("Location"))
+	}
+
+	if err := utils.MarkEmailVerified(db, userID); err != nil {
+		t.Fatalf("MarkEmailVerified failed: %v", err)
+	}
+	if rr := get(); rr.Code != http.StatusOK {
+		t.Errorf("verified user: got %d, want %d", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,106 @@
+// Package logging sets up the forum's structured logs. Every record goes
+// through Redact, so tokens, passwords and other secrets never reach the
+// output. Once the logger is made the default with slog.SetDefault, the
+// standard log package goes through it too. Requests carry a Request in
+// their context so the request log can name the signed-in user.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+)
+
+// New returns a logger writing records of level and above to w, as
+// "text" or "json".
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: l, ReplaceAttr: Redact}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// Redacted replaces secrets in the logs.
+const Redacted = "[REDACTED]"
+
+// secretKey matches the names of attributes, query parameters and form
+// fields that hold secrets.
+var secretKey = regexp.MustCompile(`(?i)(token|password|passwd|secret|session|cookie|authorization|api_?key|code_verifier)`)
+
+// secretValue matches secrets written into strings as key=value, as in
+// query strings and form bodies.
+var secretValue = regexp.MustCompile(`(?i)([\w-]*(?:token|password|passwd|secret|session|cookie|authorization|api_?key|code_verifier)[\w-]*)=([^\s&"',;]+)`)
+
+// Redact is a slog ReplaceAttr function. It hides the values of attributes
+// named like secrets, and secrets written as key=value in any string,
+// including the message. Errors and other values are checked as they would
+// be printed.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.MessageKey && secretKey.MatchString(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	var s string
+	switch a.Value.Kind() {
+	case slog.KindString:
+		s = a.Value.String()
+	case slog.KindAny:
+		s = fmt.Sprint(a.Value.Any())
+	default:
+		return a
+	}
+	if secretValue.MatchString(s) {
+		return slog.String(a.Key, RedactString(s))
+	}
+	return a
+}
+
+// RedactString hides the secrets written as key=value in s.
+func RedactString(s string) string {
+	return secretValue.ReplaceAllString(s, "$1="+Redacted)
+}
+
+// Request is what the request log records about a request beyond what the
+// request itself says. Handlers fill it in as they learn more.
+type Request struct {
+	ID     string
+	UserID string
+}
+
+type requestKey struct{}
+
+// WithRequest returns a context carrying req.
+func WithRequest(ctx context.Context, req *Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// FromContext returns the Request of ctx, or nil outside a request.
+func FromContext(ctx context.Context) *Request {
+	req, _ := ctx.Value(requestKey{}).(*Request)
+	return req
+}
+
+// SetUser records the signed-in user of the request ctx belongs to.
+func SetUser(ctx context.Context, userID string) {
+	if req := FromContext(ctx); req != nil {
+		req.UserID = userID
+	}
+}
+
+// validID matches request IDs accepted from proxies, which are copied into
+// logs and headers.
+var validID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// ValidRequestID reports whether an ID given by a proxy can be used.
+func ValidRequestID(id string) bool {
+	return validID.MatchString(id)
+}
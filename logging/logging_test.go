@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("GET /reset-password?token=abc123&next=/",
+		"session_token", "s3cret",
+		"Password", "hunter2",
+		"body", "username=alice&password=hunter2",
+		"user_id", "u1",
+		"err", fmt.Errorf("fetching /verify-email?token=t0ken: %w", io.ErrUnexpectedEOF),
+		"url", &url.URL{Path: "/auth/callback", RawQuery: "code_verifier=v3rifier"},
+	)
+	out := buf.String()
+	for _, secret := range []string{"abc123", "s3cret", "hunter2", "t0ken", "v3rifier"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log output contains %q:\n%s", secret, out)
+		}
+	}
+	for _, kept := range []string{"next=/", "username=alice", `"user_id":"u1"`, "unexpected EOF", Redacted} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("log output lacks %q:\n%s", kept, out)
+		}
+	}
+}
+
+func TestRedact_LogPackage(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "text", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(l *slog.Logger, w io.Writer, flags int) {
+		slog.SetDefault(l)
+		log.SetOutput(w)
+		log.SetFlags(flags)
+	}(slog.Default(), log.Writer(), log.Flags())
+	slog.SetDefault(logger)
+
+	log.Printf("Reset link: https://forum.example.com/reset-password?token=abc123")
+	if out := buf.String(); strings.Contains(out, "abc123") || !strings.Contains(out, "level=INFO") {
+		t.Errorf("log package output was not redacted by the handler:\n%s", out)
+	}
+}
+
+func TestNew_RejectsUnknownSettings(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Errorf("New accepted the xml format")
+	}
+	if _, err := New(&bytes.Buffer{}, "text", "loud"); err == nil {
+		t.Errorf("New accepted the loud level")
+	}
+}
@@ -1,64 +1,268 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	handlers "forum/authentication"
+	"forum/config"
 	"forum/controllers"
+	"forum/logging"
+	"forum/repository"
+	"forum/storage"
+	"forum/supervisor"
 	"forum/utils"
 )
 
 func main() {
-	if len(os.Args) != 1 {
-		log.Fatal("Usage: go run main.go ")
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
+
+	if len(args) == 0 {
+		serve(cfg)
+		return
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			c.run(cfg, args[1:])
+			return
+		}
+	}
+	fmt.Fprint(os.Stderr, usage())
+	os.Exit(2)
+}
+
+// command is a subcommand of the forum binary, given after the flags.
+type command struct {
+	name string
+	args string
+	help string
+	run  func(cfg config.Config, args []string)
+}
+
+var commands = []command{
+	{"serve", "", "run the forum, the default", func(cfg config.Config, args []string) {
+		if len(args) > 0 {
+			log.Fatal("Usage: go run . serve")
+		}
+		serve(cfg)
+	}},
+	{"migrate", "[up | down [steps] | to <version> | status]", "change or show the schema version", runMigrations},
+	{"migrate-uploads", "-from local -to s3", "copy uploads to another backend", migrateUploads},
+	{"clean-uploads", "[-dry-run] [-grace 24h]", "delete unreferenced uploads", cleanUploads},
+	{"user", "create | promote | ban | unban | reset-password ...", "manage accounts", withStores(userCommand)},
+	{"category", "list | add | rename | merge ...", "manage categories", withStores(categoryCommand)},
+	{"sessions", "purge [-user <username>]", "delete expired sessions, or all of a user's", withStores(sessionsCommand)},
+	{"recount", "[-dry-run]", "check and fix stored like, dislike and comment counts", recount},
+	{"seed", "[-users 5] [-posts 20]", "fill the database with demo content", withStores(seedCommand)},
+}
+
+func usage() string {
+	var b strings.Builder
+	b.WriteString("Usage: go run . [flags] [command]\n\nCommands:\n")
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", c.name, c.args, c.help)
+	}
+	w.Flush()
+	b.WriteString("\nRun go run . -help for the flags.\n")
+	return b.String()
+}
+
+// withStores turns an administration command into a command: it opens the
+// database, applying migrations like the server would, and exits with the
+// error the command returns.
+func withStores(run func(stores *repository.Stores, args []string, in io.Reader, out io.Writer) error) func(config.Config, []string) {
+	return func(cfg config.Config, args []string) {
+		db, err := utils.InitialiseDB(cfg.Database)
+		if err != nil {
+			log.Fatalf("Database initialization failed: %v", err)
+		}
+		err = run(repository.NewSQLite(db), args, os.Stdin, os.Stdout)
+		db.Close()
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		} else if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// readHeaderTimeout bounds reading request headers, well below the read
+// timeout that has to allow for slow uploads.
+const readHeaderTimeout = 10 * time.Second
+
+// serve runs the forum until it gets SIGINT or SIGTERM, then stops taking
+// new connections, lets requests in flight finish and stops the background
+// jobs before closing the database.
+func serve(cfg config.Config) {
+	// Everything logged from here on, including through the log package,
+	// is structured and has secrets redacted
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Fatalf("Log configuration failed: %v", err)
+	}
+	slog.SetDefault(logger)
+
 	// Initialize database
-	db, err := utils.InitialiseDB()
+	db, err := utils.InitialiseDB(cfg.Database)
 	if err != nil {
 		log.Fatalf("Database initialization failed: %v", err)
 	}
-	defer db.Close()
+	// Closed last, once the requests and the jobs using it are done
+	jobs := supervisor.New()
+	jobs.OnStop("database", db.Close)
 
 	// Initialize handlers with database
-	handlers.InitDB(db)
-	utils.InitSessionManager(utils.GlobalDB)
-
-	http.HandleFunc("/auth/github", handlers.HandleGitHubLogin)
-	http.HandleFunc("/auth/github/callback", handlers.HandleGitHubCallback)
-	http.HandleFunc("/auth/google", handlers.HandleGoogleLogin)
-	http.HandleFunc("/auth/google/callback", handlers.HandleGoogleCallback)
-	http.HandleFunc("/signup", handlers.SignUpHandler)
-	http.HandleFunc("/signin", handlers.SignInHandler)
-	http.HandleFunc("/created", controllers.CreatedPosts)
-	http.HandleFunc("/liked", controllers.LikedPosts)
+	stores := repository.NewSQLite(db)
+	if err := utils.PromoteBootstrapAdmins(db, cfg.Auth.AdminUsernames); err != nil {
+		log.Fatalf("Admin promotion failed: %v", err)
+	}
+	auth, err := handlers.New(stores, utils.NewMailer(cfg.Mail), cfg)
+	if err != nil {
+		log.Fatalf("Sign-in provider configuration failed: %v", err)
+	}
+
+	uploads, err := storage.Open(context.Background(), cfg.Uploads)
+	if err != nil {
+		log.Fatalf("Upload storage configuration failed: %v", err)
+	}
+
+	// Pushes notifications created by the database triggers to open streams
+	jobs.Go("notifications", func(ctx context.Context) {
+		utils.Notifications.Run(ctx, db)
+	})
+	jobs.Go("notification digests", func(ctx context.Context) {
+		auth.RunNotificationDigests(ctx, cfg.Notifications.DigestInterval)
+	})
+	jobs.Go("session cleanup", func(ctx context.Context) {
+		utils.RunSessionsCleanUp(ctx, db, time.Hour)
+	})
+	jobs.Go("counter reconciliation", func(ctx context.Context) {
+		utils.RunCounterReconciliation(ctx, db, cfg.Database.CounterCheckInterval)
+	})
+	jobs.Go("upload cleanup", func(ctx context.Context) {
+		utils.RunUploadsCleanUp(ctx, db, uploads, cfg.Uploads.CleanupInterval,
+			cfg.Uploads.CleanupGrace, cfg.Uploads.CleanupDryRun)
+	})
+
+	http.HandleFunc("/auth/", auth.ProviderAuth)
+	http.HandleFunc("/auth/link", auth.LinkProvider)
+	http.HandleFunc("/auth/unlink", auth.UnlinkProvider)
+	http.HandleFunc("/signup", auth.SignUp)
+	http.HandleFunc("/signin", auth.SignIn)
+	http.HandleFunc("/verify-email", auth.VerifyEmail)
+	http.HandleFunc("/verify-email/resend", auth.ResendVerification)
+	http.HandleFunc("/forgot-password", auth.ForgotPassword)
+	http.HandleFunc("/reset-password", auth.ResetPassword)
+	filterHandler := controllers.NewFilterHandler(stores)
+	http.Handle("/created", filterHandler)
+	http.Handle("/liked", filterHandler)
 	http.HandleFunc("/static/", handlers.ServeStatic)
-	http.HandleFunc("/signout", handlers.SignOutHandler(db))
+	http.Handle("/uploads/", controllers.NewUploadsHandler(uploads))
+	http.HandleFunc("/signout", auth.SignOut)
 
 	// Initialize post handler
-	postHandler := controllers.NewPostHandler()
+	postHandler := controllers.NewPostHandler(stores, uploads, cfg)
 
 	// http.Handle("/post", postHandler)
 	http.Handle("/", postHandler) // Handle root for posts
 
 	// Initialize profile handler
-	profileHandler := controllers.NewProfileHandler()
+	profileHandler := controllers.NewProfileHandler(stores, uploads, auth.Providers())
 	http.Handle("/profile/", profileHandler)
 
 	// Initialize category handler
-	categoryHandler := controllers.NewCategoryHandler()
+	categoryHandler := controllers.NewCategoryHandler(stores)
 	http.Handle("/categories", categoryHandler)
 	http.Handle("/category", categoryHandler)
+	http.Handle("/categories/", categoryHandler)
+
+	searchHandler := controllers.NewSearchHandler(stores)
+	http.Handle("/search", searchHandler)
 
-	notificationHandler := controllers.NewNotificationHandler()
+	notificationHandler := controllers.NewNotificationHandler(stores)
 	http.Handle("/notifications", notificationHandler)
+	http.Handle("/notifications/", notificationHandler)
 
-	fmt.Println("Server opened at port 8000...http://localhost:8000/")
+	sessionsHandler := controllers.NewSessionsHandler(stores)
+	http.Handle("/sessions", sessionsHandler)
+	http.Handle("/sessions/", sessionsHandler)
 
-	err = http.ListenAndServe(":8000", nil)
-	if err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	adminHandler := controllers.NewAdminHandler(stores)
+	http.Handle("/admin", adminHandler)
+	http.Handle("/admin/", adminHandler)
+
+	moderationHandler := controllers.NewModerationHandler(stores)
+	http.Handle("/report", moderationHandler)
+	http.Handle("/moderation", moderationHandler)
+	http.Handle("/moderation/", moderationHandler)
+
+	server := &http.Server{
+		Addr: cfg.Addr,
+		Handler: controllers.Chain(http.DefaultServeMux,
+			controllers.RequestLog(logger),
+			controllers.RecoverPanics(logger),
+			controllers.CSRF(stores.Sessions),
+			controllers.PageSessions(stores.Sessions, stores.Notifications),
+		),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	// Shutdown does not wait for notification streams, which never finish
+	// on their own
+	server.RegisterOnShutdown(utils.Notifications.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("server listening", "addr", cfg.Addr, "url", cfg.BaseURL+"/")
+		serverErr <- server.ListenAndServe()
+	}()
+
+	failed := false
+	select {
+	case err := <-serverErr:
+		log.Printf("Server failed: %v", err)
+		failed = true
+	case <-ctx.Done():
+		log.Printf("Shutting down, waiting up to %v for requests and background jobs", cfg.Server.ShutdownTimeout)
+	}
+	// A second signal kills the server straight away
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Requests did not finish: %v", err)
+		failed = true
+	}
+	if err := jobs.Stop(shutdownCtx); err != nil {
+		log.Printf("Shutdown incomplete:\n%v", err)
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
 	}
+	log.Println("Server stopped")
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+
+	"forum/config"
+	"forum/repository"
+	"forum/utils"
+)
+
+const sessionsUsage = `Usage: go run . sessions purge [-user <username>]
+  Deletes expired sessions, or every session of the user, signing them out.`
+
+// sessionsCommand deletes sessions ahead of the hourly cleanup the server
+// runs, or signs a user out everywhere.
+func sessionsCommand(stores *repository.Stores, args []string, in io.Reader, out io.Writer) error {
+	if len(args) == 0 || args[0] != "purge" {
+		return errors.New(sessionsUsage)
+	}
+	flags := flag.NewFlagSet("sessions purge", flag.ContinueOnError)
+	username := flags.String("user", "", "delete every session of this user")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New(sessionsUsage)
+	}
+
+	if *username != "" {
+		return withUser(stores, *username, func(user utils.User) error {
+			if err := stores.Sessions.DeleteAll(user.ID); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Signed %s out everywhere\n", user.UserName)
+			return nil
+		})
+	}
+	n, err := stores.Sessions.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Deleted %d expired sessions\n", n)
+	return nil
+}
+
+// recount checks the like, dislike and comment counts stored on posts and
+// comments, like the server does every COUNTER_CHECK_INTERVAL, and prints
+// and fixes the wrong ones.
+func recount(cfg config.Config, args []string) {
+	flags := flag.NewFlagSet("recount", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report wrong counts without fixing them")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		log.Fatal("Usage: go run . recount [-dry-run]")
+	}
+
+	db, err := utils.InitialiseDB(cfg.Database)
+	if err != nil {
+		log.Fatalf("Database initialization failed: %v", err)
+	}
+	defer db.Close()
+
+	found, err := utils.ReconcileCounters(db, !*dryRun)
+	if err != nil {
+		log.Fatalf("Recount failed: %v", err)
+	}
+	for _, d := range found {
+		fmt.Println(d)
+	}
+	switch {
+	case len(found) == 0:
+		fmt.Println("Every count is right")
+	case *dryRun:
+		fmt.Printf("%d wrong counts; dry run, nothing was changed\n", len(found))
+	default:
+		fmt.Printf("Fixed %d wrong counts\n", len(found))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"forum/config"
+	"forum/utils"
+)
+
+const migrateUsage = "Usage: go run . migrate [up | down [steps] | to <version> | status]"
+
+// runMigrations changes or reports the schema version of the database. The
+// server applies pending migrations when it starts unless MIGRATE_ON_START
+// is false, so this is mostly needed to roll back or to migrate ahead of a
+// deployment.
+func runMigrations(cfg config.Config, args []string) {
+	db, err := utils.OpenDB(cfg.Database.Path)
+	if err != nil {
+		log.Fatalf("Opening database: %v", err)
+	}
+	defer db.Close()
+
+	command := "up"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	// Migrations up to target are applied, later ones rolled back
+	var changed []utils.Migration
+	target := 0
+	switch {
+	case command == "up" && len(args) == 0:
+		changed, err = utils.Migrate(db)
+		target = math.MaxInt
+	case command == "down" && len(args) <= 1:
+		steps := 1
+		if len(args) == 1 {
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps %q", args[0])
+			}
+		}
+		changed, err = utils.Rollback(db, steps)
+	case command == "to" && len(args) == 1:
+		if target, err = strconv.Atoi(args[0]); err != nil || target < 0 {
+			log.Fatalf("Invalid version %q", args[0])
+		}
+		changed, err = utils.MigrateTo(db, target)
+	case command == "status" && len(args) == 0:
+		printMigrationStatus(db)
+		return
+	default:
+		log.Fatal(migrateUsage)
+	}
+
+	for _, m := range changed {
+		if m.Version <= target {
+			fmt.Printf("Applied %d: %s\n", m.Version, m.Name)
+		} else {
+			fmt.Printf("Rolled back %d: %s\n", m.Version, m.Name)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	if len(changed) == 0 {
+		fmt.Println("Nothing to do")
+	}
+}
+
+func printMigrationStatus(db *sql.DB) {
+	states, err := utils.MigrationStatus(db)
+	if err != nil {
+		log.Fatalf("Reading migrations: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+	for _, state := range states {
+		applied := "pending"
+		if state.Applied {
+			applied = state.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		name := state.Name
+		if state.Up == nil {
+			name += " (unknown to this build)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", state.Version, name, applied)
+	}
+	w.Flush()
+}
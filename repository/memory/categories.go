@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"fmt"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+type categories struct {
+	*data
+}
+
+func (s *categories) List() ([]utils.Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]utils.Category(nil), s.categories...), nil
+}
+
+func (s *categories) IDByName(name string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.categories {
+		if c.Name == name {
+			return c.ID, nil
+		}
+	}
+	return 0, repository.ErrNotFound
+}
+
+func (s *categories) Create(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, c := range s.categories {
+		if c.Name == name {
+			return fmt.Errorf("category %q already exists", name)
+		}
+	}
+	s.categories = append(s.categories, utils.Category{ID: s.nextID(), Name: name})
+	return nil
+}
+
+func (s *categories) Rename(id int, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.categories {
+		if s.categories[i].ID == id {
+			s.categories[i].Name = name
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (s *categories) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, c := range s.categories {
+		if c.ID == id {
+			s.categories = append(s.categories[:i], s.categories[i+1:]...)
+			break
+		}
+	}
+	for _, p := range s.posts {
+		kept := p.categories[:0]
+		for _, categoryID := range p.categories {
+			if categoryID != id {
+				kept = append(kept, categoryID)
+			}
+		}
+		p.categories = kept
+	}
+	return nil
+}
+
+func (s *categories) Merge(fromID, intoID int) error {
+	if fromID == intoID {
+		return fmt.Errorf("cannot merge a category into itself")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	from := -1
+	foundInto := false
+	for i, c := range s.categories {
+		switch c.ID {
+		case fromID:
+			from = i
+		case intoID:
+			foundInto = true
+		}
+	}
+	if from < 0 || !foundInto {
+		return repository.ErrNotFound
+	}
+	s.categories = append(s.categories[:from], s.categories[from+1:]...)
+
+	for _, p := range s.posts {
+		kept := p.categories[:0]
+		filed, hasInto := false, false
+		for _, categoryID := range p.categories {
+			switch categoryID {
+			case fromID:
+				filed = true
+				continue
+			case intoID:
+				hasInto = true
+			}
+			kept = append(kept, categoryID)
+		}
+		if filed && !hasInto {
+			kept = append(kept, intoID)
+		}
+		p.categories = kept
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+type comment struct {
+	utils.Comment
+	reactions map[string]int
+}
+
+type comments struct {
+	*data
+}
+
+func (d *data) commentView(c *comment) utils.Comment {
+	view := c.Comment
+	if author, ok := d.users[c.UserID]; ok {
+		view.Username, view.ProfilePic = author.UserName, author.ProfilePic
+	}
+	view.Likes, view.Dislikes = reactionCounts(c.reactions)
+	return view
+}
+
+func (s *comments) Create(c utils.Comment) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.ID = s.nextID()
+	c.CommentTime = time.Now()
+	s.comments[c.ID] = &comment{Comment: c, reactions: make(map[string]int)}
+	return c.ID, nil
+}
+
+func (s *comments) Get(id int) (utils.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.comments[id]
+	if !ok {
+		return utils.Comment{}, repository.ErrNotFound
+	}
+	return s.commentView(c), nil
+}
+
+func (s *comments) ListByPost(postID int) ([]utils.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []utils.Comment
+	for _, c := range s.comments {
+		if c.PostID == postID {
+			list = append(list, s.commentView(c))
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CommentTime.Equal(list[j].CommentTime) {
+			return list[i].CommentTime.After(list[j].CommentTime)
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list, nil
+}
+
+func (s *comments) Update(id int, content string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.comments[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	c.Content = content
+	return nil
+}
+
+func (s *comments) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.deleteComment(id)
+}
+
+// deleteComment removes a comment, moving its replies up to its parent. The
+// caller holds mu.
+func (d *data) deleteComment(id int) error {
+	c, ok := d.comments[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	for _, reply := range d.comments {
+		if reply.ParentID == id {
+			reply.ParentID = c.ParentID
+		}
+	}
+	delete(d.comments, id)
+	delete(d.mentions, [2]int{c.PostID, id})
+	delete(d.rendered, renderKey{utils.ContentComment, id})
+	d.deleteReports(func(r *report) bool { return r.CommentID == id })
+	return nil
+}
+
+func (s *comments) Depth(id, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.comments[id]
+	if !ok {
+		return 0, repository.ErrNotFound
+	}
+	depth := 0
+	for depth < limit {
+		parent, ok := s.comments[c.ParentID]
+		if !ok {
+			break
+		}
+		c = parent
+		depth++
+	}
+	return depth, nil
+}
+
+func (s *comments) React(userID string, commentID, like int) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.comments[commentID]
+	if !ok {
+		return 0, 0, repository.ErrNotFound
+	}
+	react(c.reactions, userID, like)
+	likes, dislikes := reactionCounts(c.reactions)
+	return likes, dislikes, nil
+}
@@ -0,0 +1,168 @@
+package memory
+
+import (
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+// identityKey is a provider and the user's ID there.
+type identityKey struct {
+	provider, providerUserID string
+}
+
+type identity struct {
+	utils.Identity
+	userID string
+}
+
+type identities struct {
+	*data
+}
+
+func (s *identities) Find(provider, providerUserID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.identities[identityKey{provider, providerUserID}]; ok {
+		return id.userID, nil
+	}
+	return "", utils.ErrIdentityNotFound
+}
+
+func (s *identities) Link(userID, provider, providerUserID, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id, ok := s.identities[identityKey{provider, providerUserID}]; ok {
+		if id.userID == userID {
+			return nil
+		}
+		return utils.ErrIdentityTaken
+	}
+	for _, id := range s.identities {
+		if id.userID == userID && id.Provider == provider {
+			return utils.ErrProviderLinked
+		}
+	}
+	s.link(userID, utils.Identity{Provider: provider, ProviderUserID: providerUserID, Email: email})
+	return nil
+}
+
+// link records an identity. The caller holds mu.
+func (d *data) link(userID string, id utils.Identity) {
+	id.CreatedAt = time.Now()
+	d.identities[identityKey{id.Provider, id.ProviderUserID}] = &identity{id, userID}
+}
+
+func (s *identities) Unlink(userID, provider string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	var linked *identity
+	count := 0
+	for _, id := range s.identities {
+		if id.userID == userID {
+			count++
+			if id.Provider == provider {
+				linked = id
+			}
+		}
+	}
+	if u.Password == "" && count <= 1 {
+		return utils.ErrLastLoginMethod
+	}
+	if linked == nil {
+		return utils.ErrIdentityNotFound
+	}
+	delete(s.identities, identityKey{linked.Provider, linked.ProviderUserID})
+	return nil
+}
+
+// Unclaimed finds none: the memory stores hold no accounts from before
+// identities were recorded.
+func (s *identities) Unclaimed(provider, username, email string) (string, error) {
+	return "", utils.ErrIdentityNotFound
+}
+
+func (s *identities) CreateUser(user utils.User, id utils.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.identities[identityKey{id.Provider, id.ProviderUserID}]; ok {
+		return utils.ErrIdentityTaken
+	}
+	for _, u := range s.users {
+		if u.ID == user.ID || u.UserName == user.UserName || (user.Email != "" && u.Email == user.Email) {
+			return errUserExists
+		}
+	}
+	if user.Role == "" {
+		user.Role = utils.RoleMember
+	}
+	s.users[user.ID] = &user
+	s.link(user.ID, id)
+	return nil
+}
+
+type token struct {
+	userID, purpose string
+	expires         time.Time
+	used            bool
+}
+
+type tokens struct {
+	*data
+}
+
+func (s *tokens) Issue(userID, purpose string, ttl time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for value, t := range s.tokens {
+		if t.userID == userID && t.purpose == purpose && !t.used {
+			delete(s.tokens, value)
+		}
+	}
+	value := utils.GenerateSessionToken()
+	s.tokens[value] = &token{userID: userID, purpose: purpose, expires: time.Now().Add(ttl)}
+	return value, nil
+}
+
+// validToken returns the unused, unexpired token with a purpose. The caller
+// holds mu.
+func (d *data) validToken(value, purpose string) (*token, error) {
+	t, ok := d.tokens[value]
+	if !ok || t.purpose != purpose || t.used || !time.Now().Before(t.expires) {
+		return nil, utils.ErrInvalidToken
+	}
+	return t, nil
+}
+
+func (s *tokens) Check(value, purpose string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.validToken(value, purpose)
+	if err != nil {
+		return "", err
+	}
+	return t.userID, nil
+}
+
+func (s *tokens) Consume(value, purpose string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.validToken(value, purpose)
+	if err != nil {
+		return "", err
+	}
+	t.used = true
+	return t.userID, nil
+}
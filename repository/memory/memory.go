@@ -0,0 +1,102 @@
+// Package memory implements the repository stores in memory, for tests
+// that should not need a database. The stores returned by New share their
+// data, so deleting a post removes its comments and banning a user ends
+// their sessions, as in SQLite. Unlike SQLite, no notifications are created
+// for reactions and comments; only RecordMentions adds any.
+package memory
+
+import (
+	"sync"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+// data is everything the stores hold. Every store method holds mu for its
+// whole run, so they are safe for concurrent use.
+type data struct {
+	mu sync.Mutex
+
+	users    map[string]*utils.User
+	blocks   map[[2]string]bool // Blocker and blocked ID
+	sessions map[string]*session
+
+	identities map[identityKey]*identity
+	tokens     map[string]*token // By the token itself
+
+	categories []utils.Category
+	posts      map[int]*post
+	comments   map[int]*comment
+
+	notifications []*notification
+	preferences   map[string]map[string]string // User ID, then type
+	mentions      map[[2]int]map[string]bool   // Post and comment ID, then user ID
+
+	reports       []*report
+	moderationLog []logEntry
+
+	rendered map[renderKey]rendered
+
+	lastID int // Posts, comments, categories, notifications and reports share IDs
+}
+
+// New returns empty stores sharing one set of data.
+func New() *repository.Stores {
+	d := &data{
+		users:       make(map[string]*utils.User),
+		blocks:      make(map[[2]string]bool),
+		sessions:    make(map[string]*session),
+		identities:  make(map[identityKey]*identity),
+		tokens:      make(map[string]*token),
+		posts:       make(map[int]*post),
+		comments:    make(map[int]*comment),
+		preferences: make(map[string]map[string]string),
+		mentions:    make(map[[2]int]map[string]bool),
+		rendered:    make(map[renderKey]rendered),
+	}
+	return &repository.Stores{
+		Posts:         &posts{d},
+		Comments:      &comments{d},
+		Users:         &users{d},
+		Sessions:      &sessions{d},
+		Categories:    &categories{d},
+		Notifications: &notifications{d},
+		Moderation:    &moderation{d},
+		Search:        &search{d},
+		Renders:       &renders{d},
+		Tokens:        &tokens{d},
+		Identities:    &identities{d},
+	}
+}
+
+// renderKey is the kind and ID of a post or comment.
+type renderKey struct {
+	kind string
+	id   int
+}
+
+func (d *data) nextID() int {
+	d.lastID++
+	return d.lastID
+}
+
+// reactionCounts counts the likes and dislikes in a map of reactions.
+func reactionCounts(reactions map[string]int) (likes, dislikes int) {
+	for _, like := range reactions {
+		if like == 1 {
+			likes++
+		} else {
+			dislikes++
+		}
+	}
+	return likes, dislikes
+}
+
+// react gives or takes back a user's reaction the way the SQLite stores do.
+func react(reactions map[string]int, userID string, like int) {
+	if existing, ok := reactions[userID]; ok && existing == like {
+		delete(reactions, userID)
+	} else {
+		reactions[userID] = like
+	}
+}
@@ -0,0 +1,221 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+type report struct {
+	repository.Report
+}
+
+type logEntry struct {
+	utils.ModerationEntry
+	createdAt time.Time
+}
+
+type moderation struct {
+	*data
+}
+
+// deleteReports removes the reports that match. The caller holds mu.
+func (d *data) deleteReports(match func(r *report) bool) {
+	kept := d.reports[:0]
+	for _, r := range d.reports {
+		if !match(r) {
+			kept = append(kept, r)
+		}
+	}
+	d.reports = kept
+}
+
+// sameContent reports whether r is on the post or comment an entry names.
+func sameContent(r *report, postID, commentID int) bool {
+	return r.PostID == postID && r.CommentID == commentID
+}
+
+func (s *moderation) CreateReport(r repository.Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.reports {
+		if existing.ReporterID == r.ReporterID && existing.Status == "open" && sameContent(existing, r.PostID, r.CommentID) {
+			return nil
+		}
+	}
+	r.ID = s.nextID()
+	r.Status = "open"
+	r.CreatedAt = time.Now()
+	s.reports = append(s.reports, &report{r})
+	return nil
+}
+
+func (s *moderation) GetReport(id int) (repository.Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.reports {
+		if r.ID == id {
+			return r.Report, nil
+		}
+	}
+	return repository.Report{}, repository.ErrNotFound
+}
+
+func (s *moderation) OpenReports(limit int) ([]repository.QueuedReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var queue []repository.QueuedReport
+	for _, r := range s.reports {
+		reporter, ok := s.users[r.ReporterID]
+		if r.Status != "open" || !ok {
+			continue
+		}
+		q := repository.QueuedReport{Report: r.Report, ReporterName: reporter.UserName}
+		var authorID string
+		if c, ok := s.comments[r.CommentID]; ok {
+			authorID, q.Excerpt, q.Hidden = c.UserID, c.Content, c.Hidden
+		} else if p, ok := s.posts[r.PostID]; ok && r.CommentID == 0 {
+			authorID, q.Excerpt, q.Hidden = p.UserID, p.Title, p.Hidden
+		}
+		if author, ok := s.users[authorID]; ok {
+			q.AuthorName = author.UserName
+		}
+		for _, other := range s.reports {
+			if other.Status == "open" && sameContent(other, r.PostID, r.CommentID) {
+				q.OpenReports++
+			}
+		}
+		queue = append(queue, q)
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		if !queue[i].CreatedAt.Equal(queue[j].CreatedAt) {
+			return queue[i].CreatedAt.Before(queue[j].CreatedAt)
+		}
+		return queue[i].ID < queue[j].ID
+	})
+	if len(queue) > limit {
+		queue = queue[:limit]
+	}
+	return queue, nil
+}
+
+// Resolve checks everything that can fail before changing anything, so a
+// failed resolution leaves the data as it was, as the SQLite transaction does.
+func (s *moderation) Resolve(entry utils.ModerationEntry, suspendUntil time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var claimed *report
+	for _, r := range s.reports {
+		if r.ID == entry.ReportID && r.Status == "open" {
+			claimed = r
+		}
+	}
+	if claimed == nil {
+		return repository.ErrReportClosed
+	}
+
+	p, postFound := s.posts[entry.PostID]
+	c, commentFound := s.comments[entry.CommentID]
+	author, authorFound := s.users[entry.TargetUserID]
+	contentFound := postFound
+	if entry.CommentID > 0 {
+		contentFound = commentFound
+	}
+	switch entry.Action {
+	case utils.ModDismiss, utils.ModWarn:
+	case utils.ModDelete, utils.ModHide:
+		if !contentFound {
+			return repository.ErrNotFound
+		}
+	case utils.ModSuspend:
+		if !authorFound {
+			return repository.ErrNotFound
+		}
+	default:
+		return fmt.Errorf("unknown moderation action %q", entry.Action)
+	}
+
+	switch entry.Action {
+	case utils.ModDismiss:
+		claimed.Status = "dismissed"
+	case utils.ModDelete:
+		if entry.CommentID > 0 {
+			s.deleteComment(entry.CommentID)
+		} else {
+			s.deletePost(entry.PostID)
+		}
+	case utils.ModHide:
+		if entry.CommentID > 0 {
+			c.Hidden = true
+		} else {
+			p.Hidden = true
+		}
+	case utils.ModWarn:
+		s.notifications = append(s.notifications, &notification{
+			Notification: utils.Notification{
+				ID:        s.nextID(),
+				Type:      "warning",
+				PostID:    entry.PostID,
+				CreatedAt: time.Now(),
+			},
+			userID:   entry.TargetUserID,
+			actorID:  entry.ModeratorID,
+			delivery: utils.DeliveryInApp,
+		})
+	case utils.ModSuspend:
+		author.SuspendedUntil = suspendUntil
+		s.signOut(author.ID)
+	}
+	if entry.Action != utils.ModDismiss {
+		for _, r := range s.reports {
+			if r.Status == "open" && sameContent(r, entry.PostID, entry.CommentID) {
+				r.Status = "actioned"
+			}
+		}
+	}
+
+	s.moderationLog = append(s.moderationLog, logEntry{entry, time.Now()})
+	return nil
+}
+
+func (s *moderation) Log(entry utils.ModerationEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.moderationLog = append(s.moderationLog, logEntry{entry, time.Now()})
+	return nil
+}
+
+func (s *moderation) RecentLog(limit int) ([]repository.LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []repository.LogEntry
+	for i := len(s.moderationLog) - 1; i >= 0 && len(entries) < limit; i-- {
+		e := s.moderationLog[i]
+		entry := repository.LogEntry{
+			Action:        e.Action,
+			ModeratorName: e.ModeratorID,
+			PostID:        e.PostID,
+			CommentID:     e.CommentID,
+			ReportID:      e.ReportID,
+			Note:          e.Note,
+			CreatedAt:     e.createdAt,
+		}
+		if moderator, ok := s.users[e.ModeratorID]; ok {
+			entry.ModeratorName = moderator.UserName
+		}
+		if target, ok := s.users[e.TargetUserID]; ok {
+			entry.TargetName = target.UserName
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
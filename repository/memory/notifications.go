@@ -0,0 +1,265 @@
+package memory
+
+import (
+	"sort"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+type notification struct {
+	utils.Notification
+	userID   string
+	actorID  string
+	delivery string
+	emailed  bool
+}
+
+type notifications struct {
+	*data
+}
+
+// groups groups the user's notifications with the given delivery the way
+// the SQLite stores do: one entry per post and type, except warnings, each
+// showing its newest notification. The caller holds mu.
+func (d *data) groups(userID, delivery string, include func(n *notification) bool) []utils.Notification {
+	newest := make(map[string]*notification)
+	actors := make(map[string]map[string]bool)
+	read := make(map[string]bool)
+	for _, n := range d.notifications {
+		if n.userID != userID || n.delivery != delivery || !include(n) {
+			continue
+		}
+		key := n.GroupKey()
+		if newest[key] == nil {
+			actors[key] = make(map[string]bool)
+			read[key] = true
+		}
+		if newest[key] == nil || n.ID > newest[key].ID {
+			newest[key] = n
+		}
+		actors[key][n.actorID] = true
+		read[key] = read[key] && n.Read
+	}
+
+	var list []utils.Notification
+	for key, n := range newest {
+		entry := n.Notification
+		if actor, ok := d.users[n.actorID]; ok {
+			entry.ActorName, entry.ActorProfilePic = actor.UserName, actor.ProfilePic
+		}
+		entry.Others = len(actors[key]) - 1
+		entry.Read = read[key]
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	return list
+}
+
+func all(*notification) bool { return true }
+
+// find returns the user's notification with the given ID. The caller
+// holds mu.
+func (s *notifications) find(userID string, notificationID int) (*notification, bool) {
+	for _, n := range s.notifications {
+		if n.ID == notificationID && n.userID == userID {
+			return n, true
+		}
+	}
+	return nil, false
+}
+
+func (s *notifications) List(userID string) ([]utils.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.groups(userID, utils.DeliveryInApp, all), nil
+}
+
+func (s *notifications) Group(userID string, notificationID int) (utils.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	target, ok := s.find(userID, notificationID)
+	if !ok || target.delivery != utils.DeliveryInApp {
+		return utils.Notification{}, repository.ErrNotFound
+	}
+	key := target.GroupKey()
+	groups := s.groups(userID, utils.DeliveryInApp, func(n *notification) bool { return n.GroupKey() == key })
+	return groups[0], nil
+}
+
+func (s *notifications) Since(userID string, afterID int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []int
+	for _, n := range s.notifications {
+		if n.userID == userID && n.delivery == utils.DeliveryInApp && n.ID > afterID {
+			ids = append(ids, n.ID)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (s *notifications) CountUnread(userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, n := range s.groups(userID, utils.DeliveryInApp, all) {
+		if !n.Read {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *notifications) MarkRead(userID string, notificationID int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	target, ok := s.find(userID, notificationID)
+	if !ok {
+		return false, nil
+	}
+	key := target.GroupKey()
+	for _, n := range s.notifications {
+		if n.userID == userID && n.delivery == utils.DeliveryInApp && n.GroupKey() == key {
+			n.Read = true
+		}
+	}
+	return true, nil
+}
+
+func (s *notifications) MarkAllRead(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, n := range s.notifications {
+		if n.userID == userID {
+			n.Read = true
+		}
+	}
+	return nil
+}
+
+func (s *notifications) Preferences(userID string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefs := make(map[string]string)
+	for _, t := range utils.NotificationTypes {
+		prefs[t.Type] = utils.DeliveryInApp
+		if delivery, ok := s.preferences[userID][t.Type]; ok {
+			prefs[t.Type] = delivery
+		}
+	}
+	return prefs, nil
+}
+
+func (s *notifications) SetPreference(userID, notificationType, delivery string) error {
+	switch delivery {
+	case utils.DeliveryInApp, utils.DeliveryEmail, utils.DeliveryOff:
+	default:
+		return utils.ErrInvalidDelivery
+	}
+	known := false
+	for _, t := range utils.NotificationTypes {
+		known = known || t.Type == notificationType
+	}
+	if !known {
+		return utils.ErrInvalidDelivery
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.preferences[userID] == nil {
+		s.preferences[userID] = make(map[string]string)
+	}
+	s.preferences[userID][notificationType] = delivery
+	return nil
+}
+
+func (s *notifications) RecordMentions(actorID string, postID, commentID int, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]int{postID, commentID}
+	known := s.mentions[key]
+	mentioned := make(map[string]bool)
+	for _, name := range utils.ParseMentions(text) {
+		var userID string
+		for _, u := range s.users {
+			if u.UserName == name {
+				userID = u.ID
+			}
+		}
+		if userID == "" {
+			continue
+		}
+		mentioned[userID] = true
+		if known[userID] || userID == actorID || s.blocks[[2]string{userID, actorID}] {
+			continue
+		}
+		delivery := utils.DeliveryInApp
+		if pref, ok := s.preferences[userID]["mention"]; ok {
+			delivery = pref
+		}
+		if delivery == utils.DeliveryOff {
+			continue
+		}
+		s.notifications = append(s.notifications, &notification{
+			Notification: utils.Notification{
+				ID:        s.nextID(),
+				Type:      "mention",
+				PostID:    postID,
+				CreatedAt: time.Now(),
+			},
+			userID:   userID,
+			actorID:  actorID,
+			delivery: delivery,
+		})
+	}
+	s.mentions[key] = mentioned
+	return nil
+}
+
+func (s *notifications) DigestRecipients() ([]utils.DigestRecipient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var recipients []utils.DigestRecipient
+	for _, n := range s.notifications {
+		u, ok := s.users[n.userID]
+		if n.delivery != utils.DeliveryEmail || n.emailed || !ok || u.Email == "" || seen[u.ID] {
+			continue
+		}
+		seen[u.ID] = true
+		recipients = append(recipients, utils.DigestRecipient{UserID: u.ID, Email: u.Email})
+	}
+	return recipients, nil
+}
+
+func (s *notifications) PendingDigest(userID string) ([]utils.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.groups(userID, utils.DeliveryEmail, func(n *notification) bool { return !n.emailed }), nil
+}
+
+func (s *notifications) MarkDigestSent(userID string, upToID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, n := range s.notifications {
+		if n.userID == userID && n.delivery == utils.DeliveryEmail && n.ID <= upToID {
+			n.emailed = true
+		}
+	}
+	return nil
+}
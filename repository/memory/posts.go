@@ -0,0 +1,245 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+type post struct {
+	utils.Post
+	categories []int
+	revisions  []repository.Revision
+	reactions  map[string]int // User ID to 1 for a like, 0 for a dislike
+}
+
+type posts struct {
+	*data
+}
+
+func (d *data) categoryIDs(names []string) []int {
+	var ids []int
+	for _, name := range names {
+		for _, c := range d.categories {
+			if c.Name == name {
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+	return ids
+}
+
+func (d *data) categoryNames(ids []int) []string {
+	var names []string
+	for _, id := range ids {
+		for _, c := range d.categories {
+			if c.ID == id {
+				names = append(names, c.Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// view returns a copy of p with its author and counts filled in.
+func (d *data) view(p *post) utils.Post {
+	view := p.Post
+	if author, ok := d.users[p.UserID]; ok {
+		view.Username, view.ProfilePic = author.UserName, author.ProfilePic
+	}
+	view.Likes, view.Dislikes = reactionCounts(p.reactions)
+	view.Comments = 0
+	for _, c := range d.comments {
+		if c.PostID == p.ID {
+			view.Comments++
+		}
+	}
+	return view
+}
+
+func (s *posts) Create(p utils.Post, categories []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.PostedAt.IsZero() {
+		p.PostedAt = time.Now()
+	}
+	p.ID = s.nextID()
+	s.posts[p.ID] = &post{Post: p, categories: s.categoryIDs(categories), reactions: make(map[string]int)}
+	return p.ID, nil
+}
+
+func (s *posts) Get(id int) (*utils.Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	view := s.view(p)
+	return &view, nil
+}
+
+// score matches the SQLite stores' scores.
+func score(p utils.Post, sortBy string, asOf time.Time) float64 {
+	net := float64(p.Likes - p.Dislikes)
+	switch sortBy {
+	case "top":
+		return net
+	case "hot":
+		age := asOf.Sub(p.PostedAt).Hours() + 2
+		return net / (age * age)
+	}
+	return 0
+}
+
+func (s *posts) Feed(q repository.FeedQuery) ([]repository.ScoredPost, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	excluded := make(map[int]bool, len(q.Exclude))
+	for _, id := range q.Exclude {
+		excluded[id] = true
+	}
+
+	var feed []repository.ScoredPost
+	for _, p := range s.posts {
+		if p.Hidden || excluded[p.ID] || p.PostedAt.After(q.AsOf) || (!q.Since.IsZero() && p.PostedAt.Before(q.Since)) {
+			continue
+		}
+		if q.Filter.AuthorID != "" && p.UserID != q.Filter.AuthorID {
+			continue
+		}
+		if _, ok := p.reactions[q.Filter.ReactedBy]; q.Filter.ReactedBy != "" && !ok {
+			continue
+		}
+		if q.Filter.CategoryName != "" {
+			found := false
+			for _, name := range s.categoryNames(p.categories) {
+				found = found || name == q.Filter.CategoryName
+			}
+			if !found {
+				continue
+			}
+		}
+
+		view := s.view(p)
+		scored := repository.ScoredPost{Post: view, Score: score(view, q.Sort, q.AsOf)}
+		if a := q.After; a != nil && !(scored.Score < a.Score || (scored.Score == a.Score && scored.ID < a.ID)) {
+			continue
+		}
+		feed = append(feed, scored)
+	}
+
+	sort.Slice(feed, func(i, j int) bool {
+		if feed[i].Score != feed[j].Score {
+			return feed[i].Score > feed[j].Score
+		}
+		return feed[i].ID > feed[j].ID
+	})
+	if len(feed) > q.Limit {
+		feed = feed[:q.Limit]
+	}
+	return feed, nil
+}
+
+func (s *posts) Categories(postID int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[postID]
+	if !ok {
+		return nil, nil
+	}
+	return s.categoryNames(p.categories), nil
+}
+
+func (s *posts) Update(edit repository.PostEdit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[edit.PostID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	var editor string
+	if u, ok := s.users[edit.EditorID]; ok {
+		editor = u.UserName
+	}
+
+	now := time.Now()
+	p.revisions = append(p.revisions, repository.Revision{
+		Title:      p.Title,
+		Content:    p.Content,
+		ImagePath:  p.ImagePath,
+		Categories: strings.Join(s.categoryNames(p.categories), ", "),
+		EditorName: editor,
+		RevisedAt:  now,
+	})
+	p.Title, p.Content, p.ImagePath, p.UpdatedAt = edit.Title, edit.Content, edit.ImagePath, now
+	p.categories = s.categoryIDs(edit.Categories)
+	return nil
+}
+
+func (s *posts) Revisions(postID int) ([]repository.Revision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[postID]
+	if !ok {
+		return nil, nil
+	}
+	return append([]repository.Revision(nil), p.revisions...), nil
+}
+
+func (s *posts) Delete(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deletePost(id)
+	return nil
+}
+
+// deletePost removes a post and everything that refers to it. The caller
+// holds mu.
+func (d *data) deletePost(id int) {
+	delete(d.posts, id)
+	delete(d.rendered, renderKey{utils.ContentPost, id})
+	for commentID, c := range d.comments {
+		if c.PostID == id {
+			delete(d.comments, commentID)
+			delete(d.rendered, renderKey{utils.ContentComment, commentID})
+		}
+	}
+	kept := d.notifications[:0]
+	for _, n := range d.notifications {
+		if n.PostID != id {
+			kept = append(kept, n)
+		}
+	}
+	d.notifications = kept
+	for key := range d.mentions {
+		if key[0] == id {
+			delete(d.mentions, key)
+		}
+	}
+	d.deleteReports(func(r *report) bool { return r.PostID == id })
+}
+
+func (s *posts) React(userID string, postID, like int) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.posts[postID]
+	if !ok {
+		return 0, 0, repository.ErrNotFound
+	}
+	react(p.reactions, userID, like)
+	likes, dislikes := reactionCounts(p.reactions)
+	return likes, dislikes, nil
+}
@@ -0,0 +1,44 @@
+package memory
+
+type rendered struct {
+	revision string
+	html     string
+}
+
+type renders struct {
+	*data
+}
+
+func (s *renders) UserIDs(usernames []string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make(map[string]string)
+	for _, name := range usernames {
+		for _, u := range s.users {
+			if u.UserName == name {
+				ids[name] = u.ID
+			}
+		}
+	}
+	return ids, nil
+}
+
+func (s *renders) Rendered(kind string, id int, revision string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.rendered[renderKey{kind, id}]
+	if !ok || r.revision != revision {
+		return "", false, nil
+	}
+	return r.html, true, nil
+}
+
+func (s *renders) SaveRendered(kind string, id int, revision, html string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rendered[renderKey{kind, id}] = rendered{revision, html}
+	return nil
+}
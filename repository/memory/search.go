@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"forum/repository"
+)
+
+type search struct {
+	*data
+}
+
+// Search matches substrings like the SQLite stores do without FTS5, and
+// orders the results by recency.
+func (s *search) Search(q repository.SearchQuery) ([]repository.SearchResult, error) {
+	terms := repository.SearchTerms(q.Text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches := func(p *post, authorID string, at time.Time, text string) bool {
+		if p.Hidden || s.username(authorID) == "" {
+			return false
+		}
+		if q.Category != "" && !contains(s.categoryNames(p.categories), q.Category) {
+			return false
+		}
+		if q.Author != "" && s.username(authorID) != q.Author {
+			return false
+		}
+		if (!q.From.IsZero() && at.Before(q.From)) || (!q.Before.IsZero() && !at.Before(q.Before)) {
+			return false
+		}
+		for _, term := range terms {
+			if !strings.Contains(strings.ToLower(text), strings.ToLower(term)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var results []repository.SearchResult
+	for _, p := range s.posts {
+		if matches(p, p.UserID, p.PostedAt, p.Title+" "+p.Content) {
+			results = append(results, repository.SearchResult{
+				Kind:      "post",
+				PostID:    p.ID,
+				PostTitle: p.Title,
+				Author:    s.username(p.UserID),
+				CreatedAt: p.PostedAt,
+				Snippet:   repository.PostSnippet(p.Title, p.Content, terms),
+			})
+		}
+	}
+	for _, c := range s.comments {
+		p, ok := s.posts[c.PostID]
+		if ok && !c.Hidden && matches(p, c.UserID, c.CommentTime, c.Content) {
+			results = append(results, repository.SearchResult{
+				Kind:      "comment",
+				PostID:    p.ID,
+				CommentID: c.ID,
+				PostTitle: p.Title,
+				Author:    s.username(c.UserID),
+				CreatedAt: c.CommentTime,
+				Snippet:   repository.Snippet(c.Content, terms),
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+	if len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	return results, nil
+}
+
+// username returns the name of a user, or "" if they do not exist. The
+// caller holds mu.
+func (d *data) username(id string) string {
+	if u, ok := d.users[id]; ok {
+		return u.UserName
+	}
+	return ""
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package memory
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"forum/utils"
+)
+
+// sessionLifetime matches the SQLite sessions.
+const sessionLifetime = 24 * time.Hour
+
+var errInvalidSession = errors.New("session expired or invalid")
+
+type session struct {
+	utils.Session
+	csrfToken string
+}
+
+type sessions struct {
+	*data
+}
+
+func (s *sessions) Create(userID, userAgent, ip string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	token := utils.GenerateSessionToken()
+	s.sessions[token] = &session{
+		Session: utils.Session{
+			ID:        token,
+			UserID:    userID,
+			ExpiresAt: now.Add(sessionLifetime),
+			UserAgent: userAgent,
+			IP:        ip,
+			CreatedAt: now,
+			LastSeen:  now,
+		},
+		csrfToken: utils.GenerateSessionToken(),
+	}
+	return token, nil
+}
+
+// valid looks up an unexpired session whose user is not banned or
+// suspended. The caller holds mu.
+func (s *sessions) valid(token string) (*session, bool) {
+	sess, ok := s.sessions[token]
+	if !ok || !sess.ExpiresAt.After(time.Now()) {
+		return nil, false
+	}
+	if u, ok := s.users[sess.UserID]; ok && (u.Banned || u.SuspendedUntil.After(time.Now())) {
+		return nil, false
+	}
+	return sess, true
+}
+
+func (s *sessions) Validate(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.valid(token)
+	if !ok {
+		return "", errInvalidSession
+	}
+	sess.LastSeen = time.Now()
+	return sess.UserID, nil
+}
+
+func (s *sessions) List(userID string) ([]utils.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []utils.Session
+	for _, sess := range s.sessions {
+		if sess.UserID == userID && sess.ExpiresAt.After(time.Now()) {
+			list = append(list, sess.Session)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeen.After(list[j].LastSeen) })
+	return list, nil
+}
+
+func (s *sessions) CSRFToken(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[token]
+	if !ok {
+		return "", errInvalidSession
+	}
+	return sess.csrfToken, nil
+}
+
+func (s *sessions) Delete(userID, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[token]; ok && sess.UserID == userID {
+		delete(s.sessions, token)
+	}
+	return nil
+}
+
+func (s *sessions) DeleteOthers(userID, keepToken string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for token, sess := range s.sessions {
+		if sess.UserID == userID && token != keepToken {
+			delete(s.sessions, token)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *sessions) DeleteAll(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.signOut(userID)
+	return nil
+}
+
+func (s *sessions) DeleteExpired() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for token, sess := range s.sessions {
+		if !sess.ExpiresAt.After(time.Now()) {
+			delete(s.sessions, token)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,200 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+var errUserExists = errors.New("username or email already exists")
+
+type users struct {
+	*data
+}
+
+func (s *users) find(match func(u *utils.User) bool) (utils.User, error) {
+	for _, u := range s.users {
+		if match(u) {
+			return *u, nil
+		}
+	}
+	return utils.User{}, repository.ErrNotFound
+}
+
+func (s *users) List() ([]utils.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []utils.User
+	for _, u := range s.users {
+		user := *u
+		user.Password = ""
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UserName < list[j].UserName })
+	return list, nil
+}
+
+func (s *users) Get(id string) (utils.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.find(func(u *utils.User) bool { return u.ID == id })
+	user.Password = ""
+	return user, err
+}
+
+func (s *users) GetByUsername(username string) (utils.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.find(func(u *utils.User) bool { return u.UserName == username })
+}
+
+func (s *users) GetByEmail(email string) (utils.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.find(func(u *utils.User) bool { return u.Email != "" && u.Email == email })
+}
+
+func (s *users) Create(user utils.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.users {
+		if u.ID == user.ID || u.UserName == user.UserName || (user.Email != "" && u.Email == user.Email) {
+			return errUserExists
+		}
+	}
+	if user.Role == "" {
+		user.Role = utils.RoleMember
+	}
+	s.users[user.ID] = &user
+	return nil
+}
+
+// update runs change on the user with the given ID.
+func (s *users) update(id string, change func(u *utils.User)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	change(u)
+	return nil
+}
+
+func (s *users) SetPassword(id, hash string) error {
+	return s.update(id, func(u *utils.User) { u.Password = hash })
+}
+
+func (s *users) SetProfilePic(id, imagePath string) error {
+	return s.update(id, func(u *utils.User) {
+		u.ProfilePic.String, u.ProfilePic.Valid = imagePath, true
+	})
+}
+
+func (s *users) Role(id string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if u, ok := s.users[id]; ok {
+		return u.Role, nil
+	}
+	return utils.RoleMember, nil
+}
+
+func (s *users) SetRole(id, role string) error {
+	if !utils.ValidRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	return s.update(id, func(u *utils.User) { u.Role = role })
+}
+
+// signOut ends every session of a user. The caller holds mu.
+func (d *data) signOut(userID string) {
+	for token, sess := range d.sessions {
+		if sess.UserID == userID {
+			delete(d.sessions, token)
+		}
+	}
+}
+
+func (s *users) Ban(id string) error {
+	return s.update(id, func(u *utils.User) {
+		u.Banned = true
+		s.signOut(id)
+	})
+}
+
+func (s *users) Suspend(id string, until time.Time) error {
+	return s.update(id, func(u *utils.User) {
+		u.SuspendedUntil = until
+		s.signOut(id)
+	})
+}
+
+func (s *users) Unban(id string) error {
+	s.update(id, func(u *utils.User) { u.Banned = false })
+	return nil
+}
+
+func (s *users) EmailVerified(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return false, repository.ErrNotFound
+	}
+	return u.EmailVerified, nil
+}
+
+func (s *users) MarkEmailVerified(id string) error {
+	s.update(id, func(u *utils.User) { u.EmailVerified = true })
+	return nil
+}
+
+func (s *users) Block(blockerID, blockedID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocks[[2]string{blockerID, blockedID}] = true
+	return nil
+}
+
+func (s *users) Unblock(blockerID, blockedID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blocks, [2]string{blockerID, blockedID})
+	return nil
+}
+
+func (s *users) IsBlocked(blockerID, blockedID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.blocks[[2]string{blockerID, blockedID}], nil
+}
+
+func (s *users) Identities(id string) ([]utils.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var list []utils.Identity
+	for _, linked := range s.identities {
+		if linked.userID == id {
+			list = append(list, linked.Identity)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Provider < list[j].Provider })
+	return list, nil
+}
@@ -0,0 +1,349 @@
+// Package repository is the forum's data access layer. Handlers depend on
+// the store interfaces declared here rather than on a database, so they can
+// be given the SQLite stores from NewSQLite in production and the in-memory
+// stores from the memory package in tests.
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"forum/utils"
+)
+
+// ErrNotFound is returned when the requested row does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrReportClosed is returned when resolving a report that is not open.
+var ErrReportClosed = errors.New("report is already resolved")
+
+// Stores bundles one of each store, so handlers can be built from a single
+// value.
+type Stores struct {
+	Posts         PostStore
+	Comments      CommentStore
+	Users         UserStore
+	Sessions      SessionStore
+	Categories    CategoryStore
+	Notifications NotificationStore
+	Moderation    ModerationStore
+	Search        SearchStore
+	Renders       RenderStore
+	Tokens        TokenStore
+	Identities    IdentityStore
+}
+
+// FeedFilter restricts a feed to a category, an author or the posts a user
+// reacted to. Empty fields do not filter.
+type FeedFilter struct {
+	CategoryName string
+	AuthorID     string
+	ReactedBy    string
+}
+
+// FeedQuery selects a page of a feed. Posts are ordered by score, highest
+// first, and then by ID, so the order is total and a page can start after
+// any post. Votes change "top" and "hot" scores between pages, so those
+// feeds page by leaving out the posts already shown instead.
+type FeedQuery struct {
+	Filter  FeedFilter
+	Sort    string    // "new", "top" or "hot"
+	AsOf    time.Time // Posts made later are left out, and "hot" ages are measured from it
+	Since   time.Time // Posts made earlier are left out, unless it is zero
+	After   *FeedPosition
+	Exclude []int // IDs of posts to leave out
+	Limit   int
+}
+
+// FeedPosition is the place of a post in a feed.
+type FeedPosition struct {
+	Score float64
+	ID    int
+}
+
+// ScoredPost is a post in a feed with the score it was sorted by.
+type ScoredPost struct {
+	utils.Post
+	Score float64
+}
+
+// PostEdit is a new version of a post. The version it replaces is kept as a
+// revision.
+type PostEdit struct {
+	PostID     int
+	EditorID   string
+	Title      string
+	Content    string
+	ImagePath  string
+	Categories []string
+}
+
+// Revision is a past version of a post, as it was before an edit.
+type Revision struct {
+	Title      string
+	Content    string
+	ImagePath  string
+	Categories string // Comma-separated names
+	EditorName string // User whose edit replaced this version
+	RevisedAt  time.Time
+}
+
+// PostStore reads and writes posts, their categories, revisions and
+// reactions.
+type PostStore interface {
+	// Create saves a post filed under the named categories and returns its
+	// ID. Unknown category names are skipped.
+	Create(post utils.Post, categories []string) (int, error)
+	// Get returns a post with its author's name and picture, hidden or not.
+	Get(id int) (*utils.Post, error)
+	Feed(q FeedQuery) ([]ScoredPost, error)
+	// Categories returns the sorted names of the categories a post is in.
+	Categories(postID int) ([]string, error)
+	Update(edit PostEdit) error
+	// Revisions returns the past versions of a post, oldest first.
+	Revisions(postID int) ([]Revision, error)
+	// Delete removes a post with its comments, reactions, revisions and
+	// everything else that refers to it.
+	Delete(id int) error
+	// React likes (like 1) or dislikes (like 0) a post for a user, or takes
+	// back the same reaction given earlier, and returns the new counts.
+	React(userID string, postID, like int) (likes, dislikes int, err error)
+}
+
+// CommentStore reads and writes comments and their reactions.
+type CommentStore interface {
+	// Create saves a comment, a reply when ParentID is set, and returns its ID.
+	Create(comment utils.Comment) (int, error)
+	Get(id int) (utils.Comment, error)
+	// ListByPost returns the comments of a post, newest first.
+	ListByPost(postID int) ([]utils.Comment, error)
+	Update(id int, content string) error
+	// Delete removes a comment. Its replies move up to its parent.
+	Delete(id int) error
+	// Depth returns how many ancestors a comment has, counting no further
+	// than limit.
+	Depth(id, limit int) (int, error)
+	React(userID string, commentID, like int) (likes, dislikes int, err error)
+}
+
+// UserStore reads and writes user accounts.
+type UserStore interface {
+	// List returns every user, ordered by username, without passwords.
+	List() ([]utils.User, error)
+	Get(id string) (utils.User, error)
+	// GetByUsername and GetByEmail return the user with the password hash,
+	// for signing in and resetting passwords. The hash is empty for accounts
+	// created through a sign-in provider.
+	GetByUsername(username string) (utils.User, error)
+	GetByEmail(email string) (utils.User, error)
+	// Create saves a new user. It fails if the username or email is taken.
+	Create(user utils.User) error
+	SetPassword(id, hash string) error
+	SetProfilePic(id, imagePath string) error
+	// Role returns a user's role, treating unknown users as members.
+	Role(id string) (string, error)
+	SetRole(id, role string) error
+	// Ban and Suspend sign the user out everywhere.
+	Ban(id string) error
+	Suspend(id string, until time.Time) error
+	Unban(id string) error
+	EmailVerified(id string) (bool, error)
+	MarkEmailVerified(id string) error
+	Block(blockerID, blockedID string) error
+	Unblock(blockerID, blockedID string) error
+	IsBlocked(blockerID, blockedID string) (bool, error)
+	Identities(id string) ([]utils.Identity, error)
+}
+
+// SessionStore reads and writes sign-in sessions.
+type SessionStore interface {
+	// Create starts a session and returns its token.
+	Create(userID, userAgent, ip string) (string, error)
+	// Validate returns the user a session token belongs to. Expired
+	// sessions and those of banned or suspended users are invalid.
+	Validate(token string) (string, error)
+	// List returns a user's unexpired sessions, most recently used first.
+	List(userID string) ([]utils.Session, error)
+	CSRFToken(token string) (string, error)
+	Delete(userID, token string) error
+	DeleteOthers(userID, keepToken string) (int64, error)
+	DeleteAll(userID string) error
+	DeleteExpired() (int64, error)
+}
+
+// CategoryStore reads and writes categories.
+type CategoryStore interface {
+	List() ([]utils.Category, error)
+	IDByName(name string) (int, error)
+	Create(name string) error
+	Rename(id int, name string) error
+	// Delete removes a category. Its posts are kept.
+	Delete(id int) error
+	// Merge files the posts of category fromID under intoID as well, then
+	// deletes fromID.
+	Merge(fromID, intoID int) error
+}
+
+// NotificationStore reads and updates notifications and how users want to
+// receive them. Most notifications are created by database triggers; only
+// mentions are recorded here.
+type NotificationStore interface {
+	// List returns the user's in-app notifications, grouped and newest first.
+	List(userID string) ([]utils.Notification, error)
+	// Group returns the in-app entry a notification is shown in.
+	Group(userID string, notificationID int) (utils.Notification, error)
+	// Since returns the IDs of the user's in-app notifications newer than
+	// afterID, oldest first.
+	Since(userID string, afterID int) ([]int, error)
+	CountUnread(userID string) (int, error)
+	// MarkRead reports false if the notification is not the user's.
+	MarkRead(userID string, notificationID int) (bool, error)
+	MarkAllRead(userID string) error
+	Preferences(userID string) (map[string]string, error)
+	SetPreference(userID, notificationType, delivery string) error
+	// RecordMentions notifies the users a post, or a comment when
+	// commentID is not 0, mentions for the first time.
+	RecordMentions(actorID string, postID, commentID int, text string) error
+	DigestRecipients() ([]utils.DigestRecipient, error)
+	PendingDigest(userID string) ([]utils.Notification, error)
+	MarkDigestSent(userID string, upToID int) error
+}
+
+// Report is a user's report on a post, or on one of its comments when
+// CommentID is not 0.
+type Report struct {
+	ID         int
+	ReporterID string
+	PostID     int
+	CommentID  int
+	Reason     string
+	Details    string
+	Status     string // "open", "dismissed" or "actioned"
+	CreatedAt  time.Time
+}
+
+// QueuedReport is an open report as shown in the moderation queue.
+type QueuedReport struct {
+	Report
+	ReporterName string
+	AuthorName   string // Empty when the content has been deleted
+	Excerpt      string // Title of a post or text of a comment
+	Hidden       bool
+	OpenReports  int // Open reports on the same content
+}
+
+// LogEntry is an entry of the moderation log with the names of the users
+// involved.
+type LogEntry struct {
+	Action        string
+	ModeratorName string
+	TargetName    string // Empty when the action had no target user
+	PostID        int
+	CommentID     int
+	ReportID      int
+	Note          string
+	CreatedAt     time.Time
+}
+
+// ModerationStore reads and writes reports and the moderation log.
+type ModerationStore interface {
+	// CreateReport records a report unless the reporter already has one open
+	// on the same content.
+	CreateReport(report Report) error
+	GetReport(id int) (Report, error)
+	// OpenReports returns up to limit open reports, oldest first.
+	OpenReports(limit int) ([]QueuedReport, error)
+	// Resolve carries out the action of a moderation entry on the content
+	// and author it names, closes the report with every other open report on
+	// the same content, and logs the entry, all in one transaction. Suspend
+	// actions suspend the author until suspendUntil. It returns
+	// ErrReportClosed if the report is no longer open.
+	Resolve(entry utils.ModerationEntry, suspendUntil time.Time) error
+	// Log appends an entry to the moderation log.
+	Log(entry utils.ModerationEntry) error
+	// RecentLog returns the newest limit entries of the moderation log.
+	RecentLog(limit int) ([]LogEntry, error)
+}
+
+// Markers wrapped around matched terms in snippets. They cannot appear in
+// form input, so they survive HTML escaping and can be swapped for tags.
+const (
+	MatchStart = "\x01"
+	MatchEnd   = "\x02"
+)
+
+// SearchQuery is a search of posts and comments for every word of Text.
+// Empty and zero fields do not filter.
+type SearchQuery struct {
+	Text     string
+	Category string
+	Author   string    // Username
+	From     time.Time // Inclusive
+	Before   time.Time // Exclusive
+	Limit    int
+}
+
+// SearchResult is a post or comment that matched a search.
+type SearchResult struct {
+	Kind      string // "post" or "comment"
+	PostID    int
+	CommentID int
+	PostTitle string
+	Author    string
+	CreatedAt time.Time
+	Snippet   string // Matched terms are between MatchStart and MatchEnd
+}
+
+// SearchStore finds posts and comments by their text. Content hidden by
+// moderators is never found.
+type SearchStore interface {
+	// Search returns the best matches first. A query without words finds
+	// nothing.
+	Search(q SearchQuery) ([]SearchResult, error)
+}
+
+// RenderStore caches the HTML rendered from the Markdown of posts and
+// comments. It is the utils.RenderCache that utils.RenderContent uses.
+type RenderStore interface {
+	// UserIDs maps the usernames that belong to an account to its ID.
+	UserIDs(usernames []string) (map[string]string, error)
+	// Rendered returns the HTML cached for a post or comment, with ok false
+	// unless it was made from revision.
+	Rendered(kind string, id int, revision string) (html string, ok bool, err error)
+	SaveRendered(kind string, id int, revision, html string) error
+}
+
+// TokenStore issues the single-use tokens sent by email, whose purposes are
+// utils.TokenVerifyEmail and utils.TokenResetPassword.
+type TokenStore interface {
+	// Issue creates a token for a user that expires after ttl. Any earlier
+	// unused token with the same purpose stops working.
+	Issue(userID, purpose string, ttl time.Duration) (string, error)
+	// Check returns the user a token belongs to without using it up, or
+	// utils.ErrInvalidToken.
+	Check(token, purpose string) (string, error)
+	// Consume marks a token as used and returns its user. A token can only
+	// be consumed once.
+	Consume(token, purpose string) (string, error)
+}
+
+// IdentityStore links accounts at sign-in providers to local users. Its
+// errors are those of utils/identities.go.
+type IdentityStore interface {
+	// Find returns the local user linked to a provider account.
+	Find(provider, providerUserID string) (string, error)
+	// Link links a provider account to a user. A provider account can
+	// belong to one user only, and a user can link one account per provider.
+	Link(userID, provider, providerUserID, email string) error
+	// Unlink removes a user's link to a provider, unless it is the only way
+	// a user without a password can sign in.
+	Unlink(userID, provider string) error
+	// Unclaimed returns a passwordless user the provider created before
+	// identities were recorded and that has no account of the provider
+	// linked yet. It matches username and email, leaving out whichever is
+	// empty.
+	Unclaimed(provider, username, email string) (string, error)
+	// CreateUser saves a user signing up through a provider together with
+	// the link to their provider account.
+	CreateUser(user utils.User, identity utils.Identity) error
+}
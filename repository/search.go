@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SearchTerms splits a query into the words a search looks for, dropping
+// FTS operators and punctuation.
+func SearchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '\''
+	})
+}
+
+// Snippet mimics the FTS5 snippet() function for substring matching: it
+// cuts a window of text around the first match and marks every term.
+func Snippet(text string, terms []string) string {
+	const radius = 80
+
+	// Without a match the snippet is the start of the text
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if matchTermAt(text, i, terms) != "" {
+			start = i
+			break
+		}
+	}
+
+	from := start - radius
+	if from < 0 {
+		from = 0
+	}
+	to := start + radius
+	if to > len(text) {
+		to = len(text)
+	}
+	// Avoid cutting multi-byte characters in half
+	for from > 0 && !utf8Start(text[from]) {
+		from--
+	}
+	for to < len(text) && !utf8Start(text[to]) {
+		to++
+	}
+
+	window := text[from:to]
+	var b strings.Builder
+	for i := 0; i < len(window); {
+		if matched := matchTermAt(window, i, terms); matched != "" {
+			b.WriteString(MatchStart + matched + MatchEnd)
+			i += len(matched)
+			continue
+		}
+		b.WriteByte(window[i])
+		i++
+	}
+
+	snippet := b.String()
+	if from > 0 {
+		snippet = "…" + snippet
+	}
+	if to < len(text) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// PostSnippet makes the snippet of a post from its content, or from its
+// title when only the title matched.
+func PostSnippet(title, content string, terms []string) string {
+	snippet := Snippet(content, terms)
+	if !strings.Contains(snippet, MatchStart) {
+		snippet = Snippet(title, terms)
+	}
+	return snippet
+}
+
+// matchTermAt returns the longest term found case-insensitively at text[i:].
+func matchTermAt(text string, i int, terms []string) string {
+	matched := ""
+	for _, term := range terms {
+		end := i + len(term)
+		if end <= len(text) && len(term) > len(matched) && strings.EqualFold(text[i:end], term) {
+			matched = text[i:end]
+		}
+	}
+	return matched
+}
+
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
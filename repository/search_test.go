@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFtsQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"golang", `"golang"*`},
+		{"go  routines", `"go"* "routines"*`},
+		{`title:x OR "y" NEAR(z)`, `"title"* "x"* "OR"* "y"* "NEAR"* "z"*`},
+		{"it's", `"it's"*`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := ftsQuery(SearchTerms(tt.query)); got != tt.want {
+				t.Errorf("ftsQuery(%q) = %s, want %s", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	text := strings.Repeat("lorem ", 40) + "Golang rocks " + strings.Repeat("ipsum ", 40)
+	got := Snippet(text, []string{"golang"})
+
+	if !strings.Contains(got, MatchStart+"Golang"+MatchEnd) {
+		t.Errorf("Snippet() = %q, want the match to be marked", got)
+	}
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Errorf("Snippet() = %q, want ellipses on both sides", got)
+	}
+
+	// A match at the very start, with another far past the window
+	text = "Golang " + strings.Repeat("lorem ", 40) + "golang"
+	got = Snippet(text, []string{"golang"})
+	if !strings.HasPrefix(got, MatchStart+"Golang"+MatchEnd) || !strings.HasSuffix(got, "…") {
+		t.Errorf("Snippet() = %q, want the window at the first match", got)
+	}
+}
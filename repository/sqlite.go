@@ -0,0 +1,46 @@
+package repository
+
+import "database/sql"
+
+// NewSQLite returns stores backed by db, which must have the forum's schema.
+func NewSQLite(db *sql.DB) *Stores {
+	return &Stores{
+		Posts:         &sqlitePosts{db: db},
+		Comments:      &sqliteComments{db: db},
+		Users:         &sqliteUsers{db: db},
+		Sessions:      &sqliteSessions{db: db},
+		Categories:    &sqliteCategories{db: db},
+		Notifications: &sqliteNotifications{db: db},
+		Moderation:    &sqliteModeration{db: db},
+		Search:        &sqliteSearch{db: db},
+		Renders:       &sqliteRenders{db: db},
+		Tokens:        &sqliteTokens{db: db},
+		Identities:    &sqliteIdentities{db: db},
+	}
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// notFound turns sql.ErrNoRows into ErrNotFound.
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return err
+}
+
+// requireRow returns ErrNotFound if an update or delete matched no rows.
+func requireRow(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
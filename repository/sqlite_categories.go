@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"forum/utils"
+)
+
+type sqliteCategories struct {
+	db *sql.DB
+}
+
+func (s *sqliteCategories) List() ([]utils.Category, error) {
+	rows, err := s.db.Query("SELECT id, name FROM categories ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []utils.Category
+	for rows.Next() {
+		var category utils.Category
+		if err := rows.Scan(&category.ID, &category.Name); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+func (s *sqliteCategories) IDByName(name string) (int, error) {
+	var id int
+	err := s.db.QueryRow("SELECT id FROM categories WHERE name = ?", name).Scan(&id)
+	return id, notFound(err)
+}
+
+func (s *sqliteCategories) Create(name string) error {
+	_, err := s.db.Exec("INSERT INTO categories (name) VALUES (?)", name)
+	return err
+}
+
+func (s *sqliteCategories) Rename(id int, name string) error {
+	return requireRow(s.db.Exec("UPDATE categories SET name = ? WHERE id = ?", name, id))
+}
+
+func (s *sqliteCategories) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM post_categories WHERE category_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteCategories) Merge(fromID, intoID int) error {
+	if fromID == intoID {
+		return fmt.Errorf("cannot merge a category into itself")
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.QueryRow("SELECT COUNT(*) FROM categories WHERE id IN (?, ?)", fromID, intoID).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != 2 {
+		return ErrNotFound
+	}
+	// Posts already in both categories keep the one row
+	if _, err := tx.Exec(`
+        INSERT OR IGNORE INTO post_categories (post_id, category_id)
+        SELECT post_id, ? FROM post_categories WHERE category_id = ?
+    `, intoID, fromID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM post_categories WHERE category_id = ?", fromID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = ?", fromID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
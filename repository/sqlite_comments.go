@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"forum/utils"
+)
+
+type sqliteComments struct {
+	db *sql.DB
+}
+
+// Create also counts the comment on its post.
+func (s *sqliteComments) Create(comment utils.Comment) (int, error) {
+	var parentID sql.NullInt64
+	if comment.ParentID != 0 {
+		parentID = sql.NullInt64{Int64: int64(comment.ParentID), Valid: true}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        INSERT INTO comments (post_id, user_id, content, parent_id)
+        VALUES (?, ?, ?, ?)`,
+		comment.PostID, comment.UserID, comment.Content, parentID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE posts SET comments = comments + 1 WHERE id = ?", comment.PostID); err != nil {
+		return 0, fmt.Errorf("updating comment count: %v", err)
+	}
+	return int(id), tx.Commit()
+}
+
+func (s *sqliteComments) Get(id int) (utils.Comment, error) {
+	var c utils.Comment
+	err := s.db.QueryRow(`
+        SELECT c.id, c.post_id, COALESCE(c.parent_id, 0), c.user_id, c.content, c.comment_at,
+               COALESCE(u.username, ''), u.profile_pic, c.likes, c.dislikes, c.hidden_at IS NOT NULL
+        FROM comments c
+        LEFT JOIN users u ON c.user_id = u.id
+        WHERE c.id = ?
+    `, id).Scan(&c.ID, &c.PostID, &c.ParentID, &c.UserID, &c.Content, &c.CommentTime,
+		&c.Username, &c.ProfilePic, &c.Likes, &c.Dislikes, &c.Hidden)
+	return c, notFound(err)
+}
+
+func (s *sqliteComments) ListByPost(postID int) ([]utils.Comment, error) {
+	rows, err := s.db.Query(`
+	  SELECT c.id, COALESCE(c.parent_id, 0), c.user_id, c.content, c.comment_at, u.username, u.profile_pic,
+	         (SELECT COUNT(*) FROM comment_reaction WHERE comment_id = c.id AND is_like = 1) as likes,
+	         (SELECT COUNT(*) FROM comment_reaction WHERE comment_id = c.id AND is_like = 0) as dislikes,
+	         c.hidden_at IS NOT NULL
+	  FROM comments c
+	  JOIN users u ON c.user_id = u.id
+	  WHERE c.post_id = ?
+	  ORDER BY c.comment_at DESC`, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []utils.Comment
+	for rows.Next() {
+		var c utils.Comment
+		err := rows.Scan(&c.ID, &c.ParentID, &c.UserID, &c.Content, &c.CommentTime, &c.Username, &c.ProfilePic, &c.Likes, &c.Dislikes, &c.Hidden)
+		if err != nil {
+			return nil, err
+		}
+		c.PostID = postID
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func (s *sqliteComments) Update(id int, content string) error {
+	return requireRow(s.db.Exec("UPDATE comments SET content = ? WHERE id = ?", content, id))
+}
+
+// Delete removes the comment's reactions, mentions and reports with it and
+// recounts the comments of its post.
+func (s *sqliteComments) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteComment(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteComment(tx *sql.Tx, id int) error {
+	var postID int
+	if err := tx.QueryRow("SELECT post_id FROM comments WHERE id = ?", id).Scan(&postID); err != nil {
+		return notFound(err)
+	}
+
+	_, err := tx.Exec(`
+        UPDATE comments
+        SET parent_id = (SELECT parent_id FROM comments WHERE id = ?)
+        WHERE parent_id = ?`, id, id)
+	if err != nil {
+		return fmt.Errorf("re-parenting replies: %v", err)
+	}
+
+	statements := []string{
+		"DELETE FROM comment_reaction WHERE comment_id = ?",
+		"DELETE FROM mentions WHERE comment_id = ?",
+		"DELETE FROM rendered_content WHERE kind = 'comment' AND content_id = ?",
+		"DELETE FROM reports WHERE comment_id = ?",
+		"DELETE FROM comments WHERE id = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return fmt.Errorf("%s: %v", stmt, err)
+		}
+	}
+
+	_, err = tx.Exec(`
+        UPDATE posts
+        SET comments = (SELECT COUNT(*) FROM comments WHERE post_id = ?)
+        WHERE id = ?`, postID, postID)
+	if err != nil {
+		return fmt.Errorf("updating comment count: %v", err)
+	}
+	return nil
+}
+
+func (s *sqliteComments) Depth(id, limit int) (int, error) {
+	var depth sql.NullInt64
+	err := s.db.QueryRow(`
+        WITH RECURSIVE ancestors(id, parent_id, depth) AS (
+            SELECT id, parent_id, 0 FROM comments WHERE id = ?
+            UNION ALL
+            SELECT c.id, c.parent_id, a.depth + 1
+            FROM comments c JOIN ancestors a ON c.id = a.parent_id
+            WHERE a.depth < ?
+        )
+        SELECT MAX(depth) FROM ancestors
+    `, id, limit).Scan(&depth)
+	if err != nil {
+		return 0, err
+	}
+	if !depth.Valid {
+		return 0, ErrNotFound
+	}
+	return int(depth.Int64), nil
+}
+
+// React leaves the counts to the comment reaction triggers.
+func (s *sqliteComments) React(userID string, commentID, like int) (int, int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRow("SELECT is_like FROM comment_reaction WHERE user_id = ? AND comment_id = ?", userID, commentID).Scan(&existing)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec("INSERT INTO comment_reaction (user_id, comment_id, is_like) VALUES (?, ?, ?)", userID, commentID, like)
+	case err != nil:
+	case existing == like:
+		// Taking the reaction back
+		_, err = tx.Exec("DELETE FROM comment_reaction WHERE user_id = ? AND comment_id = ?", userID, commentID)
+	default:
+		_, err = tx.Exec("UPDATE comment_reaction SET is_like = ? WHERE user_id = ? AND comment_id = ?", like, userID, commentID)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var likes, dislikes int
+	err = tx.QueryRow("SELECT likes, dislikes FROM comments WHERE id = ?", commentID).Scan(&likes, &dislikes)
+	if err != nil {
+		return 0, 0, notFound(err)
+	}
+	return likes, dislikes, tx.Commit()
+}
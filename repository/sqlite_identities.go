@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"forum/utils"
+)
+
+type sqliteTokens struct {
+	db *sql.DB
+}
+
+func (s *sqliteTokens) Issue(userID, purpose string, ttl time.Duration) (string, error) {
+	return utils.IssueToken(s.db, userID, purpose, ttl)
+}
+
+func (s *sqliteTokens) Check(token, purpose string) (string, error) {
+	return utils.CheckToken(s.db, token, purpose)
+}
+
+func (s *sqliteTokens) Consume(token, purpose string) (string, error) {
+	return utils.ConsumeToken(s.db, token, purpose)
+}
+
+type sqliteIdentities struct {
+	db *sql.DB
+}
+
+func (s *sqliteIdentities) Find(provider, providerUserID string) (string, error) {
+	return utils.FindIdentity(s.db, provider, providerUserID)
+}
+
+func (s *sqliteIdentities) Link(userID, provider, providerUserID, email string) error {
+	return utils.LinkIdentity(s.db, userID, provider, providerUserID, email)
+}
+
+func (s *sqliteIdentities) Unlink(userID, provider string) error {
+	return utils.UnlinkIdentity(s.db, userID, provider)
+}
+
+// Unclaimed relies on the authoriser column, which records the provider
+// that created an account.
+func (s *sqliteIdentities) Unclaimed(provider, username, email string) (string, error) {
+	if username == "" && email == "" {
+		return "", utils.ErrIdentityNotFound
+	}
+	var conditions []string
+	var args []interface{}
+	if username != "" {
+		conditions, args = append(conditions, "username = ?"), append(args, username)
+	}
+	if email != "" {
+		conditions, args = append(conditions, "email = ?"), append(args, email)
+	}
+
+	var userID string
+	err := s.db.QueryRow(`
+        SELECT id FROM users
+        WHERE `+strings.Join(conditions, " AND ")+` AND authoriser = ? AND COALESCE(password, '') = ''
+          AND NOT EXISTS (SELECT 1 FROM user_identities i WHERE i.user_id = users.id AND i.provider = ?)
+    `, append(args, provider, provider)...).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", utils.ErrIdentityNotFound
+	}
+	return userID, err
+}
+
+func (s *sqliteIdentities) CreateUser(user utils.User, identity utils.Identity) error {
+	var email, verifiedAt interface{}
+	if user.Email != "" {
+		email = user.Email
+	}
+	if user.EmailVerified {
+		verifiedAt = time.Now()
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        INSERT INTO users (id, username, email, authoriser, profile_pic, email_verified_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, user.ID, user.UserName, email, identity.Provider, user.ProfilePic, verifiedAt)
+	if err != nil {
+		return err
+	}
+	var identityEmail interface{}
+	if identity.Email != "" {
+		identityEmail = identity.Email
+	}
+	_, err = tx.Exec(`
+        INSERT INTO user_identities (provider, provider_user_id, user_id, email)
+        VALUES (?, ?, ?, ?)
+    `, identity.Provider, identity.ProviderUserID, user.ID, identityEmail)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
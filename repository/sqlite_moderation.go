@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"forum/utils"
+)
+
+type sqliteModeration struct {
+	db *sql.DB
+}
+
+func (s *sqliteModeration) CreateReport(report Report) error {
+	var comment interface{}
+	if report.CommentID > 0 {
+		comment = report.CommentID
+	}
+	_, err := s.db.Exec(`
+        INSERT INTO reports (reporter_id, post_id, comment_id, reason, details)
+        SELECT ?, ?, ?, ?, ?
+        WHERE NOT EXISTS(
+            SELECT 1 FROM reports
+            WHERE reporter_id = ? AND status = 'open' AND post_id = ? AND COALESCE(comment_id, 0) = ?)
+    `, report.ReporterID, report.PostID, comment, report.Reason, report.Details,
+		report.ReporterID, report.PostID, report.CommentID)
+	return err
+}
+
+func (s *sqliteModeration) GetReport(id int) (Report, error) {
+	report := Report{ID: id}
+	err := s.db.QueryRow(`
+        SELECT reporter_id, post_id, COALESCE(comment_id, 0), reason, details, status, created_at
+        FROM reports WHERE id = ?
+    `, id).Scan(&report.ReporterID, &report.PostID, &report.CommentID, &report.Reason, &report.Details, &report.Status, &report.CreatedAt)
+	return report, notFound(err)
+}
+
+func (s *sqliteModeration) OpenReports(limit int) ([]QueuedReport, error) {
+	rows, err := s.db.Query(`
+        SELECT r.id, r.reporter_id, r.post_id, COALESCE(r.comment_id, 0), r.reason, r.details, r.status, r.created_at,
+               rep.username,
+               COALESCE(au.username, ''),
+               COALESCE(CASE WHEN r.comment_id IS NULL THEN p.title ELSE c.content END, ''),
+               (CASE WHEN r.comment_id IS NULL THEN p.hidden_at ELSE c.hidden_at END) IS NOT NULL,
+               (SELECT COUNT(*) FROM reports o
+                WHERE o.status = 'open' AND o.post_id = r.post_id
+                  AND COALESCE(o.comment_id, 0) = COALESCE(r.comment_id, 0))
+        FROM reports r
+        JOIN users rep ON rep.id = r.reporter_id
+        LEFT JOIN posts p ON p.id = r.post_id
+        LEFT JOIN comments c ON c.id = r.comment_id
+        LEFT JOIN users au ON au.id = CASE WHEN r.comment_id IS NULL THEN p.user_id ELSE c.user_id END
+        WHERE r.status = 'open'
+        ORDER BY r.created_at, r.id
+        LIMIT ?
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []QueuedReport
+	for rows.Next() {
+		var r QueuedReport
+		err := rows.Scan(&r.ID, &r.ReporterID, &r.PostID, &r.CommentID, &r.Reason, &r.Details, &r.Status, &r.CreatedAt,
+			&r.ReporterName, &r.AuthorName, &r.Excerpt, &r.Hidden, &r.OpenReports)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// Resolve claims the report first, so a second moderator resolving it at the
+// same time gets ErrReportClosed and changes nothing.
+func (s *sqliteModeration) Resolve(entry utils.ModerationEntry, suspendUntil time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	status := "actioned"
+	if entry.Action == utils.ModDismiss {
+		status = "dismissed"
+	}
+	err = requireRow(tx.Exec(`
+        UPDATE reports SET status = ?, resolved_by = ?, resolved_at = CURRENT_TIMESTAMP
+        WHERE id = ? AND status = 'open'`, status, entry.ModeratorID, entry.ReportID))
+	if err == ErrNotFound {
+		return ErrReportClosed
+	} else if err != nil {
+		return err
+	}
+
+	table, contentID := "posts", entry.PostID
+	if entry.CommentID > 0 {
+		table, contentID = "comments", entry.CommentID
+	}
+	switch entry.Action {
+	case utils.ModDismiss:
+	case utils.ModDelete:
+		// Deleting the content also deletes its reports
+		if entry.CommentID > 0 {
+			err = deleteComment(tx, entry.CommentID)
+		} else {
+			err = deletePost(tx, entry.PostID)
+		}
+	case utils.ModHide:
+		err = requireRow(tx.Exec("UPDATE "+table+" SET hidden_at = CURRENT_TIMESTAMP WHERE id = ?", contentID))
+	case utils.ModWarn:
+		_, err = tx.Exec(`
+            INSERT INTO notifications (user_id, actor_id, post_id, type)
+            VALUES (?, ?, ?, 'warning')`, entry.TargetUserID, entry.ModeratorID, entry.PostID)
+	case utils.ModSuspend:
+		err = requireRow(tx.Exec("UPDATE users SET suspended_until = ? WHERE id = ?", suspendUntil, entry.TargetUserID))
+		if err == nil {
+			_, err = tx.Exec("DELETE FROM sessions WHERE user_id = ?", entry.TargetUserID)
+		}
+	default:
+		return fmt.Errorf("unknown moderation action %q", entry.Action)
+	}
+	if err != nil {
+		return err
+	}
+
+	if entry.Action != utils.ModDismiss {
+		_, err = tx.Exec(`
+            UPDATE reports SET status = 'actioned', resolved_by = ?, resolved_at = CURRENT_TIMESTAMP
+            WHERE status = 'open' AND post_id = ? AND COALESCE(comment_id, 0) = ?`,
+			entry.ModeratorID, entry.PostID, entry.CommentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := utils.LogModeration(tx, entry); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteModeration) Log(entry utils.ModerationEntry) error {
+	return utils.LogModeration(s.db, entry)
+}
+
+func (s *sqliteModeration) RecentLog(limit int) ([]LogEntry, error) {
+	rows, err := s.db.Query(`
+        SELECT l.action, COALESCE(m.username, l.moderator_id), COALESCE(t.username, ''),
+               COALESCE(l.post_id, 0), COALESCE(l.comment_id, 0), COALESCE(l.report_id, 0),
+               l.note, l.created_at
+        FROM moderation_log l
+        LEFT JOIN users m ON m.id = l.moderator_id
+        LEFT JOIN users t ON t.id = l.target_user_id
+        ORDER BY l.id DESC
+        LIMIT ?
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []LogEntry
+	for rows.Next() {
+		var e LogEntry
+		if err := rows.Scan(&e.Action, &e.ModeratorName, &e.TargetName, &e.PostID, &e.CommentID, &e.ReportID, &e.Note, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"database/sql"
+
+	"forum/utils"
+)
+
+type sqliteNotifications struct {
+	db *sql.DB
+}
+
+func (s *sqliteNotifications) List(userID string) ([]utils.Notification, error) {
+	return utils.ListNotifications(s.db, userID)
+}
+
+func (s *sqliteNotifications) Group(userID string, notificationID int) (utils.Notification, error) {
+	group, err := utils.GetNotificationGroup(s.db, userID, notificationID)
+	return group, notFound(err)
+}
+
+func (s *sqliteNotifications) Since(userID string, afterID int) ([]int, error) {
+	rows, err := s.db.Query(`
+        SELECT id FROM notifications
+        WHERE user_id = ? AND delivery = ? AND id > ?
+        ORDER BY id
+    `, userID, utils.DeliveryInApp, afterID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *sqliteNotifications) CountUnread(userID string) (int, error) {
+	return utils.CountUnreadNotifications(s.db, userID)
+}
+
+func (s *sqliteNotifications) MarkRead(userID string, notificationID int) (bool, error) {
+	return utils.MarkNotificationRead(s.db, userID, notificationID)
+}
+
+func (s *sqliteNotifications) MarkAllRead(userID string) error {
+	return utils.MarkAllNotificationsRead(s.db, userID)
+}
+
+func (s *sqliteNotifications) Preferences(userID string) (map[string]string, error) {
+	return utils.GetNotificationPreferences(s.db, userID)
+}
+
+func (s *sqliteNotifications) SetPreference(userID, notificationType, delivery string) error {
+	return utils.SetNotificationPreference(s.db, userID, notificationType, delivery)
+}
+
+func (s *sqliteNotifications) RecordMentions(actorID string, postID, commentID int, text string) error {
+	return utils.RecordMentions(s.db, actorID, postID, commentID, text)
+}
+
+func (s *sqliteNotifications) DigestRecipients() ([]utils.DigestRecipient, error) {
+	return utils.ListDigestRecipients(s.db)
+}
+
+func (s *sqliteNotifications) PendingDigest(userID string) ([]utils.Notification, error) {
+	return utils.PendingDigest(s.db, userID)
+}
+
+func (s *sqliteNotifications) MarkDigestSent(userID string, upToID int) error {
+	return utils.MarkDigestSent(s.db, userID, upToID)
+}
@@ -0,0 +1,359 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"forum/utils"
+)
+
+type sqlitePosts struct {
+	db *sql.DB
+}
+
+// sqliteTime formats t the way julianday() parses it.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
+
+func (s *sqlitePosts) Create(post utils.Post, categories []string) (int, error) {
+	if post.PostedAt.IsZero() {
+		post.PostedAt = time.Now()
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        INSERT INTO posts (user_id, title, content, imagepath, post_at)
+        VALUES (?, ?, ?, ?, ?)
+    `, post.UserID, post.Title, post.Content, post.ImagePath, post.PostedAt)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := setPostCategories(tx, int(id), categories); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// setPostCategories files a post under the named categories, skipping
+// names that are not categories.
+func setPostCategories(tx *sql.Tx, postID int, categories []string) error {
+	for _, name := range categories {
+		_, err := tx.Exec(`
+            INSERT OR IGNORE INTO post_categories (post_id, category_id)
+            SELECT ?, id FROM categories WHERE name = ?
+        `, postID, name)
+		if err != nil {
+			return fmt.Errorf("saving post category: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlitePosts) Get(id int) (*utils.Post, error) {
+	var post utils.Post
+	var updatedAt sql.NullTime
+	err := s.db.QueryRow(`
+        SELECT p.id, p.user_id, p.title, p.content, COALESCE(p.imagepath, ''),
+               p.post_at, p.likes, p.dislikes, p.comments,
+               u.username, u.profile_pic, p.updated_at, p.hidden_at IS NOT NULL
+        FROM posts p
+        JOIN users u ON p.user_id = u.id
+        WHERE p.id = ?
+    `, id).Scan(
+		&post.ID,
+		&post.UserID,
+		&post.Title,
+		&post.Content,
+		&post.ImagePath,
+		&post.PostedAt,
+		&post.Likes,
+		&post.Dislikes,
+		&post.Comments,
+		&post.Username,
+		&post.ProfilePic,
+		&updatedAt,
+		&post.Hidden,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	post.UpdatedAt = updatedAt.Time
+	return &post, nil
+}
+
+// Feed leaves out hidden posts. Scores are computed in SQL so that the
+// cursor condition and the order use the same values.
+func (s *sqlitePosts) Feed(q FeedQuery) ([]ScoredPost, error) {
+	asOf := sqliteTime(q.AsOf)
+
+	var score string
+	var args []interface{}
+	switch q.Sort {
+	case "top":
+		score = "CAST(p.likes - p.dislikes AS REAL)"
+	case "hot":
+		// Net votes divided by the square of the age in hours, so a post
+		// needs ever more votes to stay near the top as it gets older.
+		score = `CAST(p.likes - p.dislikes AS REAL) /
+                 (((julianday(?) - julianday(p.post_at)) * 24 + 2) *
+                  ((julianday(?) - julianday(p.post_at)) * 24 + 2))`
+		args = append(args, asOf, asOf)
+	default:
+		score = "0.0"
+	}
+
+	where := []string{"julianday(p.post_at) <= julianday(?)", "p.hidden_at IS NULL"}
+	args = append(args, asOf)
+
+	if !q.Since.IsZero() {
+		where = append(where, "julianday(p.post_at) >= julianday(?)")
+		args = append(args, sqliteTime(q.Since))
+	}
+	if q.Filter.CategoryName != "" {
+		where = append(where, `EXISTS (
+            SELECT 1 FROM post_categories pc JOIN categories c ON pc.category_id = c.id
+            WHERE pc.post_id = p.id AND c.name = ?)`)
+		args = append(args, q.Filter.CategoryName)
+	}
+	if q.Filter.AuthorID != "" {
+		where = append(where, "p.user_id = ?")
+		args = append(args, q.Filter.AuthorID)
+	}
+	if q.Filter.ReactedBy != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM reaction r WHERE r.post_id = p.id AND r.user_id = ?)")
+		args = append(args, q.Filter.ReactedBy)
+	}
+	if len(q.Exclude) > 0 {
+		where = append(where, "p.id NOT IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(q.Exclude)), ", ")+")")
+		for _, id := range q.Exclude {
+			args = append(args, id)
+		}
+	}
+
+	query := `
+        SELECT * FROM (
+            SELECT p.id, p.user_id, p.title, p.content, COALESCE(p.imagepath, ''),
+                   p.post_at, p.likes, p.dislikes, p.comments,
+                   u.username, u.profile_pic, ` + score + ` AS score
+            FROM posts p
+            JOIN users u ON p.user_id = u.id
+            WHERE ` + strings.Join(where, " AND ") + `
+        )`
+	if q.After != nil {
+		query += `
+        WHERE score < ? OR (score = ? AND id < ?)`
+		args = append(args, q.After.Score, q.After.Score, q.After.ID)
+	}
+	query += `
+        ORDER BY score DESC, id DESC
+        LIMIT ?`
+	args = append(args, q.Limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []ScoredPost
+	for rows.Next() {
+		var post ScoredPost
+		if err := rows.Scan(
+			&post.ID,
+			&post.UserID,
+			&post.Title,
+			&post.Content,
+			&post.ImagePath,
+			&post.PostedAt,
+			&post.Likes,
+			&post.Dislikes,
+			&post.Comments,
+			&post.Username,
+			&post.ProfilePic,
+			&post.Score,
+		); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func (s *sqlitePosts) Categories(postID int) ([]string, error) {
+	return postCategoryNames(s.db, postID)
+}
+
+// postCategoryNames is Categories for use inside a transaction too.
+func postCategoryNames(db querier, postID int) ([]string, error) {
+	rows, err := db.Query(`
+        SELECT c.name
+        FROM post_categories pc
+        JOIN categories c ON pc.category_id = c.id
+        WHERE pc.post_id = ?
+        ORDER BY c.name
+    `, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (s *sqlitePosts) Update(edit PostEdit) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldTitle, oldContent, oldImagePath string
+	err = tx.QueryRow("SELECT title, content, COALESCE(imagepath, '') FROM posts WHERE id = ?", edit.PostID).
+		Scan(&oldTitle, &oldContent, &oldImagePath)
+	if err != nil {
+		return notFound(err)
+	}
+	oldCats, err := postCategoryNames(tx, edit.PostID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.Exec(`
+        INSERT INTO post_revisions (post_id, editor_id, title, content, imagepath, categories, revised_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, edit.PostID, edit.EditorID, oldTitle, oldContent, oldImagePath, strings.Join(oldCats, ", "), now)
+	if err != nil {
+		return fmt.Errorf("saving post revision: %v", err)
+	}
+
+	_, err = tx.Exec(`
+        UPDATE posts SET title = ?, content = ?, imagepath = ?, updated_at = ?
+        WHERE id = ?
+    `, edit.Title, edit.Content, edit.ImagePath, now, edit.PostID)
+	if err != nil {
+		return fmt.Errorf("updating post: %v", err)
+	}
+
+	if _, err = tx.Exec("DELETE FROM post_categories WHERE post_id = ?", edit.PostID); err != nil {
+		return fmt.Errorf("clearing post categories: %v", err)
+	}
+	if err := setPostCategories(tx, edit.PostID, edit.Categories); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlitePosts) Revisions(postID int) ([]Revision, error) {
+	rows, err := s.db.Query(`
+        SELECT r.title, r.content, COALESCE(r.imagepath, ''), COALESCE(r.categories, ''),
+               r.revised_at, u.username
+        FROM post_revisions r
+        JOIN users u ON r.editor_id = u.id
+        WHERE r.post_id = ?
+        ORDER BY r.id ASC
+    `, postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var rev Revision
+		if err := rows.Scan(&rev.Title, &rev.Content, &rev.ImagePath, &rev.Categories, &rev.RevisedAt, &rev.EditorName); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+// Delete removes the dependent rows explicitly in one transaction. The
+// connection does not enable foreign keys, so ON DELETE CASCADE never fires.
+func (s *sqlitePosts) Delete(id int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deletePost(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deletePost(tx *sql.Tx, id int) error {
+	statements := []string{
+		"DELETE FROM comment_reaction WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM rendered_content WHERE kind = 'comment' AND content_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM comments WHERE post_id = ?",
+		"DELETE FROM reaction WHERE post_id = ?",
+		"DELETE FROM notifications WHERE post_id = ?",
+		"DELETE FROM mentions WHERE post_id = ?",
+		"DELETE FROM post_categories WHERE post_id = ?",
+		"DELETE FROM post_revisions WHERE post_id = ?",
+		"DELETE FROM reports WHERE post_id = ?",
+		"DELETE FROM rendered_content WHERE kind = 'post' AND content_id = ?",
+		"DELETE FROM posts WHERE id = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return fmt.Errorf("%s: %v", stmt, err)
+		}
+	}
+	return nil
+}
+
+// React leaves the counts to the reaction triggers.
+func (s *sqlitePosts) React(userID string, postID, like int) (int, int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var existing int
+	err = tx.QueryRow("SELECT like FROM reaction WHERE user_id = ? AND post_id = ?", userID, postID).Scan(&existing)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec("INSERT INTO reaction (user_id, post_id, like) VALUES (?, ?, ?)", userID, postID, like)
+	case err != nil:
+	case existing == like:
+		// Taking the reaction back
+		_, err = tx.Exec("DELETE FROM reaction WHERE user_id = ? AND post_id = ?", userID, postID)
+	default:
+		_, err = tx.Exec("UPDATE reaction SET like = ? WHERE user_id = ? AND post_id = ?", like, userID, postID)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var likes, dislikes int
+	err = tx.QueryRow("SELECT likes, dislikes FROM posts WHERE id = ?", postID).Scan(&likes, &dislikes)
+	if err != nil {
+		return 0, 0, notFound(err)
+	}
+	return likes, dislikes, tx.Commit()
+}
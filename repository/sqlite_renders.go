@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"database/sql"
+
+	"forum/utils"
+)
+
+type sqliteRenders struct {
+	db *sql.DB
+}
+
+func (s *sqliteRenders) UserIDs(usernames []string) (map[string]string, error) {
+	return utils.LookupUsernames(s.db, usernames)
+}
+
+func (s *sqliteRenders) Rendered(kind string, id int, revision string) (string, bool, error) {
+	var html string
+	err := s.db.QueryRow("SELECT html FROM rendered_content WHERE kind = ? AND content_id = ? AND revision = ?",
+		kind, id, revision).Scan(&html)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return html, err == nil, err
+}
+
+func (s *sqliteRenders) SaveRendered(kind string, id int, revision, html string) error {
+	_, err := s.db.Exec(`
+        INSERT INTO rendered_content (kind, content_id, revision, html) VALUES (?, ?, ?, ?)
+        ON CONFLICT (kind, content_id) DO UPDATE
+        SET revision = excluded.revision, html = excluded.html, rendered_at = CURRENT_TIMESTAMP
+    `, kind, id, revision, html)
+	return err
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"forum/utils"
+)
+
+type sqliteSearch struct {
+	db *sql.DB
+}
+
+// Search ranks with bm25 when FTS5 is available, weighting title matches
+// above content. Without it the search degrades to substring matching
+// ordered by recency.
+func (s *sqliteSearch) Search(q SearchQuery) ([]SearchResult, error) {
+	terms := SearchTerms(q.Text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var postWhere, commentWhere []string
+	var postArgs, commentArgs []interface{}
+
+	if utils.FullTextSearch {
+		match := ftsQuery(terms)
+		postWhere = append(postWhere, "posts_fts MATCH ?")
+		postArgs = append(postArgs, match)
+		commentWhere = append(commentWhere, "comments_fts MATCH ?")
+		commentArgs = append(commentArgs, match)
+	} else {
+		for _, term := range terms {
+			pattern := "%" + escapeLike(term) + "%"
+			postWhere = append(postWhere, `(p.title LIKE ? ESCAPE '\' OR p.content LIKE ? ESCAPE '\')`)
+			postArgs = append(postArgs, pattern, pattern)
+			commentWhere = append(commentWhere, `c.content LIKE ? ESCAPE '\'`)
+			commentArgs = append(commentArgs, pattern)
+		}
+	}
+
+	// Content hidden by moderators never shows up in search
+	postWhere = append(postWhere, "p.hidden_at IS NULL")
+	commentWhere = append(commentWhere, "c.hidden_at IS NULL", "p.hidden_at IS NULL")
+
+	if q.Category != "" {
+		inCategory := `EXISTS (
+            SELECT 1 FROM post_categories pc JOIN categories cat ON pc.category_id = cat.id
+            WHERE pc.post_id = p.id AND cat.name = ?)`
+		postWhere = append(postWhere, inCategory)
+		postArgs = append(postArgs, q.Category)
+		commentWhere = append(commentWhere, inCategory)
+		commentArgs = append(commentArgs, q.Category)
+	}
+
+	if q.Author != "" {
+		postWhere = append(postWhere, "u.username = ?")
+		postArgs = append(postArgs, q.Author)
+		commentWhere = append(commentWhere, "u.username = ?")
+		commentArgs = append(commentArgs, q.Author)
+	}
+
+	if !q.From.IsZero() {
+		postWhere = append(postWhere, "julianday(p.post_at) >= julianday(?)")
+		postArgs = append(postArgs, sqliteTime(q.From))
+		commentWhere = append(commentWhere, "julianday(c.comment_at) >= julianday(?)")
+		commentArgs = append(commentArgs, sqliteTime(q.From))
+	}
+
+	if !q.Before.IsZero() {
+		postWhere = append(postWhere, "julianday(p.post_at) < julianday(?)")
+		postArgs = append(postArgs, sqliteTime(q.Before))
+		commentWhere = append(commentWhere, "julianday(c.comment_at) < julianday(?)")
+		commentArgs = append(commentArgs, sqliteTime(q.Before))
+	}
+
+	var postSelect, commentSelect, orderBy string
+	if utils.FullTextSearch {
+		postSelect = `
+        SELECT 'post', p.id, 0, p.title, u.username, p.post_at,
+               snippet(posts_fts, -1, char(1), char(2), '…', 16),
+               bm25(posts_fts, 10.0, 1.0) AS rank
+        FROM posts_fts
+        JOIN posts p ON p.id = posts_fts.rowid
+        JOIN users u ON p.user_id = u.id`
+		commentSelect = `
+        SELECT 'comment', p.id, c.id, p.title, u.username, c.comment_at,
+               snippet(comments_fts, 0, char(1), char(2), '…', 16),
+               bm25(comments_fts) AS rank
+        FROM comments_fts
+        JOIN comments c ON c.id = comments_fts.rowid
+        JOIN posts p ON p.id = c.post_id
+        JOIN users u ON c.user_id = u.id`
+		orderBy = "ORDER BY rank ASC"
+	} else {
+		postSelect = `
+        SELECT 'post', p.id, 0, p.title, u.username, p.post_at, p.content, 0 AS rank
+        FROM posts p
+        JOIN users u ON p.user_id = u.id`
+		commentSelect = `
+        SELECT 'comment', p.id, c.id, p.title, u.username, c.comment_at, c.content, 0 AS rank
+        FROM comments c
+        JOIN posts p ON p.id = c.post_id
+        JOIN users u ON c.user_id = u.id`
+		orderBy = "ORDER BY 6 DESC"
+	}
+
+	query := postSelect + "\n        WHERE " + strings.Join(postWhere, " AND ") +
+		"\n        UNION ALL" + commentSelect + "\n        WHERE " + strings.Join(commentWhere, " AND ") +
+		"\n        " + orderBy + "\n        LIMIT ?"
+	args := append(postArgs, commentArgs...)
+	args = append(args, q.Limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var res SearchResult
+		var createdAt time.Time
+		var rank float64
+		if err := rows.Scan(&res.Kind, &res.PostID, &res.CommentID, &res.PostTitle, &res.Author, &createdAt, &res.Snippet, &rank); err != nil {
+			return nil, err
+		}
+		res.CreatedAt = createdAt
+		if !utils.FullTextSearch {
+			if res.Kind == "post" {
+				res.Snippet = PostSnippet(res.PostTitle, res.Snippet, terms)
+			} else {
+				res.Snippet = Snippet(res.Snippet, terms)
+			}
+		}
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// ftsQuery builds an FTS5 MATCH expression requiring every term, with prefix
+// matching so partially typed words still find results.
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
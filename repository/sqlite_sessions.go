@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"database/sql"
+
+	"forum/utils"
+)
+
+type sqliteSessions struct {
+	db *sql.DB
+}
+
+func (s *sqliteSessions) Create(userID, userAgent, ip string) (string, error) {
+	return utils.CreateSession(s.db, userID, userAgent, ip)
+}
+
+func (s *sqliteSessions) Validate(token string) (string, error) {
+	return utils.ValidateSession(s.db, token)
+}
+
+func (s *sqliteSessions) List(userID string) ([]utils.Session, error) {
+	return utils.ListSessions(s.db, userID)
+}
+
+func (s *sqliteSessions) CSRFToken(token string) (string, error) {
+	return utils.SessionCSRFToken(s.db, token)
+}
+
+func (s *sqliteSessions) Delete(userID, token string) error {
+	return utils.DeleteSession(s.db, userID, token)
+}
+
+func (s *sqliteSessions) DeleteOthers(userID, keepToken string) (int64, error) {
+	return utils.DeleteOtherSessions(s.db, userID, keepToken)
+}
+
+func (s *sqliteSessions) DeleteAll(userID string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
+
+func (s *sqliteSessions) DeleteExpired() (int64, error) {
+	return utils.DeleteExpiredSessions(s.db)
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"forum/utils"
+)
+
+type sqliteUsers struct {
+	db *sql.DB
+}
+
+// userColumns are read by scanUser. Accounts created through a sign-in
+// provider may have no email address or password.
+const userColumns = `
+    id, username, COALESCE(email, ''), COALESCE(password, ''), profile_pic, role,
+    banned_at IS NOT NULL, suspended_until, email_verified_at IS NOT NULL`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (utils.User, error) {
+	var user utils.User
+	var suspendedUntil sql.NullTime
+	err := row.Scan(&user.ID, &user.UserName, &user.Email, &user.Password, &user.ProfilePic, &user.Role,
+		&user.Banned, &suspendedUntil, &user.EmailVerified)
+	user.SuspendedUntil = suspendedUntil.Time
+	return user, err
+}
+
+func (s *sqliteUsers) getBy(column, value string) (utils.User, error) {
+	user, err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE "+column+" = ?", value))
+	return user, notFound(err)
+}
+
+func (s *sqliteUsers) List() ([]utils.User, error) {
+	rows, err := s.db.Query("SELECT " + userColumns + " FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []utils.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		user.Password = ""
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqliteUsers) Get(id string) (utils.User, error) {
+	user, err := s.getBy("id", id)
+	user.Password = ""
+	return user, err
+}
+
+func (s *sqliteUsers) GetByUsername(username string) (utils.User, error) {
+	return s.getBy("username", username)
+}
+
+func (s *sqliteUsers) GetByEmail(email string) (utils.User, error) {
+	return s.getBy("email", email)
+}
+
+func (s *sqliteUsers) Create(user utils.User) error {
+	if user.Role == "" {
+		user.Role = utils.RoleMember
+	}
+	var email, verifiedAt interface{}
+	if user.Email != "" {
+		email = user.Email
+	}
+	if user.EmailVerified {
+		verifiedAt = time.Now()
+	}
+	_, err := s.db.Exec(`
+        INSERT INTO users (id, email, username, password, role, email_verified_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, user.ID, email, user.UserName, user.Password, user.Role, verifiedAt)
+	return err
+}
+
+func (s *sqliteUsers) SetPassword(id, hash string) error {
+	return requireRow(s.db.Exec("UPDATE users SET password = ? WHERE id = ?", hash, id))
+}
+
+func (s *sqliteUsers) SetProfilePic(id, imagePath string) error {
+	return requireRow(s.db.Exec("UPDATE users SET profile_pic = ? WHERE id = ?", imagePath, id))
+}
+
+func (s *sqliteUsers) Role(id string) (string, error) {
+	return utils.GetUserRole(s.db, id)
+}
+
+func (s *sqliteUsers) SetRole(id, role string) error {
+	return notFound(utils.SetUserRole(s.db, id, role))
+}
+
+func (s *sqliteUsers) Ban(id string) error {
+	return notFound(utils.BanUser(s.db, id))
+}
+
+func (s *sqliteUsers) Suspend(id string, until time.Time) error {
+	return notFound(utils.SuspendUser(s.db, id, until))
+}
+
+func (s *sqliteUsers) Unban(id string) error {
+	return utils.UnbanUser(s.db, id)
+}
+
+func (s *sqliteUsers) EmailVerified(id string) (bool, error) {
+	verified, err := utils.IsEmailVerified(s.db, id)
+	return verified, notFound(err)
+}
+
+func (s *sqliteUsers) MarkEmailVerified(id string) error {
+	return utils.MarkEmailVerified(s.db, id)
+}
+
+func (s *sqliteUsers) Block(blockerID, blockedID string) error {
+	return utils.BlockUser(s.db, blockerID, blockedID)
+}
+
+func (s *sqliteUsers) Unblock(blockerID, blockedID string) error {
+	return utils.UnblockUser(s.db, blockerID, blockedID)
+}
+
+func (s *sqliteUsers) IsBlocked(blockerID, blockedID string) (bool, error) {
+	return utils.IsBlocked(s.db, blockerID, blockedID)
+}
+
+func (s *sqliteUsers) Identities(id string) ([]utils.Identity, error) {
+	return utils.ListIdentities(s.db, id)
+}
@@ -0,0 +1,725 @@
+package repository_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/repository"
+	"forum/repository/memory"
+	"forum/utils"
+)
+
+// forEachStore runs test against the SQLite stores, on a fresh database,
+// and against the memory stores, so both keep the same behaviour.
+func forEachStore(t *testing.T, test func(t *testing.T, stores *repository.Stores)) {
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := sql.Open("sqlite3_forum", filepath.Join(t.TempDir(), "forum.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if _, err := utils.Migrate(db); err != nil {
+			t.Fatalf("Migrate returned error: %v", err)
+		}
+		test(t, repository.NewSQLite(db))
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, memory.New())
+	})
+}
+
+func createUser(t *testing.T, stores *repository.Stores, username string) string {
+	t.Helper()
+	id := utils.GenerateId()
+	err := stores.Users.Create(utils.User{ID: id, UserName: username, Email: username + "@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatalf("Create(%s) returned error: %v", username, err)
+	}
+	return id
+}
+
+func TestCategoryStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		for _, name := range []string{"Gardening", "Cooking"} {
+			if err := stores.Categories.Create(name); err != nil {
+				t.Fatalf("Create(%s) returned error: %v", name, err)
+			}
+		}
+		if err := stores.Categories.Create("Cooking"); err == nil {
+			t.Errorf("Create accepted a duplicate name")
+		}
+
+		gardening, err := stores.Categories.IDByName("Gardening")
+		if err != nil {
+			t.Fatalf("IDByName returned error: %v", err)
+		}
+		for _, name := range []string{"Unknown", ""} {
+			if _, err := stores.Categories.IDByName(name); err != repository.ErrNotFound {
+				t.Errorf("IDByName(%q) returned %v, want ErrNotFound", name, err)
+			}
+		}
+
+		categories, err := stores.Categories.List()
+		if err != nil {
+			t.Fatalf("List returned error: %v", err)
+		}
+		if n := len(categories); n < 2 || categories[n-2].Name != "Gardening" || categories[n-1].Name != "Cooking" {
+			t.Errorf("List = %v, want the new categories last in order", categories)
+		}
+
+		if err := stores.Categories.Rename(gardening, "Allotments"); err != nil {
+			t.Fatalf("Rename returned error: %v", err)
+		}
+		if id, _ := stores.Categories.IDByName("Allotments"); id != gardening {
+			t.Errorf("renamed category has ID %d, want %d", id, gardening)
+		}
+		if err := stores.Categories.Rename(gardening+1000, "Other"); err != repository.ErrNotFound {
+			t.Errorf("Rename of a missing category returned %v, want ErrNotFound", err)
+		}
+
+		authorID := createUser(t, stores, "author")
+		postID, err := stores.Posts.Create(utils.Post{UserID: authorID, Title: "Beds", Content: "Raised"}, []string{"Allotments", "Cooking"})
+		if err != nil {
+			t.Fatalf("Posts.Create returned error: %v", err)
+		}
+		if err := stores.Categories.Delete(gardening); err != nil {
+			t.Fatalf("Delete returned error: %v", err)
+		}
+		if names, err := stores.Posts.Categories(postID); err != nil || len(names) != 1 || names[0] != "Cooking" {
+			t.Errorf("post categories after delete = %v, %v; want [Cooking]", names, err)
+		}
+	})
+}
+
+func TestCategoryStore_List(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		// Start without the categories the migrations add
+		existing, _ := stores.Categories.List()
+		for _, c := range existing {
+			stores.Categories.Delete(c.ID)
+		}
+
+		tests := []struct {
+			name string
+			add  []string
+			want []string
+		}{
+			{"Empty categories", nil, nil},
+			{"Single category", []string{"Technology"}, []string{"Technology"}},
+			{"Multiple categories", []string{"Sports", "Politics"}, []string{"Technology", "Sports", "Politics"}},
+		}
+		for _, tt := range tests {
+			for _, name := range tt.add {
+				if err := stores.Categories.Create(name); err != nil {
+					t.Fatalf("Create(%s) returned error: %v", name, err)
+				}
+			}
+			got, err := stores.Categories.List()
+			if err != nil {
+				t.Fatalf("%s: List returned error: %v", tt.name, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("%s: List = %v, want %v", tt.name, got, tt.want)
+				continue
+			}
+			for i, c := range got {
+				if id, _ := stores.Categories.IDByName(tt.want[i]); c.Name != tt.want[i] || c.ID != id {
+					t.Errorf("%s: List()[%d] = %+v, want %s with ID %d", tt.name, i, c, tt.want[i], id)
+				}
+			}
+		}
+	})
+}
+
+func TestCategoryStore_IDByName(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		for _, name := range []string{"Technology", "Sports", "Music"} {
+			if err := stores.Categories.Create(name); err != nil {
+				t.Fatalf("Create(%s) returned error: %v", name, err)
+			}
+		}
+		ids := map[string]int{}
+		categories, _ := stores.Categories.List()
+		for _, c := range categories {
+			ids[c.Name] = c.ID
+		}
+
+		tests := []struct {
+			name         string
+			categoryName string
+			wantID       int
+			wantErr      error
+		}{
+			{"Existing Category - Technology", "Technology", ids["Technology"], nil},
+			{"Existing Category - Sports", "Sports", ids["Sports"], nil},
+			{"Non-Existent Category", "NonExistentCategory", 0, repository.ErrNotFound},
+			{"Empty Category Name", "", 0, repository.ErrNotFound},
+		}
+		for _, tt := range tests {
+			id, err := stores.Categories.IDByName(tt.categoryName)
+			if err != tt.wantErr || id != tt.wantID {
+				t.Errorf("%s: IDByName(%q) = %d, %v; want %d, %v", tt.name, tt.categoryName, id, err, tt.wantID, tt.wantErr)
+			}
+		}
+	})
+}
+
+func TestCategoryStore_Merge(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		ids := map[string]int{}
+		for _, name := range []string{"Recipes", "Cooking", "Baking"} {
+			if err := stores.Categories.Create(name); err != nil {
+				t.Fatalf("Create(%s) returned error: %v", name, err)
+			}
+			ids[name], _ = stores.Categories.IDByName(name)
+		}
+		authorID := createUser(t, stores, "author")
+		onlyRecipes, _ := stores.Posts.Create(utils.Post{UserID: authorID, Title: "Soup", Content: "Hot"}, []string{"Recipes"})
+		both, _ := stores.Posts.Create(utils.Post{UserID: authorID, Title: "Bread", Content: "Warm"}, []string{"Recipes", "Cooking", "Baking"})
+
+		if err := stores.Categories.Merge(ids["Recipes"], ids["Cooking"]); err != nil {
+			t.Fatalf("Merge returned error: %v", err)
+		}
+		if _, err := stores.Categories.IDByName("Recipes"); err != repository.ErrNotFound {
+			t.Errorf("merged category still exists: %v", err)
+		}
+		if names, _ := stores.Posts.Categories(onlyRecipes); len(names) != 1 || names[0] != "Cooking" {
+			t.Errorf("categories of the moved post = %v, want [Cooking]", names)
+		}
+		if names, _ := stores.Posts.Categories(both); len(names) != 2 || names[0] != "Baking" || names[1] != "Cooking" {
+			t.Errorf("categories of the post in both = %v, want [Baking Cooking]", names)
+		}
+
+		if err := stores.Categories.Merge(ids["Recipes"], ids["Cooking"]); err != repository.ErrNotFound {
+			t.Errorf("Merge of a missing category returned %v, want ErrNotFound", err)
+		}
+		if err := stores.Categories.Merge(ids["Baking"], ids["Baking"]); err == nil {
+			t.Errorf("Merge accepted a category merged into itself")
+		}
+	})
+}
+
+func TestUserStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		aliceID := createUser(t, stores, "alice")
+		bobID := createUser(t, stores, "bob")
+		if err := stores.Users.Create(utils.User{ID: utils.GenerateId(), UserName: "alice"}); err == nil {
+			t.Errorf("Create accepted a taken username")
+		}
+
+		if user, err := stores.Users.Get(aliceID); err != nil || user.UserName != "alice" || user.Password != "" || user.Role != utils.RoleMember {
+			t.Errorf("Get = %+v, %v; want alice, a member, without password", user, err)
+		}
+		if user, err := stores.Users.GetByUsername("alice"); err != nil || user.Password != "hash" {
+			t.Errorf("GetByUsername = %+v, %v; want the password hash", user, err)
+		}
+		if user, err := stores.Users.GetByEmail("bob@example.com"); err != nil || user.ID != bobID || user.Password != "hash" {
+			t.Errorf("GetByEmail = %+v, %v; want bob with the password hash", user, err)
+		}
+		if _, err := stores.Users.Get("missing"); err != repository.ErrNotFound {
+			t.Errorf("Get of a missing user returned %v, want ErrNotFound", err)
+		}
+		if users, err := stores.Users.List(); err != nil || len(users) != 2 || users[0].UserName != "alice" || users[0].Password != "" {
+			t.Errorf("List = %+v, %v; want alice and bob without passwords", users, err)
+		}
+
+		if role, err := stores.Users.Role("missing"); err != nil || role != utils.RoleMember {
+			t.Errorf("Role of a missing user = %q, %v; want member", role, err)
+		}
+		if err := stores.Users.SetRole(aliceID, "overlord"); err == nil {
+			t.Errorf("SetRole accepted an unknown role")
+		}
+		if err := stores.Users.SetRole(aliceID, utils.RoleModerator); err != nil {
+			t.Fatalf("SetRole returned error: %v", err)
+		}
+		if role, _ := stores.Users.Role(aliceID); role != utils.RoleModerator {
+			t.Errorf("Role = %q, want moderator", role)
+		}
+
+		token, _ := stores.Sessions.Create(bobID, "test-agent", "127.0.0.1")
+		if err := stores.Users.Ban(bobID); err != nil {
+			t.Fatalf("Ban returned error: %v", err)
+		}
+		if _, err := stores.Sessions.Validate(token); err == nil {
+			t.Errorf("banned user's session is still valid")
+		}
+		if err := stores.Users.Unban(bobID); err != nil {
+			t.Fatalf("Unban returned error: %v", err)
+		}
+		if user, _ := stores.Users.Get(bobID); user.Banned {
+			t.Errorf("user is still banned after Unban")
+		}
+
+		token, _ = stores.Sessions.Create(bobID, "test-agent", "127.0.0.1")
+		if err := stores.Users.Suspend(bobID, time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("Suspend returned error: %v", err)
+		}
+		if _, err := stores.Sessions.Validate(token); err == nil {
+			t.Errorf("suspended user's session is still valid")
+		}
+
+		if err := stores.Users.Block(aliceID, bobID); err != nil {
+			t.Fatalf("Block returned error: %v", err)
+		}
+		if blocked, _ := stores.Users.IsBlocked(aliceID, bobID); !blocked {
+			t.Errorf("IsBlocked = false after Block")
+		}
+		if blocked, _ := stores.Users.IsBlocked(bobID, aliceID); blocked {
+			t.Errorf("blocking is not one-way")
+		}
+		stores.Users.Unblock(aliceID, bobID)
+		if blocked, _ := stores.Users.IsBlocked(aliceID, bobID); blocked {
+			t.Errorf("IsBlocked = true after Unblock")
+		}
+	})
+}
+
+func TestSessionStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		userID := createUser(t, stores, "carol")
+		first, err := stores.Sessions.Create(userID, "laptop", "127.0.0.1")
+		if err != nil {
+			t.Fatalf("Create returned error: %v", err)
+		}
+		second, _ := stores.Sessions.Create(userID, "phone", "127.0.0.2")
+
+		if got, err := stores.Sessions.Validate(first); err != nil || got != userID {
+			t.Errorf("Validate = %q, %v; want %q", got, err, userID)
+		}
+		if _, err := stores.Sessions.Validate("invalid"); err == nil {
+			t.Errorf("Validate accepted an unknown token")
+		}
+		if csrf, err := stores.Sessions.CSRFToken(first); err != nil || csrf == "" {
+			t.Errorf("CSRFToken = %q, %v", csrf, err)
+		}
+		if sessions, err := stores.Sessions.List(userID); err != nil || len(sessions) != 2 {
+			t.Errorf("List returned %d sessions, %v; want 2", len(sessions), err)
+		}
+
+		if n, err := stores.Sessions.DeleteOthers(userID, first); err != nil || n != 1 {
+			t.Errorf("DeleteOthers = %d, %v; want 1", n, err)
+		}
+		if _, err := stores.Sessions.Validate(second); err == nil {
+			t.Errorf("other session is still valid after DeleteOthers")
+		}
+
+		// Only the owner can end a session
+		stores.Sessions.Delete("someone-else", first)
+		if _, err := stores.Sessions.Validate(first); err != nil {
+			t.Errorf("session was ended by another user")
+		}
+		stores.Sessions.Delete(userID, first)
+		if _, err := stores.Sessions.Validate(first); err == nil {
+			t.Errorf("session is still valid after Delete")
+		}
+	})
+}
+
+func TestPostAndCommentStores(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		authorID := createUser(t, stores, "dave")
+		readerID := createUser(t, stores, "erin")
+		stores.Categories.Create("Gardening")
+
+		postID, err := stores.Posts.Create(utils.Post{UserID: authorID, Title: "Beds", Content: "Raised"}, []string{"Gardening", "Unknown"})
+		if err != nil {
+			t.Fatalf("Posts.Create returned error: %v", err)
+		}
+		post, err := stores.Posts.Get(postID)
+		if err != nil || post.Title != "Beds" || post.Username != "dave" {
+			t.Fatalf("Posts.Get = %+v, %v; want dave's post", post, err)
+		}
+		if names, _ := stores.Posts.Categories(postID); len(names) != 1 || names[0] != "Gardening" {
+			t.Errorf("Posts.Categories = %v, want [Gardening]", names)
+		}
+
+		if likes, dislikes, err := stores.Posts.React(readerID, postID, 1); err != nil || likes != 1 || dislikes != 0 {
+			t.Errorf("like = %d, %d, %v; want 1, 0", likes, dislikes, err)
+		}
+		if likes, dislikes, _ := stores.Posts.React(readerID, postID, 0); likes != 0 || dislikes != 1 {
+			t.Errorf("dislike after like = %d, %d; want 0, 1", likes, dislikes)
+		}
+		if likes, dislikes, _ := stores.Posts.React(readerID, postID, 0); likes != 0 || dislikes != 0 {
+			t.Errorf("second dislike = %d, %d; want it taken back", likes, dislikes)
+		}
+
+		top, err := stores.Comments.Create(utils.Comment{PostID: postID, UserID: readerID, Content: "Nice"})
+		if err != nil {
+			t.Fatalf("Comments.Create returned error: %v", err)
+		}
+		child, _ := stores.Comments.Create(utils.Comment{PostID: postID, UserID: authorID, ParentID: top, Content: "Thanks"})
+		grandchild, _ := stores.Comments.Create(utils.Comment{PostID: postID, UserID: readerID, ParentID: child, Content: "Welcome"})
+
+		if depth, err := stores.Comments.Depth(grandchild, 5); err != nil || depth != 2 {
+			t.Errorf("Depth = %d, %v; want 2", depth, err)
+		}
+		if depth, _ := stores.Comments.Depth(grandchild, 1); depth != 1 {
+			t.Errorf("Depth with limit 1 = %d, want 1", depth)
+		}
+		if likes, _, err := stores.Comments.React(authorID, top, 1); err != nil || likes != 1 {
+			t.Errorf("Comments.React = %d, %v; want 1 like", likes, err)
+		}
+
+		if err := stores.Comments.Update(child, "Thank you"); err != nil {
+			t.Fatalf("Comments.Update returned error: %v", err)
+		}
+		if c, err := stores.Comments.Get(child); err != nil || c.Content != "Thank you" || c.Username != "dave" {
+			t.Errorf("Comments.Get = %+v, %v", c, err)
+		}
+
+		if err := stores.Comments.Delete(child); err != nil {
+			t.Fatalf("Comments.Delete returned error: %v", err)
+		}
+		if c, _ := stores.Comments.Get(grandchild); c.ParentID != top {
+			t.Errorf("reply of a deleted comment has parent %d, want %d", c.ParentID, top)
+		}
+		if comments, err := stores.Comments.ListByPost(postID); err != nil || len(comments) != 2 {
+			t.Errorf("ListByPost returned %d comments, %v; want 2", len(comments), err)
+		}
+
+		if err := stores.Posts.Delete(postID); err != nil {
+			t.Fatalf("Posts.Delete returned error: %v", err)
+		}
+		if _, err := stores.Posts.Get(postID); err != repository.ErrNotFound {
+			t.Errorf("Posts.Get of a deleted post returned %v, want ErrNotFound", err)
+		}
+		if _, err := stores.Comments.Get(top); err != repository.ErrNotFound {
+			t.Errorf("comment of a deleted post returned %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestPostStore_Feed(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		authorID := createUser(t, stores, "fay")
+		readerID := createUser(t, stores, "gus")
+		var ids []int
+		for _, title := range []string{"One", "Two", "Three"} {
+			id, err := stores.Posts.Create(utils.Post{UserID: authorID, Title: title, Content: "Text"}, nil)
+			if err != nil {
+				t.Fatalf("Posts.Create returned error: %v", err)
+			}
+			ids = append(ids, id)
+		}
+		stores.Posts.React(readerID, ids[0], 1)
+
+		feed := func(q repository.FeedQuery) []int {
+			t.Helper()
+			q.Filter.AuthorID, q.AsOf, q.Limit = authorID, time.Now().Add(time.Minute), 10
+			posts, err := stores.Posts.Feed(q)
+			if err != nil {
+				t.Fatalf("Feed returned error: %v", err)
+			}
+			var got []int
+			for _, p := range posts {
+				got = append(got, p.ID)
+			}
+			return got
+		}
+		if got := feed(repository.FeedQuery{Sort: "top"}); len(got) != 3 || got[0] != ids[0] || got[1] != ids[2] {
+			t.Errorf("top feed = %v, want %d first, then newest first", got, ids[0])
+		}
+		if got := feed(repository.FeedQuery{Sort: "new", After: &repository.FeedPosition{ID: ids[2]}}); len(got) != 2 || got[0] != ids[1] {
+			t.Errorf("new feed after %d = %v, want %v", ids[2], got, []int{ids[1], ids[0]})
+		}
+		if got := feed(repository.FeedQuery{Sort: "top", Exclude: []int{ids[0], ids[2]}}); len(got) != 1 || got[0] != ids[1] {
+			t.Errorf("top feed without %d and %d = %v, want [%d]", ids[0], ids[2], got, ids[1])
+		}
+	})
+}
+
+func TestNotificationStore_Mentions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		authorID := createUser(t, stores, "frank")
+		graceID := createUser(t, stores, "grace")
+		heidiID := createUser(t, stores, "heidi")
+		postID, _ := stores.Posts.Create(utils.Post{UserID: authorID, Title: "Hello", Content: "Hi"}, nil)
+
+		if err := stores.Notifications.SetPreference(heidiID, "mention", "carrier-pigeon"); err != utils.ErrInvalidDelivery {
+			t.Errorf("SetPreference accepted an unknown delivery: %v", err)
+		}
+		if err := stores.Notifications.SetPreference(heidiID, "mention", utils.DeliveryOff); err != nil {
+			t.Fatalf("SetPreference returned error: %v", err)
+		}
+		if prefs, _ := stores.Notifications.Preferences(heidiID); prefs["mention"] != utils.DeliveryOff {
+			t.Errorf("Preferences = %v, want mentions off", prefs)
+		}
+
+		text := "@grace @heidi @frank @nobody have a look"
+		if err := stores.Notifications.RecordMentions(authorID, postID, 0, text); err != nil {
+			t.Fatalf("RecordMentions returned error: %v", err)
+		}
+		// Saving the same text again does not notify twice
+		stores.Notifications.RecordMentions(authorID, postID, 0, text)
+
+		for userID, want := range map[string]int{graceID: 1, heidiID: 0, authorID: 0} {
+			if n, err := stores.Notifications.CountUnread(userID); err != nil || n != want {
+				t.Errorf("CountUnread(%s) = %d, %v; want %d", userID, n, err, want)
+			}
+		}
+
+		list, err := stores.Notifications.List(graceID)
+		if err != nil || len(list) != 1 || list[0].Type != "mention" || list[0].ActorName != "frank" {
+			t.Fatalf("List = %+v, %v; want one mention by frank", list, err)
+		}
+		if ok, _ := stores.Notifications.MarkRead(heidiID, list[0].ID); ok {
+			t.Errorf("MarkRead accepted another user's notification")
+		}
+		if ok, err := stores.Notifications.MarkRead(graceID, list[0].ID); err != nil || !ok {
+			t.Errorf("MarkRead = %v, %v; want true", ok, err)
+		}
+		if n, _ := stores.Notifications.CountUnread(graceID); n != 0 {
+			t.Errorf("CountUnread after MarkRead = %d, want 0", n)
+		}
+	})
+}
+
+func TestModerationStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		authorID := createUser(t, stores, "author")
+		reporterID := createUser(t, stores, "reporter")
+		moderatorID := createUser(t, stores, "moderator")
+		postID, err := stores.Posts.Create(utils.Post{UserID: authorID, Title: "Spam", Content: "Buy now"}, nil)
+		if err != nil {
+			t.Fatalf("Create post returned error: %v", err)
+		}
+		commentID, err := stores.Comments.Create(utils.Comment{PostID: postID, UserID: authorID, Content: "Really"})
+		if err != nil {
+			t.Fatalf("Create comment returned error: %v", err)
+		}
+
+		for _, r := range []repository.Report{
+			{ReporterID: reporterID, PostID: postID, Reason: "spam"},
+			{ReporterID: reporterID, PostID: postID, Reason: "spam", Details: "again"},
+			{ReporterID: moderatorID, PostID: postID, Reason: "off-topic"},
+			{ReporterID: reporterID, PostID: postID, CommentID: commentID, Reason: "harassment"},
+		} {
+			if err := stores.Moderation.CreateReport(r); err != nil {
+				t.Fatalf("CreateReport returned error: %v", err)
+			}
+		}
+		queue, err := stores.Moderation.OpenReports(10)
+		if err != nil {
+			t.Fatalf("OpenReports returned error: %v", err)
+		}
+		if len(queue) != 3 {
+			t.Fatalf("OpenReports returned %d reports, want 3 without the duplicate", len(queue))
+		}
+		first, onComment := queue[0], queue[2]
+		if first.ReporterName != "reporter" || first.AuthorName != "author" || first.Excerpt != "Spam" || first.OpenReports != 2 {
+			t.Errorf("first report = %+v", first)
+		}
+		if onComment.CommentID != commentID || onComment.Excerpt != "Really" || onComment.OpenReports != 1 {
+			t.Errorf("comment report = %+v", onComment)
+		}
+
+		// A failed action changes nothing, not even the report
+		missing := utils.ModerationEntry{ModeratorID: moderatorID, Action: utils.ModSuspend, ReportID: first.ID, PostID: postID, TargetUserID: "nobody"}
+		if err := stores.Moderation.Resolve(missing, time.Now().Add(time.Hour)); err != repository.ErrNotFound {
+			t.Errorf("suspending a missing user returned %v, want ErrNotFound", err)
+		}
+		if report, _ := stores.Moderation.GetReport(first.ID); report.Status != "open" {
+			t.Errorf("failed resolution left the report %s", report.Status)
+		}
+
+		hide := utils.ModerationEntry{ModeratorID: moderatorID, Action: utils.ModHide, ReportID: first.ID, PostID: postID, TargetUserID: authorID}
+		if err := stores.Moderation.Resolve(hide, time.Time{}); err != nil {
+			t.Fatalf("Resolve returned error: %v", err)
+		}
+		if post, _ := stores.Posts.Get(postID); !post.Hidden {
+			t.Errorf("post was not hidden")
+		}
+		if err := stores.Moderation.Resolve(hide, time.Time{}); err != repository.ErrReportClosed {
+			t.Errorf("resolving a closed report returned %v, want ErrReportClosed", err)
+		}
+		if queue, _ := stores.Moderation.OpenReports(10); len(queue) != 1 || queue[0].CommentID != commentID {
+			t.Errorf("hiding the post left %+v open, want only the comment report", queue)
+		}
+
+		remove := utils.ModerationEntry{ModeratorID: moderatorID, Action: utils.ModDelete, ReportID: onComment.ID, PostID: postID, CommentID: commentID, TargetUserID: authorID, Note: "rude"}
+		if err := stores.Moderation.Resolve(remove, time.Time{}); err != nil {
+			t.Fatalf("Resolve returned error: %v", err)
+		}
+		if _, err := stores.Comments.Get(commentID); err != repository.ErrNotFound {
+			t.Errorf("deleted comment returned %v, want ErrNotFound", err)
+		}
+
+		if err := stores.Moderation.Log(utils.ModerationEntry{ModeratorID: moderatorID, Action: utils.ModBan, TargetUserID: reporterID}); err != nil {
+			t.Fatalf("Log returned error: %v", err)
+		}
+		entries, err := stores.Moderation.RecentLog(2)
+		if err != nil {
+			t.Fatalf("RecentLog returned error: %v", err)
+		}
+		if len(entries) != 2 || entries[0].Action != utils.ModBan || entries[0].TargetName != "reporter" ||
+			entries[1].Action != utils.ModDelete || entries[1].ModeratorName != "moderator" || entries[1].Note != "rude" {
+			t.Errorf("RecentLog = %+v, want the ban then the deletion", entries)
+		}
+	})
+}
+
+func TestSearchStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		if err := stores.Categories.Create("Cooking"); err != nil {
+			t.Fatalf("Create category returned error: %v", err)
+		}
+		aliceID := createUser(t, stores, "alice")
+		bobID := createUser(t, stores, "bob")
+		soupID, _ := stores.Posts.Create(utils.Post{UserID: aliceID, Title: "Leek soup", Content: "Slowly sweat the leeks"}, []string{"Cooking"})
+		breadID, _ := stores.Posts.Create(utils.Post{UserID: bobID, Title: "Bread", Content: "Knead well"}, nil)
+		stores.Comments.Create(utils.Comment{PostID: breadID, UserID: aliceID, Content: "Leeks in bread? Never"})
+
+		search := func(q repository.SearchQuery) []repository.SearchResult {
+			t.Helper()
+			q.Limit = 10
+			results, err := stores.Search.Search(q)
+			if err != nil {
+				t.Fatalf("Search(%+v) returned error: %v", q, err)
+			}
+			return results
+		}
+
+		results := search(repository.SearchQuery{Text: "leek"})
+		if len(results) != 2 {
+			t.Fatalf("Search found %d results, want the soup and the comment", len(results))
+		}
+		for _, res := range results {
+			if !strings.Contains(res.Snippet, repository.MatchStart) {
+				t.Errorf("snippet %q has no match marked", res.Snippet)
+			}
+			if res.Kind == "comment" && (res.PostID != breadID || res.PostTitle != "Bread" || res.Author != "alice") {
+				t.Errorf("comment result = %+v", res)
+			}
+		}
+		if results := search(repository.SearchQuery{Text: "leek", Category: "Cooking"}); len(results) != 1 || results[0].PostID != soupID {
+			t.Errorf("category filter found %+v, want the soup", results)
+		}
+		if results := search(repository.SearchQuery{Text: "leek", Author: "bob"}); len(results) != 0 {
+			t.Errorf("author filter found %+v, want nothing", results)
+		}
+		if results := search(repository.SearchQuery{Text: "leek", Before: time.Now().Add(-time.Hour)}); len(results) != 0 {
+			t.Errorf("date filter found %+v, want nothing", results)
+		}
+		if results := search(repository.SearchQuery{Text: "?!"}); len(results) != 0 {
+			t.Errorf("query without words found %+v", results)
+		}
+
+		// Hidden content is never found
+		report := repository.Report{ReporterID: bobID, PostID: soupID, Reason: "spam"}
+		stores.Moderation.CreateReport(report)
+		queue, _ := stores.Moderation.OpenReports(1)
+		hide := utils.ModerationEntry{ModeratorID: bobID, Action: utils.ModHide, ReportID: queue[0].ID, PostID: soupID, TargetUserID: aliceID}
+		if err := stores.Moderation.Resolve(hide, time.Time{}); err != nil {
+			t.Fatalf("Resolve returned error: %v", err)
+		}
+		if results := search(repository.SearchQuery{Text: "soup"}); len(results) != 0 {
+			t.Errorf("hidden post was found: %+v", results)
+		}
+	})
+}
+
+func TestRenderStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		aliceID := createUser(t, stores, "alice")
+		if ids, err := stores.Renders.UserIDs([]string{"alice", "nobody"}); err != nil || len(ids) != 1 || ids["alice"] != aliceID {
+			t.Errorf("UserIDs = %v, %v; want only alice", ids, err)
+		}
+
+		postID, _ := stores.Posts.Create(utils.Post{UserID: aliceID, Title: "Soup", Content: "*Hot*"}, nil)
+		if _, ok, err := stores.Renders.Rendered(utils.ContentPost, postID, "r1"); ok || err != nil {
+			t.Errorf("Rendered before saving = %v, %v", ok, err)
+		}
+		if err := stores.Renders.SaveRendered(utils.ContentPost, postID, "r1", "<em>Hot</em>"); err != nil {
+			t.Fatalf("SaveRendered returned error: %v", err)
+		}
+		if html, ok, _ := stores.Renders.Rendered(utils.ContentPost, postID, "r1"); !ok || html != "<em>Hot</em>" {
+			t.Errorf("Rendered = %q, %v", html, ok)
+		}
+		if _, ok, _ := stores.Renders.Rendered(utils.ContentPost, postID, "r2"); ok {
+			t.Errorf("HTML of another revision was served")
+		}
+
+		stores.Posts.Delete(postID)
+		if _, ok, _ := stores.Renders.Rendered(utils.ContentPost, postID, "r1"); ok {
+			t.Errorf("HTML of a deleted post was kept")
+		}
+	})
+}
+
+func TestTokenStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		aliceID := createUser(t, stores, "alice")
+		first, err := stores.Tokens.Issue(aliceID, utils.TokenResetPassword, time.Hour)
+		if err != nil {
+			t.Fatalf("Issue returned error: %v", err)
+		}
+		second, _ := stores.Tokens.Issue(aliceID, utils.TokenResetPassword, time.Hour)
+		if _, err := stores.Tokens.Check(first, utils.TokenResetPassword); err != utils.ErrInvalidToken {
+			t.Errorf("Check of a replaced token = %v, want ErrInvalidToken", err)
+		}
+		if _, err := stores.Tokens.Check(second, utils.TokenVerifyEmail); err != utils.ErrInvalidToken {
+			t.Errorf("Check with another purpose = %v, want ErrInvalidToken", err)
+		}
+		if id, err := stores.Tokens.Check(second, utils.TokenResetPassword); err != nil || id != aliceID {
+			t.Errorf("Check = %q, %v; want alice", id, err)
+		}
+		if id, err := stores.Tokens.Consume(second, utils.TokenResetPassword); err != nil || id != aliceID {
+			t.Errorf("Consume = %q, %v; want alice", id, err)
+		}
+		if _, err := stores.Tokens.Consume(second, utils.TokenResetPassword); err != utils.ErrInvalidToken {
+			t.Errorf("second Consume = %v, want ErrInvalidToken", err)
+		}
+
+		expired, _ := stores.Tokens.Issue(aliceID, utils.TokenVerifyEmail, -time.Minute)
+		if _, err := stores.Tokens.Consume(expired, utils.TokenVerifyEmail); err != utils.ErrInvalidToken {
+			t.Errorf("Consume of an expired token = %v, want ErrInvalidToken", err)
+		}
+	})
+}
+
+func TestIdentityStore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores *repository.Stores) {
+		aliceID := createUser(t, stores, "alice")
+		if err := stores.Identities.Link(aliceID, utils.ProviderGitHub, "gh-1", ""); err != nil {
+			t.Fatalf("Link returned error: %v", err)
+		}
+		if err := stores.Identities.Link(aliceID, utils.ProviderGitHub, "gh-2", ""); err != utils.ErrProviderLinked {
+			t.Errorf("Link of a second GitHub account = %v, want ErrProviderLinked", err)
+		}
+		if id, err := stores.Identities.Find(utils.ProviderGitHub, "gh-1"); err != nil || id != aliceID {
+			t.Errorf("Find = %q, %v; want alice", id, err)
+		}
+		if _, err := stores.Identities.Find(utils.ProviderGoogle, "gh-1"); err != utils.ErrIdentityNotFound {
+			t.Errorf("Find with another provider = %v, want ErrIdentityNotFound", err)
+		}
+
+		bob := utils.User{ID: utils.GenerateId(), UserName: "bob", Email: "bob@example.com", EmailVerified: true}
+		identity := utils.Identity{Provider: utils.ProviderGoogle, ProviderUserID: "g-1", Email: bob.Email}
+		if err := stores.Identities.CreateUser(bob, identity); err != nil {
+			t.Fatalf("CreateUser returned error: %v", err)
+		}
+		if err := stores.Identities.Link(aliceID, utils.ProviderGoogle, "g-1", ""); err != utils.ErrIdentityTaken {
+			t.Errorf("Link of bob's account to alice = %v, want ErrIdentityTaken", err)
+		}
+		if verified, _ := stores.Users.EmailVerified(bob.ID); !verified {
+			t.Errorf("the email of a user created by a provider was not verified")
+		}
+		if linked, _ := stores.Users.Identities(bob.ID); len(linked) != 1 || linked[0].ProviderUserID != "g-1" {
+			t.Errorf("Identities = %+v, want the Google account", linked)
+		}
+
+		// Bob has no password, so his only identity stays
+		if err := stores.Identities.Unlink(bob.ID, utils.ProviderGoogle); err != utils.ErrLastLoginMethod {
+			t.Errorf("Unlink of the only way in = %v, want ErrLastLoginMethod", err)
+		}
+		if err := stores.Identities.Unlink(aliceID, utils.ProviderGitHub); err != nil {
+			t.Errorf("Unlink returned error: %v", err)
+		}
+		if err := stores.Identities.Unlink(aliceID, utils.ProviderGitHub); err != utils.ErrIdentityNotFound {
+			t.Errorf("second Unlink = %v, want ErrIdentityNotFound", err)
+		}
+	})
+}
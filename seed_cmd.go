@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+var (
+	seedTopics = []string{
+		"Favourite keyboard shortcuts", "Best way to learn Go?", "Show us your desk setup",
+		"Weekend hiking spots", "Books that changed how you code", "Sourdough troubleshooting",
+		"Tabs or spaces, once and for all", "What are you building this month?",
+	}
+	seedReplies = []string{
+		"Great question, following.", "I had the same problem last week.", "Thanks for sharing!",
+		"Have you tried turning it off and on again?", "Strongly disagree, but well argued.",
+	}
+)
+
+// seedCommand fills a development database with demo users, posts,
+// comments and reactions. Existing demo users are reused, so it can be run
+// again for more posts.
+func seedCommand(stores *repository.Stores, args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	userCount := flags.Int("users", 5, "number of demo users, named demo1, demo2 and so on")
+	postCount := flags.Int("posts", 20, "number of posts to add")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *userCount < 1 || *postCount < 0 {
+		return fmt.Errorf("-users must be at least 1 and -posts not negative")
+	}
+
+	categories, err := stores.Categories.List()
+	if err != nil {
+		return err
+	}
+	if len(categories) == 0 {
+		return fmt.Errorf("there are no categories to file posts under, add one with: go run . category add <name>")
+	}
+
+	password, _, err := newPassword(false, in)
+	if err != nil {
+		return err
+	}
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	var userIDs []string
+	created := 0
+	for i := 1; i <= *userCount; i++ {
+		name := fmt.Sprintf("demo%d", i)
+		if user, err := stores.Users.GetByUsername(name); err == nil {
+			userIDs = append(userIDs, user.ID)
+			continue
+		} else if err != repository.ErrNotFound {
+			return err
+		}
+		user := utils.User{ID: utils.GenerateId(), UserName: name, Email: name + "@example.com", Password: hash, EmailVerified: true}
+		if err := stores.Users.Create(user); err != nil {
+			return fmt.Errorf("creating %s: %v", name, err)
+		}
+		userIDs = append(userIDs, user.ID)
+		created++
+	}
+
+	comments := 0
+	for i := 0; i < *postCount; i++ {
+		authorID := userIDs[rand.Intn(len(userIDs))]
+		names := []string{categories[rand.Intn(len(categories))].Name}
+		if other := categories[rand.Intn(len(categories))].Name; other != names[0] {
+			names = append(names, other)
+		}
+		topic := seedTopics[rand.Intn(len(seedTopics))]
+		postID, err := stores.Posts.Create(utils.Post{
+			UserID:  authorID,
+			Title:   topic,
+			Content: fmt.Sprintf("Demo post %d about *%s*.", i+1, topic),
+		}, names)
+		if err != nil {
+			return fmt.Errorf("creating a post: %v", err)
+		}
+
+		for _, userID := range userIDs {
+			switch rand.Intn(4) {
+			case 0:
+				_, _, err = stores.Posts.React(userID, postID, 1)
+			case 1:
+				_, _, err = stores.Posts.React(userID, postID, 0)
+			case 2:
+				_, err = stores.Comments.Create(utils.Comment{
+					PostID:  postID,
+					UserID:  userID,
+					Content: seedReplies[rand.Intn(len(seedReplies))],
+				})
+				comments++
+			}
+			if err != nil {
+				return fmt.Errorf("adding to post %d: %v", postID, err)
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "Created %d demo users, %d posts and %d comments\n", created, *postCount, comments)
+	if created > 0 {
+		fmt.Fprintf(out, "The new users sign in with the password %s\n", password)
+	}
+	return nil
+}
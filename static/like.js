@@ -1,3 +1,9 @@
+// CSRF token of the current session, rendered into a meta tag by the server
+function csrfToken() {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    return meta ? meta.content : '';
+}
+
 function handleReaction(event) {
     event.preventDefault();
     event.stopPropagation();
@@ -11,6 +17,7 @@ function handleReaction(event) {
         method: "POST",
         headers: {
             "Content-Type": "application/json",
+            "X-CSRF-Token": csrfToken(),
         },
         body: JSON.stringify({
             post_id: parseInt(postID),
@@ -19,6 +26,9 @@ function handleReaction(event) {
         credentials: 'include'
     })
     .then(response => {
+        if (response.status === 403) {
+            throw new Error('This page has expired. Please reload it and try again.');
+        }
         // First check if the response is JSON
         const contentType = response.headers.get("content-type");
         if (!contentType || !contentType.includes("application/json")) {
@@ -66,6 +76,7 @@ function handleCommentReaction(event) {
         method: "POST",
         headers: {
             "Content-Type": "application/json",
+            "X-CSRF-Token": csrfToken(),
         },
         body: JSON.stringify({
             comment_id: parseInt(commentID),
@@ -74,6 +85,9 @@ function handleCommentReaction(event) {
         credentials: 'include'
     })
     .then(response => {
+        if (response.status === 403) {
+            throw new Error('This page has expired. Please reload it and try again.');
+        }
         const contentType = response.headers.get("content-type");
         if (!contentType || !contentType.includes("application/json")) {
             window.location.href = '/signin';
@@ -115,7 +129,10 @@ function editComment(commentId) {
         return;
     }
     
-    const currentContent = contentDiv.textContent.trim();
+    // The Markdown source, as the div holds the rendered HTML
+    const currentContent = contentDiv.dataset.source ?? contentDiv.textContent.trim();
+    // Restored on cancel
+    const currentHTML = contentDiv.innerHTML;
     
     // Create edit form
     const form = document.createElement('form');
@@ -135,6 +152,11 @@ function editComment(commentId) {
     hiddenInput.type = 'hidden';
     hiddenInput.name = 'comment_id';
     hiddenInput.value = commentId;
+
+    const csrfInput = document.createElement('input');
+    csrfInput.type = 'hidden';
+    csrfInput.name = 'csrf_token';
+    csrfInput.value = csrfToken();
     
     // Create buttons container
     const buttonsDiv = document.createElement('div');
@@ -153,7 +175,7 @@ function editComment(commentId) {
     cancelButton.textContent = 'Cancel';
     cancelButton.onclick = (e) => {
         e.preventDefault();
-        contentDiv.innerHTML = currentContent;
+        contentDiv.innerHTML = currentHTML;
     };
     
     // Assemble the form
@@ -161,6 +183,7 @@ function editComment(commentId) {
     buttonsDiv.appendChild(cancelButton);
     form.appendChild(textarea);
     form.appendChild(hiddenInput);
+    form.appendChild(csrfInput);
     form.appendChild(buttonsDiv);
     
     // Replace content with form
@@ -184,6 +207,30 @@ function confirmDeleteComment(event, commentId) {
     }
 }
 
+// Show or hide the reply form under a comment
+function toggleReplyForm(commentId) {
+    const form = document.getElementById(`reply-form-${commentId}`);
+    if (!form) return;
+
+    const hidden = form.style.display === 'none';
+    form.style.display = hidden ? 'block' : 'none';
+    if (hidden) {
+        form.querySelector('textarea').focus();
+    }
+}
+
+// Collapse or expand a comment together with all of its replies
+function toggleThread(commentId) {
+    const thread = document.getElementById(`comment-${commentId}`);
+    if (!thread) return;
+
+    const collapsed = thread.classList.toggle('collapsed');
+    const icon = thread.querySelector('.thread-toggle i');
+    if (icon) {
+        icon.className = collapsed ? 'fas fa-plus' : 'fas fa-minus';
+    }
+}
+
 // Attach event listeners for post reaction buttons
 document.querySelectorAll(".like-btn, .dislike-btn").forEach(button => {
     button.addEventListener("click", handleReaction);
@@ -223,3 +270,11 @@ document.addEventListener('DOMContentLoaded', function() {
         });
     });
 });
+
+// Show or hide the report form for a post or comment
+function toggleReportForm(target) {
+    const form = document.getElementById(`report-form-${target}`);
+    if (!form) return;
+
+    form.style.display = form.style.display === 'none' ? 'flex' : 'none';
+}
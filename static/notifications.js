@@ -0,0 +1,45 @@
+// Keeps the unread badge in the header current and shows new notifications
+// on the notifications page as they arrive.
+document.addEventListener('DOMContentLoaded', function () {
+    const badge = document.querySelector('[data-unread-badge]');
+    if (!badge || !window.EventSource) {
+        return;
+    }
+
+    function setUnread(count) {
+        badge.textContent = count;
+        badge.hidden = count === 0;
+        const markAll = document.querySelector('.mark-all-read-form');
+        if (markAll) {
+            markAll.hidden = count === 0;
+        }
+    }
+
+    // EventSource reconnects on its own, sending the last event ID so the
+    // server can replay anything missed in between
+    const source = new EventSource('/notifications/stream');
+
+    source.addEventListener('unread', function (e) {
+        setUnread(parseInt(e.data, 10) || 0);
+    });
+
+    source.addEventListener('notification', function (e) {
+        const message = JSON.parse(e.data);
+        setUnread(message.unread);
+
+        const list = document.querySelector('.notifications-list');
+        if (!list) {
+            return;
+        }
+        // A new like on a post replaces its "alice and 3 others" entry
+        const existing = list.querySelector(`[data-notification-group="${message.group}"]`);
+        if (existing) {
+            existing.remove();
+        }
+        list.insertAdjacentHTML('afterbegin', message.html);
+        const empty = document.querySelector('.no-notifications');
+        if (empty) {
+            empty.hidden = true;
+        }
+    });
+});
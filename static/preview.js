@@ -0,0 +1,45 @@
+// Shows the Markdown in a textarea rendered by the server, as it will look
+// once posted.
+document.addEventListener('DOMContentLoaded', function () {
+    document.querySelectorAll('[data-preview-for]').forEach(function (button) {
+        const textarea = document.getElementById(button.dataset.previewFor);
+        const preview = document.getElementById(button.dataset.previewFor + '-preview');
+        if (!textarea || !preview) {
+            return;
+        }
+        const label = button.innerHTML;
+
+        button.addEventListener('click', function () {
+            if (!preview.hidden) {
+                preview.hidden = true;
+                button.innerHTML = label;
+                return;
+            }
+
+            const csrf = button.form.querySelector('input[name="csrf_token"]');
+            fetch('/preview', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/x-www-form-urlencoded',
+                    'X-CSRF-Token': csrf ? csrf.value : '',
+                },
+                body: new URLSearchParams({ content: textarea.value }),
+            })
+                .then(function (response) {
+                    if (!response.ok) {
+                        throw new Error('Preview failed');
+                    }
+                    return response.text();
+                })
+                .then(function (html) {
+                    // The server sanitises the rendered HTML
+                    preview.innerHTML = html;
+                    preview.hidden = false;
+                    button.innerHTML = '<i class="fas fa-pen"></i> Hide preview';
+                })
+                .catch(function () {
+                    alert('Could not load the preview. Please try again.');
+                });
+        });
+    });
+});
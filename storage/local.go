@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore keeps blobs as files in a directory. The forum serves them
+// itself, so it has no signed URLs.
+type LocalStore struct {
+	dir string
+}
+
+// NewLocalStore stores blobs in dir, which is created on the first Put.
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir}
+}
+
+func (s *LocalStore) path(key string) (string, bool) {
+	if !ValidKey(key) {
+		return "", false
+	}
+	return filepath.Join(s.dir, key), true
+}
+
+// Put writes data to a temporary file and renames it into place, so a
+// concurrent upload of the same image never sees it half written.
+func (s *LocalStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	name, ok := s.path(key)
+	if !ok {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *LocalStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, ok := s.path(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	name, ok := s.path(key)
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStore) Exists(ctx context.Context, key string) (bool, error) {
+	name, ok := s.path(key)
+	if !ok {
+		return false, nil
+	}
+	_, err := os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List skips temporary files left by interrupted uploads.
+func (s *LocalStore) List(ctx context.Context) ([]Blob, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var blobs []Blob
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") || !ValidKey(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // Deleted since the directory was read
+		}
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, Blob{Key: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return blobs, nil
+}
+
+func (s *LocalStore) SignedURL(ctx context.Context, key string) (string, error) {
+	return "", nil
+}
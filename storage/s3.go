@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config describes a bucket on Amazon S3 or a compatible service such as
+// MinIO.
+type S3Config struct {
+	Endpoint  string // Host and optional port, without a scheme
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	// Proxy serves blobs through the forum instead of redirecting browsers
+	// to signed URLs, for buckets browsers cannot reach.
+	Proxy     bool
+	URLExpiry time.Duration // Lifetime of signed URLs, an hour by default
+}
+
+// S3Store keeps blobs as objects in an S3 bucket.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	proxy  bool
+	expiry time.Duration
+}
+
+// NewS3Store connects to the bucket described by cfg, creating it if it
+// does not exist yet.
+func NewS3Store(ctx context.Context, cfg S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3 storage needs an endpoint and a bucket")
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("checking bucket %s: %v", cfg.Bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("creating bucket %s: %v", cfg.Bucket, err)
+		}
+	}
+
+	expiry := cfg.URLExpiry
+	if expiry <= 0 {
+		expiry = time.Hour
+	}
+	return &S3Store{client: client, bucket: cfg.Bucket, proxy: cfg.Proxy, expiry: expiry}, nil
+}
+
+func isNotFound(err error) bool {
+	return minio.ToErrorResponse(err).StatusCode == http.StatusNotFound
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:  contentType,
+		CacheControl: CacheControl,
+	})
+	return err
+}
+
+// Open returns a *minio.Object, which can seek.
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !ValidKey(key) {
+		return nil, ErrNotFound
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; Stat makes the request and reports a missing key
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if !ValidKey(key) {
+		return nil
+	}
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Store) Exists(ctx context.Context, key string) (bool, error) {
+	if !ValidKey(key) {
+		return false, nil
+	}
+	_, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *S3Store) List(ctx context.Context) ([]Blob, error) {
+	var blobs []Blob
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		blobs = append(blobs, Blob{Key: obj.Key, Size: obj.Size, ModTime: obj.LastModified})
+	}
+	return blobs, nil
+}
+
+func (s *S3Store) SignedURL(ctx context.Context, key string) (string, error) {
+	if s.proxy {
+		return "", nil
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, s.expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
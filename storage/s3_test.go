@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestS3Store runs against an S3-compatible server when S3_TEST_ENDPOINT is
+// set, e.g. a local MinIO:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	S3_TEST_ENDPOINT=localhost:9000 S3_TEST_ACCESS_KEY=minioadmin S3_TEST_SECRET_KEY=minioadmin go test ./storage
+func TestS3Store(t *testing.T) {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT is not set")
+	}
+	ctx := context.Background()
+	cfg := S3Config{
+		Endpoint:  endpoint,
+		Bucket:    "forum-test-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		AccessKey: os.Getenv("S3_TEST_ACCESS_KEY"),
+		SecretKey: os.Getenv("S3_TEST_SECRET_KEY"),
+		UseSSL:    os.Getenv("S3_TEST_USE_SSL") == "true",
+	}
+	store, err := NewS3Store(ctx, cfg)
+	if err != nil {
+		t.Fatalf("NewS3Store returned error: %v", err)
+	}
+	t.Cleanup(func() {
+		blobs, _ := store.List(ctx)
+		for _, blob := range blobs {
+			store.Delete(ctx, blob.Key)
+		}
+		store.client.RemoveBucket(ctx, cfg.Bucket)
+	})
+
+	testBlobStore(t, store)
+
+	if err := store.Put(ctx, "signed.png", []byte("png"), "image/png"); err != nil {
+		t.Fatalf("Put returned error: %v", err)
+	}
+	signedURL, err := store.SignedURL(ctx, "signed.png")
+	if err != nil || signedURL == "" {
+		t.Fatalf("SignedURL = %q, %v", signedURL, err)
+	}
+	resp, err := http.Get(signedURL)
+	if err != nil {
+		t.Fatalf("Fetching the signed URL failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("signed URL returned %d with type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	cfg.Proxy = true
+	proxied, err := NewS3Store(ctx, cfg)
+	if err != nil {
+		t.Fatalf("NewS3Store returned error: %v", err)
+	}
+	if url, err := proxied.SignedURL(ctx, "signed.png"); err != nil || url != "" {
+		t.Errorf("SignedURL with Proxy = %q, %v; want \"\"", url, err)
+	}
+}
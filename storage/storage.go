@@ -0,0 +1,143 @@
+// Package storage keeps uploaded files in a BlobStore: a directory on the
+// local disk or a bucket on an S3-compatible service. Files are addressed by
+// a flat key such as "<sha256>.jpg" and served to browsers from /uploads/.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"regexp"
+	"time"
+
+	"forum/config"
+)
+
+// URLPrefix is the path uploads are served under, whichever backend stores
+// them. Image paths saved in the database start with it.
+const URLPrefix = "/uploads/"
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores uploaded files.
+type BlobStore interface {
+	// Put stores data under key, replacing any blob already there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Open returns the blob stored under key, or ErrNotFound. The reader is
+	// an io.ReadSeeker when the backend can seek.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob under key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	// List returns every blob.
+	List(ctx context.Context) ([]Blob, error)
+	// SignedURL returns a temporary URL the browser can fetch key from
+	// directly, or "" when the blob must be proxied through Open.
+	SignedURL(ctx context.Context, key string) (string, error)
+}
+
+// Blob describes a stored file.
+type Blob struct {
+	Key     string
+	Size    int64
+	ModTime time.Time // When it was last written
+}
+
+var validKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidKey reports whether key can name a blob. Keys are single path
+// elements, so they cannot escape a local store's directory.
+func ValidKey(key string) bool {
+	return len(key) <= 255 && validKey.MatchString(key)
+}
+
+// KeyFromURL returns the key of an upload from the path it is served at, or
+// "" if uploadURL is not an upload.
+func KeyFromURL(uploadURL string) string {
+	if len(uploadURL) <= len(URLPrefix) || uploadURL[:len(URLPrefix)] != URLPrefix {
+		return ""
+	}
+	key := uploadURL[len(URLPrefix):]
+	if !ValidKey(key) {
+		return ""
+	}
+	return key
+}
+
+// ContentType guesses the type of a blob from its key's extension.
+func ContentType(key string) string {
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// Open opens the backend cfg names.
+func Open(ctx context.Context, cfg config.Uploads) (BlobStore, error) {
+	return OpenBackend(ctx, cfg.Backend, cfg)
+}
+
+// OpenBackend opens the "local" or "s3" backend with the settings in cfg,
+// whichever backend cfg names.
+func OpenBackend(ctx context.Context, backend string, cfg config.Uploads) (BlobStore, error) {
+	switch backend {
+	case "local":
+		return NewLocalStore(cfg.Dir), nil
+	case "s3":
+		return NewS3Store(ctx, S3Config{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			UseSSL:    cfg.S3.UseSSL,
+			Proxy:     cfg.S3.Proxy,
+			URLExpiry: cfg.S3.URLExpiry,
+		})
+	default:
+		return nil, fmt.Errorf("unknown upload backend %q, want local or s3", backend)
+	}
+}
+
+// Copy copies every blob in from that is missing in to. It returns how many
+// blobs were copied and how many were already there.
+func Copy(ctx context.Context, from, to BlobStore) (copied, skipped int, err error) {
+	blobs, err := from.List(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("listing blobs: %v", err)
+	}
+	for _, blob := range blobs {
+		key := blob.Key
+		exists, err := to.Exists(ctx, key)
+		if err != nil {
+			return copied, skipped, fmt.Errorf("%s: %v", key, err)
+		}
+		if exists {
+			skipped++
+			continue
+		}
+
+		rc, err := from.Open(ctx, key)
+		if err != nil {
+			return copied, skipped, fmt.Errorf("%s: %v", key, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return copied, skipped, fmt.Errorf("%s: %v", key, err)
+		}
+		if err := to.Put(ctx, key, data, ContentType(key)); err != nil {
+			return copied, skipped, fmt.Errorf("%s: %v", key, err)
+		}
+		copied++
+	}
+	return copied, skipped, nil
+}
+
+// CacheControl is sent with blobs. Keys are content hashes, or unique names
+// for older uploads, so a key's content never changes.
+const CacheControl = "public, max-age=31536000, immutable"
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testBlobStore runs the behaviour every backend must share against store,
+// which must be empty.
+func testBlobStore(t *testing.T, store BlobStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	if _, err := store.Open(ctx, "missing.jpg"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Open(missing) error = %v, want ErrNotFound", err)
+	}
+	if exists, err := store.Exists(ctx, "missing.jpg"); err != nil || exists {
+		t.Errorf("Exists(missing) = %v, %v; want false", exists, err)
+	}
+
+	if err := store.Put(ctx, "a.jpg", []byte("first"), "image/jpeg"); err != nil {
+		t.Fatalf("Put returned error: %v", err)
+	}
+	if err := store.Put(ctx, "a.jpg", []byte("second"), "image/jpeg"); err != nil {
+		t.Fatalf("Put over an existing blob returned error: %v", err)
+	}
+	if err := store.Put(ctx, "b.png", []byte("other"), "image/png"); err != nil {
+		t.Fatalf("Put returned error: %v", err)
+	}
+	if err := store.Put(ctx, "../escape.jpg", []byte("x"), "image/jpeg"); err == nil {
+		t.Errorf("Put accepted a key with a path in it")
+	}
+
+	rc, err := store.Open(ctx, "a.jpg")
+	if err != nil {
+		t.Fatalf("Open returned error: %v", err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "second" {
+		t.Errorf("Open read %q, %v; want %q", data, err, "second")
+	}
+	if exists, err := store.Exists(ctx, "a.jpg"); err != nil || !exists {
+		t.Errorf("Exists(a.jpg) = %v, %v; want true", exists, err)
+	}
+
+	blobs, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Key < blobs[j].Key })
+	if len(blobs) != 2 || blobs[0].Key != "a.jpg" || blobs[1].Key != "b.png" {
+		t.Fatalf("List = %v, want a.jpg and b.png", blobs)
+	}
+	if blobs[0].Size != int64(len("second")) || time.Since(blobs[0].ModTime) > time.Hour {
+		t.Errorf("List reported a.jpg as %d bytes written at %v", blobs[0].Size, blobs[0].ModTime)
+	}
+
+	if err := store.Delete(ctx, "a.jpg"); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	if err := store.Delete(ctx, "a.jpg"); err != nil {
+		t.Errorf("Delete of a missing blob returned error: %v", err)
+	}
+	if exists, _ := store.Exists(ctx, "a.jpg"); exists {
+		t.Errorf("a.jpg still exists after Delete")
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	testBlobStore(t, NewLocalStore(t.TempDir()))
+
+	// Stores can be configured before their directory exists
+	store := NewLocalStore(t.TempDir() + "/uploads")
+	if blobs, err := store.List(context.Background()); err != nil || len(blobs) != 0 {
+		t.Errorf("List of a new store = %v, %v", blobs, err)
+	}
+	if url, err := store.SignedURL(context.Background(), "a.jpg"); err != nil || url != "" {
+		t.Errorf("SignedURL = %q, %v; local files are proxied", url, err)
+	}
+}
+
+func TestKeyFromURL(t *testing.T) {
+	tests := map[string]string{
+		"/uploads/abc.jpg":        "abc.jpg",
+		"/uploads/abc_thumb.png":  "abc_thumb.png",
+		"/uploads/":               "",
+		"/uploads/../forum.db":    "",
+		"/uploads/a/b.jpg":        "",
+		"/static/uploads/abc.jpg": "",
+		"":                        "",
+	}
+	for uploadURL, want := range tests {
+		if got := KeyFromURL(uploadURL); got != want {
+			t.Errorf("KeyFromURL(%q) = %q, want %q", uploadURL, got, want)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	from, to := NewLocalStore(t.TempDir()), NewLocalStore(t.TempDir())
+	from.Put(ctx, "a.jpg", []byte("a"), "image/jpeg")
+	from.Put(ctx, "b.png", []byte("b"), "image/png")
+	to.Put(ctx, "b.png", []byte("b"), "image/png")
+
+	copied, skipped, err := Copy(ctx, from, to)
+	if err != nil || copied != 1 || skipped != 1 {
+		t.Fatalf("Copy = %d, %d, %v; want 1 copied and 1 skipped", copied, skipped, err)
+	}
+	if exists, _ := to.Exists(ctx, "a.jpg"); !exists {
+		t.Errorf("a.jpg was not copied")
+	}
+	if copied, _, _ := Copy(ctx, from, to); copied != 0 {
+		t.Errorf("second Copy copied %d blobs, want 0", copied)
+	}
+}
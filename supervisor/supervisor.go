@@ -0,0 +1,108 @@
+// Package supervisor runs the server's background jobs and shuts them down
+// in order: the jobs are cancelled and waited for first, then resources
+// they use, such as the database, are released.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// Supervisor runs jobs until Stop is called. The zero value is not usable;
+// call New.
+type Supervisor struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]int // Jobs that have not returned, by name
+	steps   []step
+}
+
+// step is run by Stop once the jobs have returned.
+type step struct {
+	name string
+	run  func() error
+}
+
+func New() *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Supervisor{ctx: ctx, cancel: cancel, running: make(map[string]int)}
+}
+
+// Go runs job in its own goroutine. Its context is cancelled by Stop, and
+// job must return soon after. A job that panics is logged and not
+// restarted; the other jobs and the server carry on.
+func (s *Supervisor) Go(name string, job func(ctx context.Context)) {
+	s.mu.Lock()
+	s.running[name]++
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			if s.running[name]--; s.running[name] == 0 {
+				delete(s.running, name)
+			}
+			s.mu.Unlock()
+		}()
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("Background job %s panicked: %v\n%s", name, err, debug.Stack())
+			}
+		}()
+		job(s.ctx)
+	}()
+}
+
+// OnStop adds a step for Stop to run after the jobs have returned. Steps
+// run in the reverse order they were added, so something added right after
+// it was opened is closed after everything that was set up later.
+func (s *Supervisor) OnStop(name string, run func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.steps = append(s.steps, step{name, run})
+}
+
+// Stop cancels the jobs and waits for them to return, then runs the OnStop
+// steps. If ctx ends before every job has returned, the steps run anyway
+// and the jobs still running are reported in the error, along with any
+// step that failed.
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	var errs []error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.mu.Lock()
+		for name := range s.running {
+			errs = append(errs, fmt.Errorf("background job %s did not stop: %v", name, ctx.Err()))
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	steps := s.steps
+	s.steps = nil
+	s.mu.Unlock()
+	for i := len(steps) - 1; i >= 0; i-- {
+		if err := steps[i].run(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", steps[i].name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,68 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStop_CancelsJobsThenRunsSteps(t *testing.T) {
+	s := New()
+	var order []string
+	events := make(chan string, 4)
+
+	s.OnStop("database", func() error {
+		order = append(order, "database")
+		return nil
+	})
+	s.OnStop("cache", func() error {
+		order = append(order, "cache")
+		return errors.New("flush failed")
+	})
+	for _, name := range []string{"first", "second"} {
+		s.Go(name, func(ctx context.Context) {
+			<-ctx.Done()
+			events <- name
+		})
+	}
+	s.Go("crashing", func(ctx context.Context) {
+		panic("boom")
+	})
+
+	err := s.Stop(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cache: flush failed") {
+		t.Errorf("Stop returned %v, want the failed step", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("%d jobs returned before the steps ran, want 2", len(events))
+	}
+	if len(order) != 2 || order[0] != "cache" || order[1] != "database" {
+		t.Errorf("steps ran in order %v, want cache then database", order)
+	}
+}
+
+func TestStop_GivesUpOnStuckJobs(t *testing.T) {
+	s := New()
+	release := make(chan struct{})
+	defer close(release)
+	s.Go("stuck", func(ctx context.Context) {
+		<-release
+	})
+	closed := false
+	s.OnStop("database", func() error {
+		closed = true
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := s.Stop(ctx)
+	if err == nil || !strings.Contains(err.Error(), "stuck did not stop") {
+		t.Errorf("Stop returned %v, want the stuck job named", err)
+	}
+	if !closed {
+		t.Errorf("steps did not run after the timeout")
+	}
+}
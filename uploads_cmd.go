@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"forum/config"
+	"forum/storage"
+	"forum/utils"
+)
+
+// migrateUploads copies every upload from one backend to another, e.g.
+// before switching UPLOAD_BACKEND from local to s3. Both backends use the
+// settings in cfg. Blobs already in the destination are left alone, so the
+// command can be run again after an interruption.
+func migrateUploads(cfg config.Config, args []string) {
+	flags := flag.NewFlagSet("migrate-uploads", flag.ExitOnError)
+	from := flags.String("from", "local", "backend to copy from: local or s3")
+	to := flags.String("to", "s3", "backend to copy to: local or s3")
+	flags.Parse(args)
+	if *from == *to {
+		log.Fatal("-from and -to must name different backends")
+	}
+
+	ctx := context.Background()
+	src, err := storage.OpenBackend(ctx, *from, cfg.Uploads)
+	if err != nil {
+		log.Fatalf("Opening %s storage: %v", *from, err)
+	}
+	dst, err := storage.OpenBackend(ctx, *to, cfg.Uploads)
+	if err != nil {
+		log.Fatalf("Opening %s storage: %v", *to, err)
+	}
+
+	copied, skipped, err := storage.Copy(ctx, src, dst)
+	fmt.Fprintf(os.Stdout, "Copied %d uploads from %s to %s, %d were already there\n", copied, *from, *to, skipped)
+	if err != nil {
+		log.Fatalf("Migration stopped: %v", err)
+	}
+}
+
+// cleanUploads deletes unreferenced uploads once, like the background
+// cleanup the server runs, and prints what it found.
+func cleanUploads(cfg config.Config, args []string) {
+	flags := flag.NewFlagSet("clean-uploads", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", cfg.Uploads.CleanupDryRun, "report unreferenced uploads without deleting them")
+	grace := flags.Duration("grace", cfg.Uploads.CleanupGrace, "keep unreferenced uploads younger than this")
+	flags.Parse(args)
+
+	db, err := utils.InitialiseDB(cfg.Database)
+	if err != nil {
+		log.Fatalf("Database initialization failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	store, err := storage.Open(ctx, cfg.Uploads)
+	if err != nil {
+		log.Fatalf("Upload storage configuration failed: %v", err)
+	}
+
+	result, err := utils.CleanUpUploads(ctx, db, store, *grace, *dryRun)
+	if err != nil {
+		log.Fatalf("Upload cleanup failed: %v", err)
+	}
+	if *dryRun {
+		fmt.Fprintf(os.Stdout, "%v; dry run, nothing was deleted\n", result)
+	} else {
+		fmt.Fprintf(os.Stdout, "%v; unreferenced files were deleted\n", result)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"forum/repository"
+	"forum/utils"
+)
+
+const userUsage = `Usage: go run . user <command>
+  create [-role member] [-password-stdin] <username> <email>
+  promote <username> [role]        give a role, admin unless named
+  ban <username>
+  unban <username>
+  reset-password [-password-stdin] <username>
+
+Without -password-stdin a random password is made up and printed.`
+
+// userCommand manages accounts without going through the web forms, e.g.
+// to create the first admin or let someone back in.
+func userCommand(stores *repository.Stores, args []string, in io.Reader, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(userUsage)
+	}
+	command, args := args[0], args[1:]
+	flags := flag.NewFlagSet("user "+command, flag.ContinueOnError)
+	role := utils.RoleMember
+	passwordStdin := false
+	switch command {
+	case "create":
+		flags.StringVar(&role, "role", role, "role of the new user: member, moderator or admin")
+		fallthrough
+	case "reset-password":
+		flags.BoolVar(&passwordStdin, "password-stdin", false, "read the password from the first line of standard input")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	switch {
+	case command == "create" && len(args) == 2:
+		return createUser(stores, args[0], args[1], role, passwordStdin, in, out)
+	case command == "promote" && (len(args) == 1 || len(args) == 2):
+		role = utils.RoleAdmin
+		if len(args) == 2 {
+			role = args[1]
+		}
+		return withUser(stores, args[0], func(user utils.User) error {
+			if err := stores.Users.SetRole(user.ID, role); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s is now %s\n", user.UserName, role)
+			return nil
+		})
+	case command == "ban" && len(args) == 1:
+		return withUser(stores, args[0], func(user utils.User) error {
+			if err := stores.Users.Ban(user.ID); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Banned %s and signed them out everywhere\n", user.UserName)
+			return nil
+		})
+	case command == "unban" && len(args) == 1:
+		return withUser(stores, args[0], func(user utils.User) error {
+			if err := stores.Users.Unban(user.ID); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Unbanned %s\n", user.UserName)
+			return nil
+		})
+	case command == "reset-password" && len(args) == 1:
+		return withUser(stores, args[0], func(user utils.User) error {
+			password, generated, err := newPassword(passwordStdin, in)
+			if err != nil {
+				return err
+			}
+			hash, err := utils.HashPassword(password)
+			if err != nil {
+				return err
+			}
+			if err := stores.Users.SetPassword(user.ID, hash); err != nil {
+				return err
+			}
+			if err := stores.Sessions.DeleteAll(user.ID); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Reset the password of %s and signed them out everywhere\n", user.UserName)
+			if generated {
+				fmt.Fprintf(out, "New password: %s\n", password)
+			}
+			return nil
+		})
+	}
+	return errors.New(userUsage)
+}
+
+func createUser(stores *repository.Stores, username, email, role string, passwordStdin bool, in io.Reader, out io.Writer) error {
+	if !utils.ValidateUsername(username) {
+		return fmt.Errorf("invalid username %q", username)
+	}
+	if !utils.ValidateEmail(email) {
+		return fmt.Errorf("invalid email address %q", email)
+	}
+	if !utils.ValidRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	password, generated, err := newPassword(passwordStdin, in)
+	if err != nil {
+		return err
+	}
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	// The address is taken on trust, as whoever runs this could change it
+	// in the database anyway
+	user := utils.User{
+		ID:            utils.GenerateId(),
+		UserName:      username,
+		Email:         email,
+		Password:      hash,
+		Role:          role,
+		EmailVerified: true,
+	}
+	if err := stores.Users.Create(user); err != nil {
+		return fmt.Errorf("creating %s: %v", username, err)
+	}
+	fmt.Fprintf(out, "Created %s %s\n", role, username)
+	if generated {
+		fmt.Fprintf(out, "Password: %s\n", password)
+	}
+	return nil
+}
+
+// withUser looks up a user by name and passes them to do.
+func withUser(stores *repository.Stores, username string, do func(user utils.User) error) error {
+	user, err := stores.Users.GetByUsername(username)
+	if err == repository.ErrNotFound {
+		return fmt.Errorf("no user named %q", username)
+	} else if err != nil {
+		return err
+	}
+	return do(user)
+}
+
+// newPassword reads a password from the first line of in, checking it like
+// the sign-up form does, or makes up a random one.
+func newPassword(fromInput bool, in io.Reader) (password string, generated bool, err error) {
+	if !fromInput {
+		b := make([]byte, 18)
+		if _, err := rand.Read(b); err != nil {
+			return "", false, err
+		}
+		return base64.RawURLEncoding.EncodeToString(b), true, nil
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, fmt.Errorf("reading password: %v", err)
+	}
+	password = strings.TrimRight(line, "\r\n")
+	if !utils.ValidatePassword(password) {
+		return "", false, errors.New("the password must be at least 8 characters with capital and small letters, numbers and special characters")
+	}
+	return password, false, nil
+}
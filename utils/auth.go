@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"database/sql"
 	"regexp"
 	"unicode"
 
@@ -65,3 +66,14 @@ func GenerateId() string {
 	Uid, _ := uuid.NewV4()
 	return Uid.String()
 }
+
+func IsEmailVerified(db *sql.DB, userID string) (bool, error) {
+	var verified bool
+	err := db.QueryRow("SELECT email_verified_at IS NOT NULL FROM users WHERE id = ?", userID).Scan(&verified)
+	return verified, err
+}
+
+func MarkEmailVerified(db *sql.DB, userID string) error {
+	_, err := db.Exec("UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE id = ? AND email_verified_at IS NULL", userID)
+	return err
+}
@@ -0,0 +1,27 @@
+package utils
+
+import "database/sql"
+
+// BlockUser stops blockedID from notifying blockerID through mentions.
+// Blocking someone twice is not an error.
+func BlockUser(db *sql.DB, blockerID, blockedID string) error {
+	_, err := db.Exec(`
+        INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id) VALUES (?, ?)
+    `, blockerID, blockedID)
+	return err
+}
+
+// UnblockUser lifts a block.
+func UnblockUser(db *sql.DB, blockerID, blockedID string) error {
+	_, err := db.Exec("DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?", blockerID, blockedID)
+	return err
+}
+
+// IsBlocked reports whether blockerID blocks blockedID.
+func IsBlocked(db *sql.DB, blockerID, blockedID string) (bool, error) {
+	var blocked bool
+	err := db.QueryRow(`
+        SELECT EXISTS(SELECT 1 FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?)
+    `, blockerID, blockedID).Scan(&blocked)
+	return blocked, err
+}
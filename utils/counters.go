@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// CounterDiscrepancy is a count stored on a post or comment that does not
+// match the rows it counts.
+type CounterDiscrepancy struct {
+	Table  string // "posts" or "comments"
+	ID     int
+	Column string
+	Stored int
+	Actual int
+}
+
+func (d CounterDiscrepancy) String() string {
+	return fmt.Sprintf("%s %d: %s is %d, counted %d", d.Table, d.ID, d.Column, d.Stored, d.Actual)
+}
+
+// counters are the denormalised counts, each with the expression that
+// recomputes it for the row being checked.
+var counters = []struct {
+	table, column, actual string
+}{
+	{"posts", "likes", "SELECT COUNT(*) FROM reaction WHERE post_id = posts.id AND like = 1"},
+	{"posts", "dislikes", "SELECT COUNT(*) FROM reaction WHERE post_id = posts.id AND like = 0"},
+	{"posts", "comments", "SELECT COUNT(*) FROM comments WHERE post_id = posts.id"},
+	{"comments", "likes", "SELECT COUNT(*) FROM comment_reaction WHERE comment_id = comments.id AND is_like = 1"},
+	{"comments", "dislikes", "SELECT COUNT(*) FROM comment_reaction WHERE comment_id = comments.id AND is_like = 0"},
+}
+
+// ReconcileCounters recomputes the like, dislike and comment counts stored
+// on posts and comments and returns those that were wrong. With repair the
+// wrong counts are also fixed. Everything runs in one transaction, so the
+// counts are checked and fixed against the same rows.
+func ReconcileCounters(db *sql.DB, repair bool) ([]CounterDiscrepancy, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var found []CounterDiscrepancy
+	for _, c := range counters {
+		wrong := fmt.Sprintf("%s IS NOT (%s)", c.column, c.actual)
+		rows, err := tx.Query(fmt.Sprintf("SELECT id, %s, (%s) FROM %s WHERE %s ORDER BY id", c.column, c.actual, c.table, wrong))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			d := CounterDiscrepancy{Table: c.table, Column: c.column}
+			var stored sql.NullInt64
+			if err := rows.Scan(&d.ID, &stored, &d.Actual); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			d.Stored = int(stored.Int64)
+			found = append(found, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		if repair {
+			if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = (%s) WHERE %s", c.table, c.column, c.actual, wrong)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return found, tx.Commit()
+}
+
+// RunCounterReconciliation repairs wrong counts every interval until ctx is
+// done, logging each one it fixes.
+func RunCounterReconciliation(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fixed, err := ReconcileCounters(db, true)
+			if err != nil {
+				log.Printf("Failed to reconcile counters: %v", err)
+			}
+			for _, d := range fixed {
+				log.Printf("Fixed count of %v", d)
+			}
+		case <-ctx.Done():
+			log.Println("Stopping counter reconciliation")
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReconcileCounters(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate returned error: %v", err)
+	}
+	for _, statement := range []string{
+		"INSERT INTO users (id, username) VALUES ('u1', 'alice'), ('u2', 'bob')",
+		"INSERT INTO posts (id, user_id, title, content) VALUES (1, 'u1', 'Soup', 'Hot'), (2, 'u1', 'Bread', 'Warm')",
+		"INSERT INTO reaction (user_id, post_id, like) VALUES ('u1', 1, 1), ('u2', 1, 0)",
+		"INSERT INTO comments (id, post_id, user_id, content) VALUES (1, 1, 'u2', 'Tasty')",
+		"INSERT INTO comment_reaction (user_id, comment_id, is_like) VALUES ('u1', 1, 1)",
+		// Counts a crash or a bug left wrong
+		"UPDATE posts SET likes = 5, comments = 0 WHERE id = 1",
+		"UPDATE comments SET dislikes = 2 WHERE id = 1",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	// A check alone changes nothing
+	found, err := ReconcileCounters(db, false)
+	if err != nil {
+		t.Fatalf("ReconcileCounters returned error: %v", err)
+	}
+	want := []CounterDiscrepancy{
+		{"posts", 1, "likes", 5, 1},
+		{"posts", 1, "comments", 0, 1},
+		{"comments", 1, "dislikes", 2, 0},
+	}
+	if fmt.Sprint(found) != fmt.Sprint(want) {
+		t.Errorf("ReconcileCounters found %v, want %v", found, want)
+	}
+	if again, _ := ReconcileCounters(db, false); len(again) != 3 {
+		t.Errorf("a check without repair fixed counts, %d remain", len(again))
+	}
+
+	if fixed, err := ReconcileCounters(db, true); err != nil || len(fixed) != 3 {
+		t.Fatalf("ReconcileCounters with repair = %v, %v; want the 3 wrong counts", fixed, err)
+	}
+	var likes, dislikes, comments int
+	db.QueryRow("SELECT likes, dislikes, comments FROM posts WHERE id = 1").Scan(&likes, &dislikes, &comments)
+	if likes != 1 || dislikes != 1 || comments != 1 {
+		t.Errorf("post counts = %d, %d, %d; want 1, 1, 1", likes, dislikes, comments)
+	}
+	db.QueryRow("SELECT likes, dislikes FROM comments WHERE id = 1").Scan(&likes, &dislikes)
+	if likes != 1 || dislikes != 0 {
+		t.Errorf("comment counts = %d, %d; want 1, 0", likes, dislikes)
+	}
+	if found, err := ReconcileCounters(db, false); err != nil || len(found) != 0 {
+		t.Errorf("counts still wrong after repair: %v, %v", found, err)
+	}
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"database/sql"
+)
+
+const (
+	// CSRFField is the form field that carries the CSRF token.
+	CSRFField = "csrf_token"
+	// CSRFHeader carries the token on fetch requests, whose bodies are JSON.
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// SessionCSRFToken returns the CSRF token of a session. Sessions created
+// before tokens existed are given one on first use.
+func SessionCSRFToken(db *sql.DB, sessionToken string) (string, error) {
+	var token string
+	err := db.QueryRow("SELECT COALESCE(csrf_token, '') FROM sessions WHERE id = ?", sessionToken).Scan(&token)
+	if err == sql.ErrNoRows {
+		return "", ErrNoSession
+	} else if err != nil {
+		return "", err
+	}
+	if token != "" {
+		return token, nil
+	}
+
+	_, err = db.Exec(`
+        UPDATE sessions SET csrf_token = ?
+        WHERE id = ? AND (csrf_token IS NULL OR csrf_token = '')
+    `, GenerateSessionToken(), sessionToken)
+	if err != nil {
+		return "", err
+	}
+	// Read it back in case a concurrent request set it first
+	err = db.QueryRow("SELECT csrf_token FROM sessions WHERE id = ?", sessionToken).Scan(&token)
+	return token, err
+}
+
+// ValidCSRFToken compares a submitted token with the session's in constant
+// time. An empty expected token never matches.
+func ValidCSRFToken(expected, submitted string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(submitted)) == 1
+}
@@ -0,0 +1,66 @@
+package utils
+
+import "strings"
+
+// DiffLine is a single line of a line-based diff between two texts.
+type DiffLine struct {
+	Kind string // "same", "added" or "removed"
+	Text string
+}
+
+// DiffLines compares two texts line by line and returns the edit script that
+// turns oldText into newText, using the longest common subsequence of lines.
+func DiffLines(oldText, newText string) []DiffLine {
+	oldLines := splitLines(oldText)
+	newLines := splitLines(newText)
+
+	// lcs[i][j] holds the LCS length of oldLines[i:] and newLines[j:]
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []DiffLine
+	i, j := 0, 0
+	for i < len(oldLines) && j < len(newLines) {
+		switch {
+		case oldLines[i] == newLines[j]:
+			diff = append(diff, DiffLine{Kind: "same", Text: oldLines[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, DiffLine{Kind: "removed", Text: oldLines[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Kind: "added", Text: newLines[j]})
+			j++
+		}
+	}
+	for ; i < len(oldLines); i++ {
+		diff = append(diff, DiffLine{Kind: "removed", Text: oldLines[i]})
+	}
+	for ; j < len(newLines); j++ {
+		diff = append(diff, DiffLine{Kind: "added", Text: newLines[j]})
+	}
+
+	return diff
+}
+
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
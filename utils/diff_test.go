@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		want    []DiffLine
+	}{
+		{
+			name:    "Identical texts",
+			oldText: "a\nb",
+			newText: "a\nb",
+			want:    []DiffLine{{"same", "a"}, {"same", "b"}},
+		},
+		{
+			name:    "Line added",
+			oldText: "a\nc",
+			newText: "a\nb\nc",
+			want:    []DiffLine{{"same", "a"}, {"added", "b"}, {"same", "c"}},
+		},
+		{
+			name:    "Line removed",
+			oldText: "a\nb\nc",
+			newText: "a\nc",
+			want:    []DiffLine{{"same", "a"}, {"removed", "b"}, {"same", "c"}},
+		},
+		{
+			name:    "Line changed",
+			oldText: "hello wrold",
+			newText: "hello world",
+			want:    []DiffLine{{"removed", "hello wrold"}, {"added", "hello world"}},
+		},
+		{
+			name:    "Windows line endings",
+			oldText: "a\r\nb",
+			newText: "a\nb",
+			want:    []DiffLine{{"same", "a"}, {"same", "b"}},
+		},
+		{
+			name:    "Empty to text",
+			oldText: "",
+			newText: "a",
+			want:    []DiffLine{{"added", "a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffLines(tt.oldText, tt.newText)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffLines() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
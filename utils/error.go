@@ -22,6 +22,7 @@ const (
 	ErrFileTooLarge     = "File size exceeds the 20MB limit. Please upload a smaller image."
 	ErrInvalidFileType  = "Invalid file type. Only JPEG, PNG, and GIF images are allowed."
 	ErrNotFound         = "Not Found."
+	ErrCSRF             = "This form has expired or did not come from this site. Please reload the page and try again."
 )
 
 func RenderErrorPage(w http.ResponseWriter, code int, message string) {
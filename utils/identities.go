@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Sign-in providers that can be linked to a local account.
+const (
+	ProviderGitHub = "github"
+	ProviderGoogle = "google"
+)
+
+var (
+	ErrIdentityTaken    = errors.New("this account is already linked to another user")
+	ErrProviderLinked   = errors.New("a different account from this provider is already linked")
+	ErrLastLoginMethod  = errors.New("cannot unlink the only way to sign in")
+	ErrIdentityNotFound = errors.New("identity not found")
+)
+
+// Identity is an account at an external provider linked to a local user.
+type Identity struct {
+	Provider       string
+	ProviderUserID string
+	Email          string
+	CreatedAt      time.Time
+}
+
+// ProviderInfo describes a configured sign-in provider for templates.
+type ProviderInfo struct {
+	Name  string // Used in URLs and stored in user_identities
+	Label string
+	Icon  string // Font Awesome classes
+}
+
+// FindIdentity returns the local user linked to a provider account.
+func FindIdentity(db *sql.DB, provider, providerUserID string) (string, error) {
+	var userID string
+	err := db.QueryRow(`
+        SELECT user_id FROM user_identities WHERE provider = ? AND provider_user_id = ?
+    `, provider, providerUserID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrIdentityNotFound
+	}
+	return userID, err
+}
+
+// LinkIdentity links a provider account to a user. A provider account can
+// belong to one user only, and a user can link one account per provider.
+func LinkIdentity(db *sql.DB, userID, provider, providerUserID, email string) error {
+	owner, err := FindIdentity(db, provider, providerUserID)
+	if err == nil {
+		if owner == userID {
+			return nil
+		}
+		return ErrIdentityTaken
+	} else if err != ErrIdentityNotFound {
+		return err
+	}
+
+	_, err = db.Exec(`
+        INSERT INTO user_identities (provider, provider_user_id, user_id, email)
+        VALUES (?, ?, ?, ?)
+    `, provider, providerUserID, userID, email)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrProviderLinked
+	}
+	return err
+}
+
+// UnlinkIdentity removes a user's link to a provider. Users without a
+// password must keep at least one linked provider to be able to sign in.
+func UnlinkIdentity(db *sql.DB, userID, provider string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hasPassword bool
+	var identities int
+	err = tx.QueryRow(`
+        SELECT COALESCE(password, '') != '',
+               (SELECT COUNT(*) FROM user_identities WHERE user_id = users.id)
+        FROM users WHERE id = ?
+    `, userID).Scan(&hasPassword, &identities)
+	if err != nil {
+		return err
+	}
+	if !hasPassword && identities <= 1 {
+		return ErrLastLoginMethod
+	}
+
+	result, err := tx.Exec("DELETE FROM user_identities WHERE user_id = ? AND provider = ?", userID, provider)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrIdentityNotFound
+	}
+	return tx.Commit()
+}
+
+// ListIdentities returns the provider accounts linked to a user.
+func ListIdentities(db *sql.DB, userID string) ([]Identity, error) {
+	rows, err := db.Query(`
+        SELECT provider, provider_user_id, COALESCE(email, ''), created_at
+        FROM user_identities WHERE user_id = ?
+        ORDER BY provider
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []Identity
+	for rows.Next() {
+		var id Identity
+		var createdAt sql.NullTime
+		if err := rows.Scan(&id.Provider, &id.ProviderUserID, &id.Email, &createdAt); err != nil {
+			return nil, err
+		}
+		id.CreatedAt = createdAt.Time
+		identities = append(identities, id)
+	}
+	return identities, rows.Err()
+}
@@ -0,0 +1,303 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"regexp"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Sizes generated for every uploaded image besides the original.
+const (
+	ImageThumb  = "thumb"
+	ImageMedium = "medium"
+)
+
+// imageSizes is the longest side, in pixels, of each generated size. Smaller
+// images are not scaled up.
+var imageSizes = map[string]int{
+	ImageThumb:  400,
+	ImageMedium: 1280,
+}
+
+// maxImagePixels guards against small files that decode to huge images.
+const maxImagePixels = 50_000_000
+
+var ErrImageTooLarge = errors.New("Image dimensions are too large. Please upload a smaller image.")
+
+// hashedImageName matches the names uploads are stored under since sizes
+// have been generated; older uploads have no other sizes.
+var hashedImageName = regexp.MustCompile(`^[0-9a-f]{64}\.(jpg|png|gif)$`)
+
+// ImageVariant returns the path of the given size of the image at
+// imagePath, or imagePath itself when the image has no such size.
+// Sizes of GIFs are stored as PNG, since only their first frame is kept.
+func ImageVariant(imagePath, size string) string {
+	dir, name := path.Split(imagePath)
+	if _, ok := imageSizes[size]; !ok || !hashedImageName.MatchString(name) {
+		return imagePath
+	}
+	ext := path.Ext(name)
+	variantExt := ext
+	if ext == ".gif" {
+		variantExt = ".png"
+	}
+	return dir + strings.TrimSuffix(name, ext) + "_" + size + variantExt
+}
+
+// EncodeImage decodes an image of contentType, as detected by
+// ValidateImage, and encodes it again so that EXIF and other metadata are
+// dropped. JPEGs are turned upright first, since their EXIF orientation is
+// lost with the metadata. It returns the original size and one image for
+// each of ImageThumb and ImageMedium.
+func EncodeImage(data []byte, contentType string) ([]byte, map[string][]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, ErrInvalidImage
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, nil, ErrImageTooLarge
+	}
+
+	var original bytes.Buffer
+	var img image.Image
+	switch contentType {
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, ErrInvalidImage
+		}
+		img = applyOrientation(img, jpegOrientation(data))
+		err = jpeg.Encode(&original, img, &jpeg.Options{Quality: 85})
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, ErrInvalidImage
+		}
+		err = png.Encode(&original, img)
+	case "image/gif":
+		// Every frame is decoded, each as large as the image
+		frames, ok := gifFrames(data)
+		if !ok {
+			return nil, nil, ErrInvalidImage
+		}
+		if frames*config.Width*config.Height > maxImagePixels {
+			return nil, nil, ErrImageTooLarge
+		}
+		var g *gif.GIF
+		g, err = gif.DecodeAll(bytes.NewReader(data))
+		if err != nil || len(g.Image) == 0 {
+			return nil, nil, ErrInvalidImage
+		}
+		// Frames may cover only part of the image
+		first := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+		draw.Draw(first, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Src)
+		img = first
+		err = gif.EncodeAll(&original, g)
+	default:
+		return nil, nil, ErrInvalidImage
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sizes := make(map[string][]byte, len(imageSizes))
+	for size, longest := range imageSizes {
+		var buf bytes.Buffer
+		scaled := scaleDown(img, longest)
+		if contentType == "image/jpeg" {
+			err = jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85})
+		} else {
+			err = png.Encode(&buf, scaled)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		sizes[size] = buf.Bytes()
+	}
+	return original.Bytes(), sizes, nil
+}
+
+// gifFrames counts the frames of a GIF without decoding them, reporting
+// false if the file is cut short or malformed.
+func gifFrames(data []byte) (int, bool) {
+	if len(data) < 13 {
+		return 0, false
+	}
+	i := 13
+	// Global colour table
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	// skipSubBlocks moves i past a sequence of data sub-blocks
+	skipSubBlocks := func() bool {
+		for i < len(data) {
+			n := int(data[i])
+			i += 1 + n
+			if n == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	frames := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // Extension
+			i += 2
+			if !skipSubBlocks() {
+				return 0, false
+			}
+		case 0x2C: // Image descriptor
+			if i+10 > len(data) {
+				return 0, false
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			// LZW minimum code size
+			i++
+			if !skipSubBlocks() {
+				return 0, false
+			}
+			frames++
+		case 0x3B: // Trailer
+			return frames, true
+		default:
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// scaleDown fits img into a square of longest pixels, keeping its aspect
+// ratio.
+func scaleDown(img image.Image, longest int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= longest && h <= longest {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*longest/w)
+		w = longest
+	} else {
+		w = max(1, w*longest/h)
+		h = longest
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, b, xdraw.Src, nil)
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, from 1 (upright)
+// to 8, or 1 when it has none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		// Metadata comes before the image data
+		if marker == 0xDA || length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of a TIFF
+// structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// applyOrientation turns img upright according to its EXIF orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored
+				sx, sy = w-1-x, y
+			case 3: // Upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // Upside down and mirrored
+				sx, sy = x, h-1-y
+			case 5: // Mirrored and turned left
+				sx, sy = y, x
+			case 6: // Turned left, so rotate clockwise
+				sx, sy = y, h-1-x
+			case 7: // Mirrored and turned right
+				sx, sy = w-1-y, h-1-x
+			case 8: // Turned right, so rotate anticlockwise
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+// uploadFile is an in-memory multipart.File.
+type uploadFile struct{ *bytes.Reader }
+
+func (uploadFile) Close() error { return nil }
+
+func newUpload(data []byte, filename, contentType string) (multipart.File, *multipart.FileHeader) {
+	header := &multipart.FileHeader{Filename: filename, Size: int64(len(data)), Header: map[string][]string{
+		"Content-Type": {contentType},
+	}}
+	return uploadFile{bytes.NewReader(data)}, header
+}
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// encodeJPEG returns a w by h JPEG with an EXIF segment holding orientation.
+// Its top-left pixel is red and the rest white.
+func encodeJPEG(t *testing.T, w, h int, orientation uint16) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.RGBA{255, 0, 0, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Little-endian TIFF with one IFD entry: orientation, a SHORT
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:], 0x0112)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], orientation)
+	tiff = append(tiff, entry...)
+	tiff = append(tiff, 0, 0, 0, 0)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+func TestValidateImage(t *testing.T) {
+	pngData := encodePNG(t, 2, 2)
+
+	// The content decides, not the name or the type the client sent
+	if got, err := ValidateImage(newUpload(pngData, "picture.txt", "text/plain")); err != nil || got != "image/png" {
+		t.Errorf("ValidateImage(png named .txt) = %q, %v; want image/png", got, err)
+	}
+	_, err := ValidateImage(newUpload([]byte("<html>not an image</html>"), "picture.png", "image/png"))
+	if !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("ValidateImage(html named .png) error = %v, want ErrInvalidImage", err)
+	}
+
+	file, header := newUpload(pngData, "big.png", "image/png")
+	header.Size = MaxFileSize + 1
+	if _, err := ValidateImage(file, header); !errors.Is(err, ErrImageFileTooLarge) {
+		t.Errorf("ValidateImage(oversized) error = %v, want ErrImageFileTooLarge", err)
+	}
+}
+
+func TestImageVariant(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		path, size, want string
+	}{
+		{"/static/uploads/" + hash + ".jpg", ImageThumb, "/static/uploads/" + hash + "_thumb.jpg"},
+		{"/static/uploads/" + hash + ".png", ImageMedium, "/static/uploads/" + hash + "_medium.png"},
+		{"/static/uploads/" + hash + ".gif", ImageThumb, "/static/uploads/" + hash + "_thumb.png"},
+		{"/static/uploads/0123abcd.jpg", ImageThumb, "/static/uploads/0123abcd.jpg"},
+		{"/static/uploads/" + hash + ".jpg", "huge", "/static/uploads/" + hash + ".jpg"},
+		{"", ImageThumb, ""},
+	}
+	for _, tt := range tests {
+		if got := ImageVariant(tt.path, tt.size); got != tt.want {
+			t.Errorf("ImageVariant(%q, %q) = %q, want %q", tt.path, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeImage(t *testing.T) {
+	// Orientation 6: the camera was turned, so the image must be rotated
+	// clockwise, moving the red corner to the top right
+	data := encodeJPEG(t, 40, 20, 6)
+	if got := jpegOrientation(data); got != 6 {
+		t.Fatalf("jpegOrientation = %d, want 6", got)
+	}
+	original, sizes, err := EncodeImage(data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("EncodeImage returned error: %v", err)
+	}
+	if bytes.Contains(original, []byte("Exif")) {
+		t.Errorf("EXIF metadata was kept")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Errorf("rotated size = %dx%d, want 20x40", b.Dx(), b.Dy())
+	}
+	if r, g, _, _ := img.At(17, 2).RGBA(); r>>8 < 200 || g>>8 > 60 {
+		t.Errorf("top-right pixel is not red after rotation")
+	}
+	if len(sizes[ImageThumb]) == 0 || len(sizes[ImageMedium]) == 0 {
+		t.Errorf("sizes = %v, want thumb and medium", sizes)
+	}
+
+	_, sizes, err = EncodeImage(encodePNG(t, 2000, 1000), "image/png")
+	if err != nil {
+		t.Fatalf("EncodeImage returned error: %v", err)
+	}
+	for size, want := range map[string]image.Point{ImageThumb: {400, 200}, ImageMedium: {1280, 640}} {
+		config, err := png.DecodeConfig(bytes.NewReader(sizes[size]))
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %v", size, err)
+		}
+		if config.Width != want.X || config.Height != want.Y {
+			t.Errorf("%s is %dx%d, want %dx%d", size, config.Width, config.Height, want.X, want.Y)
+		}
+	}
+
+	if _, _, err := EncodeImage([]byte("not an image"), "image/png"); !errors.Is(err, ErrInvalidImage) {
+		t.Errorf("EncodeImage(garbage) error = %v, want ErrInvalidImage", err)
+	}
+}
+
+func encodeGIF(t *testing.T, w, h, frames int) []byte {
+	t.Helper()
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, w, h), color.Palette{color.Black, color.White}))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncodeImage_GIFFrames(t *testing.T) {
+	data := encodeGIF(t, 40, 20, 3)
+	if frames, ok := gifFrames(data); frames != 3 || !ok {
+		t.Errorf("gifFrames = %d, %v; want 3, true", frames, ok)
+	}
+	if _, ok := gifFrames(data[:len(data)-5]); ok {
+		t.Errorf("gifFrames accepted a truncated GIF")
+	}
+	if _, _, err := EncodeImage(data, "image/gif"); err != nil {
+		t.Errorf("EncodeImage returned error: %v", err)
+	}
+
+	// Each frame is well within the limit, all of them together are not
+	data = encodeGIF(t, 2000, 2000, 13)
+	if _, _, err := EncodeImage(data, "image/gif"); err != ErrImageTooLarge {
+		t.Errorf("EncodeImage(13 frames of 2000x2000) error = %v, want ErrImageTooLarge", err)
+	}
+}
@@ -3,19 +3,57 @@ package utils
 import (
 	"database/sql"
 	"fmt"
+	"log"
 
-	_ "github.com/mattn/go-sqlite3"
+	"forum/config"
 )
 
-var GlobalDB *sql.DB
+// FullTextSearch reports whether search can use the FTS5 index. The SQLite
+// driver only ships FTS5 when built with the sqlite_fts5 tag.
+var FullTextSearch bool
 
-func InitialiseDB() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", "./forum.db")
+// OpenDB opens the database at path without changing its schema.
+func OpenDB(path string) (*sql.DB, error) {
+	return sql.Open(driverName, path)
+}
+
+// InitialiseDB opens the database and brings its schema up to date, see
+// Migrate. Without cfg.MigrateOnStart migrations are left to the migrate
+// command, and the database must be up to date already.
+func InitialiseDB(cfg config.Database) (*sql.DB, error) {
+	db, err := OpenDB(cfg.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	GlobalDB = db
+	if !cfg.MigrateOnStart {
+		err = CheckMigrations(db)
+	} else {
+		var applied []Migration
+		applied, err = Migrate(db)
+		for _, m := range applied {
+			log.Printf("Applied migration %d: %s", m.Version, m.Name)
+		}
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	FullTextSearch, err = searchIndexReady(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to check the search index: %v", err)
+	}
+
+	return db, nil
+}
+
+// createInitialSchema is the first migration: the schema as it was before
+// migrations existed. Databases created back then already have it, so
+// every statement tolerates what already exists.
+func createInitialSchema(db querier) error {
+	var err error
 
 	// Create Users table
 	_, err = db.Exec(`
@@ -32,7 +70,7 @@ func InitialiseDB() (*sql.DB, error) {
         CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
     `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create users table: %v", err)
+		return fmt.Errorf("failed to create users table: %v", err)
 	}
 
 	// Create Posts table
@@ -54,7 +92,7 @@ func InitialiseDB() (*sql.DB, error) {
     CREATE INDEX IF NOT EXISTS idx_posts_post_at ON posts(post_at);
     `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create posts table: %v", err)
+		return fmt.Errorf("failed to create posts table: %v", err)
 	}
 
     _, err = db.Exec(`
@@ -73,7 +111,7 @@ func InitialiseDB() (*sql.DB, error) {
     CREATE INDEX IF NOT EXISTS idx_comments_user_id ON comments(user_id);
     `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create comments table: %v", err)
+		return fmt.Errorf("failed to create comments table: %v", err)
 	}
 
 	// Create Reaction table
@@ -92,7 +130,7 @@ func InitialiseDB() (*sql.DB, error) {
 	CREATE INDEX IF NOT EXISTS idx_reaction_user_id ON reaction(user_id);
 `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create reaction table: %v", err)
+		return fmt.Errorf("failed to create reaction table: %v", err)
 	}
 
 	// Create Triggers
@@ -113,7 +151,7 @@ BEGIN
 END;
 `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create triggers: %v", err)
+		return fmt.Errorf("failed to create triggers: %v", err)
 	}
 
 	_, err = db.Exec(`
@@ -135,7 +173,7 @@ BEGIN
 END;
 `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create triggers: %v", err)
+		return fmt.Errorf("failed to create triggers: %v", err)
 	}
 
 	_, err = db.Exec(`
@@ -155,7 +193,7 @@ BEGIN
 END;
 `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create triggers: %v", err)
+		return fmt.Errorf("failed to create triggers: %v", err)
 	}
 
 	_, err = db.Exec(`
@@ -173,47 +211,14 @@ END;
     CREATE INDEX IF NOT EXISTS idx_notifications_user_id ON notifications(user_id);
 `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create notifications table: %v", err)
+		return fmt.Errorf("failed to create notifications table: %v", err)
 	}
 
-	// Add these triggers after notifications table creation
-	_, err = db.Exec(`
-CREATE TRIGGER IF NOT EXISTS AfterPostReaction
-AFTER INSERT ON reaction
-BEGIN
-    INSERT INTO notifications (user_id, actor_id, post_id, type)
-    SELECT 
-        p.user_id,     -- Post owner (receiver of notification)
-        NEW.user_id,   -- Person who reacted (actor)
-        NEW.post_id,   -- Post that was reacted to
-        CASE 
-            WHEN NEW.like = 1 THEN 'like'
-            ELSE 'dislike'
-        END
-    FROM posts p
-    WHERE p.id = NEW.post_id
-    AND p.user_id != NEW.user_id; -- Don't notify if user reacts to their own post
-END;
-
-CREATE TRIGGER IF NOT EXISTS AfterPostComment
-AFTER INSERT ON comments
-BEGIN
-    INSERT INTO notifications (user_id, actor_id, post_id, type)
-    SELECT 
-        p.user_id,     -- Post owner (receiver of notification)
-        NEW.user_id,   -- Person who commented (actor)
-        NEW.post_id,   -- Post that was commented on
-        'comment'
-    FROM posts p
-    WHERE p.id = NEW.post_id
-    AND p.user_id != NEW.user_id; -- Don't notify if user comments on their own post
-END;
-`)
+	_, err = db.Exec(initialNotificationTriggers)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create triggers: %v", err)
+		return fmt.Errorf("failed to create triggers: %v", err)
 	}
 
-	
 	// Create Comment Reaction table
 	_, err = db.Exec(`
     CREATE TABLE IF NOT EXISTS comment_reaction (
@@ -230,7 +235,7 @@ END;
     CREATE INDEX IF NOT EXISTS idx_comment_reaction_user_id ON comment_reaction(user_id);
 `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create comment_reaction table: %v", err)
+		return fmt.Errorf("failed to create comment_reaction table: %v", err)
 	}
 
 	// Create triggers to update comment likes/dislikes after insert, update and delete
@@ -246,7 +251,7 @@ END;
 
 `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create trigger AfterCommentReactionInsert: %v", err)
+		return fmt.Errorf("failed to create trigger AfterCommentReactionInsert: %v", err)
 	}
 
 	_, err = db.Exec(`
@@ -269,7 +274,7 @@ END;
 
 `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create trigger AfterCommentReactionUpdate: %v", err)
+		return fmt.Errorf("failed to create trigger AfterCommentReactionUpdate: %v", err)
 	}
 
 	_, err = db.Exec(`
@@ -284,7 +289,7 @@ END;
 
 `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create trigger AfterCommentReactionDelete: %v", err)
+		return fmt.Errorf("failed to create trigger AfterCommentReactionDelete: %v", err)
 	}
 
 	_, err = db.Exec(`
@@ -294,11 +299,11 @@ END;
     );
     `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create categories table: %v", err)
+		return fmt.Errorf("failed to create categories table: %v", err)
 	}
-	err = InsertDefaultCategories()
+	err = InsertDefaultCategories(db)
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert default categories: %v", err)
+		return fmt.Errorf("failed to insert default categories: %v", err)
 	}
 
 	_, err = db.Exec(`
@@ -312,7 +317,7 @@ END;
     CREATE INDEX IF NOT EXISTS idx_post_categories_category_id ON post_categories(category_id);
     `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create post_categories table: %v", err)
+		return fmt.Errorf("failed to create post_categories table: %v", err)
 	}
 
 	_, err = db.Exec(`
@@ -326,13 +331,134 @@ END;
     CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
     `)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create sessions table: %v", err)
+		return fmt.Errorf("failed to create sessions table: %v", err)
 	}
 
-	return db, nil
+	return nil
+}
+
+// initialNotificationTriggers notify post authors of reactions and
+// comments. Migration 10 replaces them.
+const initialNotificationTriggers = `
+CREATE TRIGGER IF NOT EXISTS AfterPostReaction
+AFTER INSERT ON reaction
+BEGIN
+    INSERT INTO notifications (user_id, actor_id, post_id, type)
+    SELECT 
+        p.user_id,     -- Post owner (receiver of notification)
+        NEW.user_id,   -- Person who reacted (actor)
+        NEW.post_id,   -- Post that was reacted to
+        CASE 
+            WHEN NEW.like = 1 THEN 'like'
+            ELSE 'dislike'
+        END
+    FROM posts p
+    WHERE p.id = NEW.post_id
+    AND p.user_id != NEW.user_id; -- Don't notify if user reacts to their own post
+END;
+
+CREATE TRIGGER IF NOT EXISTS AfterPostComment
+AFTER INSERT ON comments
+BEGIN
+    INSERT INTO notifications (user_id, actor_id, post_id, type)
+    SELECT 
+        p.user_id,     -- Post owner (receiver of notification)
+        NEW.user_id,   -- Person who commented (actor)
+        NEW.post_id,   -- Post that was commented on
+        'comment'
+    FROM posts p
+    WHERE p.id = NEW.post_id
+    AND p.user_id != NEW.user_id; -- Don't notify if user comments on their own post
+END;
+`
+
+// dropInitialSchema undoes createInitialSchema, leaving an empty database.
+// The search index goes too, as it indexes the tables dropped.
+var dropInitialSchema = execSQL(`
+    DROP TABLE IF EXISTS posts_fts;
+    DROP TABLE IF EXISTS comments_fts;
+    DROP TABLE sessions;
+    DROP TABLE post_categories;
+    DROP TABLE categories;
+    DROP TABLE comment_reaction;
+    DROP TABLE notifications;
+    DROP TABLE reaction;
+    DROP TABLE comments;
+    DROP TABLE posts;
+    DROP TABLE users;
+    `)
+
+// fts5Available reports whether the driver was built with FTS5.
+func fts5Available(db querier) (bool, error) {
+	var available bool
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available)
+	return available, err
+}
+
+// searchIndexReady reports whether this build can search with the index the
+// search index migration creates. Databases migrated by a build without FTS5
+// do not have the index; rolling that migration back and applying it again
+// with an FTS5 build creates it.
+func searchIndexReady(db *sql.DB) (bool, error) {
+	available, err := fts5Available(db)
+	if err != nil || !available {
+		if err == nil {
+			log.Println("FTS5 is not available, search falls back to substring matching")
+		}
+		return false, err
+	}
+
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'posts_fts')").Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		log.Printf("The search index was not created, search falls back to substring matching; run migrate to %d and migrate up with this build to create it", searchIndexVersion-1)
+	}
+	return exists, nil
+}
+
+// addColumnIfMissing adds a column to a table that already exists. CREATE TABLE
+// IF NOT EXISTS leaves existing tables untouched, so columns introduced after a
+// database was first created have to be added explicitly.
+func addColumnIfMissing(db querier, table, column, definition string) error {
+	exists, err := columnExists(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+func columnExists(db querier, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
 }
 
-func InsertDefaultCategories() error {
+func InsertDefaultCategories(db execer) error {
 	categories := []string{
 		"Tech",
 		"Programming",
@@ -344,7 +470,7 @@ func InsertDefaultCategories() error {
 	}
 
 	for _, category := range categories {
-		_, err := GlobalDB.Exec("INSERT OR IGNORE INTO categories (name) VALUES (?)", category)
+		_, err := db.Exec("INSERT OR IGNORE INTO categories (name) VALUES (?)", category)
 		if err != nil {
 			return fmt.Errorf("failed to insert category %s: %v", category, err)
 		}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/config"
+)
+
+// Mailer sends plain text email.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends mail through an SMTP server. Authentication is skipped
+// when Username is empty.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	msg, err := formatMessage(m.From, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, msg)
+}
+
+// LogMailer writes messages to a file instead of sending them, or to the log
+// when Path is empty. It is meant for development and tests.
+type LogMailer struct {
+	Path string
+	From string
+
+	mu sync.Mutex
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	msg, err := formatMessage(m.From, to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	if m.Path == "" {
+		log.Printf("Mail to %s:\n%s", to, msg)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(msg, "\r\n"...))
+	return err
+}
+
+// NewMailer returns an SMTPMailer when cfg has an SMTP host and a
+// LogMailer writing to cfg.LogFile otherwise.
+func NewMailer(cfg config.Mail) Mailer {
+	if cfg.SMTPHost != "" {
+		return &SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}
+	}
+	return &LogMailer{Path: cfg.LogFile, From: cfg.From}
+}
+
+// formatMessage builds an RFC 5322 message. Header values containing line
+// breaks are rejected so they cannot inject extra headers.
+func formatMessage(from, to, subject, body string) ([]byte, error) {
+	for _, v := range []string{from, to, subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("invalid mail header value %q", v)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatMessage_RejectsHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name, to, subject string
+	}{
+		{"Subject", "user@example.com", "Hello\r\nBcc: victim@example.com"},
+		{"To", "user@example.com\nBcc: victim@example.com", "Hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := formatMessage("forum@localhost", tt.to, tt.subject, "body"); err == nil {
+				t.Error("formatMessage accepted a header value with a line break")
+			}
+		})
+	}
+}
+
+func TestLogMailer_AppendsToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := &LogMailer{Path: path, From: "forum@localhost"}
+
+	if err := m.Send("a@example.com", "First", "one"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := m.Send("b@example.com", "Second", "two\nlines"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read mail log: %v", err)
+	}
+	for _, want := range []string{"To: a@example.com", "Subject: First", "To: b@example.com", "two\r\nlines"} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("mail log is missing %q", want)
+		}
+	}
+}
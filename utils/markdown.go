@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	mdhtml "github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// Kinds of content whose rendered HTML is cached.
+const (
+	ContentPost    = "post"
+	ContentComment = "comment"
+)
+
+// markdownVersion is part of every cache key. Change it when the rendering
+// changes so cached HTML is rebuilt.
+const markdownVersion = "1"
+
+// Markdown extensions for posts and comments.
+const markdownExtensions = parser.NoIntraEmphasis | parser.Tables | parser.FencedCode |
+	parser.Autolink | parser.Strikethrough | parser.SpaceHeadings | parser.BackslashLineBreak
+
+// codeFormatter highlights fenced code with CSS classes. static/highlight.css
+// holds the rules for codeStyle, written by WriteHighlightCSS.
+var (
+	codeFormatter = chromahtml.New(chromahtml.WithClasses(true))
+	codeStyle     = styles.Get("github")
+)
+
+// WriteHighlightCSS writes the stylesheet for highlighted code.
+func WriteHighlightCSS(w io.Writer) error {
+	return codeFormatter.WriteCSS(w, codeStyle)
+}
+
+// markdownPolicy is the allowlist rendered HTML is sanitised against. Raw
+// HTML in the source is already dropped by the renderer; the policy makes
+// sure nothing else gets through either.
+var markdownPolicy = newMarkdownPolicy()
+
+func newMarkdownPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "strong", "em", "del", "blockquote", "hr",
+		"h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "li", "pre", "code",
+		"table", "thead", "tbody", "tr", "th", "td")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^mention$`)).OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.AllowRelativeURLs(true)
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnFullyQualifiedLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+
+	// Token classes from the highlighter
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-z0-9]+$`)).OnElements("pre", "span")
+	return p
+}
+
+// MentionLookup finds the users @username mentions link to.
+type MentionLookup interface {
+	// UserIDs maps the usernames that belong to an account to its ID.
+	UserIDs(usernames []string) (map[string]string, error)
+}
+
+// RenderCache keeps the HTML rendered for posts and comments, keyed by the
+// revision it was made from.
+type RenderCache interface {
+	MentionLookup
+	// Rendered returns the HTML cached for a post or comment, with ok false
+	// unless it was made from revision.
+	Rendered(kind string, id int, revision string) (html string, ok bool, err error)
+	SaveRendered(kind string, id int, revision, html string) error
+}
+
+// RenderMarkdown renders text as sanitised HTML, with fenced code
+// highlighted and @username mentions of existing users linked to their
+// profiles.
+func RenderMarkdown(users MentionLookup, text string) template.HTML {
+	ids, err := mentionedUsers(users, text)
+	if err != nil {
+		log.Printf("Error looking up mentioned users: %v", err)
+	}
+	return renderMarkdown(text, ids)
+}
+
+// mentionedUsers looks up the users text mentions, if it mentions any.
+func mentionedUsers(users MentionLookup, text string) (map[string]string, error) {
+	names := ParseMentions(text)
+	if len(names) == 0 {
+		return map[string]string{}, nil
+	}
+	return users.UserIDs(names)
+}
+
+// renderMarkdown renders text, linking the mentions of users, which maps
+// usernames to IDs.
+func renderMarkdown(text string, users map[string]string) template.HTML {
+	renderer := mdhtml.NewRenderer(mdhtml.RendererOptions{
+		Flags: mdhtml.SkipHTML | mdhtml.Safelink,
+		RenderNodeHook: func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+			switch node := node.(type) {
+			case *ast.Text:
+				// Links cannot be nested, so mentions inside one stay text
+				if inLink(node) {
+					return ast.GoToNext, false
+				}
+				io.WriteString(w, linkMentions(string(node.Literal), users))
+				return ast.GoToNext, true
+			case *ast.CodeBlock:
+				highlightCode(w, string(node.Literal), string(node.Info))
+				return ast.GoToNext, true
+			}
+			return ast.GoToNext, false
+		},
+	})
+
+	doc := parser.NewWithExtensions(markdownExtensions).Parse([]byte(text))
+	return template.HTML(markdownPolicy.SanitizeBytes(markdown.Render(doc, renderer)))
+}
+
+func inLink(node ast.Node) bool {
+	for parent := node.GetParent(); parent != nil; parent = parent.GetParent() {
+		if _, ok := parent.(*ast.Link); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightCode writes a fenced code block, highlighted when its info string
+// names a language the highlighter knows.
+func highlightCode(w io.Writer, code, info string) {
+	var lexer chroma.Lexer
+	if fields := strings.Fields(info); len(fields) > 0 {
+		lexer = lexers.Get(fields[0])
+	}
+	if lexer != nil {
+		var buf bytes.Buffer
+		iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+		if err == nil {
+			err = codeFormatter.Format(&buf, codeStyle, iterator)
+		}
+		if err == nil {
+			w.Write(buf.Bytes())
+			return
+		}
+		log.Printf("Error highlighting code: %v", err)
+	}
+	io.WriteString(w, "<pre><code>"+template.HTMLEscapeString(code)+"</code></pre>\n")
+}
+
+// RenderContent returns the HTML for a post or comment, rendering it only
+// when text, or a user it mentions, has changed since the cached copy was
+// made. kind is ContentPost or ContentComment.
+func RenderContent(cache RenderCache, kind string, id int, text string) template.HTML {
+	users, err := mentionedUsers(cache, text)
+	if err != nil {
+		// Not cached, so the links appear once the lookup works again
+		log.Printf("Error looking up mentioned users: %v", err)
+		return renderMarkdown(text, nil)
+	}
+	revision := contentRevision(text, users)
+
+	cached, ok, err := cache.Rendered(kind, id, revision)
+	if err != nil {
+		log.Printf("Error reading rendered %s %d: %v", kind, id, err)
+	} else if ok {
+		return template.HTML(cached)
+	}
+
+	rendered := renderMarkdown(text, users)
+	if err := cache.SaveRendered(kind, id, revision, string(rendered)); err != nil {
+		log.Printf("Error caching rendered %s %d: %v", kind, id, err)
+	}
+	return rendered
+}
+
+// contentRevision identifies what the HTML of text is made from: the
+// renderer, the text and the users its mentions link to, who may sign up,
+// be renamed or be deleted after the text was written.
+func contentRevision(text string, users map[string]string) string {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	io.WriteString(h, markdownVersion+"\x00"+text)
+	for _, name := range names {
+		io.WriteString(h, "\x00"+name+"="+users[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
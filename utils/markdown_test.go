@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "Formatting",
+			text:     "**bold** and *italic*\n\n- one\n- two",
+			contains: []string{"<strong>bold</strong>", "<em>italic</em>", "<li>one</li>"},
+		},
+		{
+			name:     "Backslash line break",
+			text:     "first\\\nsecond",
+			contains: []string{"first<br"},
+		},
+		{
+			name:     "Raw HTML is dropped",
+			text:     "<script>alert(1)</script><img src=x onerror=alert(1)>hello",
+			contains: []string{"hello"},
+			excludes: []string{"<script", "<img", "onerror"},
+		},
+		{
+			name:     "Unsafe link schemes",
+			text:     "[click](javascript:alert(1))",
+			excludes: []string{"javascript:", "<a"},
+		},
+		{
+			name:     "External links",
+			text:     "see https://example.com",
+			contains: []string{`href="https://example.com"`, `rel="nofollow noopener"`, `target="_blank"`},
+		},
+		{
+			name:     "Highlighted code",
+			text:     "```go\nfunc main() {}\n```",
+			contains: []string{`<pre class="chroma">`, `<span class="kd">func</span>`},
+		},
+		{
+			name:     "Code in an unknown language",
+			text:     "```nosuchlanguage\n<b>x</b>\n```",
+			contains: []string{"<pre><code>&lt;b&gt;x&lt;/b&gt;"},
+		},
+		{
+			name:     "Inline code",
+			text:     "use `<div>`",
+			contains: []string{"<code>&lt;div&gt;</code>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(RenderMarkdown(nil, tt.text))
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("RenderMarkdown(%q) = %q, want it to contain %q", tt.text, got, want)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("RenderMarkdown(%q) = %q, should not contain %q", tt.text, got, unwanted)
+				}
+			}
+		})
+	}
+}
+
+// static/highlight.css must match the style the highlighter uses.
+func TestHighlightCSSUpToDate(t *testing.T) {
+	want, err := os.ReadFile("../static/highlight.css")
+	if err != nil {
+		t.Fatalf("Failed to read highlight.css: %v", err)
+	}
+	var got bytes.Buffer
+	if err := WriteHighlightCSS(&got); err != nil {
+		t.Fatalf("WriteHighlightCSS returned error: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Errorf("static/highlight.css is out of date; regenerate it with WriteHighlightCSS")
+	}
+}
+
+// renderCache is a RenderCache over a map of users, by name.
+type renderCache struct {
+	users map[string]string
+	html  map[string]string // By kind and ID
+	saved map[string]string // Revision each HTML was made from
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{users: map[string]string{}, html: map[string]string{}, saved: map[string]string{}}
+}
+
+func (c *renderCache) UserIDs(names []string) (map[string]string, error) {
+	ids := make(map[string]string)
+	for _, name := range names {
+		if id, ok := c.users[name]; ok {
+			ids[name] = id
+		}
+	}
+	return ids, nil
+}
+
+func (c *renderCache) Rendered(kind string, id int, revision string) (string, bool, error) {
+	key := fmt.Sprint(kind, id)
+	return c.html[key], c.saved[key] == revision, nil
+}
+
+func (c *renderCache) SaveRendered(kind string, id int, revision, html string) error {
+	key := fmt.Sprint(kind, id)
+	c.html[key], c.saved[key] = html, revision
+	return nil
+}
+
+func TestRenderContent_Mentions(t *testing.T) {
+	cache := newRenderCache()
+	mentionsBob := func() bool {
+		t.Helper()
+		return strings.Contains(string(RenderContent(cache, ContentPost, 1, "Thanks @bob")), `class="mention"`)
+	}
+
+	if mentionsBob() {
+		t.Errorf("mention of a missing user was linked")
+	}
+	// The cached HTML is rebuilt when the mentioned user signs up, is
+	// renamed and is deleted
+	cache.users["bob"] = "u1"
+	if !mentionsBob() {
+		t.Errorf("mention was not linked after bob signed up")
+	}
+	delete(cache.users, "bob")
+	cache.users["robert"] = "u1"
+	if mentionsBob() {
+		t.Errorf("mention still linked after bob was renamed")
+	}
+	cache.users = map[string]string{"bob": "u1"}
+	mentionsBob()
+	cache.users = map[string]string{}
+	if mentionsBob() {
+		t.Errorf("mention still linked after bob was deleted")
+	}
+}
+
+func TestRenderContent_Cache(t *testing.T) {
+	cache := newRenderCache()
+	if html := RenderContent(cache, ContentPost, 1, "*first*"); !strings.Contains(string(html), "<em>first</em>") {
+		t.Errorf("RenderContent = %q", html)
+	}
+
+	// Cached HTML is served until the text changes
+	for key := range cache.html {
+		cache.html[key] = "cached"
+	}
+	if html := RenderContent(cache, ContentPost, 1, "*first*"); html != "cached" {
+		t.Errorf("unchanged text rendered again: %q", html)
+	}
+	if html := RenderContent(cache, ContentPost, 1, "*second*"); !strings.Contains(string(html), "<em>second</em>") {
+		t.Errorf("edited text served stale HTML: %q", html)
+	}
+}
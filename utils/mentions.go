@@ -0,0 +1,140 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// mentionPattern matches @username where the @ does not follow a word
+// character, so email addresses are not taken for mentions.
+var mentionPattern = regexp.MustCompile(`(^|[^\p{L}\p{N}_@.])@([\p{L}\p{N}_][\p{L}\p{N}_.-]*)`)
+
+// ParseMentions returns the usernames mentioned in text, each once and in
+// the order they first appear. Punctuation ending a sentence is not taken
+// as part of the name.
+func ParseMentions(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		name := strings.TrimRight(m[2], ".-")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// LookupUsernames maps the usernames that belong to an account to its ID.
+func LookupUsernames(db *sql.DB, names []string) (map[string]string, error) {
+	ids := make(map[string]string)
+	if len(names) == 0 {
+		return ids, nil
+	}
+
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	rows, err := db.Query("SELECT id, username FROM users WHERE username IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		ids[name] = id
+	}
+	return ids, rows.Err()
+}
+
+// RecordMentions stores who a post, or a comment when commentID is not 0,
+// mentions and sends a mention notification to each user mentioned for the
+// first time. Editing the text does not notify users it already mentioned.
+// Users are not notified if they block the author or turned mention
+// notifications off.
+func RecordMentions(db *sql.DB, actorID string, postID, commentID int, text string) error {
+	users, err := LookupUsernames(db, ParseMentions(text))
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	known := make(map[string]bool)
+	rows, err := tx.Query("SELECT user_id FROM mentions WHERE post_id = ? AND comment_id = ?", postID, commentID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		known[userID] = true
+	}
+	rows.Close()
+
+	if _, err := tx.Exec("DELETE FROM mentions WHERE post_id = ? AND comment_id = ?", postID, commentID); err != nil {
+		return err
+	}
+	for _, userID := range users {
+		_, err := tx.Exec("INSERT INTO mentions (post_id, comment_id, user_id) VALUES (?, ?, ?)", postID, commentID, userID)
+		if err != nil {
+			return err
+		}
+		if known[userID] || userID == actorID {
+			continue
+		}
+		_, err = tx.Exec(`
+            INSERT INTO notifications (user_id, actor_id, post_id, type, delivery)
+            SELECT ?, ?, ?, 'mention', COALESCE(np.delivery, 'in_app')
+            FROM (SELECT 1)
+            LEFT JOIN notification_preferences np ON np.user_id = ? AND np.type = 'mention'
+            WHERE COALESCE(np.delivery, 'in_app') != 'off'
+            AND NOT EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?)
+        `, userID, actorID, postID, userID, userID, actorID)
+		if err != nil {
+			return fmt.Errorf("notifying mentioned user: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// linkMentions escapes text for HTML and links each @username found in
+// users to its profile.
+func linkMentions(text string, users map[string]string) string {
+	if len(users) == 0 {
+		return template.HTMLEscapeString(text)
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, m := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		// m[4]:m[5] is the name, with the @ just before it
+		nameStart := m[4]
+		name := strings.TrimRight(text[nameStart:m[5]], ".-")
+		userID, ok := users[name]
+		if !ok {
+			continue
+		}
+		out.WriteString(template.HTMLEscapeString(text[last : nameStart-1]))
+		fmt.Fprintf(&out, `<a href="/profile/%s" class="mention">@%s</a>`,
+			template.HTMLEscapeString(userID), template.HTMLEscapeString(name))
+		last = nameStart + len(name)
+	}
+	out.WriteString(template.HTMLEscapeString(text[last:]))
+	return out.String()
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"No mentions", "hello world", nil},
+		{"Start of text", "@alice hi", []string{"alice"}},
+		{"Trailing punctuation", "thanks @bob.", []string{"bob"}},
+		{"Dots inside name", "cc @jane.doe-2, please", []string{"jane.doe-2"}},
+		{"Duplicates", "@alice and @bob and @alice", []string{"alice", "bob"}},
+		{"Email address", "mail me at carol@example.com", nil},
+		{"Double at", "@@dave", nil},
+		{"Unicode", "(@Zoë)", []string{"Zoë"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseMentions(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMentions(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so the schema can be
+// inspected and changed inside a migration's transaction.
+type querier interface {
+	execer
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Migration is a numbered change to the database schema. Up and Down each
+// run in a transaction, together with the update to schema_migrations, so a
+// migration that fails leaves nothing behind.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error // nil if the migration cannot be undone
+}
+
+// migrations lists every migration in version order. Add new ones at the
+// end with the next version; never change one that has been released, as
+// databases that already ran it would not see the change.
+var migrations = []Migration{
+	{1, "initial schema", func(tx *sql.Tx) error { return createInitialSchema(tx) }, dropInitialSchema},
+	{2, "post revisions", addPostRevisions, dropPostRevisions},
+	{3, "comment replies", addCommentReplies, dropCommentReplies},
+	{4, "user roles", addUserRoles, dropUserRoles},
+	{5, "reports and moderation log", addModeration, dropModeration},
+	{6, "session details", addSessionDetails, dropSessionDetails},
+	{7, "email verification", addEmailVerification, dropEmailVerification},
+	{8, "CSRF tokens", addCSRFTokens, dropCSRFTokens},
+	{9, "user identities", addUserIdentities, dropUserIdentities},
+	{10, "notification state and preferences", addNotificationPreferences, dropNotificationPreferences},
+	{11, "mentions and blocks", addMentions, dropMentions},
+	{12, "rendered content", addRenderedContent, dropRenderedContent},
+	{13, "uploads served from /uploads/", moveUploads, unmoveUploads},
+	{searchIndexVersion, "search index", addSearchIndex, dropSearchIndex},
+}
+
+// searchIndexVersion is the migration that creates the FTS5 search index.
+const searchIndexVersion = 14
+
+// execSQL returns a migration step that runs statements as they are.
+func execSQL(statements string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+// MigrationState is a migration and whether it has been applied. Versions
+// recorded in the database that this build does not know have no Up.
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrate applies every pending migration. It returns the migrations applied.
+func Migrate(db *sql.DB) ([]Migration, error) {
+	return migrateTo(db, migrations, migrations[len(migrations)-1].Version)
+}
+
+// MigrateTo applies or rolls back migrations until version is the last one
+// applied. Version 0 rolls back everything that can be.
+func MigrateTo(db *sql.DB, version int) ([]Migration, error) {
+	return migrateTo(db, migrations, version)
+}
+
+// Rollback undoes the last steps applied migrations, latest first.
+func Rollback(db *sql.DB, steps int) ([]Migration, error) {
+	return rollback(db, migrations, steps)
+}
+
+// MigrationStatus returns every known migration, and any unknown ones the
+// database has, in version order.
+func MigrationStatus(db *sql.DB) ([]MigrationState, error) {
+	return migrationStatus(db, migrations)
+}
+
+// CheckMigrations returns an error unless the database has exactly the
+// migrations this build knows.
+func CheckMigrations(db *sql.DB) error {
+	states, err := MigrationStatus(db)
+	if err != nil {
+		return err
+	}
+	for _, state := range states {
+		if state.Up == nil {
+			return fmt.Errorf("database has migration %d, which this build does not know", state.Version)
+		}
+		if !state.Applied {
+			return fmt.Errorf("migration %d (%s) is pending, run: go run . migrate up", state.Version, state.Name)
+		}
+	}
+	return nil
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at DATETIME NOT NULL
+    );
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+	return nil
+}
+
+func migrationStatus(db *sql.DB, list []Migration) ([]MigrationState, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationState)
+	for rows.Next() {
+		var state MigrationState
+		if err := rows.Scan(&state.Version, &state.Name, &state.AppliedAt); err != nil {
+			return nil, err
+		}
+		state.Applied = true
+		applied[state.Version] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var states []MigrationState
+	for _, m := range list {
+		state := MigrationState{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			state.Applied, state.AppliedAt = true, a.AppliedAt
+			delete(applied, m.Version)
+		}
+		states = append(states, state)
+	}
+	// Left by a newer build
+	for _, state := range applied {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+func migrateTo(db *sql.DB, list []Migration, version int) ([]Migration, error) {
+	states, err := migrationStatus(db, list)
+	if err != nil {
+		return nil, err
+	}
+	for _, state := range states {
+		if state.Up == nil {
+			return nil, fmt.Errorf("database has migration %d, which this build does not know; it was migrated by a newer version", state.Version)
+		}
+	}
+
+	var done []Migration
+	for _, state := range states {
+		if state.Version <= version && !state.Applied {
+			if err := runMigration(db, state.Migration, true); err != nil {
+				return done, err
+			}
+			done = append(done, state.Migration)
+		}
+	}
+	for i := len(states) - 1; i >= 0; i-- {
+		state := states[i]
+		if state.Version > version && state.Applied {
+			if err := runMigration(db, state.Migration, false); err != nil {
+				return done, err
+			}
+			done = append(done, state.Migration)
+		}
+	}
+	return done, nil
+}
+
+func rollback(db *sql.DB, list []Migration, steps int) ([]Migration, error) {
+	states, err := migrationStatus(db, list)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(states) - 1; i >= 0 && len(done) < steps; i-- {
+		state := states[i]
+		if !state.Applied {
+			continue
+		}
+		if state.Up == nil {
+			return done, fmt.Errorf("database has migration %d, which this build does not know; roll it back with the build that applied it", state.Version)
+		}
+		if err := runMigration(db, state.Migration, false); err != nil {
+			return done, err
+		}
+		done = append(done, state.Migration)
+	}
+	return done, nil
+}
+
+// runMigration applies m, or undoes it when up is false, and records the
+// change in schema_migrations in the same transaction.
+func runMigration(db *sql.DB, m Migration, up bool) error {
+	step, verb := m.Up, "apply"
+	if !up {
+		step, verb = m.Down, "roll back"
+	}
+	if step == nil {
+		return fmt.Errorf("migration %d (%s) cannot be rolled back", m.Version, m.Name)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := step(tx); err != nil {
+		return fmt.Errorf("failed to %s migration %d (%s): %v", verb, m.Version, m.Name, err)
+	}
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+			m.Version, m.Name, time.Now())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record migration %d: %v", m.Version, err)
+	}
+	return tx.Commit()
+}
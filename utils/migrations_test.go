@@ -0,0 +1,267 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open(driverName, filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", name).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	db := openTestDB(t)
+	list := []Migration{
+		{1, "create a", execSQL("CREATE TABLE a (id INTEGER)"), execSQL("DROP TABLE a")},
+		{2, "create b", execSQL("CREATE TABLE b (id INTEGER)"), execSQL("DROP TABLE b")},
+		{3, "add b.name", execSQL("ALTER TABLE b ADD COLUMN name TEXT"), execSQL("ALTER TABLE b DROP COLUMN name")},
+	}
+
+	done, err := migrateTo(db, list, 3)
+	if err != nil || len(done) != 3 {
+		t.Fatalf("migrating up applied %d migrations, error %v", len(done), err)
+	}
+	if ok, _ := columnExists(db, "b", "name"); !ok {
+		t.Errorf("b.name was not added")
+	}
+	if done, err := migrateTo(db, list, 3); err != nil || len(done) != 0 {
+		t.Errorf("migrating an up to date database applied %d migrations, error %v", len(done), err)
+	}
+
+	done, err = rollback(db, list, 2)
+	if err != nil || len(done) != 2 || done[0].Version != 3 || done[1].Version != 2 {
+		t.Fatalf("rolling back 2 steps undid %v, error %v", done, err)
+	}
+	if tableExists(t, db, "b") || !tableExists(t, db, "a") {
+		t.Errorf("rollback left the wrong tables")
+	}
+
+	states, err := migrationStatus(db, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 3 || !states[0].Applied || states[1].Applied || states[2].Applied {
+		t.Errorf("status after rollback = %+v", states)
+	}
+	if states[0].AppliedAt.IsZero() {
+		t.Errorf("applied migration has no time")
+	}
+
+	if done, err := migrateTo(db, list, 2); err != nil || len(done) != 1 || !tableExists(t, db, "b") {
+		t.Errorf("migrating to 2 applied %v, error %v", done, err)
+	}
+}
+
+func TestMigrationFailureRollsBack(t *testing.T) {
+	db := openTestDB(t)
+	list := []Migration{
+		{1, "create a", execSQL("CREATE TABLE a (id INTEGER)"), nil},
+		{2, "broken", func(tx *sql.Tx) error {
+			if _, err := tx.Exec("CREATE TABLE b (id INTEGER)"); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		}, nil},
+	}
+
+	done, err := migrateTo(db, list, 2)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("migrateTo error = %v, want the migration's error", err)
+	}
+	if len(done) != 1 || tableExists(t, db, "b") {
+		t.Errorf("a failed migration left changes behind: applied %v", done)
+	}
+	states, _ := migrationStatus(db, list)
+	if !states[0].Applied || states[1].Applied {
+		t.Errorf("status after failure = %+v", states)
+	}
+
+	// Migrations without Down cannot be rolled back
+	if _, err := rollback(db, list, 1); err == nil || !tableExists(t, db, "a") {
+		t.Errorf("rolled back an irreversible migration, error %v", err)
+	}
+}
+
+func TestMigrateUnknownVersion(t *testing.T) {
+	db := openTestDB(t)
+	list := []Migration{{1, "create a", execSQL("CREATE TABLE a (id INTEGER)"), nil}}
+	if _, err := migrateTo(db, list, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (5, 'future', CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrateTo(db, list, 1); err == nil {
+		t.Errorf("migrated a database that has a migration from a newer build")
+	}
+	states, err := migrationStatus(db, list)
+	if err != nil || len(states) != 2 || states[1].Version != 5 || states[1].Up != nil {
+		t.Errorf("status = %+v, %v; want the unknown migration listed last", states, err)
+	}
+}
+
+// Databases created before migrations existed already have most of the
+// schema; the migrations complete them.
+func TestMigrationsOnExistingDatabase(t *testing.T) {
+	db := openTestDB(t)
+	_, err := db.Exec(`
+    CREATE TABLE users (
+        id TEXT PRIMARY KEY NOT NULL,
+        email TEXT UNIQUE,
+        username TEXT UNIQUE,
+        authoriser TEXT,
+        password TEXT,
+        profile_pic TEXT
+    );
+    INSERT INTO users (id, email, username, profile_pic) VALUES ('u1', 'a@example.com', 'alice', '/static/uploads/a.png');
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("migrations failed on an existing database: %v", err)
+	}
+	var role, pic string
+	var verified sql.NullTime
+	err = db.QueryRow("SELECT role, profile_pic, email_verified_at FROM users WHERE id = 'u1'").Scan(&role, &pic, &verified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role != RoleMember || pic != "/uploads/a.png" || !verified.Valid {
+		t.Errorf("existing user migrated to role %q, picture %q, verified %v", role, pic, verified.Valid)
+	}
+	for _, table := range []string{"posts", "sessions", "rendered_content"} {
+		if !tableExists(t, db, table) {
+			t.Errorf("table %s was not created", table)
+		}
+	}
+}
+
+// schema returns the definitions of everything in the database but the
+// migrations table, by name.
+func schema(t *testing.T, db *sql.DB) map[string]string {
+	t.Helper()
+	rows, err := db.Query("SELECT type || ' ' || name, COALESCE(sql, '') FROM sqlite_master WHERE name NOT IN ('schema_migrations', 'sqlite_sequence')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	objects := map[string]string{}
+	for rows.Next() {
+		var name, definition string
+		if err := rows.Scan(&name, &definition); err != nil {
+			t.Fatal(err)
+		}
+		objects[name] = strings.Join(strings.Fields(definition), " ")
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return objects
+}
+
+// Rolling back to any version leaves the schema a database migrated
+// straight to that version has, and migrating up again restores the rest.
+func TestMigrationsRollBack(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	latest := schema(t, db)
+
+	for version := len(migrations) - 1; version >= 0; version-- {
+		if _, err := MigrateTo(db, version); err != nil {
+			t.Fatalf("rolling back to %d: %v", version, err)
+		}
+		fresh := openTestDB(t)
+		if _, err := migrateTo(fresh, migrations, version); err != nil {
+			t.Fatal(err)
+		}
+		got, want := schema(t, db), schema(t, fresh)
+		for name, definition := range want {
+			if got[name] != definition {
+				t.Errorf("after rolling back to %d, %s is %q, want %q", version, name, got[name], definition)
+			}
+		}
+		for name := range got {
+			if _, ok := want[name]; !ok {
+				t.Errorf("rolling back to %d left %s behind", version, name)
+			}
+		}
+	}
+
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("migrating up again: %v", err)
+	}
+	got := schema(t, db)
+	for name, definition := range latest {
+		if got[name] != definition {
+			t.Errorf("after migrating up again, %s is %q, want %q", name, got[name], definition)
+		}
+	}
+	if len(got) != len(latest) {
+		t.Errorf("migrating up again made %d objects, want %d", len(got), len(latest))
+	}
+}
+
+// The search index migration indexes posts written before it, and does
+// nothing when the driver lacks FTS5.
+func TestSearchIndexMigration(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := MigrateTo(db, searchIndexVersion-1); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec(`
+    INSERT INTO users (id, username, email) VALUES ('u1', 'alice', 'a@example.com');
+    INSERT INTO posts (user_id, title, content) VALUES ('u1', 'Gardening', 'Growing tomatoes');
+    `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	available, err := fts5Available(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ready, err := searchIndexReady(db)
+	if err != nil || ready != available {
+		t.Fatalf("searchIndexReady = %v, %v; want %v", ready, err, available)
+	}
+	if !available {
+		if tableExists(t, db, "posts_fts") {
+			t.Errorf("search index created without FTS5")
+		}
+		t.Skip("built without the sqlite_fts5 tag")
+	}
+
+	var matches int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts_fts WHERE posts_fts MATCH 'tomato*'").Scan(&matches); err != nil {
+		t.Fatal(err)
+	}
+	if matches != 1 {
+		t.Errorf("search index matched %d existing posts, want 1", matches)
+	}
+}
@@ -0,0 +1,69 @@
+package utils
+
+import "database/sql"
+
+// Moderation actions recorded in the moderation log.
+const (
+	ModDismiss = "dismiss"
+	ModDelete  = "delete"
+	ModHide    = "hide"
+	ModWarn    = "warn"
+	ModSuspend = "suspend"
+	ModBan     = "ban"
+	ModUnban   = "unban"
+	ModSetRole = "set_role"
+)
+
+// ReportReasons are the reasons a user can pick when reporting content.
+var ReportReasons = []string{"spam", "harassment", "hate", "off-topic", "other"}
+
+func ValidReportReason(reason string) bool {
+	for _, r := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// ModerationEntry is one decision in the moderation log. Zero IDs are stored
+// as NULL.
+type ModerationEntry struct {
+	ModeratorID  string
+	Action       string
+	ReportID     int
+	TargetUserID string
+	PostID       int
+	CommentID    int
+	Note         string
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx, so log entries can be
+// written in the same transaction as the decision they record.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// LogModeration appends an entry to the moderation log. The table rejects
+// updates and deletes, so entries cannot be changed once written.
+func LogModeration(db execer, e ModerationEntry) error {
+	_, err := db.Exec(`
+        INSERT INTO moderation_log (moderator_id, action, report_id, target_user_id, post_id, comment_id, note)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, e.ModeratorID, e.Action, nullInt(e.ReportID), nullString(e.TargetUserID), nullInt(e.PostID), nullInt(e.CommentID), e.Note)
+	return err
+}
+
+func nullInt(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,456 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// driverName is the SQLite driver with the hooks the forum relies on.
+const driverName = "sqlite3_forum"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// Notifications are inserted by triggers, so Go code never sees
+			// them being created. The update hook fires for those inserts too.
+			conn.RegisterUpdateHook(func(op int, _ string, table string, _ int64) {
+				if op == sqlite3.SQLITE_INSERT && table == "notifications" {
+					Notifications.Wake()
+				}
+			})
+			return nil
+		},
+	})
+}
+
+// How a user receives a type of notification.
+const (
+	DeliveryInApp = "in_app"
+	DeliveryEmail = "email" // Collected into a periodic digest
+	DeliveryOff   = "off"
+)
+
+// ErrInvalidDelivery is returned for a notification type or delivery that
+// users cannot choose.
+var ErrInvalidDelivery = errors.New("invalid notification preference")
+
+// NotificationType is a kind of notification users can choose how to
+// receive. Moderation warnings are not listed: they are always shown.
+type NotificationType struct {
+	Type  string
+	Label string
+}
+
+var NotificationTypes = []NotificationType{
+	{"like", "Someone likes your post"},
+	{"dislike", "Someone dislikes your post"},
+	{"comment", "Someone comments on your post"},
+	{"reply", "Someone replies to your comment"},
+	{"mention", "Someone mentions you"},
+}
+
+// Message describes what the actor did, for use after their name.
+func (n Notification) Message() string {
+	switch n.Type {
+	case "like":
+		return "liked your post"
+	case "dislike":
+		return "disliked your post"
+	case "comment":
+		return "commented on your post"
+	case "reply":
+		return "replied to your comment"
+	case "mention":
+		return "mentioned you"
+	case "warning":
+		return "sent you a moderation warning about your content on this post"
+	}
+	return "interacted with your post"
+}
+
+// Actors names who the notification is from, e.g. "alice and 12 others".
+func (n Notification) Actors() string {
+	switch n.Others {
+	case 0:
+		return n.ActorName
+	case 1:
+		return n.ActorName + " and 1 other"
+	}
+	return fmt.Sprintf("%s and %d others", n.ActorName, n.Others)
+}
+
+// GroupKey identifies the entry a notification is shown in.
+func (n Notification) GroupKey() string {
+	if n.Type == "warning" {
+		return fmt.Sprintf("warning-%d", n.ID)
+	}
+	return fmt.Sprintf("%s-%d", n.Type, n.PostID)
+}
+
+// groupedNotificationsQuery selects a user's notifications grouped so that
+// reactions and comments on the same post show as one entry, e.g. "alice and
+// 12 others liked your post". Warnings are never grouped. Each entry takes
+// its ID, time and actor from the newest notification in it and is read
+// only once all of them are. The %s is replaced by extra conditions.
+const groupedNotificationsQuery = `
+    SELECT n.id, n.type, n.post_id, n.created_at, u.username, u.profile_pic, g.actors - 1, g.read
+    FROM (
+        SELECT MAX(id) AS id, COUNT(DISTINCT actor_id) AS actors, MIN(read_at IS NOT NULL) AS read
+        FROM notifications
+        WHERE user_id = ? AND delivery = ? %s
+        GROUP BY post_id, type, CASE WHEN type = 'warning' THEN id END
+    ) g
+    JOIN notifications n ON n.id = g.id
+    JOIN users u ON u.id = n.actor_id
+    ORDER BY n.id DESC
+`
+
+// sameGroup restricts groupedNotificationsQuery to the entry of one
+// notification. It takes the notification's ID three times.
+const sameGroup = `
+        AND post_id = (SELECT post_id FROM notifications WHERE id = ?)
+        AND type = (SELECT type FROM notifications WHERE id = ?)
+        AND (type != 'warning' OR id = ?)`
+
+func queryNotificationGroups(db *sql.DB, filter string, args ...interface{}) ([]Notification, error) {
+	rows, err := db.Query(fmt.Sprintf(groupedNotificationsQuery, filter), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		var n Notification
+		err := rows.Scan(&n.ID, &n.Type, &n.PostID, &n.CreatedAt, &n.ActorName, &n.ActorProfilePic, &n.Others, &n.Read)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// ListNotifications returns the user's in-app notifications, grouped and
+// newest first.
+func ListNotifications(db *sql.DB, userID string) ([]Notification, error) {
+	return queryNotificationGroups(db, "", userID, DeliveryInApp)
+}
+
+// GetNotificationGroup returns the in-app entry notificationID is shown in.
+// It returns sql.ErrNoRows if the notification is gone or not the user's.
+func GetNotificationGroup(db *sql.DB, userID string, notificationID int) (Notification, error) {
+	groups, err := queryNotificationGroups(db, sameGroup,
+		userID, DeliveryInApp, notificationID, notificationID, notificationID)
+	if err != nil {
+		return Notification{}, err
+	}
+	if len(groups) == 0 {
+		return Notification{}, sql.ErrNoRows
+	}
+	return groups[0], nil
+}
+
+// NotificationEvent is delivered to a user's open notification streams.
+// NotificationID is 0 when only the unread count changed, for example after
+// the user marked notifications read in another tab.
+type NotificationEvent struct {
+	NotificationID int
+}
+
+// NotificationBroker fans new in-app notification rows out to the streams
+// of the users they are addressed to.
+type NotificationBroker struct {
+	// PollInterval is how often the table is checked without a wake-up. It
+	// catches rows the update hook misses, such as those written by another
+	// process.
+	PollInterval time.Duration
+
+	mu     sync.Mutex
+	subs   map[string]map[chan NotificationEvent]struct{}
+	closed bool
+	wake   chan struct{}
+	lastID int64
+}
+
+// Notifications is the broker started by main.
+var Notifications = NewNotificationBroker()
+
+func NewNotificationBroker() *NotificationBroker {
+	return &NotificationBroker{
+		PollInterval: 2 * time.Second,
+		subs:         make(map[string]map[chan NotificationEvent]struct{}),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Subscribe registers a stream for userID. The returned function must be
+// called when the stream closes. The channel is closed when the broker is.
+func (b *NotificationBroker) Subscribe(userID string) (<-chan NotificationEvent, func()) {
+	ch := make(chan NotificationEvent, 16)
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan NotificationEvent]struct{})
+	}
+	b.subs[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs[userID], ch)
+		if len(b.subs[userID]) == 0 {
+			delete(b.subs, userID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Wake asks the broker to look for new rows now. It never blocks, so it is
+// safe to call from inside SQLite hooks.
+func (b *NotificationBroker) Wake() {
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Publish sends ev to every stream userID has open. Streams that are not
+// keeping up miss the event rather than holding up the others.
+func (b *NotificationBroker) Publish(userID string, ev NotificationEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[userID] {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Close closes every stream's channel, so open streams end and browsers
+// reconnect, to another server if this one is shutting down. Later
+// subscriptions get a closed channel.
+func (b *NotificationBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for userID, chans := range b.subs {
+		for ch := range chans {
+			close(ch)
+		}
+		delete(b.subs, userID)
+	}
+}
+
+// Run delivers new notification rows until ctx is cancelled.
+func (b *NotificationBroker) Run(ctx context.Context, db *sql.DB) {
+	// Start at the newest row so a restart does not replay old notifications
+	if err := db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM notifications").Scan(&b.lastID); err != nil {
+		log.Printf("Error reading latest notification: %v", err)
+	}
+
+	ticker := time.NewTicker(b.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.wake:
+			// The update hook fires while the inserting statement is still
+			// running. Give it a moment to commit; the ticker picks the row
+			// up if this is not long enough.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		case <-ticker.C:
+		}
+		b.deliver(db)
+	}
+}
+
+func (b *NotificationBroker) deliver(db *sql.DB) {
+	rows, err := db.Query(`
+        SELECT id, user_id, delivery
+        FROM notifications
+        WHERE id > ?
+        ORDER BY id
+    `, b.lastID)
+	if err != nil {
+		log.Printf("Error polling notifications: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var userID, delivery string
+		if err := rows.Scan(&id, &userID, &delivery); err != nil {
+			log.Printf("Error scanning notification: %v", err)
+			return
+		}
+		b.lastID = int64(id)
+		if delivery == DeliveryInApp {
+			b.Publish(userID, NotificationEvent{NotificationID: id})
+		}
+	}
+}
+
+// CountUnreadNotifications returns how many of the user's in-app entries
+// have unread notifications. Grouped notifications count once.
+func CountUnreadNotifications(db *sql.DB, userID string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+        SELECT COUNT(*) FROM (
+            SELECT 1 FROM notifications
+            WHERE user_id = ? AND delivery = ? AND read_at IS NULL
+            GROUP BY post_id, type, CASE WHEN type = 'warning' THEN id END
+        )
+    `, userID, DeliveryInApp).Scan(&count)
+	return count, err
+}
+
+// MarkNotificationRead marks the entry a notification is shown in read,
+// including the notifications grouped with it. It reports false if the
+// notification does not exist or belongs to someone else.
+func MarkNotificationRead(db *sql.DB, userID string, notificationID int) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM notifications WHERE id = ? AND user_id = ?)",
+		notificationID, userID).Scan(&exists)
+	if err != nil || !exists {
+		return false, err
+	}
+	_, err = db.Exec(`
+        UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+        WHERE user_id = ? AND delivery = ? AND read_at IS NULL`+sameGroup,
+		userID, DeliveryInApp, notificationID, notificationID, notificationID)
+	if err != nil {
+		return false, err
+	}
+	Notifications.Publish(userID, NotificationEvent{})
+	return true, nil
+}
+
+// MarkAllNotificationsRead marks every unread notification of the user read.
+func MarkAllNotificationsRead(db *sql.DB, userID string) error {
+	_, err := db.Exec(`
+        UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+        WHERE user_id = ? AND read_at IS NULL
+    `, userID)
+	if err != nil {
+		return err
+	}
+	Notifications.Publish(userID, NotificationEvent{})
+	return nil
+}
+
+// GetNotificationPreferences returns how the user receives each type in
+// NotificationTypes. Types without a stored preference are shown in-app.
+func GetNotificationPreferences(db *sql.DB, userID string) (map[string]string, error) {
+	prefs := make(map[string]string)
+	for _, t := range NotificationTypes {
+		prefs[t.Type] = DeliveryInApp
+	}
+
+	rows, err := db.Query("SELECT type, delivery FROM notification_preferences WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var notificationType, delivery string
+		if err := rows.Scan(&notificationType, &delivery); err != nil {
+			return nil, err
+		}
+		if _, ok := prefs[notificationType]; ok {
+			prefs[notificationType] = delivery
+		}
+	}
+	return prefs, rows.Err()
+}
+
+// SetNotificationPreference chooses how the user receives a type of
+// notification. It applies to notifications created from now on.
+func SetNotificationPreference(db *sql.DB, userID, notificationType, delivery string) error {
+	switch delivery {
+	case DeliveryInApp, DeliveryEmail, DeliveryOff:
+	default:
+		return ErrInvalidDelivery
+	}
+	known := false
+	for _, t := range NotificationTypes {
+		known = known || t.Type == notificationType
+	}
+	if !known {
+		return ErrInvalidDelivery
+	}
+
+	_, err := db.Exec(`
+        INSERT INTO notification_preferences (user_id, type, delivery) VALUES (?, ?, ?)
+        ON CONFLICT (user_id, type) DO UPDATE SET delivery = excluded.delivery
+    `, userID, notificationType, delivery)
+	return err
+}
+
+// DigestRecipient is a user with notifications waiting for the email digest.
+type DigestRecipient struct {
+	UserID string
+	Email  string
+}
+
+// ListDigestRecipients returns the users with notifications that have not
+// been emailed yet.
+func ListDigestRecipients(db *sql.DB) ([]DigestRecipient, error) {
+	rows, err := db.Query(`
+        SELECT DISTINCT u.id, u.email
+        FROM notifications n
+        JOIN users u ON u.id = n.user_id
+        WHERE n.delivery = ? AND n.emailed_at IS NULL
+        AND u.email IS NOT NULL AND u.email != ''
+    `, DeliveryEmail)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []DigestRecipient
+	for rows.Next() {
+		var r DigestRecipient
+		if err := rows.Scan(&r.UserID, &r.Email); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// PendingDigest returns the user's grouped notifications that have not been
+// emailed yet.
+func PendingDigest(db *sql.DB, userID string) ([]Notification, error) {
+	return queryNotificationGroups(db, "AND emailed_at IS NULL", userID, DeliveryEmail)
+}
+
+// MarkDigestSent records that the user's digest notifications up to and
+// including upToID have been emailed. Ones created while the digest was
+// being sent wait for the next digest.
+func MarkDigestSent(db *sql.DB, userID string, upToID int) error {
+	_, err := db.Exec(`
+        UPDATE notifications SET emailed_at = CURRENT_TIMESTAMP
+        WHERE user_id = ? AND delivery = ? AND emailed_at IS NULL AND id <= ?
+    `, userID, DeliveryEmail, upToID)
+	return err
+}
@@ -0,0 +1,21 @@
+package utils
+
+import "testing"
+
+func TestNotificationBroker_Close(t *testing.T) {
+	b := NewNotificationBroker()
+	events, unsubscribe := b.Subscribe("1")
+
+	b.Close()
+	if _, ok := <-events; ok {
+		t.Errorf("stream channel is still open after Close")
+	}
+	// Unsubscribing after Close must not close the channel a second time
+	unsubscribe()
+
+	late, unsubscribe := b.Subscribe("1")
+	defer unsubscribe()
+	if _, ok := <-late; ok {
+		t.Errorf("Subscribe after Close returned an open channel")
+	}
+}
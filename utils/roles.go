@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// Permission names an action that only some roles may perform.
+type Permission string
+
+const (
+	PermManageCategories Permission = "manage_categories"
+	PermDeleteAnyContent Permission = "delete_any_content"
+	PermBanUsers         Permission = "ban_users"
+	PermManageRoles      Permission = "manage_roles"
+	PermReviewReports    Permission = "review_reports"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:     {PermManageCategories, PermDeleteAnyContent, PermBanUsers, PermManageRoles, PermReviewReports},
+	RoleModerator: {PermDeleteAnyContent, PermBanUsers, PermReviewReports},
+	RoleMember:    {},
+}
+
+// roleRank orders roles so that staff can only act on users below them.
+var roleRank = map[string]int{
+	RoleMember:    0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks reports whether a user with role may moderate a user with target.
+// Admins may act on anyone, everyone else only on lower roles.
+func Outranks(role, target string) bool {
+	return role == RoleAdmin || roleRank[role] > roleRank[target]
+}
+
+// GetUserRole returns the role of a user, treating unknown users as members.
+func GetUserRole(db *sql.DB, userID string) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return RoleMember, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+func SetUserRole(db *sql.DB, userID, role string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("invalid role %q", role)
+	}
+	result, err := db.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// BanUser marks a user as banned and signs them out everywhere.
+func BanUser(db *sql.DB, userID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET banned_at = CURRENT_TIMESTAMP WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SuspendUser signs a user out and keeps them from signing in until the
+// given time.
+func SuspendUser(db *sql.DB, userID string, until time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET suspended_until = ? WHERE id = ?", until, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func UnbanUser(db *sql.DB, userID string) error {
+	_, err := db.Exec("UPDATE users SET banned_at = NULL WHERE id = ?", userID)
+	return err
+}
+
+// PromoteBootstrapAdmins gives the admin role to the named users, so a
+// fresh installation has someone who can hand out roles.
+func PromoteBootstrapAdmins(db *sql.DB, usernames []string) error {
+	for _, name := range usernames {
+		result, err := db.Exec("UPDATE users SET role = ? WHERE username = ?", RoleAdmin, name)
+		if err != nil {
+			return fmt.Errorf("failed to promote %s: %v", name, err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			log.Printf("Admin user %s does not exist yet", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package utils
+
+import "testing"
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleAdmin, PermManageRoles, true},
+		{RoleAdmin, PermManageCategories, true},
+		{RoleModerator, PermDeleteAnyContent, true},
+		{RoleModerator, PermBanUsers, true},
+		{RoleModerator, PermManageRoles, false},
+		{RoleModerator, PermManageCategories, false},
+		{RoleMember, PermDeleteAnyContent, false},
+		{"unknown", PermBanUsers, false},
+	}
+
+	for _, tt := range tests {
+		if got := HasPermission(tt.role, tt.perm); got != tt.want {
+			t.Errorf("HasPermission(%s, %s) = %v, want %v", tt.role, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	tests := []struct {
+		role, target string
+		want         bool
+	}{
+		{RoleAdmin, RoleAdmin, true},
+		{RoleAdmin, RoleMember, true},
+		{RoleModerator, RoleMember, true},
+		{RoleModerator, RoleModerator, false},
+		{RoleModerator, RoleAdmin, false},
+		{RoleMember, RoleMember, false},
+	}
+
+	for _, tt := range tests {
+		if got := Outranks(tt.role, tt.target); got != tt.want {
+			t.Errorf("Outranks(%s, %s) = %v, want %v", tt.role, tt.target, got, tt.want)
+		}
+	}
+}
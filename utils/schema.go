@@ -0,0 +1,563 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// The schema changes made since the initial schema, one function per
+// migration step. Until migrations existed they were applied on every start,
+// so databases from then already have some of them and the Up steps
+// tolerate what already exists.
+
+func addPostRevisions(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS post_revisions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        post_id INTEGER NOT NULL,
+        editor_id TEXT NOT NULL,
+        title TEXT NOT NULL,
+        content TEXT NOT NULL,
+        imagepath TEXT,
+        categories TEXT,
+        revised_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+        FOREIGN KEY (editor_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_post_revisions_post_id ON post_revisions(post_id);
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create post_revisions table: %v", err)
+	}
+	return addColumnIfMissing(tx, "posts", "updated_at", "DATETIME")
+}
+
+var dropPostRevisions = execSQL(`
+    DROP TABLE post_revisions;
+    ALTER TABLE posts DROP COLUMN updated_at;
+    `)
+
+func addCommentReplies(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "comments", "parent_id", "INTEGER REFERENCES comments(id)"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id)")
+	return err
+}
+
+var dropCommentReplies = execSQL(`
+    DROP INDEX idx_comments_parent_id;
+    ALTER TABLE comments DROP COLUMN parent_id;
+    `)
+
+func addUserRoles(tx *sql.Tx) error {
+	for _, column := range []struct{ name, definition string }{
+		// 'admin', 'moderator' or 'member', see roles.go
+		{"role", "TEXT NOT NULL DEFAULT 'member'"},
+		{"banned_at", "DATETIME"},
+		{"suspended_until", "DATETIME"},
+	} {
+		if err := addColumnIfMissing(tx, "users", column.name, column.definition); err != nil {
+			return fmt.Errorf("failed to add users.%s column: %v", column.name, err)
+		}
+	}
+	return nil
+}
+
+var dropUserRoles = execSQL(`
+    ALTER TABLE users DROP COLUMN suspended_until;
+    ALTER TABLE users DROP COLUMN banned_at;
+    ALTER TABLE users DROP COLUMN role;
+    `)
+
+func addModeration(tx *sql.Tx) error {
+	// Content hidden by moderators stays in the database but is only shown to staff
+	for _, table := range []string{"posts", "comments"} {
+		if err := addColumnIfMissing(tx, table, "hidden_at", "DATETIME"); err != nil {
+			return fmt.Errorf("failed to add %s.hidden_at column: %v", table, err)
+		}
+	}
+
+	// Reports on comments also record the comment's post, so the queue can
+	// link to it and deleting the post removes its reports
+	_, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS reports (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        reporter_id TEXT NOT NULL,
+        post_id INTEGER NOT NULL,
+        comment_id INTEGER,
+        reason TEXT NOT NULL,
+        details TEXT NOT NULL DEFAULT '',
+        status TEXT NOT NULL DEFAULT 'open', -- 'open', 'dismissed' or 'actioned'
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        resolved_by TEXT,
+        resolved_at DATETIME,
+        FOREIGN KEY (reporter_id) REFERENCES users(id),
+        FOREIGN KEY (post_id) REFERENCES posts(id),
+        FOREIGN KEY (comment_id) REFERENCES comments(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status);
+    CREATE INDEX IF NOT EXISTS idx_reports_post_id ON reports(post_id);
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create reports table: %v", err)
+	}
+
+	// The moderation log is append-only. IDs are kept without foreign keys so
+	// entries survive the deletion of the content they describe.
+	_, err = tx.Exec(`
+    CREATE TABLE IF NOT EXISTS moderation_log (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        moderator_id TEXT NOT NULL,
+        action TEXT NOT NULL,
+        report_id INTEGER,
+        target_user_id TEXT,
+        post_id INTEGER,
+        comment_id INTEGER,
+        note TEXT NOT NULL DEFAULT '',
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+    );
+    CREATE INDEX IF NOT EXISTS idx_moderation_log_created_at ON moderation_log(created_at);
+
+    CREATE TRIGGER IF NOT EXISTS ModerationLogNoUpdate
+    BEFORE UPDATE ON moderation_log
+    BEGIN
+        SELECT RAISE(ABORT, 'moderation log is append-only');
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS ModerationLogNoDelete
+    BEFORE DELETE ON moderation_log
+    BEGIN
+        SELECT RAISE(ABORT, 'moderation log is append-only');
+    END;
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create moderation_log table: %v", err)
+	}
+	return nil
+}
+
+// dropModeration throws the moderation log away with the rest, since
+// dropping a table does not run its delete triggers.
+var dropModeration = execSQL(`
+    DROP TABLE moderation_log;
+    DROP TABLE reports;
+    ALTER TABLE comments DROP COLUMN hidden_at;
+    ALTER TABLE posts DROP COLUMN hidden_at;
+    `)
+
+// addSessionDetails records the device details shown on the sessions page.
+func addSessionDetails(tx *sql.Tx) error {
+	for _, column := range []struct{ name, definition string }{
+		{"created_at", "DATETIME"},
+		{"last_seen", "DATETIME"},
+		{"user_agent", "TEXT"},
+		{"ip", "TEXT"},
+	} {
+		if err := addColumnIfMissing(tx, "sessions", column.name, column.definition); err != nil {
+			return fmt.Errorf("failed to add sessions.%s column: %v", column.name, err)
+		}
+	}
+	return nil
+}
+
+var dropSessionDetails = execSQL(`
+    ALTER TABLE sessions DROP COLUMN ip;
+    ALTER TABLE sessions DROP COLUMN user_agent;
+    ALTER TABLE sessions DROP COLUMN last_seen;
+    ALTER TABLE sessions DROP COLUMN created_at;
+    `)
+
+func addEmailVerification(tx *sql.Tx) error {
+	// Accounts created before email verification existed are treated as verified
+	hadVerification, err := columnExists(tx, "users", "email_verified_at")
+	if err != nil {
+		return fmt.Errorf("failed to inspect users table: %v", err)
+	}
+	if !hadVerification {
+		if _, err = tx.Exec("ALTER TABLE users ADD COLUMN email_verified_at DATETIME"); err != nil {
+			return fmt.Errorf("failed to add users.email_verified_at column: %v", err)
+		}
+		if _, err = tx.Exec("UPDATE users SET email_verified_at = CURRENT_TIMESTAMP"); err != nil {
+			return fmt.Errorf("failed to mark existing users verified: %v", err)
+		}
+	}
+
+	// Single-use tokens for email verification and password resets. Only
+	// hashes of the tokens are stored.
+	_, err = tx.Exec(`
+    CREATE TABLE IF NOT EXISTS user_tokens (
+        token_hash TEXT PRIMARY KEY,
+        user_id TEXT NOT NULL,
+        purpose TEXT NOT NULL, -- 'verify_email' or 'reset_password'
+        expires_at DATETIME NOT NULL,
+        used_at DATETIME,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens(user_id);
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create user_tokens table: %v", err)
+	}
+	return nil
+}
+
+var dropEmailVerification = execSQL(`
+    DROP TABLE user_tokens;
+    ALTER TABLE users DROP COLUMN email_verified_at;
+    `)
+
+// addCSRFTokens gives sessions the token forms and fetch calls must echo
+// back, see CSRFProtect.
+func addCSRFTokens(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "sessions", "csrf_token", "TEXT")
+}
+
+var dropCSRFTokens = execSQL("ALTER TABLE sessions DROP COLUMN csrf_token")
+
+// addUserIdentities records the accounts at sign-in providers that can sign
+// in as a local user. They are matched by the provider's stable user ID,
+// never by username or email.
+func addUserIdentities(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+    CREATE TABLE IF NOT EXISTS user_identities (
+        provider TEXT NOT NULL, -- 'github', 'google' or an OpenID Connect provider
+        provider_user_id TEXT NOT NULL,
+        user_id TEXT NOT NULL,
+        email TEXT,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (provider, provider_user_id),
+        UNIQUE (user_id, provider),
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );
+    `)
+	return err
+}
+
+var dropUserIdentities = execSQL("DROP TABLE user_identities")
+
+func addNotificationPreferences(tx *sql.Tx) error {
+	// Unread notifications have no read_at and are counted in the header badge
+	if err := addColumnIfMissing(tx, "notifications", "read_at", "DATETIME"); err != nil {
+		return fmt.Errorf("failed to add notifications.read_at column: %v", err)
+	}
+	_, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_notifications_user_read ON notifications(user_id, read_at)")
+	if err != nil {
+		return fmt.Errorf("failed to create notifications read index: %v", err)
+	}
+
+	// How each user wants to receive each type of notification. Types
+	// without a row are shown in-app.
+	_, err = tx.Exec(`
+    CREATE TABLE IF NOT EXISTS notification_preferences (
+        user_id TEXT NOT NULL,
+        type TEXT NOT NULL,
+        delivery TEXT NOT NULL CHECK (delivery IN ('in_app', 'email', 'off')),
+        PRIMARY KEY (user_id, type),
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );
+    `)
+	if err != nil {
+		return fmt.Errorf("failed to create notification_preferences table: %v", err)
+	}
+	if err := addColumnIfMissing(tx, "notifications", "delivery", "TEXT NOT NULL DEFAULT 'in_app'"); err != nil {
+		return fmt.Errorf("failed to add notifications.delivery column: %v", err)
+	}
+	if err := addColumnIfMissing(tx, "notifications", "emailed_at", "DATETIME"); err != nil {
+		return fmt.Errorf("failed to add notifications.emailed_at column: %v", err)
+	}
+	if err := createNotificationTriggers(tx); err != nil {
+		return fmt.Errorf("failed to create notification triggers: %v", err)
+	}
+	return nil
+}
+
+// dropNotificationPreferences brings back the initial triggers, which
+// notify of every reaction and comment.
+func dropNotificationPreferences(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+    DROP TRIGGER AfterPostReaction;
+    DROP TRIGGER AfterPostReactionChange;
+    DROP TRIGGER AfterPostReactionRetract;
+    DROP TRIGGER AfterPostComment;
+    DROP TRIGGER AfterCommentReply;
+    ` + initialNotificationTriggers + `
+    ALTER TABLE notifications DROP COLUMN emailed_at;
+    ALTER TABLE notifications DROP COLUMN delivery;
+    DROP TABLE notification_preferences;
+    DROP INDEX idx_notifications_user_read;
+    ALTER TABLE notifications DROP COLUMN read_at;
+    `)
+	return err
+}
+
+// addMentions records who a post (comment_id 0) or comment mentions, so
+// edits only notify newly mentioned users, and who does not want to hear
+// from whom.
+var addMentions = execSQL(`
+    CREATE TABLE IF NOT EXISTS mentions (
+        post_id INTEGER NOT NULL,
+        comment_id INTEGER NOT NULL DEFAULT 0,
+        user_id TEXT NOT NULL,
+        PRIMARY KEY (post_id, comment_id, user_id),
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );
+    CREATE INDEX IF NOT EXISTS idx_mentions_comment_id ON mentions(comment_id);
+
+    CREATE TABLE IF NOT EXISTS user_blocks (
+        blocker_id TEXT NOT NULL,
+        blocked_id TEXT NOT NULL,
+        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (blocker_id, blocked_id),
+        FOREIGN KEY (blocker_id) REFERENCES users(id),
+        FOREIGN KEY (blocked_id) REFERENCES users(id)
+    );
+    `)
+
+var dropMentions = execSQL(`
+    DROP TABLE user_blocks;
+    DROP TABLE mentions;
+    `)
+
+// addRenderedContent caches the HTML rendered from the Markdown of a post
+// or comment until the text changes.
+var addRenderedContent = execSQL(`
+    CREATE TABLE IF NOT EXISTS rendered_content (
+        kind TEXT NOT NULL CHECK (kind IN ('post', 'comment')),
+        content_id INTEGER NOT NULL,
+        revision TEXT NOT NULL,
+        html TEXT NOT NULL,
+        rendered_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (kind, content_id)
+    );
+    `)
+
+var dropRenderedContent = execSQL("DROP TABLE rendered_content")
+
+// uploadColumns hold the paths of uploaded images.
+var uploadColumns = []struct{ table, name string }{
+	{"posts", "imagepath"}, {"post_revisions", "imagepath"}, {"users", "profile_pic"},
+}
+
+// moveUploads rewrites the paths of uploads, which used to be served from
+// the static directory and are now served from /uploads/ whichever backend
+// stores them.
+func moveUploads(tx *sql.Tx) error {
+	for _, column := range uploadColumns {
+		_, err := tx.Exec(`UPDATE ` + column.table + ` SET ` + column.name + ` = '/uploads/' || substr(` + column.name + `, 17)
+            WHERE ` + column.name + ` LIKE '/static/uploads/%'`)
+		if err != nil {
+			return fmt.Errorf("failed to move %s.%s to /uploads/: %v", column.table, column.name, err)
+		}
+	}
+	return nil
+}
+
+// unmoveUploads points the paths back into the static directory, where
+// the local backend keeps the files.
+func unmoveUploads(tx *sql.Tx) error {
+	for _, column := range uploadColumns {
+		_, err := tx.Exec(`UPDATE ` + column.table + ` SET ` + column.name + ` = '/static/uploads/' || substr(` + column.name + `, 10)
+            WHERE ` + column.name + ` LIKE '/uploads/%'`)
+		if err != nil {
+			return fmt.Errorf("failed to move %s.%s back to /static/uploads/: %v", column.table, column.name, err)
+		}
+	}
+	return nil
+}
+
+// createNotificationTriggers sets up the triggers that notify users of
+// reactions, comments and replies to their content. Each looks up the
+// recipient's preference for the type: nothing is written if it is off, and
+// the row's delivery says whether it is shown in-app or emailed.
+//
+// The triggers are dropped first, since databases created by an earlier
+// version may have older definitions of them.
+func createNotificationTriggers(db execer) error {
+	_, err := db.Exec(`
+DROP TRIGGER IF EXISTS AfterPostReaction;
+DROP TRIGGER IF EXISTS AfterPostReactionChange;
+DROP TRIGGER IF EXISTS AfterPostReactionRetract;
+DROP TRIGGER IF EXISTS AfterPostComment;
+DROP TRIGGER IF EXISTS AfterCommentReply;
+
+CREATE TRIGGER AfterPostReaction
+AFTER INSERT ON reaction
+BEGIN
+    INSERT INTO notifications (user_id, actor_id, post_id, type, delivery)
+    SELECT
+        p.user_id,     -- Post owner (receiver of notification)
+        NEW.user_id,   -- Person who reacted (actor)
+        NEW.post_id,   -- Post that was reacted to
+        CASE WHEN NEW.like = 1 THEN 'like' ELSE 'dislike' END,
+        COALESCE(np.delivery, 'in_app')
+    FROM posts p
+    LEFT JOIN notification_preferences np
+        ON np.user_id = p.user_id
+        AND np.type = CASE WHEN NEW.like = 1 THEN 'like' ELSE 'dislike' END
+    WHERE p.id = NEW.post_id
+    AND p.user_id != NEW.user_id -- Don't notify if user reacts to their own post
+    AND COALESCE(np.delivery, 'in_app') != 'off';
+END;
+
+-- Switching between like and dislike replaces the notification
+CREATE TRIGGER AfterPostReactionChange
+AFTER UPDATE OF like ON reaction
+WHEN OLD.like != NEW.like
+BEGIN
+    DELETE FROM notifications
+    WHERE actor_id = OLD.user_id AND post_id = OLD.post_id AND type IN ('like', 'dislike');
+
+    INSERT INTO notifications (user_id, actor_id, post_id, type, delivery)
+    SELECT
+        p.user_id,
+        NEW.user_id,
+        NEW.post_id,
+        CASE WHEN NEW.like = 1 THEN 'like' ELSE 'dislike' END,
+        COALESCE(np.delivery, 'in_app')
+    FROM posts p
+    LEFT JOIN notification_preferences np
+        ON np.user_id = p.user_id
+        AND np.type = CASE WHEN NEW.like = 1 THEN 'like' ELSE 'dislike' END
+    WHERE p.id = NEW.post_id
+    AND p.user_id != NEW.user_id
+    AND COALESCE(np.delivery, 'in_app') != 'off';
+END;
+
+-- Retracting a reaction removes its notification
+CREATE TRIGGER AfterPostReactionRetract
+AFTER DELETE ON reaction
+BEGIN
+    DELETE FROM notifications
+    WHERE actor_id = OLD.user_id AND post_id = OLD.post_id AND type IN ('like', 'dislike');
+END;
+
+CREATE TRIGGER AfterPostComment
+AFTER INSERT ON comments
+BEGIN
+    INSERT INTO notifications (user_id, actor_id, post_id, type, delivery)
+    SELECT
+        p.user_id,     -- Post owner (receiver of notification)
+        NEW.user_id,   -- Person who commented (actor)
+        NEW.post_id,   -- Post that was commented on
+        'comment',
+        COALESCE(np.delivery, 'in_app')
+    FROM posts p
+    LEFT JOIN notification_preferences np ON np.user_id = p.user_id AND np.type = 'comment'
+    WHERE p.id = NEW.post_id
+    AND p.user_id != NEW.user_id -- Don't notify if user comments on their own post
+    AND COALESCE(np.delivery, 'in_app') != 'off';
+END;
+
+CREATE TRIGGER AfterCommentReply
+AFTER INSERT ON comments
+WHEN NEW.parent_id IS NOT NULL
+BEGIN
+    INSERT INTO notifications (user_id, actor_id, post_id, type, delivery)
+    SELECT
+        parent.user_id, -- Parent comment author (receiver of notification)
+        NEW.user_id,    -- Person who replied (actor)
+        NEW.post_id,    -- Post the conversation belongs to
+        'reply',
+        COALESCE(np.delivery, 'in_app')
+    FROM comments parent
+    JOIN posts p ON p.id = NEW.post_id
+    LEFT JOIN notification_preferences np ON np.user_id = parent.user_id AND np.type = 'reply'
+    WHERE parent.id = NEW.parent_id
+    AND parent.user_id != NEW.user_id -- Don't notify if user replies to themselves
+    AND parent.user_id != p.user_id   -- The post author is already notified of every comment
+    AND COALESCE(np.delivery, 'in_app') != 'off';
+END;
+`)
+	return err
+}
+
+// addSearchIndex sets up FTS5 tables over post titles and content and over
+// comment content, kept in sync by triggers. Without FTS5 in the driver it
+// does nothing, and search falls back to substring matching. Databases that
+// older versions indexed on start already have the tables.
+func addSearchIndex(tx *sql.Tx) error {
+	available, err := fts5Available(tx)
+	if err != nil || !available {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'posts_fts')").Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+    CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+        title, content,
+        content='posts', content_rowid='id',
+        tokenize='porter unicode61'
+    );
+    CREATE VIRTUAL TABLE IF NOT EXISTS comments_fts USING fts5(
+        content,
+        content='comments', content_rowid='id',
+        tokenize='porter unicode61'
+    );
+
+    CREATE TRIGGER IF NOT EXISTS PostsFtsInsert
+    AFTER INSERT ON posts
+    BEGIN
+        INSERT INTO posts_fts (rowid, title, content) VALUES (NEW.id, NEW.title, NEW.content);
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS PostsFtsDelete
+    AFTER DELETE ON posts
+    BEGIN
+        INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', OLD.id, OLD.title, OLD.content);
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS PostsFtsUpdate
+    AFTER UPDATE OF title, content ON posts
+    BEGIN
+        INSERT INTO posts_fts (posts_fts, rowid, title, content) VALUES ('delete', OLD.id, OLD.title, OLD.content);
+        INSERT INTO posts_fts (rowid, title, content) VALUES (NEW.id, NEW.title, NEW.content);
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS CommentsFtsInsert
+    AFTER INSERT ON comments
+    BEGIN
+        INSERT INTO comments_fts (rowid, content) VALUES (NEW.id, NEW.content);
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS CommentsFtsDelete
+    AFTER DELETE ON comments
+    BEGIN
+        INSERT INTO comments_fts (comments_fts, rowid, content) VALUES ('delete', OLD.id, OLD.content);
+    END;
+
+    CREATE TRIGGER IF NOT EXISTS CommentsFtsUpdate
+    AFTER UPDATE OF content ON comments
+    BEGIN
+        INSERT INTO comments_fts (comments_fts, rowid, content) VALUES ('delete', OLD.id, OLD.content);
+        INSERT INTO comments_fts (rowid, content) VALUES (NEW.id, NEW.content);
+    END;
+    `)
+	if err != nil || exists {
+		return err
+	}
+
+	// Index content written before the search tables existed
+	_, err = tx.Exec(`
+    INSERT INTO posts_fts (posts_fts) VALUES ('rebuild');
+    INSERT INTO comments_fts (comments_fts) VALUES ('rebuild');
+    `)
+	return err
+}
+
+var dropSearchIndex = execSQL(`
+    DROP TRIGGER IF EXISTS PostsFtsInsert;
+    DROP TRIGGER IF EXISTS PostsFtsDelete;
+    DROP TRIGGER IF EXISTS PostsFtsUpdate;
+    DROP TRIGGER IF EXISTS CommentsFtsInsert;
+    DROP TRIGGER IF EXISTS CommentsFtsDelete;
+    DROP TRIGGER IF EXISTS CommentsFtsUpdate;
+    DROP TABLE IF EXISTS posts_fts;
+    DROP TABLE IF EXISTS comments_fts;
+    `)
@@ -3,43 +3,40 @@ package utils
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"time"
 )
 
 var (
-	ErrActiveSession = fmt.Errorf("user already has an active session")
-	ErrNoSession     = fmt.Errorf("no active session found")
+	ErrNoSession = fmt.Errorf("no active session found")
 )
 
+const sessionLifetime = 24 * time.Hour
+
 func GenerateSessionToken() string {
 	b := make([]byte, 32)
 	rand.Read(b)
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-func CreateSession(db *sql.DB, userID string) (string, error) {
-	// Delete any existing session for the user
-	_, err := db.Exec(`
-        DELETE FROM sessions
-        WHERE user_id = ?
-    `, userID)
-	if err != nil {
-		return "", fmt.Errorf("failed to delete existing session: %v", err)
-	}
-
-	// Generate new session
+// CreateSession starts a new session for a user. Existing sessions on other
+// devices are kept, so a user can be signed in on several devices at once.
+func CreateSession(db *sql.DB, userID, userAgent, ip string) (string, error) {
 	SessionToken := GenerateSessionToken()
-	ExpiresAt := time.Now().Add(24 * time.Hour)
+	now := time.Now()
+	ExpiresAt := now.Add(sessionLifetime)
 
-	// Create new session
-	_, err = db.Exec(`
-        INSERT INTO sessions(id, user_id, expires_at)
-        VALUES (?, ?, ?)
-    `, SessionToken, userID, ExpiresAt)
+	_, err := db.Exec(`
+        INSERT INTO sessions(id, user_id, expires_at, created_at, last_seen, user_agent, ip, csrf_token)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `, SessionToken, userID, ExpiresAt, now, now, userAgent, ip, GenerateSessionToken())
 	if err != nil {
 		return "", fmt.Errorf("failed to create session: %v", err)
 	}
@@ -50,9 +47,12 @@ func CreateSession(db *sql.DB, userID string) (string, error) {
 
 func ValidateSession(db *sql.DB, sessionToken string) (string, error) {
 	var userID string
+	// Banned and suspended users are treated as signed out
 	err := db.QueryRow(`
-		SELECT user_id FROM sessions 
-		WHERE id = ? AND expires_at > ?
+		SELECT s.user_id FROM sessions s
+		LEFT JOIN users u ON u.id = s.user_id
+		WHERE s.id = ? AND s.expires_at > ? AND u.banned_at IS NULL
+		  AND (u.suspended_until IS NULL OR julianday(u.suspended_until) <= julianday('now'))
 	`, sessionToken, time.Now()).Scan(&userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -60,9 +60,83 @@ func ValidateSession(db *sql.DB, sessionToken string) (string, error) {
 		}
 		return "", fmt.Errorf("error validating session: %v", err)
 	}
+
+	// Sessions are validated several times per request, so last_seen is
+	// only written when it is more than a minute old
+	_, err = db.Exec(`
+		UPDATE sessions SET last_seen = ?
+		WHERE id = ? AND (last_seen IS NULL OR julianday(last_seen) < julianday('now', '-1 minute'))
+	`, time.Now(), sessionToken)
+	if err != nil {
+		log.Printf("Failed to update session last seen time: %v", err)
+	}
 	return userID, nil
 }
 
+// ListSessions returns a user's unexpired sessions, most recently used first.
+func ListSessions(db *sql.DB, userID string) ([]Session, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, expires_at, COALESCE(user_agent, ''), COALESCE(ip, ''), created_at, last_seen
+		FROM sessions
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY julianday(COALESCE(last_seen, created_at, expires_at)) DESC
+	`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		var createdAt, lastSeen sql.NullTime
+		if err := rows.Scan(&s.ID, &s.UserID, &s.ExpiresAt, &s.UserAgent, &s.IP, &createdAt, &lastSeen); err != nil {
+			return nil, err
+		}
+		// Sessions from before device tracking have no times recorded
+		s.CreatedAt, s.LastSeen = createdAt.Time, lastSeen.Time
+		if !createdAt.Valid {
+			s.CreatedAt = s.ExpiresAt.Add(-sessionLifetime)
+		}
+		if !lastSeen.Valid {
+			s.LastSeen = s.CreatedAt
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// SessionPublicID identifies a session on pages and in forms without
+// revealing the session token itself.
+func SessionPublicID(sessionToken string) string {
+	sum := sha256.Sum256([]byte(sessionToken))
+	return hex.EncodeToString(sum[:8])
+}
+
+// DeleteSession signs out one of a user's sessions.
+func DeleteSession(db *sql.DB, userID, sessionToken string) error {
+	_, err := db.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", sessionToken, userID)
+	return err
+}
+
+// DeleteOtherSessions signs a user out everywhere except the given session.
+func DeleteOtherSessions(db *sql.DB, userID, keepToken string) (int64, error) {
+	result, err := db.Exec("DELETE FROM sessions WHERE user_id = ? AND id != ?", userID, keepToken)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ClientIP returns the address of the client that sent the request.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func DeleteExpiredSessions(db *sql.DB) (int64, error) {
 	result, err := db.Exec(`
 		DELETE FROM sessions
@@ -79,30 +153,24 @@ func DeleteExpiredSessions(db *sql.DB) (int64, error) {
 	return deletedSessions, nil
 }
 
-func StartSessionsCLeanUp(ctx context.Context, db *sql.DB, interval time.Duration) {
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				rowsAffected, err := DeleteExpiredSessions(db)
-				if err != nil {
-					log.Printf("Failed to clean up expired sessions: %v", err)
-				} else if rowsAffected > 0 {
-					log.Printf("Cleaned up %d expired sessions", rowsAffected)
-				}
-			case <-ctx.Done():
-				log.Println("Stopping session cleanup goroutine")
-				return
+// RunSessionsCleanUp deletes expired sessions every interval until ctx is
+// done.
+func RunSessionsCleanUp(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rowsAffected, err := DeleteExpiredSessions(db)
+			if err != nil {
+				log.Printf("Failed to clean up expired sessions: %v", err)
+			} else if rowsAffected > 0 {
+				log.Printf("Cleaned up %d expired sessions", rowsAffected)
 			}
+		case <-ctx.Done():
+			log.Println("Stopping session cleanup")
+			return
 		}
-	}()
-}
-
-func InitSessionManager(db *sql.DB) {
-	ctx := context.Background()
-	interval := 1 * time.Hour
-	StartSessionsCLeanUp(ctx, db, interval)
+	}
 }
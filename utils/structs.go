@@ -2,15 +2,20 @@ package utils
 
 import (
 	"database/sql"
+	"html/template"
 	"time"
 )
 
 type User struct {
-	ID         string
-	UserName   string
-	Email      string
-	Password   string
-	ProfilePic sql.NullString
+	ID             string
+	UserName       string
+	Email          string
+	Password       string // Hash, only loaded where it is checked
+	ProfilePic     sql.NullString
+	Role           string
+	Banned         bool
+	SuspendedUntil time.Time // Zero unless the user was ever suspended
+	EmailVerified  bool
 }
 
 type Post struct {
@@ -18,8 +23,13 @@ type Post struct {
 	UserID       string
 	Title        string
 	Content      string
+	ContentHTML  template.HTML // Content rendered from Markdown
 	ImagePath    string
+	PostedAt     time.Time
+	UpdatedAt    time.Time // Zero when the post has never been edited
 	PostTime     string
+	EditedTime   string // Empty when the post has never been edited
+	Hidden       bool   // Hidden by a moderator
 	Likes        int
 	Dislikes     int
 	Comments     int
@@ -33,13 +43,16 @@ type Post struct {
 type Comment struct {
 	ID          int
 	PostID      int
+	ParentID    int // 0 for top-level comments
 	UserID      string
 	Username    string
 	Content     string
+	ContentHTML template.HTML // Content rendered from Markdown
 	CommentTime time.Time
 	Likes       int
 	Dislikes    int
 	ProfilePic  sql.NullString
+	Hidden      bool // Hidden by a moderator
 }
 
 type Category struct {
@@ -51,6 +64,10 @@ type Session struct {
 	ID        string
 	UserID    string
 	ExpiresAt time.Time
+	UserAgent string
+	IP        string
+	CreatedAt time.Time
+	LastSeen  time.Time
 }
 
 type ErrorPageData struct {
@@ -63,14 +80,27 @@ type PageData struct {
 	Posts         []Post
 	CurrentUserID string
 	Users []User
+	Feed          FeedNav
+}
+
+// FeedNav holds the sort state and navigation links of a paginated feed.
+type FeedNav struct {
+	Sort    string            // "new", "top" or "hot"
+	Window  string            // Time window of the "top" sort
+	NewURL  string
+	HotURL  string
+	TopURLs map[string]string // Keyed by window: day, week, month, year, all
+	NextURL string            // Empty on the last page
 }
 
 type Notification struct {
     ID                 int
-    Type              string    // "like", "dislike", "comment"
+    Type              string    // "like", "dislike", "comment", "reply", "warning"
     PostID            int       // ID of the affected post
     ActorName         string    // Username of person who performed action
     ActorProfilePic   sql.NullString // Profile picture of actor
     CreatedAt         time.Time // When notification was created
     CreatedAtFormatted string   // Formatted time string (e.g., "2 hours ago")
+    Read              bool      // The user has marked it read
+    Others            int       // Other actors grouped into the same entry
 }
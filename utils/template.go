@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"path/filepath"
+)
+
+// PageSession is what page templates show about the session making a
+// request. Visitors who are not signed in get "" and 0.
+type PageSession interface {
+	CSRFToken() string
+	UnreadNotifications() int
+}
+
+type pageSessionKey struct{}
+
+// WithPageSession returns a context carrying the PageSession ParseTemplate
+// uses for a request.
+func WithPageSession(ctx context.Context, s PageSession) context.Context {
+	return context.WithValue(ctx, pageSessionKey{}, s)
+}
+
+// noSession is the PageSession of requests that carry none, such as those
+// of tests calling handlers directly.
+type noSession struct{}
+
+func (noSession) CSRFToken() string        { return "" }
+func (noSession) UnreadNotifications() int { return 0 }
+
+// ParseTemplate parses page templates with the functions every page may
+// use. csrfField renders the hidden input each POST form must include and
+// csrfToken the bare token, for the meta tag read by like.js.
+// unreadNotifications counts the signed-in user's unread notifications for
+// the header badge and imageVariant picks a smaller size of an uploaded
+// image. The token and the count come from the request's PageSession.
+func ParseTemplate(r *http.Request, files ...string) (*template.Template, error) {
+	session, ok := r.Context().Value(pageSessionKey{}).(PageSession)
+	if !ok {
+		session = noSession{}
+	}
+
+	var token *string
+	csrfToken := func() string {
+		// Only look the token up if the page uses it
+		if token == nil {
+			t := session.CSRFToken()
+			token = &t
+		}
+		return *token
+	}
+
+	var unread *int
+	unreadNotifications := func() int {
+		if unread == nil {
+			n := session.UnreadNotifications()
+			unread = &n
+		}
+		return *unread
+	}
+
+	funcs := template.FuncMap{
+		"csrfToken": csrfToken,
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + CSRFField + `" value="` +
+				template.HTMLEscapeString(csrfToken()) + `">`)
+		},
+		"unreadNotifications": unreadNotifications,
+		"imageVariant":        ImageVariant,
+	}
+	return template.New(filepath.Base(files[0])).Funcs(funcs).ParseFiles(files...)
+}
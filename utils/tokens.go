@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Purposes of the single-use tokens sent by email.
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+var ErrInvalidToken = errors.New("token is invalid, expired or already used")
+
+// Only a hash of each token is stored, so a leaked database cannot be used
+// to reset passwords.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueToken creates a token for a user that expires after ttl. Any earlier
+// unused token with the same purpose stops working.
+func IssueToken(db *sql.DB, userID, purpose string, ttl time.Duration) (string, error) {
+	token := GenerateSessionToken()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM user_tokens WHERE user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO user_tokens (token_hash, user_id, purpose, expires_at)
+        VALUES (?, ?, ?, ?)
+    `, hashToken(token), userID, purpose, time.Now().Add(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+// CheckToken returns the user a token belongs to without using it up.
+func CheckToken(db *sql.DB, token, purpose string) (string, error) {
+	var userID string
+	err := db.QueryRow(`
+        SELECT user_id FROM user_tokens
+        WHERE token_hash = ? AND purpose = ? AND used_at IS NULL
+          AND julianday(expires_at) > julianday('now')
+    `, hashToken(token), purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidToken
+	}
+	return userID, err
+}
+
+// ConsumeToken marks a token as used and returns its user. A token can only
+// be consumed once.
+func ConsumeToken(db *sql.DB, token, purpose string) (string, error) {
+	userID, err := CheckToken(db, token, purpose)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := db.Exec(`
+        UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP
+        WHERE token_hash = ? AND used_at IS NULL
+    `, hashToken(token))
+	if err != nil {
+		return "", err
+	}
+	// Another request may have used the token since it was checked
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", ErrInvalidToken
+	}
+	return userID, nil
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"forum/storage"
+)
+
+// UploadCleanUpResult summarises one pass of CleanUpUploads.
+type UploadCleanUpResult struct {
+	Scanned    int   // Blobs in storage
+	Referenced int   // Blobs still used by a post, revision or profile
+	Recent     int   // Unreferenced blobs younger than the grace period
+	Deleted    int   // Unreferenced blobs deleted, or that would be in a dry run
+	Bytes      int64 // Size of the deleted blobs
+}
+
+func (r UploadCleanUpResult) String() string {
+	return fmt.Sprintf("%d files: %d referenced, %d unreferenced but recent, %d unreferenced and older than the grace period (%d bytes)",
+		r.Scanned, r.Referenced, r.Recent, r.Deleted, r.Bytes)
+}
+
+// referencedUploads returns the keys of every blob used by a post, a post
+// revision or a profile picture, including the other sizes of each image.
+func referencedUploads(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`
+		SELECT imagepath FROM posts WHERE imagepath != ''
+		UNION SELECT imagepath FROM post_revisions WHERE imagepath != ''
+		UNION SELECT profile_pic FROM users WHERE profile_pic IS NOT NULL AND profile_pic != ''
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var imagePath string
+		if err := rows.Scan(&imagePath); err != nil {
+			return nil, err
+		}
+		for _, p := range []string{imagePath, ImageVariant(imagePath, ImageThumb), ImageVariant(imagePath, ImageMedium)} {
+			if key := storage.KeyFromURL(p); key != "" {
+				keys[key] = true
+			}
+		}
+	}
+	return keys, rows.Err()
+}
+
+// CleanUpUploads deletes blobs in store that nothing in the database refers
+// to, such as replaced profile pictures and images of posts that failed to
+// save. Blobs written less than grace ago are kept, since the post or
+// profile using a new upload is saved after the upload is stored. With
+// dryRun nothing is deleted, but the result counts what would have been.
+func CleanUpUploads(ctx context.Context, db *sql.DB, store storage.BlobStore, grace time.Duration, dryRun bool) (UploadCleanUpResult, error) {
+	var result UploadCleanUpResult
+
+	// Listed before the references are read, so an upload saved in between
+	// is either referenced or too recent to delete
+	blobs, err := store.List(ctx)
+	if err != nil {
+		return result, fmt.Errorf("listing uploads: %v", err)
+	}
+	referenced, err := referencedUploads(db)
+	if err != nil {
+		return result, fmt.Errorf("reading image references: %v", err)
+	}
+
+	cutoff := time.Now().Add(-grace)
+	for _, blob := range blobs {
+		result.Scanned++
+		switch {
+		case referenced[blob.Key]:
+			result.Referenced++
+		case blob.ModTime.After(cutoff):
+			result.Recent++
+		default:
+			if !dryRun {
+				if err := store.Delete(ctx, blob.Key); err != nil {
+					return result, fmt.Errorf("deleting %s: %v", blob.Key, err)
+				}
+			}
+			result.Deleted++
+			result.Bytes += blob.Size
+		}
+	}
+	return result, nil
+}
+
+// RunUploadsCleanUp runs CleanUpUploads every interval until ctx is done.
+func RunUploadsCleanUp(ctx context.Context, db *sql.DB, store storage.BlobStore, interval, grace time.Duration, dryRun bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			result, err := CleanUpUploads(ctx, db, store, grace, dryRun)
+			if err != nil {
+				log.Printf("Failed to clean up uploads: %v", err)
+			} else if dryRun {
+				log.Printf("Upload cleanup dry run: %v; nothing was deleted", result)
+			} else {
+				log.Printf("Cleaned up uploads: %v; deleted the unreferenced files", result)
+			}
+		case <-ctx.Done():
+			log.Println("Stopping upload cleanup")
+			return
+		}
+	}
+}
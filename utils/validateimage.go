@@ -2,35 +2,47 @@ package utils
 
 import (
 	"errors"
+	"io"
 	"mime/multipart"
-	"path/filepath"
+	"net/http"
 )
 
 const MaxFileSize = 20 << 20 // 20MB
 
-var ValidImageTypes = map[string]bool{
-	"image/jpeg": true,
-	"image/png":  true,
-	"image/gif":  true,
+// Upload errors whose message can be shown to the user as is.
+var (
+	ErrImageFileTooLarge = errors.New(ErrFileTooLarge)
+	ErrInvalidImage      = errors.New(ErrInvalidFileType)
+)
+
+// ValidImageTypes maps the image types accepted for upload to the extension
+// they are stored with.
+var ValidImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
 }
 
-func ValidateImage(file multipart.File, header *multipart.FileHeader) error {
-	// Check file size
+// ValidateImage checks the size of an upload and returns its content type,
+// detected from the file's first bytes. The file name and the Content-Type
+// sent by the client are not trusted. file is left at its start.
+func ValidateImage(file multipart.File, header *multipart.FileHeader) (string, error) {
 	if header.Size > MaxFileSize {
-		return errors.New("file too large")
+		return "", ErrImageFileTooLarge
 	}
 
-	// Check file type
-	ext := filepath.Ext(header.Filename)
-	switch ext {
-	case ".jpg", ".jpeg", ".png", ".gif":
-		contentType := header.Header.Get("Content-Type")
-		if !ValidImageTypes[contentType] {
-			return errors.New("invalid file type")
-		}
-	default:
-		return errors.New("invalid file type")
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
 	}
 
-	return nil
+	contentType := http.DetectContentType(head[:n])
+	if _, ok := ValidImageTypes[contentType]; !ok {
+		return "", ErrInvalidImage
+	}
+	return contentType, nil
 }